@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// preserveAttributes carries the permissions, owner and extended attributes
+// of src over to the partial copy of it, before the copy gets its final name
+// dst, so the file never shows up with looser permissions than its source.
+// What is carried over depends on the platform; see copyAttributes.
+func preserveAttributes(src, partial, dst string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	err := newOpError("preserve attributes", dst, copyAttributes(src, partial, info, cfg.FS))
+	return metadataResult(dst, err, cfg)
+}
+
+// metadataResult turns a failure to preserve metadata into a warning,
+// recorded in the summary, unless --strict-metadata is set: the content is
+// already safely in place.
+func metadataResult(path string, err error, cfg FilesMoveConfiguration) error {
+	if err == nil || cfg.StrictMetadata {
+		return err
+	}
+	log.Printf(locMsg("metadata_not_preserved", cfg.Language), path, err)
+	cfg.Summary.recordMetadataWarning(path, err, cfg.Language)
+	return nil
+}
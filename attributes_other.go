@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// copyAttributes is not supported on this platform; copies get the default
+// permissions.
+func copyAttributes(src, dst string, info os.FileInfo, fsys FileSystem) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// copyAttributes carries the permission bits, including setuid, setgid and
+// sticky, and the extended attributes over. The owner and group are only
+// carried over when running as root, the only user allowed to give a file
+// away.
+func copyAttributes(src, dst string, info os.FileInfo, fsys FileSystem) error {
+	var errs []error
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+		errs = append(errs, fsys.Chown(dst, int(stat.Uid), int(stat.Gid)))
+	}
+	// After the chown, which clears the setuid and setgid bits.
+	mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	errs = append(errs, fsys.Chmod(dst, mode))
+	errs = append(errs, copyXattrs(src, dst, fsys))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// carriedAttributes are the file attributes a copy keeps.
+const carriedAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN |
+	windows.FILE_ATTRIBUTE_SYSTEM | windows.FILE_ATTRIBUTE_ARCHIVE
+
+// copyAttributes carries the read-only, hidden, system and archive
+// attributes over. Permissions are left to the ACL the copy inherits from
+// its new folder, as Explorer does when copying.
+func copyAttributes(src, dst string, info os.FileInfo, fsys FileSystem) error {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok || data.FileAttributes&carriedAttributes == 0 {
+		return nil
+	}
+	if fsys.ReadOnly() {
+		return refuse("set attributes", dst)
+	}
+	path, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	current, err := windows.GetFileAttributes(path)
+	if err != nil {
+		return err
+	}
+	return windows.SetFileAttributes(path, current&^carriedAttributes|data.FileAttributes&carriedAttributes)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// auditMissing is a file of the source with no copy in the archive. Err says
+// why a file that could not be read was not confirmed.
+type auditMissing struct {
+	Path string
+	Size int64
+	Err  error
+}
+
+// auditResult is what audit found: how many source files it checked and
+// their size, and those without a copy in the archive.
+type auditResult struct {
+	Checked int
+	Bytes   int64
+	Missing []auditMissing
+}
+
+// auditArchive checks that every file of cfg.InputFolder, the backup or drive
+// that was organized, has a copy with the same content somewhere in the
+// archive, cfg.OutputFolder, whatever its name and folder there. Only files
+// that share a size are hashed, with cfg.Hash on cfg.Workers goroutines, and
+// archive files cfg.Index holds an up-to-date XXH3 hash of are not read
+// again. Links in the archive are no copies and are left out: symlinks, and
+// hardlinks to a source file, which share its data.
+func auditArchive(cfg FilesMoveConfiguration) (auditResult, error) {
+	var result auditResult
+	bySize := map[int64][]string{}
+	linked := map[fileID]bool{}
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() || isStructoArtifact(path) {
+			return nil
+		}
+		if id, links, ok := hardlinkID(info); ok && links > 1 {
+			linked[id] = true
+		}
+		result.Checked++
+		result.Bytes += info.Size()
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	archiveCfg := cfg
+	archiveCfg.InputFolder = cfg.OutputFolder
+	// found holds the contents of the archive by auditKey, and sizes the
+	// sizes of the files hashed into it.
+	found := map[string]bool{}
+	sizes := map[int64]bool{}
+	archived := map[string]int64{}
+	err = walkInputFiles(archiveCfg, func(path string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() || isStructoArtifact(path) || bySize[info.Size()] == nil {
+			return nil
+		}
+		if id, _, ok := hardlinkID(info); ok && linked[id] {
+			return nil
+		}
+		if cfg.Hash == HashXXH3 {
+			if hash, ok := cfg.Index.knownHash(path, info); ok {
+				found[auditKey(info.Size(), hash)] = true
+				sizes[info.Size()] = true
+				return nil
+			}
+		}
+		archived[path] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	paths := make([]string, 0, len(archived))
+	for path := range archived {
+		paths = append(paths, path)
+	}
+	hashes := hashFiles(paths, cfg.Hash, cfg.HashMmap, cfg.Workers, func(path string, err error) {
+		log.Printf(locMsg("hash_error", cfg.Language), path, err)
+	})
+	for path, hash := range hashes {
+		found[auditKey(archived[path], hash)] = true
+		sizes[archived[path]] = true
+	}
+
+	// Files of a size no archive file has are missing without reading them.
+	var candidates []string
+	for size, paths := range bySize {
+		if sizes[size] {
+			candidates = append(candidates, paths...)
+		} else {
+			for _, path := range paths {
+				result.Missing = append(result.Missing, auditMissing{Path: path, Size: size})
+			}
+		}
+	}
+	unreadable := map[string]error{}
+	hashes = hashFiles(candidates, cfg.Hash, cfg.HashMmap, cfg.Workers, func(path string, err error) {
+		unreadable[path] = err
+	})
+	for size, paths := range bySize {
+		if !sizes[size] {
+			continue
+		}
+		for _, path := range paths {
+			if err, ok := unreadable[path]; ok {
+				result.Missing = append(result.Missing, auditMissing{Path: path, Size: size, Err: err})
+			} else if !found[auditKey(size, hashes[path])] {
+				result.Missing = append(result.Missing, auditMissing{Path: path, Size: size})
+			}
+		}
+	}
+	sort.Slice(result.Missing, func(i, j int) bool { return result.Missing[i].Path < result.Missing[j].Path })
+	return result, nil
+}
+
+// auditKey identifies a content by its size and hash.
+func auditKey(size int64, hash string) string {
+	return fmt.Sprintf("%d:%s", size, hash)
+}
+
+// printAuditReport lists the files of the source missing from the archive
+// and closes with a count.
+func printAuditReport(w io.Writer, result auditResult) {
+	var missingBytes int64
+	unreadable := 0
+	if len(result.Missing) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "MISSING\tSIZE\tDETAIL")
+		for _, missing := range result.Missing {
+			detail := "no copy in the archive"
+			if missing.Err != nil {
+				detail = "could not be read: " + missing.Err.Error()
+				unreadable++
+			}
+			missingBytes += missing.Size
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", missing.Path, formatBytes(missing.Size), detail)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d source files checked (%s): %d missing (%s), %d of them unreadable\n",
+		result.Checked, formatBytes(result.Bytes), len(result.Missing), formatBytes(missingBytes), unreadable)
+}
+
+// writeAuditList writes the paths of the missing files to path, one per
+// line, as --files-from reads them. The list is the command's own output and
+// goes through fsys, which only --no-write makes read-only.
+func writeAuditList(fsys FileSystem, result auditResult, path string) error {
+	var list strings.Builder
+	for _, missing := range result.Missing {
+		list.WriteString(missing.Path + "\n")
+	}
+	return newOpError("write missing-files list", path, fsys.WriteFile(path, []byte(list.String()), 0644))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// backupPathsPlaceholder is replaced in --backup-cmd by the changed folders.
+const backupPathsPlaceholder = "{paths}"
+
+// runBackupHook runs --backup-cmd once a run has placed every file, over
+// the folders of the output it placed files in or moved them out of, so a
+// restic or borg snapshot only has to look at those. Dry runs place nothing
+// and never trigger it.
+func runBackupHook(cfg FilesMoveConfiguration) error {
+	if cfg.BackupCmd == "" || cfg.DryRun || cfg.Journal == nil {
+		return nil
+	}
+	changed := map[string]bool{}
+	cfg.Journal.mu.Lock()
+	for _, entry := range cfg.Journal.Entries {
+		addEntryFolders(changed, cfg.Journal.Output, entry)
+	}
+	cfg.Journal.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	var folders []string
+	for _, rel := range coveringFolders(cfg.OutputFolder, changed) {
+		folder, err := filepath.Abs(filepath.Join(cfg.OutputFolder, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		folders = append(folders, folder)
+	}
+
+	log.Printf(locMsg("backup_started", cfg.Language), len(folders))
+	cmd := backupCommand(cfg.BackupCmd, folders)
+	output, err := cfg.FS.RunCommand(cmd)
+	if len(output) > 0 {
+		log.Print(strings.TrimRight(string(output), "\n"))
+	}
+	if err != nil {
+		return fmt.Errorf("backup command %q failed: %w", cfg.BackupCmd, err)
+	}
+	log.Println(locMsg("backup_finished", cfg.Language))
+	return nil
+}
+
+// backupCommand builds the shell command for --backup-cmd, with the quoted
+// folders in place of {paths}, or after the command when it has none.
+// Other braces are left alone, so borg placeholders such as {now} work.
+func backupCommand(command string, folders []string) *exec.Cmd {
+	quoted := make([]string, len(folders))
+	for i, folder := range folders {
+		quoted[i] = shellQuote(folder)
+	}
+	paths := strings.Join(quoted, " ")
+	if strings.Contains(command, backupPathsPlaceholder) {
+		command = strings.ReplaceAll(command, backupPathsPlaceholder, paths)
+	} else {
+		command += " " + paths
+	}
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,20 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns the creation time reported by stat(2).
+func birthTime(path string, info os.FileInfo) (*time.Time, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, errors.New("btime is not available for this file")
+	}
+	btime := time.Unix(int64(stat.Birthtimespec.Sec), int64(stat.Birthtimespec.Nsec))
+	return &btime, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthTime returns the creation time reported by statx(2). Not every
+// filesystem records it; ext4, XFS, btrfs and tmpfs do on recent kernels.
+func birthTime(path string, info os.FileInfo) (*time.Time, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx); err != nil {
+		return nil, err
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return nil, errors.New("btime is not recorded by this filesystem")
+	}
+	btime := time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	return &btime, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// birthTime is not supported on this platform; the chain falls through to the next source.
+func birthTime(path string, info os.FileInfo) (*time.Time, error) {
+	return nil, errors.New("btime is not supported on this platform")
+}
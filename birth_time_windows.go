@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns the creation time Windows keeps for every file.
+func birthTime(path string, info os.FileInfo) (*time.Time, error) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil, errors.New("btime is not available for this file")
+	}
+	btime := time.Unix(0, data.CreationTime.Nanoseconds())
+	return &btime, nil
+}
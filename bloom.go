@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	// bloomFalsePositiveRate is the share of new keys a filter wrongly reports
+	// as maybe known; those cost one lookup that finds nothing.
+	bloomFalsePositiveRate = 0.01
+	// bloomHeadroom is how many keys a filter holds room for beyond those it
+	// starts with, so keys added during a run keep it accurate.
+	bloomHeadroom = 1 << 20
+)
+
+// bloomFilter remembers a set of keys in a fixed number of bits. It may
+// report a key it never saw as present, at bloomFalsePositiveRate once full,
+// but never misses a key it was given. A nil filter knows nothing and reports
+// every key as maybe present, so callers fall back to their exact lookup.
+type bloomFilter struct {
+	mu     sync.RWMutex
+	bits   []uint64
+	hashes uint64
+}
+
+// bloomKey is the pair of hashes a key is reduced to; the filter derives all
+// of its bit positions from it.
+type bloomKey struct{ lo, hi uint64 }
+
+func newBloomKey(key string) bloomKey {
+	sum := xxh3.HashString128(key)
+	return bloomKey{lo: sum.Lo, hi: sum.Hi | 1}
+}
+
+// newBloomFilter sizes a filter for capacity keys at bloomFalsePositiveRate.
+func newBloomFilter(capacity int) *bloomFilter {
+	n := float64(max(capacity, 1))
+	bits := math.Ceil(-n * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bits / n * math.Ln2)
+	return &bloomFilter{
+		bits:   make([]uint64, (uint64(bits)+63)/64),
+		hashes: uint64(max(hashes, 1)),
+	}
+}
+
+func (b *bloomFilter) add(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addKey(newBloomKey(key))
+}
+
+func (b *bloomFilter) addKey(k bloomKey) {
+	size := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (k.lo + i*k.hi) % size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain reports false only for keys that were never added.
+func (b *bloomFilter) mayContain(key string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	k := newBloomKey(key)
+	size := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (k.lo + i*k.hi) % size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// knownChunks holds, per chunk store, a Bloom filter of the chunk hashes it
+// holds. Most chunks of a new file are new too, and the filter lets them be
+// written without first looking for them on disk, one lookup per chunk that
+// adds up at millions of files. The filter is built on first use from one
+// listing of the store and kept up to date as chunks are written.
+var knownChunks = struct {
+	sync.Mutex
+	filters map[string]*bloomFilter
+}{filters: map[string]*bloomFilter{}}
+
+// knownChunkFilter returns the filter of the chunk store at storeRoot. When
+// the store cannot be listed it returns nil, so every chunk is looked up.
+func knownChunkFilter(storeRoot string) *bloomFilter {
+	knownChunks.Lock()
+	defer knownChunks.Unlock()
+	filter, ok := knownChunks.filters[storeRoot]
+	if !ok {
+		filter = loadChunkFilter(storeRoot)
+		knownChunks.filters[storeRoot] = filter
+	}
+	return filter
+}
+
+// loadChunkFilter lists the chunks of a store, as laid out by chunkPath, into
+// a new filter.
+func loadChunkFilter(storeRoot string) *bloomFilter {
+	fanout, err := os.ReadDir(storeRoot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newBloomFilter(bloomHeadroom)
+	}
+	if err != nil {
+		return nil
+	}
+	var keys []bloomKey
+	for _, entry := range fanout {
+		if !entry.IsDir() {
+			continue
+		}
+		names, err := readDirNames(filepath.Join(storeRoot, entry.Name()))
+		if err != nil {
+			return nil
+		}
+		for _, name := range names {
+			if !strings.HasSuffix(name, ".tmp") {
+				keys = append(keys, newBloomKey(name))
+			}
+		}
+	}
+	filter := newBloomFilter(len(keys) + bloomHeadroom)
+	for _, k := range keys {
+		filter.addKey(k)
+	}
+	return filter
+}
+
+// readDirNames lists the names in dir without reading each entry's type.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
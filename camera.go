@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// cameraFolder returns dir, the date folder of the image at path, with a
+// folder for the camera that took it below, with --camera-folders. Files
+// without a camera model, such as videos and screenshots, stay in dir.
+func cameraFolder(dir, path string, info os.FileInfo, cfg FilesMoveConfiguration) string {
+	if !cfg.CameraFolders || !isImageFile(path) {
+		return dir
+	}
+	tags, err := cfg.ExifCache.tags(path, info)
+	if err != nil {
+		return dir
+	}
+	name := cameraFolderName(tags.make, tags.model)
+	if name == "" {
+		return dir
+	}
+	return filepath.Join(dir, sanitizeFileName(name, cfg.Capabilities))
+}
+
+// cameraFolderName names the folder of a camera after its EXIF make and
+// model, as in "Canon_EOS_R6". The first word of the make comes first
+// unless the model starts with it already, so "NIKON CORPORATION" and
+// "NIKON Z 6" give "NIKON_Z_6", and "Apple" and "iPhone 13" give
+// "Apple_iPhone_13". Anything but letters, digits, dots and dashes becomes
+// one underscore. It is empty without a model.
+func cameraFolderName(maker, model string) string {
+	if strings.TrimSpace(model) == "" {
+		return ""
+	}
+	brand, _, _ := strings.Cut(strings.TrimSpace(maker), " ")
+	first, _, _ := strings.Cut(strings.TrimSpace(model), " ")
+	if brand != "" && !strings.EqualFold(first, brand) {
+		model = brand + " " + model
+	}
+	var name strings.Builder
+	gap := false
+	for _, r := range model {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' {
+			if gap && name.Len() > 0 {
+				name.WriteByte('_')
+			}
+			name.WriteRune(r)
+			gap = false
+		} else {
+			gap = true
+		}
+	}
+	return strings.Trim(name.String(), ".")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FSCapabilities describes what the destination filesystem accepts. Sanitization
+// and conflict detection adapt to it.
+type FSCapabilities struct {
+	Probed          bool
+	CaseSensitive   bool
+	MaxNameLength   int
+	InvalidChars    string
+	TimeGranularity time.Duration
+	Symlinks        bool
+	Hardlinks       bool
+}
+
+// candidateInvalidChars are the characters some filesystems (FAT, NTFS, SMB) reject.
+const candidateInvalidChars = `<>:"|?*\`
+
+// unprobedInvalidChars are rejected by the host OS whatever the filesystem,
+// and cannot be probed safely: on Windows "a:b" names an alternate data
+// stream of "a", so creating it succeeds without any file named "a:b".
+func unprobedInvalidChars() string {
+	if runtime.GOOS == "windows" {
+		return `:\`
+	}
+	return ""
+}
+
+// defaultCapabilities is used when the destination cannot be probed, e.g. in
+// a dry run or --no-write mode. It assumes the usual behavior of the host OS.
+func defaultCapabilities() FSCapabilities {
+	caps := FSCapabilities{
+		CaseSensitive:   true,
+		MaxNameLength:   255,
+		TimeGranularity: time.Nanosecond,
+		Symlinks:        true,
+		Hardlinks:       true,
+	}
+	switch runtime.GOOS {
+	case "windows":
+		caps.CaseSensitive = false
+		caps.InvalidChars = candidateInvalidChars
+		caps.TimeGranularity = 100 * time.Nanosecond
+	case "darwin":
+		caps.CaseSensitive = false
+		caps.InvalidChars = ":"
+	}
+	return caps
+}
+
+// probeCapabilities experiments inside a scratch folder in dir and removes it
+// afterwards, leaving nothing behind.
+func probeCapabilities(dir string, fsys FileSystem) (FSCapabilities, error) {
+	caps := defaultCapabilities()
+	if fsys.ReadOnly() {
+		return caps, nil
+	}
+	scratch := filepath.Join(dir, fmt.Sprintf(".structo-probe-%d", os.Getpid()))
+	if err := fsys.MkdirAll(scratch, 0755); err != nil {
+		return caps, newOpError("create probe folder", scratch, err)
+	}
+	defer os.RemoveAll(scratch)
+
+	caps.Probed = true
+	caps.CaseSensitive = probeCaseSensitive(scratch, fsys)
+	caps.MaxNameLength = probeMaxNameLength(scratch, fsys)
+	caps.InvalidChars = probeInvalidChars(scratch, fsys)
+	caps.TimeGranularity = probeTimeGranularity(scratch, fsys)
+	caps.Symlinks, caps.Hardlinks = probeLinks(scratch, fsys)
+	return caps, nil
+}
+
+func probeTouch(fsys FileSystem, path string) bool {
+	f, err := fsys.Create(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func probeCaseSensitive(scratch string, fsys FileSystem) bool {
+	lower := filepath.Join(scratch, "case-probe")
+	if !probeTouch(fsys, lower) {
+		return true
+	}
+	return !fileExists(filepath.Join(scratch, "CASE-PROBE"))
+}
+
+// probeMaxNameLength binary-searches the longest accepted name, in bytes.
+func probeMaxNameLength(scratch string, fsys FileSystem) int {
+	lo, hi := 8, 1024
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		path := filepath.Join(scratch, strings.Repeat("n", mid))
+		if probeTouch(fsys, path) {
+			fsys.Remove(path)
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// probeInvalidChars counts a character as accepted only when the folder then
+// lists a file with exactly the probed name; a driver that maps or splits
+// the name (streams, substitutions) counts as rejecting it.
+func probeInvalidChars(scratch string, fsys FileSystem) string {
+	skip := unprobedInvalidChars()
+	var invalid strings.Builder
+	for _, c := range candidateInvalidChars {
+		if strings.ContainsRune(skip, c) {
+			invalid.WriteRune(c)
+			continue
+		}
+		name := "char" + string(c) + "probe"
+		path := filepath.Join(scratch, name)
+		if probeTouch(fsys, path) && probeListed(scratch, name) {
+			fsys.Remove(path)
+			continue
+		}
+		invalid.WriteRune(c)
+	}
+	return invalid.String()
+}
+
+func probeListed(scratch, name string) bool {
+	entries, err := os.ReadDir(scratch)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTimeGranularity sets a timestamp with nanosecond detail and checks which
+// precision survives the round trip (e.g. 2s on FAT, 100ns on NTFS).
+func probeTimeGranularity(scratch string, fsys FileSystem) time.Duration {
+	path := filepath.Join(scratch, "time-probe")
+	if !probeTouch(fsys, path) {
+		return time.Nanosecond
+	}
+	want := time.Date(2001, 1, 1, 0, 0, 1, 123456789, time.UTC)
+	if err := fsys.Chtimes(path, want, want); err != nil {
+		return time.Nanosecond
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Nanosecond
+	}
+	got := info.ModTime()
+	for _, unit := range []time.Duration{time.Nanosecond, 100 * time.Nanosecond, time.Microsecond, time.Millisecond, 10 * time.Millisecond, time.Second, 2 * time.Second} {
+		diff := got.Sub(want)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < unit {
+			return unit
+		}
+	}
+	return 2 * time.Second
+}
+
+func probeLinks(scratch string, fsys FileSystem) (bool, bool) {
+	target := filepath.Join(scratch, "link-target")
+	if !probeTouch(fsys, target) {
+		return false, false
+	}
+	symlinks := fsys.Symlink(target, filepath.Join(scratch, "symlink-probe")) == nil
+	hardlinks := fsys.Link(target, filepath.Join(scratch, "hardlink-probe")) == nil
+	return symlinks, hardlinks
+}
+
+// roundToGranularity truncates t to what the destination can store. Truncating
+// ourselves, instead of leaving it to the driver (some round up), keeps
+// re-runs stable: a file never crosses a date bucket boundary on its way in.
+func roundToGranularity(t time.Time, granularity time.Duration) time.Time {
+	if granularity <= time.Nanosecond {
+		return t
+	}
+	return t.Truncate(granularity)
+}
+
+// logCapabilities records the detected capabilities for traceability.
+func logCapabilities(caps FSCapabilities, lang string) {
+	invalid := caps.InvalidChars
+	if invalid == "" {
+		invalid = "none"
+	}
+	log.Printf(locMsg("fs_capabilities", lang), caps.Probed, caps.CaseSensitive, caps.MaxNameLength,
+		invalid, caps.TimeGranularity, caps.Symlinks, caps.Hardlinks)
+}
+
+// sanitizeFileName replaces characters the destination rejects with "_" and
+// shortens names that are too long, keeping the extension and valid UTF-8.
+func sanitizeFileName(name string, caps FSCapabilities) string {
+	if caps.InvalidChars != "" {
+		name = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(caps.InvalidChars, r) {
+				return '_'
+			}
+			return r
+		}, name)
+		// Windows also rejects names ending in a dot or space.
+		if strings.ContainsRune(caps.InvalidChars, '|') {
+			name = strings.TrimRight(name, ". ")
+			if name == "" {
+				name = "_"
+			}
+		}
+	}
+	if caps.MaxNameLength > 0 && len(name) > caps.MaxNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) >= caps.MaxNameLength/2 {
+			ext = ""
+		}
+		stem := name[:len(name)-len(ext)]
+		stem = truncateUTF8(stem, caps.MaxNameLength-len(ext))
+		name = stem + ext
+	}
+	return name
+}
+
+// sanitizeRelPath sanitizes every component of a relative path.
+func sanitizeRelPath(rel string, caps FSCapabilities) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = sanitizeFileName(part, caps)
+		}
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// checkModeSupported fails early when the destination cannot hold the requested links.
+func checkModeSupported(mode OrganizeMode, caps FSCapabilities) error {
+	if mode == ModeSymlink && !caps.Symlinks {
+		return fmt.Errorf("the output filesystem does not support symlinks; use --mode copy or hardlink")
+	}
+	if mode == ModeHardlink && !caps.Hardlinks {
+		return fmt.Errorf("the output filesystem does not support hardlinks; use --mode copy or symlink")
+	}
+	return nil
+}
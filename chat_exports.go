@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatNamePattern is a way WhatsApp or Telegram names the media it saves or
+// exports, with where the year, month, day and time are in its matches.
+type chatNamePattern struct {
+	re *regexp.Regexp
+	// year, month and day index the submatches; the time, when there is
+	// one, follows the last of them.
+	year, month, day int
+	// twelveHour is set when a last submatch holds AM or PM.
+	twelveHour bool
+}
+
+// chatNamePatterns match the names of WhatsApp and Telegram media. These
+// files lost their EXIF data on the way and carry the date they were saved
+// or exported as their modification time, so their names are the only
+// record of when they were sent.
+var chatNamePatterns = []chatNamePattern{
+	// WhatsApp on Android: "IMG-20240131-WA0001.jpg", "PTT-20240131-WA0002.opus".
+	{re: regexp.MustCompile(`^(?:IMG|VID|AUD|PTT|STK|DOC)-(\d{4})(\d{2})(\d{2})-WA\d+`), year: 1, month: 2, day: 3},
+	// WhatsApp Desktop, Web and iOS: "WhatsApp Image 2024-01-31 at 23.59.59.jpeg",
+	// or "... at 11.59.59 PM.jpeg".
+	{re: regexp.MustCompile(`(?i)^WhatsApp (?:Image|Video|Audio|Ptt|Document|Sticker|GIF) (\d{4})-(\d{2})-(\d{2}) at (\d{1,2})\.(\d{2})\.(\d{2})(?:[\s\x{202f}]?([AP]M))?`), year: 1, month: 2, day: 3, twelveHour: true},
+	// WhatsApp chat exports on iOS: "00000012-PHOTO-2024-01-31-23-59-59.jpg".
+	{re: regexp.MustCompile(`^\d{8}-(?:PHOTO|VIDEO|AUDIO|STICKER|GIF)-(\d{4})-(\d{2})-(\d{2})-(\d{2})-(\d{2})-(\d{2})`), year: 1, month: 2, day: 3},
+	// Telegram Desktop exports: "photo_12@31-01-2024_23-59-59.jpg".
+	{re: regexp.MustCompile(`^[a-z_]+_\d+@(\d{2})-(\d{2})-(\d{4})_(\d{2})-(\d{2})-(\d{2})`), year: 3, month: 2, day: 1},
+	// Older Telegram Desktop exports and saves: "photo_2024-01-31_23-59-59.jpg".
+	{re: regexp.MustCompile(`^(?:photo|video|file|audio|voice|sticker|round_video)_(\d{4})-(\d{2})-(\d{2})_(\d{2})-(\d{2})-(\d{2})`), year: 1, month: 2, day: 3},
+}
+
+// errNotChatName reports a name WhatsApp and Telegram do not give files.
+var errNotChatName = errors.New("not a WhatsApp or Telegram file name")
+
+// dateFromChatName returns the date in the name of a WhatsApp or Telegram
+// file, see chatNamePatterns.
+func dateFromChatName(name string) (*time.Time, error) {
+	for _, pattern := range chatNamePatterns {
+		match := pattern.re.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		parts := []string{match[pattern.year], match[pattern.month], match[pattern.day]}
+		rest := match[max(pattern.year, pattern.month, pattern.day)+1:]
+		meridiem := ""
+		if pattern.twelveHour {
+			meridiem, rest = strings.ToUpper(rest[len(rest)-1]), rest[:len(rest)-1]
+		}
+		parts = append(parts, rest...)
+		if meridiem != "" {
+			hour, _ := strconv.Atoi(parts[3])
+			if hour < 1 || hour > 12 {
+				continue
+			}
+			hour %= 12
+			if meridiem == "PM" {
+				hour += 12
+			}
+			parts[3] = strconv.Itoa(hour)
+		}
+		if date, ok := dateFromParts(parts); ok {
+			return &date, nil
+		}
+	}
+	return nil, errNotChatName
+}
+
+const (
+	// telegramExportPrefix starts the folder names of Telegram Desktop
+	// chat exports, as in "ChatExport_2024-01-31".
+	telegramExportPrefix = "ChatExport_"
+	// telegramExportFolderPrefix starts the name such a folder gets in the
+	// output, followed by the name of the chat.
+	telegramExportFolderPrefix = "Telegram - "
+	// chatHeaderLimit bounds how much of messages.html is read for the name
+	// of the chat, which is in its header.
+	chatHeaderLimit = 64 << 10
+)
+
+// telegramChatTitle finds the chat name in the header of messages.html.
+var telegramChatTitle = regexp.MustCompile(`(?s)<div class="page_header">.*?<div class="text bold">\s*(.*?)\s*</div>`)
+
+// telegramExports caches the chat names of the folders looked at, "" for
+// folders that are no Telegram chat export.
+var telegramExports sync.Map
+
+// telegramChatName returns the name of the chat that dir, a Telegram
+// Desktop chat export, holds, from its result.json or messages.html.
+func telegramChatName(dir string) (string, bool) {
+	if !strings.HasPrefix(filepath.Base(dir), telegramExportPrefix) {
+		return "", false
+	}
+	if name, ok := telegramExports.Load(dir); ok {
+		return name.(string), name != ""
+	}
+	name, err := readTelegramChatName(dir)
+	if err != nil {
+		name = ""
+	}
+	telegramExports.Store(dir, name)
+	return name, name != ""
+}
+
+// readTelegramChatName reads the chat name of a Telegram chat export.
+func readTelegramChatName(dir string) (string, error) {
+	if f, err := os.Open(filepath.Join(dir, "result.json")); err == nil {
+		defer f.Close()
+		return jsonChatName(f)
+	}
+	f, err := os.Open(filepath.Join(dir, "messages.html"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header, err := io.ReadAll(io.LimitReader(f, chatHeaderLimit))
+	if err != nil {
+		return "", err
+	}
+	match := telegramChatTitle.FindSubmatch(header)
+	if match == nil {
+		return "", fmt.Errorf("no chat name in %s", f.Name())
+	}
+	return strings.TrimSpace(html.UnescapeString(string(match[1]))), nil
+}
+
+// jsonChatName reads the top-level "name" of result.json, which comes before
+// its messages, without reading those.
+func jsonChatName(r io.Reader) (string, error) {
+	dec := json.NewDecoder(r)
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return "", errors.New("result.json is not an object")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if key == "name" {
+			var name string
+			err := dec.Decode(&name)
+			return name, err
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("result.json has no chat name")
+}
+
+// chatExportRelPath returns rel, a path relative to the input folder kept by
+// --preserve-structure, with each Telegram chat export on the way named
+// after its chat, as in "Telegram - Family", instead of the date it was
+// exported on. WhatsApp names its exports after the chat already.
+func chatExportRelPath(input, rel string) string {
+	parts := strings.Split(rel, string(filepath.Separator))
+	dir := input
+	for i, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if name, ok := telegramChatName(dir); ok {
+			parts[i] = telegramExportFolderPrefix + name
+		}
+	}
+	return filepath.Join(parts...)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type OutputBackend int
+
+const (
+	BackendFilesystem OutputBackend = iota
+	BackendChunkStore
+)
+
+const (
+	BackendNameFilesystem = "fs"
+	BackendNameChunkStore = "chunkstore"
+)
+
+var backendName = map[OutputBackend]string{
+	BackendFilesystem: BackendNameFilesystem,
+	BackendChunkStore: BackendNameChunkStore,
+}
+
+var reverseBackendName = map[string]OutputBackend{
+	BackendNameFilesystem: BackendFilesystem,
+	BackendNameChunkStore: BackendChunkStore,
+}
+
+// String returns the string representation of OutputBackend.
+func (b OutputBackend) String() string {
+	return backendName[b]
+}
+
+// ParseOutputBackend parses a string into an OutputBackend.
+func ParseOutputBackend(input string) (OutputBackend, error) {
+	if backend, ok := reverseBackendName[input]; ok {
+		return backend, nil
+	}
+	return 0, fmt.Errorf("invalid OutputBackend: %s", input)
+}
+
+const (
+	// chunkStoreDirName is the hidden folder in the output root that holds chunk data.
+	chunkStoreDirName = ".structo-chunks"
+	// manifestExt is appended to the organized file name; the manifest replaces the file in the date tree.
+	manifestExt = ".structo-manifest"
+
+	chunkMinSize = 256 << 10
+	chunkMaxSize = 4 << 20
+	// chunkAvgBits gives an average chunk size of roughly 1 MiB past the minimum.
+	chunkAvgBits = 20
+)
+
+// gearTable drives the rolling gear hash. It is generated from a fixed seed so
+// chunk boundaries (and therefore dedup) are stable across runs and releases.
+var gearTable = buildGearTable()
+
+func buildGearTable() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+type chunkRef struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// chunkManifest describes how to reassemble one organized file from the chunk store.
+type chunkManifest struct {
+	Version int         `json:"version"`
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Chunks  []chunkRef  `json:"chunks"`
+}
+
+// nextChunk reads the next content-defined chunk from r into buf.
+// A boundary is declared when the top bits of the gear hash are zero, bounded by
+// chunkMinSize and chunkMaxSize, so insertions only disturb nearby chunks.
+// The hash runs over what r has buffered, which is then copied and consumed
+// in one piece, rather than byte by byte.
+func nextChunk(r *bufio.Reader, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	var hash uint64
+	for {
+		if r.Buffered() == 0 {
+			if _, err := r.Peek(1); err == io.EOF {
+				if len(buf) == 0 {
+					return nil, io.EOF
+				}
+				return buf, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		window, _ := r.Peek(r.Buffered())
+		for i, b := range window {
+			hash = (hash << 1) + gearTable[b]
+			if size := len(buf) + i + 1; size >= chunkMaxSize || (size >= chunkMinSize && hash>>(64-chunkAvgBits) == 0) {
+				buf = append(buf, window[:i+1]...)
+				r.Discard(i + 1)
+				return buf, nil
+			}
+		}
+		buf = append(buf, window...)
+		r.Discard(len(window))
+	}
+}
+
+// chunkStoreRoot returns the chunk store directory for an output folder.
+func chunkStoreRoot(outputFolder string) string {
+	return filepath.Join(outputFolder, chunkStoreDirName)
+}
+
+// chunkPath is where the chunk with hash is kept; hash must pass validChunkHash.
+func chunkPath(storeRoot, hash string) string {
+	return filepath.Join(storeRoot, hash[:2], hash)
+}
+
+// validChunkHash reports whether hash is a hex SHA-256, as storeChunks names
+// chunks.
+func validChunkHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isChunkStorePath reports whether path is the chunk store itself or lives inside it.
+func isChunkStorePath(path string, cfg FilesMoveConfiguration) bool {
+	_, ok := relWithin(chunkStoreRoot(cfg.OutputFolder), path)
+	return ok
+}
+
+// storeInChunkStore splits src into content-defined chunks, writes any chunk not
+// already present, and replaces the file in the date tree with a manifest.
+func storeInChunkStore(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	manifestPath, err := ensureUniquePath("", dst+manifestExt, cfg)
+	if err != nil {
+		return "", fmt.Errorf("error ensuring unique path: %w", err)
+	}
+
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would store in chunk store: %s => %s", src, manifestPath)
+		return manifestPath, nil
+	}
+	if err := storeChunks(ctx, src, manifestPath, info, cfg); err != nil {
+		cfg.Reservations.release(manifestPath)
+		return "", err
+	}
+	return manifestPath, nil
+}
+
+// storeChunks writes the chunks of src and its manifest, then removes src
+// unless the mode keeps it.
+func storeChunks(ctx context.Context, src, manifestPath string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	storeRoot := chunkStoreRoot(cfg.OutputFolder)
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return newOpError("open source", src, err)
+	}
+	defer srcFile.Close()
+
+	manifest := chunkManifest{
+		Version: 1,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode().Perm(),
+		ModTime: info.ModTime(),
+	}
+	reader := bufio.NewReaderSize(srcFile, 1<<20)
+	buf := make([]byte, 0, chunkMaxSize)
+	for {
+		// Chunks already written are content-addressed and harmless if a
+		// cancelled file never gets its manifest.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, readErr := nextChunk(reader, buf)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return newOpError("read", src, readErr)
+		}
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		if writeErr := writeChunk(cfg.FS, storeRoot, hash, chunk); writeErr != nil {
+			return writeErr
+		}
+		manifest.Chunks = append(manifest.Chunks, chunkRef{Hash: hash, Size: len(chunk)})
+	}
+	srcFile.Close()
+
+	if err := writeManifest(cfg.FS, manifestPath, manifest, cfg.Capabilities.TimeGranularity); err != nil {
+		return err
+	}
+	if cfg.Mode.KeepsSource() {
+		return nil
+	}
+	return newOpError("remove original", src, cfg.FS.Remove(src))
+}
+
+// writeChunk stores a chunk under its hash unless an identical chunk already
+// exists. Only chunks the store's knownChunkFilter may hold are looked for.
+func writeChunk(fsys FileSystem, storeRoot, hash string, data []byte) error {
+	path := chunkPath(storeRoot, hash)
+	known := knownChunkFilter(storeRoot)
+	if known.mayContain(hash) && fileExists(path) {
+		return nil
+	}
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return newOpError("create chunk directory", filepath.Dir(path), err)
+	}
+	// Each writer gets its own temp file, so workers storing the same new
+	// chunk do not share one, and a temp file left by a crash blocks nothing.
+	// The .tmp suffix keeps loadChunkFilter from counting it as a chunk.
+	tmp, err := writeChunkTemp(fsys, path, data)
+	if err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, path); err != nil {
+		fsys.Remove(tmp)
+		if fileExists(path) {
+			// Written meanwhile by another run sharing the store.
+			known.add(hash)
+			return nil
+		}
+		return newOpError("commit chunk", path, err)
+	}
+	known.add(hash)
+	return newOpError("protect chunk", path, fsys.Chmod(path, 0444))
+}
+
+// writeChunkTemp writes data to a new, uniquely named temp file beside path
+// and returns its name.
+func writeChunkTemp(fsys FileSystem, path string, data []byte) (string, error) {
+	var f *os.File
+	var tmp string
+	for {
+		tmp = fmt.Sprintf("%s.%016x.tmp", path, rand.Uint64())
+		var err error
+		f, err = fsys.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", newOpError("write chunk", tmp, err)
+		}
+		break
+	}
+	_, err := f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fsys.Remove(tmp)
+		return "", newOpError("write chunk", tmp, err)
+	}
+	return tmp, nil
+}
+
+func writeManifest(fsys FileSystem, path string, manifest chunkManifest, granularity time.Duration) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(path, data, 0644); err != nil {
+		return newOpError("write manifest", path, err)
+	}
+	// The manifest body keeps the precise time; the file itself gets what the disk can hold.
+	modTime := roundToGranularity(manifest.ModTime, granularity)
+	return fsys.Chtimes(path, modTime, modTime)
+}
+
+// restoreFromChunkStore reassembles the file described by a manifest at dst,
+// verifying every chunk against its hash.
+func restoreFromChunkStore(manifestPath, dst, outputFolder string, fsys FileSystem) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return newOpError("read manifest", manifestPath, err)
+	}
+	var manifest chunkManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest %q: %w", manifestPath, err)
+	}
+	for _, ref := range manifest.Chunks {
+		if !validChunkHash(ref.Hash) {
+			return fmt.Errorf("corrupt manifest %q: invalid chunk hash %q", manifestPath, ref.Hash)
+		}
+	}
+
+	if err := fsys.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return newOpError("create directory", filepath.Dir(dst), err)
+	}
+	// The file is written to a partial file first, as copyFilePreserve does,
+	// so a failed restore leaves nothing under dst to block a retry.
+	partial := dst + partialSuffix
+	if err := writeChunks(manifest, partial, outputFolder, fsys); err != nil {
+		fsys.Remove(partial)
+		return err
+	}
+	if fileExists(dst) {
+		fsys.Remove(partial)
+		return newOpError("create", dst, os.ErrExist)
+	}
+	if err := fsys.Rename(partial, dst); err != nil {
+		fsys.Remove(partial)
+		return newOpError("rename", partial, err)
+	}
+	return nil
+}
+
+// writeChunks writes the content the chunks of manifest make up to path,
+// checking each chunk on the way, and gives it the manifest's time.
+func writeChunks(manifest chunkManifest, path, outputFolder string, fsys FileSystem) error {
+	out, err := fsys.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, manifest.Mode)
+	if err != nil {
+		return newOpError("create", path, err)
+	}
+	defer out.Close()
+
+	storeRoot := chunkStoreRoot(outputFolder)
+	for _, ref := range manifest.Chunks {
+		chunk, err := os.ReadFile(chunkPath(storeRoot, ref.Hash))
+		if err != nil {
+			return newOpError("read chunk", chunkPath(storeRoot, ref.Hash), err)
+		}
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != ref.Hash {
+			return fmt.Errorf("chunk %s is corrupt", ref.Hash)
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return newOpError("write", path, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return newOpError("write", path, err)
+	}
+	return newOpError("preserve times", path, fsys.Chtimes(path, manifest.ModTime, manifest.ModTime))
+}
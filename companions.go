@@ -0,0 +1,296 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sidecarExts are the extensions of files that describe another file of the
+// same name: edits (XMP, Apple AAE, RawTherapee, DxO), camera thumbnails
+// and subtitles. A sidecar is named like its file, as "photo.xmp", or after
+// its whole name, as "photo.cr2.xmp".
+var sidecarExts = map[string]bool{
+	"xmp": true, "aae": true, "pp3": true, "dop": true, "thm": true,
+	"srt": true, "vtt": true, "ass": true, "ssa": true, "sub": true,
+}
+
+// rawExts are the extensions of camera raw files, which a sidecar named
+// like several files belongs to first: Lightroom writes them for raw files.
+var rawExts = map[string]bool{
+	"raw": true, "cr2": true, "cr3": true, "nef": true, "arw": true,
+	"dng": true, "orf": true, "rw2": true, "raf": true,
+}
+
+// livePhotoExts are the extensions of the photo of a Live Photo, and
+// livePhotoVideoExts those of its video, which has the photo's name.
+var (
+	livePhotoExts      = map[string]bool{"heic": true, "heif": true, "jpg": true, "jpeg": true}
+	livePhotoVideoExts = map[string]bool{"mov": true, "mp4": true}
+)
+
+// companionChainLimit bounds how many leaders are followed up from a
+// companion, as from a subtitle to a Live Photo video to its photo.
+const companionChainLimit = 4
+
+// lowerExt returns the extension of name in lowercase, without the dot.
+func lowerExt(name string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+// companionDir is what the listing of a folder says about companions:
+// files that belong to another file of the folder, its leader, and go
+// wherever it goes. Sidecars belong to the photo or video they describe,
+// and the video of a Live Photo to its photo.
+type companionDir struct {
+	modTime time.Time
+	// files holds the regular files of the folder by name.
+	files map[string]fs.DirEntry
+	// stems lists the names of the files by their name without extension.
+	stems map[string][]string
+	// followers lists the companions of each file that is no companion
+	// itself, in name order.
+	followers map[string][]string
+}
+
+// companionDirs caches the listings of the folders last looked at. A
+// listing is used while the folder keeps its modification time, which
+// changes with every file added or removed.
+var companionDirs = struct {
+	sync.Mutex
+	dirs map[string]*companionDir
+}{dirs: map[string]*companionDir{}}
+
+// companionDirCache is how many listings companionDirs holds.
+const companionDirCache = 64
+
+// listCompanionDir returns the listing of dir, or nil when it cannot be
+// read, from companionDirs when it is still current.
+func listCompanionDir(dir string) *companionDir {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+	companionDirs.Lock()
+	cached := companionDirs.dirs[dir]
+	companionDirs.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) {
+		return cached
+	}
+	listing := readCompanionDir(dir)
+	if listing == nil {
+		return nil
+	}
+	listing.modTime = info.ModTime()
+	companionDirs.Lock()
+	if len(companionDirs.dirs) >= companionDirCache {
+		companionDirs.dirs = map[string]*companionDir{}
+	}
+	companionDirs.dirs[dir] = listing
+	companionDirs.Unlock()
+	return listing
+}
+
+// readCompanionDir lists dir, or returns nil when it cannot be read.
+func readCompanionDir(dir string) *companionDir {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	listing := &companionDir{
+		files:     map[string]fs.DirEntry{},
+		stems:     map[string][]string{},
+		followers: map[string][]string{},
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			name := entry.Name()
+			listing.files[name] = entry
+			stem := strings.TrimSuffix(name, filepath.Ext(name))
+			listing.stems[stem] = append(listing.stems[stem], name)
+		}
+	}
+	for _, entry := range entries {
+		if root, ok := listing.root(entry.Name()); ok {
+			listing.followers[root] = append(listing.followers[root], entry.Name())
+		}
+	}
+	return listing
+}
+
+// leader returns the file of the folder that name is a companion of.
+func (d *companionDir) leader(name string) (string, bool) {
+	if d.files[name] == nil {
+		return "", false
+	}
+	ext := lowerExt(name)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	switch {
+	case sidecarExts[ext]:
+		if d.files[stem] != nil && sidecarRank(lowerExt(stem)) > 0 {
+			return stem, true
+		}
+		return d.best(stem, sidecarRank)
+	case livePhotoVideoExts[ext]:
+		return d.best(stem, func(ext string) int {
+			if livePhotoExts[ext] {
+				return 1
+			}
+			return 0
+		})
+	}
+	return "", false
+}
+
+// root returns the leader that name, a companion, ends up following,
+// through companions of companions.
+func (d *companionDir) root(name string) (string, bool) {
+	root, ok := d.leader(name)
+	for i := 0; ok && i < companionChainLimit; i++ {
+		next, more := d.leader(root)
+		if !more {
+			return root, root != name
+		}
+		root = next
+	}
+	return "", false
+}
+
+// best returns the file named stem with the best rank of its extension,
+// the lowest above 0; ties go to the first name.
+func (d *companionDir) best(stem string, rank func(ext string) int) (string, bool) {
+	best, bestRank := "", 0
+	for _, name := range d.stems[stem] {
+		r := rank(lowerExt(name))
+		if r > 0 && (bestRank == 0 || r < bestRank || r == bestRank && name < best) {
+			best, bestRank = name, r
+		}
+	}
+	return best, bestRank > 0
+}
+
+// sidecarRank ranks the files a sidecar may belong to: raw files first,
+// then other photos, then videos.
+func sidecarRank(ext string) int {
+	switch {
+	case rawExts[ext]:
+		return 1
+	case extCategories[ext] == "images":
+		return 2
+	case extCategories[ext] == "videos":
+		return 3
+	}
+	return 0
+}
+
+// companionLeader returns the file that path is a companion of, following
+// companions of companions, as long as it is still there. Without
+// cfg.Companions files have no leaders.
+func companionLeader(path string, cfg FilesMoveConfiguration) (string, os.FileInfo, bool) {
+	ext := lowerExt(path)
+	if !cfg.Companions || !sidecarExts[ext] && !livePhotoVideoExts[ext] {
+		return "", nil, false
+	}
+	listing := listCompanionDir(filepath.Dir(path))
+	if listing == nil {
+		return "", nil, false
+	}
+	root, ok := listing.root(filepath.Base(path))
+	if !ok {
+		return "", nil, false
+	}
+	leader := filepath.Join(filepath.Dir(path), root)
+	info, err := os.Lstat(leader)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return leader, info, true
+}
+
+// folderFile returns the file whose date and name decide the folder of
+// path: its leader when it has one, see companionLeader, or path itself.
+// A companion so lands beside its leader even when its own date, such as
+// that of a sidecar edited years later, points elsewhere.
+func folderFile(path string, info os.FileInfo, cfg FilesMoveConfiguration) (string, os.FileInfo) {
+	if leader, leaderInfo, ok := companionLeader(path, cfg); ok {
+		return leader, leaderInfo
+	}
+	return path, info
+}
+
+// overriddenFolder looks path up in --overrides, and then dated, the file
+// that decides its folder, see folderFile: a companion goes where its
+// leader was pinned unless it was pinned itself.
+func overriddenFolder(path string, info os.FileInfo, dated string, datedInfo os.FileInfo, cfg FilesMoveConfiguration) (string, bool, error) {
+	pinned, overridden, err := cfg.Overrides.folder(path, info, cfg)
+	if err != nil || overridden || dated == path {
+		return pinned, overridden, err
+	}
+	return cfg.Overrides.folder(dated, datedInfo, cfg)
+}
+
+// walkInputGroups is walkInputFiles for organizing: a file with companions
+// is handed over with them, and its companions are not handed over on
+// their own, so they are placed right after it. With --files-from, or
+// without cfg.Companions, every file is handed over on its own.
+//
+// A folder is listed once, when the walk reaches its first file, and that
+// listing holds while the walk is in it, however many files are moved out
+// of it meanwhile. The walk leaves a folder for good once it reaches a file
+// outside it.
+func walkInputGroups(cfg FilesMoveConfiguration, fn func(task fileTask) error) error {
+	if !cfg.Companions || cfg.FilesFrom != nil {
+		return walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+			return fn(fileTask{path: path, info: info})
+		})
+	}
+	listings := map[string]*companionDir{}
+	return walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		dir, name := filepath.Dir(path), filepath.Base(path)
+		for listed := range listings {
+			if listed != dir && !isBelow(dir, listed) {
+				delete(listings, listed)
+			}
+		}
+		listing, ok := listings[dir]
+		if !ok {
+			listing = readCompanionDir(dir)
+			listings[dir] = listing
+		}
+		if listing == nil {
+			return fn(fileTask{path: path, info: info})
+		}
+		if _, ok := listing.root(name); ok {
+			return nil
+		}
+		task := fileTask{path: path, info: info}
+		followers := listing.followers[name]
+		sort.Strings(followers)
+		for _, follower := range followers {
+			task.companions = append(task.companions, fileTask{
+				path: filepath.Join(dir, follower),
+				info: newLazyFileInfo(listing.files[follower]),
+			})
+		}
+		return fn(task)
+	})
+}
+
+// companionDestination returns where a companion goes once its leader,
+// from leaderSource, was placed at leaderDestination: beside it, with the
+// leader's new name in place of its old one, so a "(1)" given to the
+// leader is given to the companion too.
+func companionDestination(path, leaderSource, leaderDestination string, cfg FilesMoveConfiguration) string {
+	oldStem := strings.TrimSuffix(filepath.Base(leaderSource), filepath.Ext(leaderSource))
+	placed := strings.TrimSuffix(leaderDestination, manifestExt)
+	newStem := strings.TrimSuffix(filepath.Base(placed), filepath.Ext(placed))
+	name := filepath.Base(path)
+	if rest, ok := strings.CutPrefix(name, oldStem); ok {
+		name = newStem + rest
+	}
+	return filepath.Join(filepath.Dir(placed), sanitizeFileName(name, cfg.Capabilities))
+}
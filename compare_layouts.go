@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// layoutStats summarizes what one folder format would produce for the input.
+type layoutStats struct {
+	Format        FolderFormat
+	Files         int
+	Folders       int
+	TotalBytes    int64
+	LargestFolder string
+	LargestCount  int
+}
+
+// compareLayouts plans the same input once per format. cfg must be a dry-run,
+// read-only planning configuration.
+func compareLayouts(formats []FolderFormat, cfg FilesMoveConfiguration) ([]layoutStats, error) {
+	var results []layoutStats
+	for _, format := range formats {
+		if format == AnchorYears && cfg.AnchorDate.IsZero() {
+			return nil, fmt.Errorf("%s needs --anchor-date", format)
+		}
+		formatCfg := cfg
+		formatCfg.FolderFormat = format
+		formatCfg.Summary = newRunSummary()
+		plan, err := buildPlan(formatCfg)
+		if err != nil {
+			return nil, fmt.Errorf("planning with %s: %w", format, err)
+		}
+		results = append(results, summarizeLayout(format, plan))
+	}
+	return results, nil
+}
+
+func summarizeLayout(format FolderFormat, plan *Plan) layoutStats {
+	stats := layoutStats{Format: format}
+	perFolder := map[string]int{}
+	for _, move := range plan.Moves {
+		dir := filepath.Dir(move.Destination)
+		perFolder[dir]++
+		stats.Files++
+		stats.TotalBytes += move.Size
+	}
+	stats.Folders = len(perFolder)
+	for dir, count := range perFolder {
+		if count > stats.LargestCount || (count == stats.LargestCount && dir < stats.LargestFolder) {
+			stats.LargestCount = count
+			stats.LargestFolder = dir
+		}
+	}
+	if rel, err := filepath.Rel(plan.Output, stats.LargestFolder); err == nil {
+		stats.LargestFolder = rel
+	}
+	return stats
+}
+
+// printLayoutComparison writes the comparison as an aligned table.
+func printLayoutComparison(w io.Writer, results []layoutStats) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tFILES\tFOLDERS\tAVG FILES/FOLDER\tTOTAL SIZE\tLARGEST FOLDER")
+	for _, r := range results {
+		avg := 0.0
+		if r.Folders > 0 {
+			avg = float64(r.Files) / float64(r.Folders)
+		}
+		largest := "-"
+		if r.LargestCount > 0 {
+			largest = fmt.Sprintf("%s (%d)", r.LargestFolder, r.LargestCount)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\n", r.Format, r.Files, r.Folders, avg, formatBytes(r.TotalBytes), largest)
+	}
+	tw.Flush()
+}
+
+// parseFolderFormatList parses a comma-separated list of folder format names.
+func parseFolderFormatList(input string) ([]FolderFormat, error) {
+	var formats []FolderFormat
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		format, err := ParseFolderFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, format)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("at least one folder format is required")
+	}
+	return formats, nil
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -3,19 +3,228 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/alexflint/go-arg"
 )
 
+// UndoCommand reverts a previous run using the journal it wrote.
+type UndoCommand struct {
+	Journal string `arg:"positional,required" help:"Path to a journal, .structo/journal-<timestamp>.json in the output folder."`
+}
+
+// PlanCommand records every intended move to a file without touching disk.
+type PlanCommand struct {
+	Out string `arg:"--out,required" help:"Path of the plan file to write (JSON)."`
+}
+
+// ApplyCommand executes plans written by the plan command.
+type ApplyCommand struct {
+	Plans []string `arg:"positional,required" help:"Plan files written by 'structo plan', or folders of them; applied in order as one run."`
+	Only  []string `arg:"--only,separate" help:"Apply only moves matching field~glob or field!~glob, with field src, dest or date, e.g. 'dest~2023/*' (repeatable; all must match)."`
+}
+
+// DiagCommand bundles the last run's artifacts for bug reports.
+type DiagCommand struct {
+	Out              string `arg:"--out" help:"Path of the zip file to write (defaults to structo-diag-<timestamp>.zip)."`
+	IncludeFilenames bool   `arg:"--include-filenames" help:"Keep file and folder names in the bundle instead of redacting them."`
+}
+
+// CompareLayoutsCommand plans the input under several folder formats side by side.
+type CompareLayoutsCommand struct {
+	Formats string `arg:"--formats,required" help:"Comma-separated folder formats to compare, e.g. year-then-quarters,half-years."`
+}
+
+// DedupeCommand finds files with identical content and optionally resolves them.
+type DedupeCommand struct {
+	Action string `arg:"--action" default:"report" help:"What to do with duplicates when --no-dry-run is given: report (nothing), remove or hardlink; removals and relinks are journaled and can be undone."`
+	Mmap   bool   `arg:"--mmap" help:"Hash files through memory maps, fastest on local SSDs; files that cannot be mapped are read as usual."`
+}
+
+// RenameCommand gives files canonical names in place without moving them.
+type RenameCommand struct {
+	Pattern string `arg:"--pattern" default:"{name}{ext}" help:"New file name; placeholders: {name}, {ext}, {date}, {time}, {year}, {month}, {day}, each optionally piped through helpers such as {name|slugify}."`
+}
+
+// TagCommand records the classification of files in place without moving them.
+type TagCommand struct {
+	Store string `arg:"--store" default:"xattr" help:"Where to write the tags: xattr (extended attributes, alternate data streams on Windows) or db (.structo/tags.db in --input)."`
+}
+
+// FlattenCommand pulls every file of a nested tree into one flat folder.
+type FlattenCommand struct {
+	RestorePaths bool `arg:"--restore-paths" help:"Put files back at the paths they had before organizing, as recorded in the journals of --input; files without a record are flattened."`
+}
+
+// WatchCommand keeps running and organizes new files as they arrive.
+type WatchCommand struct {
+	Debounce time.Duration `arg:"--debounce" default:"2s" help:"How long a new file must stay unchanged before it is organized."`
+}
+
+// TestRulesCommand shows where sample files would go under the current settings.
+type TestRulesCommand struct {
+	Sample string `arg:"--sample,required" help:"A folder of sample files, or a text file listing sample paths one per line."`
+}
+
+// ConfigSchemaCommand prints the JSON Schema of config files.
+type ConfigSchemaCommand struct{}
+
+// ConfigMigrateCommand upgrades a config file written for an older release.
+type ConfigMigrateCommand struct {
+	File string `arg:"positional" help:"Config file to upgrade (defaults to --config or the user config file)."`
+	Out  string `arg:"--out" help:"Write the upgraded file here instead of replacing the original (which is kept as .bak)."`
+}
+
+// ConfigCommand groups the config file tools.
+type ConfigCommand struct {
+	Schema  *ConfigSchemaCommand  `arg:"subcommand:schema" help:"Print the JSON Schema of config files, for editor completion and validation."`
+	Migrate *ConfigMigrateCommand `arg:"subcommand:migrate" help:"Upgrade deprecated settings and folder format names in a config file."`
+}
+
+// OrganizeCommand organizes the input, like running without a subcommand.
+type OrganizeCommand struct{}
+
+// VerifyCommand audits an organized tree under the current settings.
+type VerifyCommand struct {
+	Duplicates bool `arg:"--duplicates" help:"Also report files whose content is already in another file of the tree, hashed with --hash."`
+}
+
+// RepairCommand moves the misplaced files of an organized tree into place.
+type RepairCommand struct{}
+
+// AuditCommand checks that every file of a source has a copy in the archive.
+type AuditCommand struct {
+	Source string `arg:"--source,required" help:"The backup or drive that was organized, whose every file must have a copy in --output."`
+	Out    string `arg:"--out" help:"Also write the paths of the missing files here, one per line, for --files-from."`
+}
+
+// SelftestCommand runs a full cycle over a generated tree.
+type SelftestCommand struct {
+	Dir  string `arg:"--dir" help:"Folder to build the test tree in, e.g. on the drive you want to check (defaults to the system temporary folder)."`
+	Keep bool   `arg:"--keep" help:"Keep the test tree and its log afterwards; it is always kept when a check fails."`
+}
+
+// GalleryCommand writes a static HTML gallery of an organized tree.
+type GalleryCommand struct {
+	Dir       string `arg:"positional" help:"Organized folder to show (defaults to --output, then --input)."`
+	Out       string `arg:"--out" help:"Folder to write the gallery to (defaults to .structo-gallery in the organized folder)."`
+	ThumbSize int    `arg:"--thumb-size" default:"320" help:"Longest side of thumbnails, in pixels."`
+}
+
+// SyncCommand mirrors the folders of an organized tree changed since the
+// last sync to a backup.
+type SyncCommand struct {
+	Dir       string `arg:"positional" help:"Organized folder to mirror (defaults to --output, then --input)."`
+	To        string `arg:"--to,required" help:"Folder or rsync destination, such as host:/backup/photos, to mirror to."`
+	Transport string `arg:"--transport" default:"auto" help:"How to copy: rsync, builtin (local folders only) or auto (rsync when installed)."`
+}
+
+// StatsCommand summarizes an organized tree without changing it.
+type StatsCommand struct {
+	Dir string `arg:"positional" help:"Folder to summarize (defaults to --output, then --input)."`
+}
+
 type CommandLineArguments struct {
-	Input             string  `arg:"--input,required" help:"Path to the input folder (required)."`
-	Output            string  `arg:"--output" help:"Path to the output folder (defaults to input folder)."`
-	Lang              string  `arg:"--lang" help:"Language to use (e.g., 'en' for English or 'es' for Spanish; defaults to 'en')."`
-	PreserveStructure bool    `arg:"--preserve-structure" help:"Preserve subfolder structure under the quarter folder."`
-	Before            *string `arg:"--before" help:"Date in YYYY-MM-DD format; files before this date will be processed."`
-	NoDryRun          *bool   `arg:"--no-dry-run" help:"This will make the changes happen."`
-	FolderFormat      *string `arg:"--folder-format" help:"The folder format to use when creating files and directories"`
+	Organize       *OrganizeCommand       `arg:"subcommand:organize" help:"Organize the input into dated folders (the default); e.g. 'structo organize --profile photos'."`
+	Run            *OrganizeCommand       `arg:"subcommand:run" help:"Deprecated name of organize."`
+	Undo           *UndoCommand           `arg:"subcommand:undo" help:"Revert a previous run from its journal."`
+	Plan           *PlanCommand           `arg:"subcommand:plan" help:"Write the intended moves to a plan file without touching disk."`
+	Apply          *ApplyCommand          `arg:"subcommand:apply" help:"Execute one or more plans written by 'structo plan'."`
+	CompareLayouts *CompareLayoutsCommand `arg:"subcommand:compare-layouts" help:"Plan the input under several folder formats and compare the results."`
+	Dedupe         *DedupeCommand         `arg:"subcommand:dedupe" help:"Find files with identical content under --input and report, remove or hardlink them."`
+	Rename         *RenameCommand         `arg:"subcommand:rename" help:"Apply the rename pattern and sanitize rules to files in place, without moving them."`
+	Tag            *TagCommand            `arg:"subcommand:tag" help:"Record the year, quarter, type, event and folder of every file under --input in extended attributes or a database, without moving anything."`
+	Flatten        *FlattenCommand        `arg:"subcommand:flatten" help:"Pull every file under --input into --output itself, undoing a folder structure."`
+	Watch          *WatchCommand          `arg:"subcommand:watch" help:"Stay resident and organize new files under --input as they stabilize."`
+	TestRules      *TestRulesCommand      `arg:"subcommand:test-rules" help:"Print where sample files would go under the current flags and config, without writing anything."`
+	ConfigTools    *ConfigCommand         `arg:"subcommand:config" help:"Config file tools."`
+	Verify         *VerifyCommand         `arg:"subcommand:verify" help:"Check that every file under --output sits in the folder its date leads to, and report undated files and empty folders."`
+	Repair         *RepairCommand         `arg:"subcommand:repair" help:"Move the files verify reports as misplaced into the folders their dates lead to (a dry run unless --no-dry-run)."`
+	Audit          *AuditCommand          `arg:"subcommand:audit" help:"Check that every file of --source has a copy with the same content somewhere under --output, before wiping the source."`
+	Stats          *StatsCommand          `arg:"subcommand:stats" help:"Count the files and bytes of an organized tree per top-level folder and per kind of file."`
+	Gallery        *GalleryCommand        `arg:"subcommand:gallery" help:"Write a static HTML gallery with thumbnails of the images of an organized tree, one page per folder."`
+	Sync           *SyncCommand           `arg:"subcommand:sync" help:"Mirror the folders of an organized tree that changed since the last sync to a backup, with rsync or the built-in copy (a dry run unless --no-dry-run)."`
+	Selftest       *SelftestCommand       `arg:"subcommand:selftest" help:"Organize, verify and undo a generated test tree, to check structo on this system and filesystem."`
+	Diag           *DiagCommand           `arg:"subcommand:diag" help:"Package the last run's log, summary and environment into a zip for bug reports."`
+
+	Config             string        `arg:"--config" help:"YAML or TOML file with default flag values (defaults to the 'structo/config.yaml' user config file); flags override it."`
+	Profile            string        `arg:"--profile" help:"Name of a profile in the config file whose settings apply over the top-level ones."`
+	Input              string        `arg:"--input" help:"Path to the input folder (required)."`
+	Output             string        `arg:"--output" help:"Path to the output folder (defaults to input folder)."`
+	Lang               string        `arg:"--lang" help:"Language to use (e.g., 'en' for English or 'es' for Spanish; defaults to 'en')."`
+	PreserveStructure  bool          `arg:"--preserve-structure" help:"Preserve subfolder structure under the quarter folder."`
+	PreserveDirMode    bool          `arg:"--preserve-dir-mode" help:"With --preserve-structure, also give the recreated subfolders the permissions of the originals, not only their times."`
+	Before             *string       `arg:"--before" help:"Date in YYYY-MM-DD format; files before this date will be processed."`
+	After              *string       `arg:"--after" help:"Date in YYYY-MM-DD format; files from this date on will be processed. Combine with --before for a range."`
+	NoDryRun           *bool         `arg:"--no-dry-run" help:"This will make the changes happen."`
+	IKnowWhatImDoing   bool          `arg:"--i-know-what-im-doing" help:"Allow system folders such as / or C:\\Windows, or your home folder itself, as input or output."`
+	NoWrite            bool          `arg:"--no-write" help:"Hard read-only mode: every filesystem write is refused, even with --no-dry-run."`
+	Copy               bool          `arg:"--copy" help:"Copy files into the output structure and leave the originals untouched (same as --mode copy)."`
+	Mode               *string       `arg:"--mode" help:"How files are placed: move (default), copy, symlink or hardlink."`
+	FolderFormat       *string       `arg:"--folder-format" help:"The folder format to use when creating files and directories"`
+	FolderFormatAlias  []string      `arg:"--folder-format-alias,separate" help:"Define a folder format alias as alias=format (repeatable)."`
+	FolderTemplate     string        `arg:"--folder-template" help:"Folder path under the output built from placeholders and helpers, e.g. '{year}/{ext|extCategory}'; it replaces --folder-format."`
+	Backend            *string       `arg:"--backend" help:"Output backend: 'fs' (default) or the experimental 'chunkstore' (content-addressed, deduplicated)."`
+	OnConflict         *string       `arg:"--on-conflict" help:"What to do when a destination name is taken: compare-hash (default; skip identical files, rename others), rename, skip or overwrite."`
+	YearDataset        string        `arg:"--year-dataset" help:"Create a dataset per year folder: 'btrfs' (subvolume) or 'zfs:<parent-dataset>'."`
+	YearDatasetCmd     string        `arg:"--year-dataset-cmd" help:"Custom command run once per new year folder; {path} and {year} are substituted."`
+	BackupCmd          string        `arg:"--backup-cmd" help:"Command run after a run placed every file, to back up the output folders it changed, e.g. 'restic backup {paths}'; the folders replace {paths}, or are appended without it."`
+	Workers            int           `arg:"--workers" help:"Number of files moved in parallel; by default chosen from the storage type (1 for spinning disks, more for SSD, NVMe and network shares)."`
+	Heatmap            string        `arg:"--heatmap" help:"Write an HTML calendar heatmap of file dates to this path."`
+	SplitThreshold     int           `arg:"--split-threshold" help:"Split a quarter holding more than N files into month folders, and such a month into day folders (0 disables)."`
+	BucketOffset       time.Duration `arg:"--bucket-offset" help:"Shift where days, and with them months and quarters, begin: with '4h', files from before 04:00 count as the previous day; with '-7h', files from after 17:00 count as the next day."`
+	TimeZone           string        `arg:"--timezone" help:"Time zone the folders of files are worked out in: 'local', a name such as 'Europe/Madrid' or 'UTC', or an offset such as '+05:30'; photos with an EXIF or GPS offset are moved into it."`
+	HourFormat         *string       `arg:"--hour-format" help:"How the day-then-hours folder format names hour folders: 24h (default, like '15'), which sort in the order of the day, or ampm (like '03PM'), as earlier releases did."`
+	SchoolYearStart    int           `arg:"--school-year-start" help:"Month (1-12) school years start in, for the school-year folder format; September by default."`
+	AnchorDate         *string       `arg:"--anchor-date" help:"Date in YYYY-MM-DD format that the anchor-years folder format counts years from, e.g. a birth date."`
+	CameraFolders      bool          `arg:"--camera-folders" help:"Put photos in a folder per camera below their date folder, named after the EXIF make and model, e.g. 'Canon_EOS_R6'."`
+	EventGap           time.Duration `arg:"--event-gap" help:"Longest time between two files of the same event, for the events folder format; '4h' by default."`
+	Periods            string        `arg:"--periods" help:"File of named date ranges, one per line like '2022-07-01..2022-07-21 => Japan Trip'; matching files go to that folder instead."`
+	Overrides          string        `arg:"--overrides" help:"CSV file pinning single files to folders, one 'source,folder' row each; the source is a path or 'xxh3:<hash>'. It trumps every other rule."`
+	StrictMetadata     bool          `arg:"--strict-metadata" help:"Fail a file when its timestamps, permissions or attributes cannot be preserved instead of warning."`
+	NoProgress         bool          `arg:"--no-progress" help:"Do not show the progress bar on stderr."`
+	SkipHidden         bool          `arg:"--skip-hidden" help:"Skip hidden files and folders (dotfiles, and the hidden attribute on Windows and macOS)."`
+	IncludeExt         string        `arg:"--include-ext" help:"Comma-separated list of extensions to organize (e.g. 'jpg,heic,mp4'); other files are skipped."`
+	ExcludeExt         string        `arg:"--exclude-ext" help:"Comma-separated list of extensions to skip (e.g. 'tmp,part')."`
+	QuarantineAfter    int           `arg:"--quarantine-after" help:"Move a file that has failed this many runs to the .structo-quarantine folder with its error history (0 disables)."`
+	Rescan             bool          `arg:"--rescan" help:"Plan every file again, ignoring the record of files the last run left settled."`
+	Index              string        `arg:"--index" help:"Database recording where every file was placed, its date source and content hash, kept across runs; files it already placed and content it already holds are skipped."`
+	Incremental        bool          `arg:"--incremental" help:"Skip every source file the --index already processed, by its path, size and modification time, before any other work."`
+	ExifCache          string        `arg:"--exif-cache" help:"Cache of the EXIF dates of parsed images, so they are not parsed again (defaults to structo/exif-cache.db in the user cache folder)."`
+	NoExifCache        bool          `arg:"--no-exif-cache" help:"Parse the EXIF data of every image, without reading or updating the EXIF cache."`
+	Junctions          *string       `arg:"--junctions" help:"What to do with directory junctions and volume mount points in the input on Windows: skip (default), follow or fail."`
+	OutputQuota        string        `arg:"--output-quota" help:"Stop the run before the output folder grows past this size, e.g. '500GB'; its size comes from --index when given."`
+	OutputQuotaWarn    string        `arg:"--output-quota-warn" help:"Warn once the output folder grows past this size, e.g. '450GB'."`
+	MoveOnReboot       bool          `arg:"--move-on-reboot" help:"On Windows, schedule the files still locked by another program at the end of a move for when Windows restarts (needs administrator rights)."`
+	NoHardlinkSets     bool          `arg:"--no-hardlink-sets" help:"Place every hardlink of a file on its own, as a separate copy when it has to be copied, instead of linking them together again at the destination."`
+	NoCompanions       bool          `arg:"--no-companions" help:"Organize sidecars, subtitles and Live Photo videos on their own instead of with the file they belong to."`
+	Trash              bool          `arg:"--trash" help:"When a move has to copy and delete, for example across drives, send the original to the trash instead of deleting it."`
+	PruneEmpty         bool          `arg:"--prune-empty" help:"After a successful run, remove input folders left empty by the files moved out of them."`
+	MinSize            string        `arg:"--min-size" help:"Skip files smaller than this, e.g. '10MB' or '1.5GiB'."`
+	MaxSize            string        `arg:"--max-size" help:"Skip files larger than this, e.g. '4GB'."`
+	Fsync              bool          `arg:"--fsync" help:"Flush every copy to disk before it gets its final name, so it survives a power loss."`
+	Retries            int           `arg:"--retries" help:"Try a file again up to this many times when it fails with a temporary error, such as a locked file or a share that did not answer."`
+	RetryDelay         time.Duration `arg:"--retry-delay" help:"Wait before the first retry, e.g. '2s' (the default); it doubles with every attempt."`
+	KeepGoing          bool          `arg:"--keep-going" help:"Carry on past files that fail, and report them all at the end, instead of stopping at the first one."`
+	NoCopyOffload      bool          `arg:"--no-copy-offload" help:"Always copy through this machine instead of letting the server or storage array copy (NFS server-side copy, SMB copychunk, ODX)."`
+	Hash               *string       `arg:"--hash" help:"Content hash of dedupe and verify --duplicates: xxh3 (default; fastest), sha256 or blake3 (cryptographic, nearly as fast as xxh3 on modern CPUs)."`
+	NoReflink          bool          `arg:"--no-reflink" help:"Always write copies in full instead of cloning files that share a copy-on-write filesystem with their destination."`
+	CopyBuffer         string        `arg:"--copy-buffer" help:"Buffer size of copies that are written in full, e.g. '4MB' (default 1MiB)."`
+	LargeFileThreshold string        `arg:"--large-file-threshold" help:"Copy files of at least this size, e.g. '4GB', with live progress and checkpoints so an interrupted copy resumes."`
+	LargeFileQueue     bool          `arg:"--large-file-queue" help:"Organize files over --large-file-threshold in a separate low-priority queue, after or alongside the small ones."`
+	MinAge             time.Duration `arg:"--min-age" help:"Skip files modified less than this long ago, e.g. '10m', so transfers in progress are left alone."`
+	StabilityCheck     time.Duration `arg:"--stability-check" help:"Skip files whose size or modification time changes within this period, e.g. '5s'."`
+	IncludeGlob        []string      `arg:"--include-glob,separate" help:"Only organize paths, relative to the input, matching this glob; '**' matches any number of folders (repeatable)."`
+	ExcludeGlob        []string      `arg:"--exclude-glob,separate" help:"Skip paths, relative to the input, matching this glob, e.g. 'node_modules/**' (repeatable)."`
+	ExcludeRegex       []string      `arg:"--exclude-regex,separate" help:"Skip paths, relative to the input, matching this regular expression (repeatable)."`
+	ExcludeDir         []string      `arg:"--exclude-dir,separate" help:"Do not descend into folders with this name, or this path relative to the input; globs allowed (repeatable)."`
+	MaxDepth           int           `arg:"--max-depth" help:"Only descend this many folder levels below the input; 1 organizes the input's own files only (0 means no limit)."`
+	FilesFrom          string        `arg:"--files-from" help:"Organize only the files listed in this file, one per line or NUL-separated, instead of walking --input; '-' reads the list from stdin."`
+	DateSource         *string       `arg:"--date-source" help:"Ordered, comma-separated list of date sources to try (exif, filename, chat, mtime, ctime, btime; defaults to 'exif,chat,mtime')."`
 }
 
 type FilesMoveConfiguration struct {
@@ -23,16 +232,199 @@ type FilesMoveConfiguration struct {
 	OutputFolder      string
 	Language          string
 	PreserveStructure bool
-	DryRun            bool
-	Before            *string
-	Logger            *os.File
-	FolderFormat      FolderFormat
+	// PreserveDirMode and DirTimes carry the input folders' permissions and
+	// times over to the folders --preserve-structure recreates; see restoreDirTimes.
+	PreserveDirMode bool
+	DirTimes        *DirTimes
+	DryRun          bool
+	Mode            OrganizeMode
+	Before          *string
+	After           *string
+	Logger          *os.File
+	FS              FileSystem
+	Summary         *RunSummary
+	Journal         *Journal
+	Warnings        []string
+	FolderFormat    FolderFormat
+	DateSources     []DateSource
+	Backend         OutputBackend
+	OnConflict      ConflictPolicy
+	YearDataset     string
+	YearDatasetCmd  string
+	// BackupCmd is run over the output folders a successful run changed.
+	BackupCmd    string
+	Workers      int
+	Capabilities FSCapabilities
+	// Reservations are the destination names this run has chosen, set up
+	// with the capabilities; see Reservations.
+	Reservations   *Reservations
+	Progress       *Progress
+	StrictMetadata bool
+	SplitThreshold int
+	// BucketOffset is subtracted from a file's date before picking its
+	// folder; see bucketDate.
+	BucketOffset time.Duration
+	// TimeZone is the zone dates are put in before picking their folder,
+	// see inTimeZone; nil keeps them as they are read.
+	TimeZone *time.Location
+	// HourFormat names the hour folders of the day-then-hours format.
+	HourFormat HourFormat
+	// SchoolYearStart is the first month of a school year.
+	SchoolYearStart time.Month
+	// AnchorDate is the day the anchor-years format counts from.
+	AnchorDate time.Time
+	// EventGap is the longest time between two files of one event.
+	EventGap time.Duration
+	// CameraFolders adds a folder per camera below the date folders.
+	CameraFolders bool
+	// FolderTemplate, when set, replaces FolderFormat; see createTemplateFolder.
+	FolderTemplate string
+	// Periods are named date ranges that take precedence over FolderFormat.
+	Periods []customPeriod
+	// Overrides pin single files to folders, ahead of every other rule.
+	Overrides *Overrides
+	// IncludeExt and ExcludeExt hold lowercase extensions without the dot.
+	IncludeExt []string
+	ExcludeExt []string
+	// SkipHidden leaves hidden files and folders alone.
+	SkipHidden bool
+	// QuarantineAfter is the number of failures, counted across runs in
+	// Failures, after which a file is quarantined; 0 disables it.
+	QuarantineAfter int
+	Failures        *FailureHistory
+	// Tree remembers the files the last run left settled; Rescan ignores it.
+	Tree   *TreeSnapshot
+	Rescan bool
+	// IndexPath is the --index database, opened into Index by withFileIndex.
+	IndexPath string
+	Index     *FileIndex
+	// Incremental skips the sources Index already processed before anything
+	// else is done with them.
+	Incremental bool
+	// ExifCachePath is the EXIF date cache, opened into ExifCache by
+	// withExifCache; it is empty with --no-exif-cache.
+	ExifCachePath string
+	ExifCache     *ExifCache
+	// Junctions decides what the walk does with directory junctions and
+	// volume mount points; see walkMountPoint.
+	Junctions JunctionPolicy
+	// Locked postpones files locked by other programs to the end of the run;
+	// MoveOnReboot schedules those still locked then. See retryLockedFiles.
+	Locked       *LockedFiles
+	MoveOnReboot bool
+	// QuotaLimit and QuotaWarn are the sizes of --output-quota and
+	// --output-quota-warn in bytes, 0 when not given; Quota keeps the
+	// output within them.
+	QuotaLimit int64
+	QuotaWarn  int64
+	Quota      *OutputQuota
+	// HardlinkSets keeps hardlinks of one file linked at the destination,
+	// through Hardlinks; see withHardlinkSets.
+	HardlinkSets bool
+	Hardlinks    *HardlinkSets
+	// Companions moves sidecars and Live Photo videos with the files they
+	// belong to; see walkInputGroups.
+	Companions bool
+	// MinSize and MaxSize bound file sizes in bytes; a MaxSize of 0 means no limit.
+	MinSize int64
+	MaxSize int64
+	// Hash is the content hash that tells files apart; HashMmap hashes them
+	// through memory maps. See hashFile.
+	Hash     HashAlgorithm
+	HashMmap bool
+	// ConflictHash compares a file with the one holding its destination
+	// name: SHA-256 unless --hash picks another. See sameContent.
+	ConflictHash HashAlgorithm
+	// Fsync flushes copies to disk before they are renamed into place.
+	Fsync bool
+	// Retries and RetryDelay try files again after temporary errors; see transferWithRetries.
+	Retries    int
+	RetryDelay time.Duration
+	// KeepGoing carries on past failed files; they are reported at the end.
+	KeepGoing bool
+	// Trash sends the originals of moves done by copying to the trash.
+	Trash bool
+	// PruneEmpty removes input folders emptied by the run; see pruneEmptySources.
+	PruneEmpty bool
+	// NoCopyOffload disables server-side copies; see offloadFile.
+	NoCopyOffload bool
+	// NoReflink disables copy-on-write clones; see reflinkFile.
+	NoReflink bool
+	// CopyBuffer is the buffer size, in bytes, of copies written in full; see copyBuffered.
+	CopyBuffer int
+	// LargeFileThreshold, in bytes, marks files copied with checkpoints and
+	// progress; 0 disables it. LargeFileQueue defers them behind small files.
+	LargeFileThreshold int64
+	LargeFileQueue     bool
+	// MinAge and StabilityCheck keep files that are still being written in place.
+	MinAge         time.Duration
+	StabilityCheck time.Duration
+	// Stability holds the files --stability-check saw settle, see
+	// withStabilityCheck.
+	Stability *FileStability
+	// Path patterns match paths relative to InputFolder, with forward slashes.
+	IncludeGlobs   []string
+	ExcludeGlobs   []string
+	ExcludeRegexps []*regexp.Regexp
+	// FilesFrom, when not nil, replaces the walk of InputFolder with these
+	// paths; see walkListedFiles.
+	FilesFrom []string
+	// ExcludeDirs and MaxDepth prune the walk itself; see isExcludedDir.
+	ExcludeDirs []string
+	MaxDepth    int
+	// Dates holds the dates resolved before placing anything, when Density
+	// or Events needed them.
+	Dates *CollectedDates
+	// Density holds per-period file counts when SplitThreshold is set.
+	Density *periodDensity
+	// Events holds the events of the files under the events format.
+	Events *eventClusters
+	// ConfigFile is the config file the arguments were read from, if any.
+	ConfigFile string
+	// Profile is the config file profile in use, if any.
+	Profile string
+	// SyncTo is where sync mirrors the tree; run records keep it so the next
+	// sync to the same place knows when the last one finished.
+	SyncTo string
 }
 
-func parseArgs() (FilesMoveConfiguration, error) {
+// parseCommandLine reads the config file, if any, then the command line on top
+// of it, exiting with usage on malformed input.
+func parseCommandLine() CommandLineArguments {
 	var args CommandLineArguments
-	arg.MustParse(&args)
+	path := rawFlagValue(os.Args[1:], "config")
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	profile := rawFlagValue(os.Args[1:], "profile")
+	if profile != "" && path == "" {
+		fmt.Fprintf(os.Stderr, "error: --profile %q needs a config file\n", profile)
+		os.Exit(exitFatal)
+	}
+	// The config tools read files that may not load yet, such as one to migrate.
+	if path != "" && !isConfigToolsCommand(os.Args[1:]) {
+		if err := loadConfigFile(path, profile, &args); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid config file: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	// go-arg exits with -1 on usage errors; those are configuration errors too.
+	parser, err := arg.NewParser(arg.Config{Exit: func(code int) {
+		if code != 0 {
+			code = exitFatal
+		}
+		os.Exit(code)
+	}}, &args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	parser.MustParse(os.Args[1:])
+	args.Config = path
+	return args
+}
 
+func parseArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
 	if args.Input == "" {
 		return FilesMoveConfiguration{}, fmt.Errorf("invalid folders: input=%q, output=%q", args.Input, args.Output)
 	}
@@ -40,11 +432,12 @@ func parseArgs() (FilesMoveConfiguration, error) {
 	if args.Output == "" {
 		args.Output = args.Input
 	}
-
-	if args.Lang == "" {
-		args.Lang = "en"
+	if err := checkSystemFolders(args); err != nil {
+		return FilesMoveConfiguration{}, err
 	}
 
+	args.Lang = langOrDefault(args.Lang)
+
 	var before *string
 	if args.Before != nil {
 		parsedDate, err := validateDate(*args.Before)
@@ -53,32 +446,439 @@ func parseArgs() (FilesMoveConfiguration, error) {
 		}
 		before = &parsedDate
 	}
+	var after *string
+	if args.After != nil {
+		parsedDate, err := validateDate(*args.After)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid date format for 'after': %v", err)
+		}
+		after = &parsedDate
+	}
+	if before != nil && after != nil && *after >= *before {
+		return FilesMoveConfiguration{}, fmt.Errorf("empty date range: --after %s is not earlier than --before %s", *after, *before)
+	}
 
 	noDryRun := false
 	if args.NoDryRun != nil {
 		noDryRun = *args.NoDryRun
 	}
 
+	aliases, err := parseFolderFormatAliases(args.FolderFormatAlias)
+	if err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid folder format alias: %v", err)
+	}
+
+	var warnings []string
 	folderFormat := YearThenQuarters
-	var err error = nil
 	if args.FolderFormat != nil {
-		folderFormat, err = ParseFolderFormat(*args.FolderFormat)
+		var warning string
+		folderFormat, warning, err = resolveFolderFormat(*args.FolderFormat, aliases)
 		if err != nil {
 			return FilesMoveConfiguration{}, fmt.Errorf("invalid folder format: %v", err)
 		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	folderTemplate := filepath.ToSlash(args.FolderTemplate)
+	if folderTemplate != "" {
+		if err := validateFolderTemplate(folderTemplate); err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --folder-template: %v", err)
+		}
+	}
+
+	timeZone, err := parseTimeZone(args.TimeZone)
+	if err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --timezone: %v", err)
+	}
+	anchorZone := time.Local
+	if timeZone != nil {
+		anchorZone = timeZone
+	}
+	var anchorDate time.Time
+	if args.AnchorDate != nil {
+		anchorDate, err = time.ParseInLocation("2006-01-02", *args.AnchorDate, anchorZone)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --anchor-date: %v", err)
+		}
+	}
+	if folderFormat == AnchorYears && anchorDate.IsZero() {
+		return FilesMoveConfiguration{}, fmt.Errorf("--folder-format %s needs --anchor-date", folderFormat)
+	}
+
+	var periods []customPeriod
+	if args.Periods != "" {
+		periods, err = loadPeriods(args.Periods)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --periods: %v", err)
+		}
+	}
+	var overrides *Overrides
+	if args.Overrides != "" {
+		overrides, err = loadOverrides(args.Overrides, args.Input, args.Output)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --overrides: %v", err)
+		}
+	}
+
+	dateSources := defaultDateSources
+	if args.DateSource != nil {
+		dateSources, err = ParseDateSources(*args.DateSource)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid date source: %v", err)
+		}
+	}
+
+	backend := BackendFilesystem
+	if args.Backend != nil {
+		backend, err = ParseOutputBackend(*args.Backend)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid backend: %v", err)
+		}
+	}
+
+	hashAlgorithm, err := parseHashArg(args.Hash)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	conflictHash := HashSHA256
+	if args.Hash != nil {
+		conflictHash = hashAlgorithm
+	}
+
+	onConflict := ConflictCompareHash
+	if args.OnConflict != nil {
+		onConflict, err = ParseConflictPolicy(*args.OnConflict)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid conflict policy: %v", err)
+		}
+	}
+
+	junctions := JunctionSkip
+	if args.Junctions != nil {
+		junctions, err = ParseJunctionPolicy(*args.Junctions)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --junctions: %v", err)
+		}
+	}
+
+	hourFormat := Hour24
+	if args.HourFormat != nil {
+		hourFormat, err = ParseHourFormat(*args.HourFormat)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --hour-format: %v", err)
+		}
+	}
+
+	mode := ModeMove
+	if args.Copy {
+		mode = ModeCopy
+	}
+	if args.Mode != nil {
+		mode, err = ParseOrganizeMode(*args.Mode)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid mode: %v", err)
+		}
+		if args.Copy && mode != ModeCopy {
+			return FilesMoveConfiguration{}, fmt.Errorf("--copy conflicts with --mode %s", mode)
+		}
+	}
+	if mode.IsLink() && backend == BackendChunkStore {
+		return FilesMoveConfiguration{}, fmt.Errorf("--mode %s is not supported by the %s backend", mode, backend)
+	}
+	if mode.IsLink() && args.Output == args.Input {
+		return FilesMoveConfiguration{}, fmt.Errorf("--mode %s needs an --output folder separate from --input", mode)
+	}
+
+	if args.SplitThreshold < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid split threshold: %d must not be negative", args.SplitThreshold)
+	}
+	if args.MoveOnReboot && runtime.GOOS != "windows" {
+		warnings = append(warnings, "--move-on-reboot only works on Windows and is ignored here")
+	} else if args.MoveOnReboot && (mode != ModeMove || backend != BackendFilesystem) {
+		warnings = append(warnings, fmt.Sprintf("--move-on-reboot only applies to moves and is ignored with --mode %s", mode))
+	}
+	if args.SplitThreshold > 0 && folderFormat != YearThenQuarters {
+		warnings = append(warnings, fmt.Sprintf("--split-threshold only refines the %q format and is ignored for %q", YearThenQuarters, folderFormat))
+	}
+
+	includeExt, err := parseExtensionList(args.IncludeExt)
+	if err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --include-ext: %v", err)
+	}
+	excludeExt, err := parseExtensionList(args.ExcludeExt)
+	if err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --exclude-ext: %v", err)
+	}
+
+	quotaLimit, quotaWarn, err := parseQuotas(args.OutputQuota, args.OutputQuotaWarn)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	if (quotaLimit > 0 || quotaWarn > 0) && mode.IsLink() {
+		warnings = append(warnings, fmt.Sprintf("links take no space, so --output-quota does not count the files placed with --mode %s", mode))
+	}
+	minSize, maxSize, err := parseSizeLimits(args.MinSize, args.MaxSize)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	var largeFileThreshold int64
+	if args.LargeFileThreshold != "" {
+		if largeFileThreshold, err = parseByteSize(args.LargeFileThreshold); err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --large-file-threshold: %v", err)
+		}
+	}
+	if args.Retries < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --retries: %d must not be negative", args.Retries)
+	}
+	if args.RetryDelay < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --retry-delay: %s must not be negative", args.RetryDelay)
+	}
+	copyBuffer := defaultCopyBuffer
+	if args.CopyBuffer != "" {
+		size, err := parseByteSize(args.CopyBuffer)
+		if err != nil {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --copy-buffer: %v", err)
+		}
+		if size < 4<<10 || size > 1<<30 {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --copy-buffer: %s must be between 4KiB and 1GiB", args.CopyBuffer)
+		}
+		copyBuffer = int(size)
+	}
+	if args.Incremental && args.Index == "" {
+		return FilesMoveConfiguration{}, fmt.Errorf("--incremental needs --index")
+	}
+	if args.LargeFileQueue && largeFileThreshold == 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("--large-file-queue needs --large-file-threshold")
+	}
+	if args.QuarantineAfter < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid quarantine threshold: %d must not be negative", args.QuarantineAfter)
+	}
+	schoolYearStart := defaultSchoolYearStart
+	if args.SchoolYearStart != 0 {
+		if args.SchoolYearStart < 1 || args.SchoolYearStart > 12 {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --school-year-start %d: must be a month from 1 to 12", args.SchoolYearStart)
+		}
+		schoolYearStart = time.Month(args.SchoolYearStart)
+	}
+	eventGap := defaultEventGap
+	if args.EventGap != 0 {
+		if args.EventGap < 0 {
+			return FilesMoveConfiguration{}, fmt.Errorf("invalid --event-gap %s: must not be negative", args.EventGap)
+		}
+		eventGap = args.EventGap
+	}
+	if args.BucketOffset <= -24*time.Hour || args.BucketOffset >= 24*time.Hour {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --bucket-offset %s: must be less than 24h either way", args.BucketOffset)
+	}
+	if args.MinAge < 0 || args.StabilityCheck < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("--min-age and --stability-check must not be negative")
+	}
+
+	if err := validateGlobs(args.IncludeGlob); err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --include-glob: %v", err)
+	}
+	if err := validateGlobs(args.ExcludeGlob); err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --exclude-glob: %v", err)
+	}
+	if err := validateGlobs(args.ExcludeDir); err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --exclude-dir: %v", err)
+	}
+	var filesFrom []string
+	if args.FilesFrom != "" {
+		if filesFrom, err = readFileList(args.FilesFrom); err != nil {
+			return FilesMoveConfiguration{}, err
+		}
+	}
+	if args.MaxDepth < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid max depth: %d must not be negative", args.MaxDepth)
+	}
+	excludeRegexps, err := compileRegexps(args.ExcludeRegex)
+	if err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid --exclude-regex: %v", err)
+	}
+
+	workers := args.Workers
+	if workers < 0 {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid workers: %d must not be negative", workers)
+	}
+
+	if err := validateYearDataset(args.YearDataset); err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid year dataset: %v", err)
 	}
 
 	return FilesMoveConfiguration{
-		InputFolder:       args.Input,
-		OutputFolder:      args.Output,
-		Language:          args.Lang,
-		PreserveStructure: args.PreserveStructure,
-		DryRun:            !noDryRun,
-		Before:            before,
-		FolderFormat:      folderFormat,
+		InputFolder:        args.Input,
+		OutputFolder:       args.Output,
+		Language:           args.Lang,
+		PreserveStructure:  args.PreserveStructure,
+		PreserveDirMode:    args.PreserveDirMode,
+		DryRun:             !noDryRun,
+		Mode:               mode,
+		Before:             before,
+		After:              after,
+		FolderFormat:       folderFormat,
+		FolderTemplate:     folderTemplate,
+		DateSources:        dateSources,
+		Backend:            backend,
+		OnConflict:         onConflict,
+		Hash:               hashAlgorithm,
+		ConflictHash:       conflictHash,
+		FS:                 newFileSystem(args.NoWrite),
+		Summary:            newRunSummary(),
+		YearDataset:        args.YearDataset,
+		YearDatasetCmd:     args.YearDatasetCmd,
+		BackupCmd:          args.BackupCmd,
+		Warnings:           warnings,
+		Workers:            workers,
+		Capabilities:       defaultCapabilities(),
+		StrictMetadata:     args.StrictMetadata,
+		SplitThreshold:     args.SplitThreshold,
+		IncludeExt:         includeExt,
+		ExcludeExt:         excludeExt,
+		SkipHidden:         args.SkipHidden,
+		QuarantineAfter:    args.QuarantineAfter,
+		Rescan:             args.Rescan,
+		IndexPath:          args.Index,
+		Incremental:        args.Incremental,
+		ExifCachePath:      exifCachePath(args),
+		Companions:         !args.NoCompanions,
+		HardlinkSets:       !args.NoHardlinkSets,
+		MoveOnReboot:       args.MoveOnReboot,
+		QuotaLimit:         quotaLimit,
+		QuotaWarn:          quotaWarn,
+		Junctions:          junctions,
+		Trash:              args.Trash,
+		PruneEmpty:         args.PruneEmpty,
+		MinSize:            minSize,
+		MaxSize:            maxSize,
+		Fsync:              args.Fsync,
+		NoCopyOffload:      args.NoCopyOffload,
+		NoReflink:          args.NoReflink,
+		CopyBuffer:         copyBuffer,
+		Retries:            args.Retries,
+		RetryDelay:         retryDelayOrDefault(args.RetryDelay),
+		KeepGoing:          args.KeepGoing,
+		LargeFileThreshold: largeFileThreshold,
+		LargeFileQueue:     args.LargeFileQueue,
+		MinAge:             args.MinAge,
+		BucketOffset:       args.BucketOffset,
+		TimeZone:           timeZone,
+		HourFormat:         hourFormat,
+		SchoolYearStart:    schoolYearStart,
+		AnchorDate:         anchorDate,
+		EventGap:           eventGap,
+		CameraFolders:      args.CameraFolders,
+		Periods:            periods,
+		Overrides:          overrides,
+		StabilityCheck:     args.StabilityCheck,
+		IncludeGlobs:       args.IncludeGlob,
+		ExcludeGlobs:       args.ExcludeGlob,
+		FilesFrom:          filesFrom,
+		ExcludeDirs:        args.ExcludeDir,
+		MaxDepth:           args.MaxDepth,
+		ExcludeRegexps:     excludeRegexps,
+		ConfigFile:         args.Config,
+		Profile:            args.Profile,
 	}, nil
 }
 
+// checkSystemFolders refuses system folders as input or output unless
+// --i-know-what-im-doing is given.
+func checkSystemFolders(args CommandLineArguments) error {
+	if args.IKnowWhatImDoing {
+		return nil
+	}
+	if err := checkSystemPath("input", args.Input); err != nil {
+		return err
+	}
+	if args.Output == "" {
+		return nil
+	}
+	return checkSystemPath("output", args.Output)
+}
+
+// langOrDefault returns lang, or English when no language was given.
+func langOrDefault(lang string) string {
+	if lang == "" {
+		return "en"
+	}
+	return lang
+}
+
+// retryDelayOrDefault returns delay, or defaultRetryDelay when none was given.
+func retryDelayOrDefault(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return defaultRetryDelay
+	}
+	return delay
+}
+
+// parseSizeLimits parses --min-size and --max-size; empty means no limit.
+func parseSizeLimits(minArg, maxArg string) (int64, int64, error) {
+	var minSize, maxSize int64
+	var err error
+	if minArg != "" {
+		if minSize, err = parseByteSize(minArg); err != nil {
+			return 0, 0, fmt.Errorf("invalid --min-size: %v", err)
+		}
+	}
+	if maxArg != "" {
+		if maxSize, err = parseByteSize(maxArg); err != nil {
+			return 0, 0, fmt.Errorf("invalid --max-size: %v", err)
+		}
+		if maxSize == 0 {
+			return 0, 0, fmt.Errorf("invalid --max-size: %q must be larger than zero", maxArg)
+		}
+		if maxSize < minSize {
+			return 0, 0, fmt.Errorf("--max-size %s is smaller than --min-size %s", maxArg, minArg)
+		}
+	}
+	return minSize, maxSize, nil
+}
+
+// parseQuotas parses --output-quota and --output-quota-warn; empty means
+// none.
+func parseQuotas(limitArg, warnArg string) (int64, int64, error) {
+	var limit, warn int64
+	var err error
+	if limitArg != "" {
+		if limit, err = parseByteSize(limitArg); err != nil || limit == 0 {
+			return 0, 0, fmt.Errorf("invalid --output-quota: %q must be a size larger than zero", limitArg)
+		}
+	}
+	if warnArg != "" {
+		if warn, err = parseByteSize(warnArg); err != nil || warn == 0 {
+			return 0, 0, fmt.Errorf("invalid --output-quota-warn: %q must be a size larger than zero", warnArg)
+		}
+		if limit > 0 && warn >= limit {
+			return 0, 0, fmt.Errorf("--output-quota-warn %s must be smaller than --output-quota %s", warnArg, limitArg)
+		}
+	}
+	return limit, warn, nil
+}
+
+// parseExtensionList splits a comma-separated list such as "jpg,.HEIC, mp4"
+// into lowercase extensions without the leading dot.
+func parseExtensionList(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	var exts []string
+	for _, item := range strings.Split(list, ",") {
+		ext := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(item), "."))
+		if ext == "" {
+			return nil, fmt.Errorf("empty extension in %q", list)
+		}
+		if strings.ContainsAny(ext, `/\`) {
+			return nil, fmt.Errorf("%q is not an extension", item)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
 func validateDate(dateStr string) (string, error) {
 	const layout = "2006-01-02"
 	_, err := time.Parse(layout, dateStr)
@@ -87,3 +887,342 @@ func validateDate(dateStr string) (string, error) {
 	}
 	return dateStr, nil
 }
+
+// parsePlanArgs builds a planning configuration: always a dry run on a read-only
+// filesystem, whatever flags were given.
+func parsePlanArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.DryRun = true
+	cfg.FS = newFileSystem(true)
+	return cfg, nil
+}
+
+// parseVerifyArgs builds a read-only configuration for verify.
+func parseVerifyArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	args, err := organizedTreeArgs(args, "verify")
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	return parsePlanArgs(args)
+}
+
+// parseRepairArgs builds the configuration for repair, which reorganizes the
+// tree under --output in place; it accepts the same flags as organizing.
+func parseRepairArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	args, err := organizedTreeArgs(args, "repair")
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, validateRepairConfig(cfg)
+}
+
+// organizedTreeArgs points --input at --output for commands that work on an
+// organized tree, which is both walked and the root of the expected folders.
+func organizedTreeArgs(args CommandLineArguments, command string) (CommandLineArguments, error) {
+	if args.Output == "" {
+		args.Output = args.Input
+	}
+	if args.Output == "" {
+		return args, fmt.Errorf("%s needs --output", command)
+	}
+	args.Output = filepath.Clean(args.Output)
+	args.Input = args.Output
+	return args, nil
+}
+
+// parseTestRulesArgs builds a read-only configuration for test-rules. The
+// sample folder stands in for --input when none is configured.
+func parseTestRulesArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	if args.Input == "" {
+		args.Input = args.TestRules.Sample
+		if info, err := os.Stat(args.Input); err == nil && !info.IsDir() {
+			args.Input = filepath.Dir(args.Input)
+		}
+	}
+	return parsePlanArgs(args)
+}
+
+// parseDedupeArgs builds the dedupe configuration. Dry runs use a read-only
+// filesystem so the report can never change anything.
+func parseDedupeArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	if args.Input == "" {
+		return FilesMoveConfiguration{}, fmt.Errorf("dedupe needs --input")
+	}
+	switch args.Dedupe.Action {
+	case DedupeActionReport, DedupeActionRemove, DedupeActionHardlink:
+	default:
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid dedupe action %q: expected report, remove or hardlink", args.Dedupe.Action)
+	}
+	if err := checkSystemFolders(args); err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	hashAlgorithm, err := parseHashArg(args.Hash)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	cfg := parseRecordedRunArgs(args, args.Input, args.Input)
+	cfg.Hash = hashAlgorithm
+	cfg.HashMmap = args.Dedupe.Mmap
+	cfg.Workers = args.Workers
+	if cfg.DryRun {
+		cfg.FS = newFileSystem(true)
+	}
+	return cfg, nil
+}
+
+// parseAuditArgs builds a read-only configuration for audit. The source and
+// the archive may not hold one another, since a file would then confirm
+// itself.
+func parseAuditArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	if args.Output == "" {
+		return FilesMoveConfiguration{}, fmt.Errorf("audit needs --output")
+	}
+	source, err := filepath.Abs(args.Audit.Source)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	output, err := filepath.Abs(args.Output)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	if source == output || isBelow(source, output) || isBelow(output, source) {
+		return FilesMoveConfiguration{}, fmt.Errorf("audit needs --source and --output to be separate folders, got %s and %s", source, output)
+	}
+	hashAlgorithm, err := parseHashArg(args.Hash)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+	cfg := parseRecordedRunArgs(args, source, output)
+	cfg.Hash = hashAlgorithm
+	cfg.Workers = args.Workers
+	cfg.DryRun = true
+	cfg.FS = newFileSystem(true)
+	return cfg, nil
+}
+
+// parseHashArg parses --hash; XXH3 is the default.
+func parseHashArg(value *string) (HashAlgorithm, error) {
+	if value == nil {
+		return HashXXH3, nil
+	}
+	algo, err := ParseHashAlgorithm(*value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --hash: %v", err)
+	}
+	return algo, nil
+}
+
+// parseRenameArgs builds the configuration for renaming in place: the output
+// folder is the input folder, and files never leave their directory.
+func parseRenameArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	if err := validateRenamePattern(args.Rename.Pattern); err != nil {
+		return FilesMoveConfiguration{}, fmt.Errorf("invalid rename pattern: %v", err)
+	}
+	args.Output = args.Input
+	return parseArgs(args)
+}
+
+// parseTagArgs builds the configuration for tagging in place: like rename, the
+// output folder is the input folder, which the folders in the tags are
+// relative to.
+func parseTagArgs(args CommandLineArguments) (FilesMoveConfiguration, TagStore, error) {
+	store, err := ParseTagStore(args.Tag.Store)
+	if err != nil {
+		return FilesMoveConfiguration{}, 0, fmt.Errorf("invalid --store: %v", err)
+	}
+	args.Output = args.Input
+	cfg, err := parseArgs(args)
+	return cfg, store, err
+}
+
+// parseFlattenArgs builds the configuration for flattening; it accepts the same
+// flags as organizing, with --output as the flat folder.
+func parseFlattenArgs(args CommandLineArguments) (FilesMoveConfiguration, error) {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, validateFlattenConfig(cfg)
+}
+
+// parseApplyArgs builds the configuration for applying a plan; folders come from the plan.
+func parseApplyArgs(args CommandLineArguments, plan *Plan) FilesMoveConfiguration {
+	return parseRecordedRunArgs(args, plan.Input, plan.Output)
+}
+
+// parseUndoArgs builds the configuration used to revert a journal. The output
+// folder is taken from the journal so logs land next to the organized files.
+func parseUndoArgs(args CommandLineArguments, journal *Journal) FilesMoveConfiguration {
+	return parseRecordedRunArgs(args, journal.Input, journal.Output)
+}
+
+// exifCachePath returns the EXIF cache to use, or "" with --no-exif-cache.
+func exifCachePath(args CommandLineArguments) string {
+	if args.NoExifCache {
+		return ""
+	}
+	if args.ExifCache != "" {
+		return args.ExifCache
+	}
+	return defaultExifCachePath()
+}
+
+// parseRecordedRunArgs builds the configuration shared by commands that replay a
+// recorded run. Where and how files go comes from the record, so only the
+// flags that govern the run itself apply, such as the language, write-safety,
+// retries and --keep-going; the fields set below are the full list.
+func parseRecordedRunArgs(args CommandLineArguments, input, output string) FilesMoveConfiguration {
+	lang := langOrDefault(args.Lang)
+	noDryRun := args.NoDryRun != nil && *args.NoDryRun
+	return FilesMoveConfiguration{
+		InputFolder:    input,
+		OutputFolder:   output,
+		Language:       lang,
+		DryRun:         !noDryRun,
+		FS:             newFileSystem(args.NoWrite),
+		Capabilities:   defaultCapabilities(),
+		ConflictHash:   HashSHA256,
+		Summary:        newRunSummary(),
+		StrictMetadata: args.StrictMetadata,
+		PruneEmpty:     args.PruneEmpty,
+		IndexPath:      args.Index,
+		HardlinkSets:   !args.NoHardlinkSets,
+		BackupCmd:      args.BackupCmd,
+		Retries:        args.Retries,
+		RetryDelay:     retryDelayOrDefault(args.RetryDelay),
+		KeepGoing:      args.KeepGoing,
+		ConfigFile:     args.Config,
+		Profile:        args.Profile,
+	}
+}
+
+// ConfigSnapshot is the part of the configuration recorded with each run.
+type ConfigSnapshot struct {
+	Input             string   `json:"input"`
+	Output            string   `json:"output"`
+	Language          string   `json:"language"`
+	FolderFormat      string   `json:"folder_format"`
+	FolderTemplate    string   `json:"folder_template,omitempty"`
+	Mode              string   `json:"mode"`
+	Backend           string   `json:"backend"`
+	OnConflict        string   `json:"on_conflict"`
+	DateSources       []string `json:"date_sources"`
+	DryRun            bool     `json:"dry_run"`
+	PreserveStructure bool     `json:"preserve_structure"`
+	Before            string   `json:"before,omitempty"`
+	After             string   `json:"after,omitempty"`
+	StrictMetadata    bool     `json:"strict_metadata"`
+	SkipHidden        bool     `json:"skip_hidden,omitempty"`
+	IncludeExt        []string `json:"include_ext,omitempty"`
+	ExcludeExt        []string `json:"exclude_ext,omitempty"`
+	MinSize           int64    `json:"min_size,omitempty"`
+	MaxSize           int64    `json:"max_size,omitempty"`
+	BucketOffset      string   `json:"bucket_offset,omitempty"`
+	TimeZone          string   `json:"timezone,omitempty"`
+	HourFormat        string   `json:"hour_format,omitempty"`
+	SchoolYearStart   int      `json:"school_year_start,omitempty"`
+	SplitThreshold    int      `json:"split_threshold,omitempty"`
+	AnchorDate        string   `json:"anchor_date,omitempty"`
+	EventGap          string   `json:"event_gap,omitempty"`
+	CameraFolders     bool     `json:"camera_folders,omitempty"`
+	Periods           []string `json:"periods,omitempty"`
+	Overrides         []string `json:"overrides,omitempty"`
+	MinAge            string   `json:"min_age,omitempty"`
+	StabilityCheck    string   `json:"stability_check,omitempty"`
+	IncludeGlob       []string `json:"include_glob,omitempty"`
+	ExcludeGlob       []string `json:"exclude_glob,omitempty"`
+	ExcludeRegex      []string `json:"exclude_regex,omitempty"`
+	ExcludeDir        []string `json:"exclude_dir,omitempty"`
+	MaxDepth          int      `json:"max_depth,omitempty"`
+	ConfigFile        string   `json:"config_file,omitempty"`
+	SyncTo            string   `json:"sync_to,omitempty"`
+	Profile           string   `json:"profile,omitempty"`
+	NoCompanions      bool     `json:"no_companions,omitempty"`
+	NoHardlinkSets    bool     `json:"no_hardlink_sets,omitempty"`
+	Junctions         string   `json:"junctions,omitempty"`
+}
+
+func snapshotConfig(cfg FilesMoveConfiguration) ConfigSnapshot {
+	snapshot := ConfigSnapshot{
+		Input:             cfg.InputFolder,
+		Output:            cfg.OutputFolder,
+		Language:          cfg.Language,
+		FolderFormat:      cfg.FolderFormat.String(),
+		FolderTemplate:    cfg.FolderTemplate,
+		Mode:              cfg.Mode.String(),
+		Backend:           cfg.Backend.String(),
+		OnConflict:        cfg.OnConflict.String(),
+		DryRun:            cfg.DryRun,
+		PreserveStructure: cfg.PreserveStructure,
+		StrictMetadata:    cfg.StrictMetadata,
+		SkipHidden:        cfg.SkipHidden,
+		IncludeExt:        cfg.IncludeExt,
+		ExcludeExt:        cfg.ExcludeExt,
+		MinSize:           cfg.MinSize,
+		MaxSize:           cfg.MaxSize,
+		IncludeGlob:       cfg.IncludeGlobs,
+		ExcludeGlob:       cfg.ExcludeGlobs,
+		ExcludeDir:        cfg.ExcludeDirs,
+		MaxDepth:          cfg.MaxDepth,
+		SplitThreshold:    cfg.SplitThreshold,
+		ConfigFile:        cfg.ConfigFile,
+		Profile:           cfg.Profile,
+		SyncTo:            cfg.SyncTo,
+		NoCompanions:      !cfg.Companions,
+		NoHardlinkSets:    !cfg.HardlinkSets,
+	}
+	for _, source := range cfg.DateSources {
+		snapshot.DateSources = append(snapshot.DateSources, source.String())
+	}
+	if cfg.Before != nil {
+		snapshot.Before = *cfg.Before
+	}
+	if cfg.After != nil {
+		snapshot.After = *cfg.After
+	}
+	if cfg.Junctions != JunctionSkip {
+		snapshot.Junctions = cfg.Junctions.String()
+	}
+	// Earlier runs named hour folders ampm without recording it, so it is
+	// the one format left out.
+	if cfg.FolderFormat == DayThenHours && cfg.HourFormat != HourAMPM {
+		snapshot.HourFormat = cfg.HourFormat.String()
+	}
+	if cfg.FolderFormat == SchoolYears {
+		snapshot.SchoolYearStart = int(cfg.SchoolYearStart)
+	}
+	for _, period := range cfg.Periods {
+		snapshot.Periods = append(snapshot.Periods, period.String())
+	}
+	snapshot.Overrides = cfg.Overrides.entries()
+	if cfg.FolderFormat == AnchorYears {
+		snapshot.AnchorDate = cfg.AnchorDate.Format("2006-01-02")
+	}
+	if cfg.FolderFormat == Events {
+		snapshot.EventGap = cfg.EventGap.String()
+	}
+	snapshot.CameraFolders = cfg.CameraFolders
+	if cfg.BucketOffset != 0 {
+		snapshot.BucketOffset = cfg.BucketOffset.String()
+	}
+	if cfg.TimeZone != nil {
+		snapshot.TimeZone = cfg.TimeZone.String()
+	}
+	if cfg.MinAge > 0 {
+		snapshot.MinAge = cfg.MinAge.String()
+	}
+	if cfg.StabilityCheck > 0 {
+		snapshot.StabilityCheck = cfg.StabilityCheck.String()
+	}
+	for _, re := range cfg.ExcludeRegexps {
+		snapshot.ExcludeRegex = append(snapshot.ExcludeRegex, re.String())
+	}
+	return snapshot
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// defaultConfigNames are looked up, in order, in the user config directory
+// ($XDG_CONFIG_HOME/structo on Linux) when --config is not given.
+var defaultConfigNames = []string{"config.yaml", "config.yml", "config.toml"}
+
+// rawFlagValue finds --name in the raw arguments. It runs before go-arg so the
+// config file can pre-populate the arguments that flags then override.
+func rawFlagValue(argv []string, name string) string {
+	for i, a := range argv {
+		if a == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			return value
+		}
+		if a == "--"+name && i+1 < len(argv) {
+			return argv[i+1]
+		}
+	}
+	return ""
+}
+
+// isConfigToolsCommand reports whether argv runs "structo config ...".
+func isConfigToolsCommand(argv []string) bool {
+	for i, a := range argv {
+		if a == "--" {
+			return false
+		}
+		if a == "config" && (i == 0 || !strings.HasPrefix(argv[i-1], "--") || strings.Contains(argv[i-1], "=")) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultConfigPath returns the first existing default config file, if any.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range defaultConfigNames {
+		path := filepath.Join(dir, "structo", name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfigFile reads a YAML or TOML file whose keys are flag names without
+// the dashes, e.g. "folder-format: year-then-quarters", into args. The file is
+// validated first, so every problem is reported with its line and column.
+// Settings of the named profile, if any, are applied over the top-level ones.
+func loadConfigFile(path, profile string, args *CommandLineArguments) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := parseConfigDocument(path, data, strings.EqualFold(filepath.Ext(path), ".toml"))
+	if err != nil {
+		return err
+	}
+	if err := validateConfigDocument(path, doc); err != nil {
+		return err
+	}
+	if err := applyConfigEntries(path, doc.Entries, args); err != nil {
+		return err
+	}
+	if profile == "" {
+		return nil
+	}
+	var names []string
+	for _, p := range doc.Profiles {
+		if p.Name == profile {
+			return applyConfigEntries(path, p.Entries, args)
+		}
+		names = append(names, p.Name)
+	}
+	return fmt.Errorf("%s: unknown profile %q (available: %s)", path, profile, strings.Join(names, ", "))
+}
+
+func applyConfigEntries(path string, entries []configEntry, args *CommandLineArguments) error {
+	fields := configurableFields(args)
+	for _, entry := range entries {
+		if err := setConfigField(fields[entry.Key], entry.Value); err != nil {
+			return fmt.Errorf("%s:%d:%d: %w", path, entry.Line, entry.Column, err)
+		}
+	}
+	return nil
+}
+
+// configurableFields maps setting names to the fields of args they set.
+func configurableFields(args *CommandLineArguments) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	v := reflect.ValueOf(args).Elem()
+	for _, setting := range configSettings() {
+		fields[setting.Name] = v.Field(setting.index)
+	}
+	return fields
+}
+
+func setConfigField(field reflect.Value, value any) error {
+	if field.Kind() == reflect.Pointer {
+		elem := reflect.New(field.Type().Elem())
+		if err := setConfigField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+	switch field.Interface().(type) {
+	case time.Duration:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration like \"2s\", got %v", value)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %v", value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		switch n := value.(type) {
+		case int:
+			field.SetInt(int64(n))
+		case int64:
+			field.SetInt(n)
+		default:
+			return fmt.Errorf("expected a whole number, got %v", value)
+		}
+	case reflect.Slice:
+		list, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected a list, got %v", value)
+		}
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected a list of strings, got %v", item)
+			}
+			strs = append(strs, s)
+		}
+		field.Set(reflect.ValueOf(strs))
+	default:
+		return fmt.Errorf("cannot be set from a config file")
+	}
+	return nil
+}
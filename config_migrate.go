@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configEdit replaces Old at Offset in a config file with New. An empty New
+// with a whole line as Old removes that line.
+type configEdit struct {
+	Offset int
+	Old    string
+	New    string
+	Line   int
+	Column int
+	Note   string
+}
+
+// configMigration is the result of upgrading one config file.
+type configMigration struct {
+	Data  []byte
+	Edits []configEdit
+}
+
+// migrateConfig rewrites settings that older releases accepted into their
+// current form: keys spelled with underscores, deprecated folder format names
+// (also as alias targets) and the --copy flag, now --mode copy. Comments and
+// layout are kept because only the affected text is replaced.
+func migrateConfig(path string, data []byte) (configMigration, error) {
+	isTOML := strings.EqualFold(filepath.Ext(path), ".toml")
+	doc, err := parseConfigDocument(path, data, isTOML)
+	if err != nil {
+		return configMigration{}, err
+	}
+	names := map[string]bool{}
+	for _, setting := range configSettings() {
+		names[setting.Name] = true
+	}
+
+	var edits []configEdit
+	sections := [][]configEntry{doc.Entries}
+	for _, profile := range doc.Profiles {
+		sections = append(sections, profile.Entries)
+	}
+	inheritsCopy := false
+	for i, entries := range sections {
+		values := map[string]any{}
+		for _, entry := range entries {
+			values[canonicalConfigKey(entry.Key, names)] = entry.Value
+		}
+		if i == 0 {
+			inheritsCopy = values["copy"] == true && values["mode"] == nil
+		}
+		for _, entry := range entries {
+			key := canonicalConfigKey(entry.Key, names)
+			if key == "copy" {
+				mode, _ := values["mode"].(string)
+				edits = append(edits, migrateCopySetting(data, entry, mode, i > 0 && inheritsCopy, isTOML)...)
+				continue
+			}
+			if key != entry.Key {
+				edits = appendEdit(edits, data, entry.KeyLine, entry.KeyColumn, entry.Key, key,
+					fmt.Sprintf("renamed setting %q to %q", entry.Key, key))
+			}
+			switch key {
+			case "folder-format":
+				if name, ok := entry.Value.(string); ok {
+					if format, deprecated := deprecatedStateName[name]; deprecated {
+						edits = appendEdit(edits, data, entry.Line, entry.Column, name, format.String(),
+							fmt.Sprintf("replaced deprecated folder format %q with %q", name, format))
+					}
+				}
+			case "folder-format-alias":
+				for _, pair := range stringItems(entry.Value) {
+					alias, target, ok := strings.Cut(pair, "=")
+					format, deprecated := deprecatedStateName[strings.TrimSpace(target)]
+					if !ok || !deprecated {
+						continue
+					}
+					updated := alias + "=" + format.String()
+					edits = appendEdit(edits, data, entry.Line, entry.Column, pair, updated,
+						fmt.Sprintf("replaced deprecated folder format in alias %q with %q", pair, updated))
+				}
+			}
+		}
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Offset > edits[j].Offset })
+	out := bytes.Clone(data)
+	for _, edit := range edits {
+		out = append(out[:edit.Offset:edit.Offset], append([]byte(edit.New), out[edit.Offset+len(edit.Old):]...)...)
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Offset < edits[j].Offset })
+	return configMigration{Data: out, Edits: edits}, nil
+}
+
+// canonicalConfigKey maps a key spelled with underscores or capitals, such as
+// "folder_format", to its setting name when the key itself is not one.
+func canonicalConfigKey(key string, names map[string]bool) string {
+	if names[key] {
+		return key
+	}
+	if dashed := strings.ToLower(strings.ReplaceAll(key, "_", "-")); names[dashed] {
+		return dashed
+	}
+	return key
+}
+
+// migrateCopySetting turns "copy: true" into "mode: copy". A disabled copy is
+// dropped, unless it overrides a copying top level in a profile, where it
+// becomes "mode: move". A copy next to a different mode is left for the user.
+func migrateCopySetting(data []byte, entry configEntry, mode string, inheritsCopy, isTOML bool) []configEdit {
+	enabled, _ := entry.Value.(bool)
+	value := "copy"
+	switch {
+	case enabled && mode != "" && mode != value:
+		return []configEdit{{
+			Offset: positionOffset(data, entry.KeyLine, entry.KeyColumn),
+			Line:   entry.KeyLine, Column: entry.KeyColumn,
+			Note: fmt.Sprintf("left %q in place: it conflicts with mode %q; remove one of them", entry.Key, mode),
+		}}
+	case !enabled && mode == "" && inheritsCopy:
+		value = "move"
+	case !enabled || mode != "":
+		start := positionOffset(data, entry.KeyLine, 1)
+		end := len(data)
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		return []configEdit{{
+			Offset: start, Old: string(data[start:end]),
+			Line: entry.KeyLine, Column: 1,
+			Note: fmt.Sprintf("removed %q, which has no effect here", entry.Key),
+		}}
+	}
+	var edits []configEdit
+	edits = appendEdit(edits, data, entry.KeyLine, entry.KeyColumn, entry.Key, "mode",
+		fmt.Sprintf("replaced %q with mode %q", entry.Key, value))
+	if isTOML {
+		value = strconv.Quote(value)
+	}
+	return appendEdit(edits, data, entry.Line, entry.Column, fmt.Sprint(enabled), value, "")
+}
+
+// appendEdit locates old at or after line:column and records its replacement.
+// Values may be quoted or sit later in a list, so the first match wins.
+func appendEdit(edits []configEdit, data []byte, line, column int, old, replacement, note string) []configEdit {
+	start := positionOffset(data, line, column)
+	i := bytes.Index(data[start:], []byte(old))
+	if i < 0 {
+		return edits
+	}
+	line, column = offsetPosition(data, start+i)
+	return append(edits, configEdit{
+		Offset: start + i, Old: old, New: replacement,
+		Line: line, Column: column, Note: note,
+	})
+}
+
+// positionOffset converts a 1-based line and column into a byte offset.
+func positionOffset(data []byte, line, column int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(data[offset:], '\n')
+		if i < 0 {
+			return len(data)
+		}
+		offset += i + 1
+	}
+	return min(offset+max(column-1, 0), len(data))
+}
+
+// stringItems returns the strings of a decoded list value.
+func stringItems(value any) []string {
+	list, _ := value.([]any)
+	var items []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// writeConfigMigration writes the upgraded file to out, or over path after
+// keeping the original as path.bak when out is empty, and returns where it went.
+// Both go through fsys, so --no-write refuses them.
+func writeConfigMigration(fsys FileSystem, path, out string, migration configMigration) (string, error) {
+	if out == "" {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return "", newOpError("read config", path, err)
+		}
+		if err := fsys.WriteFile(path+".bak", original, 0644); err != nil {
+			return "", newOpError("back up config", path+".bak", err)
+		}
+		out = path
+	}
+	return out, newOpError("write config", out, fsys.WriteFile(out, migration.Data, 0644))
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configSetting describes one config file key, derived from its flag.
+type configSetting struct {
+	Name  string
+	Kind  string
+	Help  string
+	Enum  []string
+	index int
+}
+
+// configEntry is one top-level setting read from a config file, with the
+// positions of its key and value for error messages.
+type configEntry struct {
+	Key       string
+	Value     any
+	KeyLine   int
+	KeyColumn int
+	Line      int
+	Column    int
+}
+
+// configProfile is a named set of settings applied over the top-level ones.
+type configProfile struct {
+	Name    string
+	Entries []configEntry
+}
+
+// configDocument is a parsed config file.
+type configDocument struct {
+	Entries  []configEntry
+	Profiles []configProfile
+}
+
+// profilesKey holds the named profiles in a config file.
+const profilesKey = "profiles"
+
+// configEnums lists the accepted values of settings with a fixed set of names.
+func configEnums() map[string][]string {
+	return map[string][]string{
+		"mode":        sortedKeys(reverseModeName),
+		"backend":     sortedKeys(reverseBackendName),
+		"on-conflict": sortedKeys(reverseConflictPolicyName),
+		"junctions":   sortedKeys(reverseJunctionPolicyName),
+		"hour-format": sortedKeys(reverseHourFormatName),
+		"lang":        supportedLanguages,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configSettings describes every setting a config file may contain, in flag
+// order. Subcommands, --config and --profile cannot be set from a file.
+func configSettings() []configSetting {
+	var settings []configSetting
+	enums := configEnums()
+	t := reflect.TypeOf(CommandLineArguments{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("arg"), ",")
+		name, ok := strings.CutPrefix(name, "--")
+		if !ok || name == "config" || name == "profile" {
+			continue
+		}
+		settings = append(settings, configSetting{
+			Name:  name,
+			Kind:  settingKind(field.Type),
+			Help:  field.Tag.Get("help"),
+			Enum:  enums[name],
+			index: i,
+		})
+	}
+	return settings
+}
+
+func settingKind(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return "duration"
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int:
+		return "integer"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+// configJSONSchema returns a JSON Schema for config files, for editor completion
+// and validation of structo.yaml.
+func configJSONSchema() ([]byte, error) {
+	properties := map[string]any{}
+	for _, setting := range configSettings() {
+		property := map[string]any{"description": setting.Help}
+		switch setting.Kind {
+		case "array":
+			property["type"] = "array"
+			property["items"] = map[string]any{"type": "string"}
+		case "duration":
+			property["type"] = "string"
+			property["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+		default:
+			property["type"] = setting.Kind
+		}
+		if setting.Enum != nil {
+			property["enum"] = setting.Enum
+		}
+		if setting.Name == "date-source" {
+			names := strings.Join(sortedKeys(reverseDateSourceName), "|")
+			property["pattern"] = fmt.Sprintf(`^\s*(%s)(\s*,\s*(%s))*\s*$`, names, names)
+		}
+		properties[setting.Name] = property
+	}
+	settings := map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           properties,
+	}
+	topLevel := map[string]any{profilesKey: map[string]any{
+		"description":          "Named profiles, selected with --profile; each overrides the top-level settings.",
+		"type":                 "object",
+		"additionalProperties": map[string]any{"$ref": "#/$defs/settings"},
+	}}
+	for name, property := range properties {
+		topLevel[name] = property
+	}
+	schema := map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "structo configuration",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           topLevel,
+		"$defs":                map[string]any{"settings": settings},
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(schema)
+	return buf.Bytes(), err
+}
+
+// parseConfigDocument decodes a YAML or TOML config file into its settings and
+// profiles, keeping line and column information.
+func parseConfigDocument(path string, data []byte, isTOML bool) (configDocument, error) {
+	if isTOML {
+		return parseTOMLDocument(path, data)
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return configDocument{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(node.Content) == 0 {
+		return configDocument{}, nil
+	}
+	root := node.Content[0]
+	var doc configDocument
+	var profiles *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == profilesKey {
+			profiles = root.Content[i+1]
+			root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+			break
+		}
+	}
+	entries, err := yamlEntries(path, root)
+	if err != nil {
+		return doc, err
+	}
+	doc.Entries = entries
+	if profiles == nil {
+		return doc, nil
+	}
+	if profiles.Kind != yaml.MappingNode {
+		return doc, fmt.Errorf("%s:%d:%d: %s expects a mapping of profile names to settings", path, profiles.Line, profiles.Column, profilesKey)
+	}
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		entries, err := yamlEntries(path, profiles.Content[i+1])
+		if err != nil {
+			return doc, err
+		}
+		doc.Profiles = append(doc.Profiles, configProfile{Name: profiles.Content[i].Value, Entries: entries})
+	}
+	return doc, nil
+}
+
+// yamlEntries reads the settings of one YAML mapping.
+func yamlEntries(path string, node *yaml.Node) ([]configEntry, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d:%d: expected a mapping of settings", path, node.Line, node.Column)
+	}
+	var entries []configEntry
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var decoded any
+		if err := value.Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("%s:%d:%d: %w", path, value.Line, value.Column, err)
+		}
+		entries = append(entries, configEntry{
+			Key: key.Value, Value: decoded,
+			KeyLine: key.Line, KeyColumn: key.Column,
+			Line: value.Line, Column: value.Column,
+		})
+	}
+	return entries, nil
+}
+
+// parseTOMLDocument reads top-level settings and [profiles.<name>] tables.
+func parseTOMLDocument(path string, data []byte) (configDocument, error) {
+	values := map[string]any{}
+	if _, err := toml.Decode(string(data), &values); err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			line, column := offsetPosition(data, parseErr.Position.Start)
+			message := parseErr.Message
+			if message == "" {
+				message = parseErr.Error()
+			}
+			return configDocument{}, fmt.Errorf("%s:%d:%d: %s", path, line, column, message)
+		}
+		return configDocument{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var doc configDocument
+	profiles, hasProfiles := values[profilesKey]
+	delete(values, profilesKey)
+	// Top-level keys come before the first table header.
+	topEnd := len(data)
+	if loc := regexp.MustCompile(`(?m)^[ \t]*\[`).FindIndex(data); loc != nil {
+		topEnd = loc[0]
+	}
+	doc.Entries = tomlEntries(data[:topEnd], 0, data, values)
+	if !hasProfiles {
+		return doc, nil
+	}
+	tables, ok := profiles.(map[string]any)
+	if !ok {
+		return doc, fmt.Errorf("%s: %s expects [%s.<name>] tables", path, profilesKey, profilesKey)
+	}
+	for _, name := range sortedKeys(tables) {
+		settings, ok := tables[name].(map[string]any)
+		if !ok {
+			return doc, fmt.Errorf("%s: profile %q expects a table of settings", path, name)
+		}
+		header := regexp.MustCompile(`(?m)^[ \t]*\[[ \t]*` + profilesKey + `\.` + regexp.QuoteMeta(name) + `[ \t]*\]`)
+		start := 0
+		if loc := header.FindIndex(data); loc != nil {
+			start = loc[1]
+		}
+		doc.Profiles = append(doc.Profiles, configProfile{Name: name, Entries: tomlEntries(data[start:], start, data, settings)})
+	}
+	return doc, nil
+}
+
+// tomlEntries turns decoded values into entries, locating each key within
+// section, which starts at offset in data.
+func tomlEntries(section []byte, offset int, data []byte, values map[string]any) []configEntry {
+	var entries []configEntry
+	for key, value := range values {
+		entry := configEntry{Key: key, Value: value}
+		if keyAt, valueAt, ok := tomlKeyOffsets(section, key); ok {
+			entry.KeyLine, entry.KeyColumn = offsetPosition(data, offset+keyAt)
+			entry.Line, entry.Column = offsetPosition(data, offset+valueAt)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Line < entries[j].Line })
+	return entries
+}
+
+// offsetPosition converts a byte offset into a 1-based line and column.
+func offsetPosition(data []byte, offset int) (int, int) {
+	offset = min(max(offset, 0), len(data))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// tomlKeyOffsets finds where key and its value start in section; the TOML
+// decoder does not report positions itself.
+func tomlKeyOffsets(section []byte, key string) (int, int, bool) {
+	pattern := regexp.MustCompile(`(?m)^[ \t]*("?` + regexp.QuoteMeta(key) + `"?)[ \t]*=[ \t]*`)
+	loc := pattern.FindSubmatchIndex(section)
+	if loc == nil {
+		return 0, 0, false
+	}
+	return loc[2], loc[1], true
+}
+
+// validateConfigDocument checks the top-level settings and every profile.
+func validateConfigDocument(path string, doc configDocument) error {
+	errs := []error{validateConfigEntries(path, doc.Entries)}
+	for _, profile := range doc.Profiles {
+		errs = append(errs, validateConfigEntries(path, profile.Entries))
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfigEntries checks every entry against the settings and reports
+// all problems at once, each prefixed with path:line:column.
+func validateConfigEntries(path string, entries []configEntry) error {
+	settings := map[string]configSetting{}
+	var names []string
+	for _, setting := range configSettings() {
+		settings[setting.Name] = setting
+		names = append(names, setting.Name)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		setting, ok := settings[entry.Key]
+		if !ok {
+			problem := fmt.Sprintf("unknown setting %q", entry.Key)
+			if suggestion := closestName(entry.Key, names); suggestion != "" {
+				problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			errs = append(errs, fmt.Errorf("%s:%d:%d: %s", path, entry.KeyLine, entry.KeyColumn, problem))
+			continue
+		}
+		if problem := checkSettingValue(setting, entry.Value); problem != "" {
+			errs = append(errs, fmt.Errorf("%s:%d:%d: %s", path, entry.Line, entry.Column, problem))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSettingValue returns a description of what is wrong with value, or "".
+func checkSettingValue(setting configSetting, value any) string {
+	switch setting.Kind {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("%s expects true or false, got %s", setting.Name, describeValue(value))
+		}
+	case "integer":
+		switch value.(type) {
+		case int, int64:
+		default:
+			return fmt.Sprintf("%s expects a whole number, got %s", setting.Name, describeValue(value))
+		}
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			return fmt.Sprintf("%s expects a list, got %s", setting.Name, describeValue(value))
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return fmt.Sprintf("%s expects a list of strings, got %s", setting.Name, describeValue(item))
+			}
+		}
+	case "duration":
+		s, ok := value.(string)
+		if _, err := time.ParseDuration(s); !ok || err != nil {
+			return fmt.Sprintf("%s expects a duration like \"2s\", got %s", setting.Name, describeValue(value))
+		}
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("%s expects a string, got %s", setting.Name, describeValue(value))
+		}
+		if setting.Enum != nil && !containsString(setting.Enum, s) {
+			return fmt.Sprintf("%s must be one of %s, got %q", setting.Name, strings.Join(setting.Enum, ", "), s)
+		}
+		switch setting.Name {
+		case "date-source":
+			if _, err := ParseDateSources(s); err != nil {
+				return fmt.Sprintf("date-source: %v", err)
+			}
+		case "before", "after":
+			if _, err := validateDate(s); err != nil {
+				return fmt.Sprintf("%s expects a YYYY-MM-DD date, got %q", setting.Name, s)
+			}
+		}
+	}
+	return ""
+}
+
+// describeValue quotes strings so "3" and 3 are told apart in messages.
+func describeValue(value any) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closestName suggests the setting a typo most likely meant, if any is close.
+func closestName(name string, names []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range names {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ConflictPolicy decides what happens when a file's destination name is
+// already taken.
+type ConflictPolicy int
+
+const (
+	// ConflictCompareHash skips files whose content is already there and
+	// places others under a "(1)" name. It is the zero value, so plans
+	// written before the policy existed keep this behavior.
+	ConflictCompareHash ConflictPolicy = iota
+	ConflictRename
+	ConflictSkip
+	ConflictOverwrite
+)
+
+const (
+	ConflictNameCompareHash = "compare-hash"
+	ConflictNameRename      = "rename"
+	ConflictNameSkip        = "skip"
+	ConflictNameOverwrite   = "overwrite"
+)
+
+var conflictPolicyName = map[ConflictPolicy]string{
+	ConflictCompareHash: ConflictNameCompareHash,
+	ConflictRename:      ConflictNameRename,
+	ConflictSkip:        ConflictNameSkip,
+	ConflictOverwrite:   ConflictNameOverwrite,
+}
+
+var reverseConflictPolicyName = map[string]ConflictPolicy{
+	ConflictNameCompareHash: ConflictCompareHash,
+	ConflictNameRename:      ConflictRename,
+	ConflictNameSkip:        ConflictSkip,
+	ConflictNameOverwrite:   ConflictOverwrite,
+}
+
+// String returns the string representation of ConflictPolicy.
+func (p ConflictPolicy) String() string {
+	return conflictPolicyName[p]
+}
+
+// ParseConflictPolicy parses a string into a ConflictPolicy.
+func ParseConflictPolicy(input string) (ConflictPolicy, error) {
+	if policy, ok := reverseConflictPolicyName[input]; ok {
+		return policy, nil
+	}
+	return 0, fmt.Errorf("invalid ConflictPolicy: %s", input)
+}
+
+// conflictSkippedError reports that a destination name was taken and
+// --on-conflict skip left the source where it is.
+type conflictSkippedError struct {
+	Source   string
+	Existing string
+}
+
+func (e *conflictSkippedError) Error() string {
+	return fmt.Sprintf("%q was not placed: %q already exists", e.Source, e.Existing)
+}
+
+// resolveDestination picks where src goes when dst may be taken, following
+// cfg.OnConflict. The chosen name is reserved for the rest of the run.
+// Overwrite never replaces a file placed earlier in the same run, which would
+// lose it; such clashes get a "(1)" name instead.
+func resolveDestination(src, dst string, cfg FilesMoveConfiguration) (string, error) {
+	switch cfg.OnConflict {
+	case ConflictRename:
+		return ensureUniquePath("", dst, cfg)
+	case ConflictSkip:
+		if cfg.Reservations.reserve(dst) {
+			return dst, nil
+		}
+		return "", &conflictSkippedError{Source: src, Existing: dst}
+	case ConflictOverwrite:
+		if cfg.Reservations.claim(dst) {
+			if _, err := os.Lstat(dst); err == nil {
+				log.Printf(locMsg("conflict_overwrite", cfg.Language), dst, src)
+				if err := setAside(dst, cfg); err != nil {
+					cfg.Reservations.release(dst)
+					return "", err
+				}
+			}
+			return dst, nil
+		}
+		return ensureUniquePath("", dst, cfg)
+	default:
+		return ensureUniquePath(src, dst, cfg)
+	}
+}
+
+// overwrittenDirName is the folder of the metadata folder keeping the files
+// --on-conflict overwrite replaced, so that undo can put them back.
+const overwrittenDirName = "overwritten"
+
+// setAside moves the file at dst, about to be overwritten, into the
+// overwritten folder under the time of the run and its path in the output,
+// and keeps where it went for the journal entry of the file replacing it.
+// A dry run moves nothing.
+func setAside(dst string, cfg FilesMoveConfiguration) error {
+	if cfg.DryRun {
+		return nil
+	}
+	started := time.Now()
+	if cfg.Journal != nil {
+		started = cfg.Journal.StartedAt
+	}
+	rel, ok := relBelow(cfg.OutputFolder, dst)
+	if !ok {
+		rel = filepath.Base(dst)
+	}
+	aside := filepath.Join(metadataDir(cfg.OutputFolder), overwrittenDirName, started.Format(journalTimestamp), filepath.FromSlash(rel))
+	if err := cfg.FS.MkdirAll(filepath.Dir(aside), 0755); err != nil {
+		return newOpError("create overwritten folder", filepath.Dir(aside), err)
+	}
+	// Overwriting the same name twice in a run sets both files aside.
+	aside, err := ensureUniquePath("", aside, cfg)
+	if err != nil {
+		return err
+	}
+	if err := cfg.FS.Rename(dst, aside); err != nil {
+		return newOpError("set aside overwritten file", dst, err)
+	}
+	cfg.Reservations.keepAside(dst, aside)
+	return nil
+}
+
+// abandonDestination gives up dst after placing a file there failed: the
+// file an overwrite set aside goes back, and the name is released.
+func abandonDestination(dst string, cfg FilesMoveConfiguration) {
+	if aside := cfg.Reservations.takeAside(dst); aside != "" {
+		if err := cfg.FS.Rename(aside, dst); err != nil {
+			log.Printf(locMsg("overwritten_restore_error", cfg.Language), aside, dst, err)
+		}
+	}
+	cfg.Reservations.release(dst)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// errNoOffload reports that a copy cannot be handed to the storage here; the
+// caller falls back to copying the bytes itself.
+var errNoOffload = errors.New("copy offload is not supported")
+
+// offloadFile asks the operating system to copy src to dst so that, between
+// shares that support it, the data is copied by the server or storage array
+// (NFS server-side copy, SMB copychunk, ODX) instead of through this machine.
+// It reports false, leaving nothing at dst, when the copy cannot be offloaded.
+func offloadFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.NoCopyOffload || cfg.FS.ReadOnly() {
+		return false, nil
+	}
+	err := offloadCopy(ctx, src, dst, info.Size(), cfg)
+	if errors.Is(err, errNoOffload) {
+		return false, nil
+	}
+	if err != nil {
+		cfg.FS.Remove(dst)
+		return false, newOpError("copy", dst, err)
+	}
+	return true, nil
+}
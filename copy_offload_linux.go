@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// offloadChunk is the most copy_file_range is asked for at once, so a
+// cancellation is noticed between chunks.
+const offloadChunk = 64 << 20
+
+// offloadCopy copies src to dst with copy_file_range(2), which NFS 4.2 and
+// SMB mounts turn into a server-side copy.
+func offloadCopy(ctx context.Context, src, dst string, size int64, cfg FilesMoveConfiguration) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errNoOffload
+	}
+	defer srcFile.Close()
+	dstFile, err := cfg.FS.Create(dst)
+	if err != nil {
+		return errNoOffload
+	}
+	defer dstFile.Close()
+
+	_, err = offloadRange(ctx, dstFile, srcFile, size)
+	if errors.Is(err, errNoOffload) {
+		dstFile.Close()
+		cfg.FS.Remove(dst)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
+
+// offloadRange copies n bytes from src to dst at their current offsets. It
+// returns errNoOffload, having copied nothing, when the filesystems involved
+// do not support copy_file_range between them.
+func offloadRange(ctx context.Context, dst, src *os.File, n int64) (int64, error) {
+	var copied int64
+	for copied < n {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		written, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(min(n-copied, offloadChunk)), 0)
+		if err != nil {
+			if copied == 0 && isOffloadUnsupported(err) {
+				return 0, errNoOffload
+			}
+			return copied, err
+		}
+		if written == 0 {
+			return copied, errors.New("file shrank while being copied")
+		}
+		copied += int64(written)
+	}
+	return copied, nil
+}
+
+func isOffloadUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EBADF) || errors.Is(err, unix.EPERM)
+}
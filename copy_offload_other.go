@@ -0,0 +1,17 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"context"
+	"os"
+)
+
+// offloadCopy is not supported on this platform; files are copied as usual.
+func offloadCopy(ctx context.Context, src, dst string, size int64, cfg FilesMoveConfiguration) error {
+	return errNoOffload
+}
+
+func offloadRange(ctx context.Context, dst, src *os.File, n int64) (int64, error) {
+	return 0, errNoOffload
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const copyFileFailIfExists = 0x1
+
+var procCopyFileExW = windows.NewLazySystemDLL("kernel32.dll").NewProc("CopyFileExW")
+
+// offloadCopy copies src to dst with CopyFileExW, which uses ODX or SMB
+// copychunk whenever both ends support them.
+func offloadCopy(ctx context.Context, src, dst string, size int64, cfg FilesMoveConfiguration) error {
+	if procCopyFileExW.Find() != nil {
+		return errNoOffload
+	}
+	srcPtr, err := windows.UTF16PtrFromString(src)
+	if err != nil {
+		return errNoOffload
+	}
+	dstPtr, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return errNoOffload
+	}
+
+	// CopyFileExW polls cancel and gives up, removing dst, once it is set.
+	var cancel int32
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&cancel, 1)
+		case <-done:
+		}
+	}()
+
+	ok, _, callErr := procCopyFileExW.Call(
+		uintptr(unsafe.Pointer(srcPtr)), uintptr(unsafe.Pointer(dstPtr)),
+		0, 0, uintptr(unsafe.Pointer(&cancel)), copyFileFailIfExists)
+	if ok != 0 {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var errno windows.Errno
+	if errors.As(callErr, &errno) && (errno == windows.ERROR_NOT_SUPPORTED || errno == windows.ERROR_CALL_NOT_IMPLEMENTED) {
+		return errNoOffload
+	}
+	return &os.PathError{Op: "CopyFileEx", Path: dst, Err: callErr}
+}
+
+// offloadRange is not available on Windows, whose copy offload works on
+// whole files only.
+func offloadRange(ctx context.Context, dst, src *os.File, n int64) (int64, error) {
+	return 0, errNoOffload
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DateSource int
+
+const (
+	DateSourceExif DateSource = iota
+	DateSourceFilename
+	DateSourceMtime
+	DateSourceCtime
+	DateSourceBtime
+	DateSourceChat
+)
+
+const (
+	SourceExif     = "exif"
+	SourceFilename = "filename"
+	SourceMtime    = "mtime"
+	SourceCtime    = "ctime"
+	SourceBtime    = "btime"
+	SourceChat     = "chat"
+)
+
+var dateSourceName = map[DateSource]string{
+	DateSourceExif:     SourceExif,
+	DateSourceFilename: SourceFilename,
+	DateSourceMtime:    SourceMtime,
+	DateSourceCtime:    SourceCtime,
+	DateSourceBtime:    SourceBtime,
+	DateSourceChat:     SourceChat,
+}
+
+var reverseDateSourceName = map[string]DateSource{
+	SourceExif:     DateSourceExif,
+	SourceFilename: DateSourceFilename,
+	SourceMtime:    DateSourceMtime,
+	SourceCtime:    DateSourceCtime,
+	SourceBtime:    DateSourceBtime,
+	SourceChat:     DateSourceChat,
+}
+
+// defaultDateSources tries EXIF for images, then mtime, with the names of
+// WhatsApp and Telegram media in between, since their mtimes are when they
+// were saved. Before the chat source the default was EXIF, then mtime.
+var defaultDateSources = []DateSource{DateSourceExif, DateSourceChat, DateSourceMtime}
+
+// String returns the string representation of DateSource.
+func (ds DateSource) String() string {
+	return dateSourceName[ds]
+}
+
+// ParseDateSources parses an ordered, comma-separated list such as "exif,filename,mtime".
+func ParseDateSources(input string) ([]DateSource, error) {
+	var sources []DateSource
+	seen := map[DateSource]bool{}
+	for _, part := range strings.Split(input, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name == "" {
+			continue
+		}
+		source, ok := reverseDateSourceName[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid DateSource: %s", name)
+		}
+		if seen[source] {
+			return nil, fmt.Errorf("duplicate DateSource: %s", name)
+		}
+		seen[source] = true
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("at least one date source is required")
+	}
+	return sources, nil
+}
+
+// resolveFileDate tries each source of --date-source in order and returns the
+// first date that can be derived, in the zone of --timezone, together with
+// the source that produced it. EXIF dates come from the cache when it has
+// them.
+func resolveFileDate(path string, info os.FileInfo, cfg FilesMoveConfiguration) (time.Time, DateSource, error) {
+	if resolved, ok := cfg.Dates.lookup(path, info); ok {
+		return resolved.date, resolved.source, nil
+	}
+	for _, source := range cfg.DateSources {
+		date, err := dateFromSource(source, path, info, cfg.ExifCache)
+		if err == nil && date != nil {
+			return inTimeZone(*date, source, cfg.TimeZone), source, nil
+		}
+	}
+	return time.Time{}, 0, fmt.Errorf("no date source could date %q", path)
+}
+
+// CollectedDates holds the dates of every input file, and of every file
+// already in the output tree, resolved in one walk before anything is
+// placed. The split and events folder formats need all of them up front;
+// planning then reads a file's date from here instead of resolving it
+// again. watch adds the files it finds later, see add.
+type CollectedDates struct {
+	mu sync.Mutex
+	// files are the walked files with the date of the file deciding their
+	// folder, see folderFile, in walk order.
+	files  []collectedFile
+	seen   map[string]bool
+	byPath map[string]resolvedDate
+}
+
+type collectedFile struct {
+	path string
+	date time.Time
+}
+
+// resolvedDate is a resolved date, with the size and time of the file it
+// was resolved from, so a file changed since is resolved afresh.
+type resolvedDate struct {
+	date    time.Time
+	source  DateSource
+	size    int64
+	modTime time.Time
+}
+
+// collectDates walks the input and, unless it is the input, the output, and
+// resolves the date of every file the filters let through.
+func collectDates(cfg FilesMoveConfiguration) *CollectedDates {
+	dates := &CollectedDates{seen: map[string]bool{}, byPath: map[string]resolvedDate{}}
+	collect := func(path string, info os.FileInfo) error {
+		if isStructoArtifact(path) {
+			return nil
+		}
+		if skip, err := applyInPlaceFilters(path, info, cfg); skip || err != nil {
+			return nil
+		}
+		dated, datedInfo := folderFile(path, info, cfg)
+		date, source, err := resolveFileDate(dated, datedInfo, cfg)
+		if err != nil {
+			return nil
+		}
+		dates.record(path, dated, datedInfo, date, source)
+		return nil
+	}
+	walkInputFiles(cfg, collect)
+	if cfg.OutputFolder != cfg.InputFolder && fileExists(cfg.OutputFolder) {
+		outputCfg := cfg
+		outputCfg.InputFolder, outputCfg.FilesFrom = cfg.OutputFolder, nil
+		walkInputFiles(outputCfg, collect)
+	}
+	return dates
+}
+
+// withCollectedDates runs collectDates unless an earlier pass already did.
+func withCollectedDates(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.Dates == nil {
+		cfg.Dates = collectDates(cfg)
+	}
+	return cfg
+}
+
+func (d *CollectedDates) record(path, dated string, datedInfo os.FileInfo, date time.Time, source DateSource) {
+	d.files = append(d.files, collectedFile{path: path, date: date})
+	d.seen[path] = true
+	d.byPath[dated] = resolvedDate{date: date, source: source, size: datedInfo.Size(), modTime: datedInfo.ModTime()}
+}
+
+// add collects the date of a file found after the walk, and returns it
+// unless the file was collected before or cannot be dated.
+func (d *CollectedDates) add(path string, info os.FileInfo, cfg FilesMoveConfiguration) (time.Time, bool) {
+	if d == nil {
+		return time.Time{}, false
+	}
+	d.mu.Lock()
+	seen := d.seen[path]
+	d.mu.Unlock()
+	if seen {
+		return time.Time{}, false
+	}
+	dated, datedInfo := folderFile(path, info, cfg)
+	date, source, err := resolveFileDate(dated, datedInfo, cfg)
+	if err != nil {
+		return time.Time{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.record(path, dated, datedInfo, date, source)
+	return date, true
+}
+
+// lookup returns the collected date of path, as long as the file has not
+// changed since.
+func (d *CollectedDates) lookup(path string, info os.FileInfo) (resolvedDate, bool) {
+	if d == nil {
+		return resolvedDate{}, false
+	}
+	d.mu.Lock()
+	resolved, ok := d.byPath[path]
+	d.mu.Unlock()
+	if !ok || resolved.size != info.Size() || !resolved.modTime.Equal(info.ModTime()) {
+		return resolvedDate{}, false
+	}
+	return resolved, true
+}
+
+func dateFromSource(source DateSource, path string, info os.FileInfo, cache *ExifCache) (*time.Time, error) {
+	switch source {
+	case DateSourceExif:
+		if !isImageFile(path) {
+			return nil, errors.New("not an image file")
+		}
+		return cache.dateTaken(path, info)
+	case DateSourceFilename:
+		return dateFromFilename(info.Name())
+	case DateSourceMtime:
+		modTime := info.ModTime()
+		return &modTime, nil
+	case DateSourceCtime:
+		return changeTime(info)
+	case DateSourceBtime:
+		return birthTime(path, info)
+	case DateSourceChat:
+		return dateFromChatName(info.Name())
+	default:
+		return nil, fmt.Errorf("unsupported DateSource %d", source)
+	}
+}
+
+// filenameDatePatterns match dates embedded in names such as "IMG_20240131_235959.jpg"
+// or "Scan 2024-01-31.pdf". Patterns with a time component are tried first.
+var filenameDatePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?:^|[^0-9])(\d{4})[-_.]?(\d{2})[-_.]?(\d{2})[-_ T.]?(\d{2})[-_.:h]?(\d{2})[-_.:m]?(\d{2})(?:[^0-9]|$)`),
+	regexp.MustCompile(`(?:^|[^0-9])(\d{4})[-_.]?(\d{2})[-_.]?(\d{2})(?:[^0-9]|$)`),
+}
+
+// dateFromFilename extracts a plausible date from a file name, reading the
+// names of WhatsApp and Telegram media the way they are written.
+func dateFromFilename(name string) (*time.Time, error) {
+	if date, err := dateFromChatName(name); err == nil {
+		return date, nil
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, pattern := range filenameDatePatterns {
+		for _, match := range pattern.FindAllStringSubmatch(base, -1) {
+			if date, ok := dateFromParts(match[1:]); ok {
+				return &date, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no date found in file name %q", name)
+}
+
+// dateFromParts builds a date from year, month, day and optional hour, minute,
+// second strings, rejecting values that would be normalized by time.Date.
+func dateFromParts(parts []string) (time.Time, bool) {
+	values := make([]int, 6)
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, false
+		}
+		values[i] = value
+	}
+	year, month, day, hour, minute, second := values[0], values[1], values[2], values[3], values[4], values[5]
+	if year < 1900 || year > 2100 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+	date := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the inode change time (ctime) reported by stat(2).
+func changeTime(info os.FileInfo) (*time.Time, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, errors.New("ctime is not available for this file")
+	}
+	ctime := time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+	return &ctime, nil
+}
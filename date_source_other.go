@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// changeTime is not supported on this platform; the chain falls through to the next source.
+func changeTime(info os.FileInfo) (*time.Time, error) {
+	return nil, errors.New("ctime is not supported on this platform")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	DedupeActionReport   = "report"
+	DedupeActionRemove   = "remove"
+	DedupeActionHardlink = "hardlink"
+)
+
+// duplicateGroup is a set of files with identical content. Keep is the copy
+// that stays; the others are removed or replaced by hardlinks to it.
+// Duplicates lists every path of them, so hardlinks of one duplicate are all
+// there, and Redundant counts them as the one file they are.
+type duplicateGroup struct {
+	Hash       string
+	Size       int64
+	Keep       string
+	Duplicates []string
+	Redundant  int
+}
+
+// findDuplicates walks root and groups files by content. Hardlinks of one
+// another are one file, not duplicates: only the first path of each is
+// hashed, and the others go with it. Only files sharing a size are hashed,
+// with cfg.Hash on cfg.Workers goroutines. With XXH3, files cfg.Index holds
+// an up-to-date hash of are not read again. Within a group the shortest path
+// is kept (ties broken alphabetically), which prefers "photo.jpg" over
+// "photo(1).jpg"; hardlinks of the kept path are left alone.
+func findDuplicates(cfg FilesMoveConfiguration) ([]duplicateGroup, error) {
+	bySize := map[int64][]string{}
+	indexed := map[string]string{}
+	// firstLink holds the first path seen of each file with several
+	// hardlinks, and links the other paths of it by that first one.
+	firstLink := map[fileID]string{}
+	links := map[string][]string{}
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() || isStructoArtifact(path) || info.Size() == 0 {
+			return nil
+		}
+		if id, count, ok := hardlinkID(info); ok && count > 1 {
+			if first, seen := firstLink[id]; seen {
+				links[first] = append(links[first], path)
+				return nil
+			}
+			firstLink[id] = path
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+		if cfg.Hash == HashXXH3 {
+			if hash, ok := cfg.Index.knownHash(path, info); ok {
+				indexed[path] = hash
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, path := range paths {
+			if _, ok := indexed[path]; !ok {
+				candidates = append(candidates, path)
+			}
+		}
+	}
+	hashes := hashFiles(candidates, cfg.Hash, cfg.HashMmap, cfg.Workers, func(path string, err error) {
+		log.Printf(locMsg("hash_error", cfg.Language), path, err)
+	})
+	for path, hash := range indexed {
+		hashes[path] = hash
+	}
+
+	var groups []duplicateGroup
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		byHash := map[string][]string{}
+		for _, path := range paths {
+			if hash, ok := hashes[path]; ok {
+				byHash[hash] = append(byHash[hash], path)
+			}
+		}
+		for hash, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			sort.Slice(same, func(i, j int) bool {
+				if len(same[i]) != len(same[j]) {
+					return len(same[i]) < len(same[j])
+				}
+				return same[i] < same[j]
+			})
+			group := duplicateGroup{Hash: hash, Size: size, Keep: same[0], Redundant: len(same) - 1}
+			for _, dup := range same[1:] {
+				group.Duplicates = append(group.Duplicates, dup)
+				group.Duplicates = append(group.Duplicates, links[dup]...)
+			}
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Keep < groups[j].Keep })
+	return groups, nil
+}
+
+// resolveDuplicates removes or hardlinks every duplicate, as action says, and
+// journals each change. In dry runs it only logs, and the report action
+// changes nothing.
+func resolveDuplicates(ctx context.Context, groups []duplicateGroup, action string, cfg FilesMoveConfiguration) error {
+	if action == DedupeActionReport {
+		return nil
+	}
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, dup := range group.Duplicates {
+			if cfg.DryRun {
+				log.Printf("[DRY RUN] Would %s duplicate: %s (same as %s)", action, dup, group.Keep)
+				continue
+			}
+			if err := resolveDuplicate(group.Keep, dup, action, cfg); err != nil {
+				log.Printf(locMsg("dedupe_error", cfg.Language), dup, err)
+				cfg.Summary.recordFailure(dup, err, cfg.Language)
+				continue
+			}
+			log.Printf(locMsg("dedupe_resolved", cfg.Language), action, dup, group.Keep)
+			cfg.Summary.recordTransferred()
+		}
+	}
+	return nil
+}
+
+// resolveDuplicate removes dup, or replaces it with a hardlink to keep, once
+// its bytes are found to match keep's: the hashes that grouped them may come
+// from the index, or be stale by now. The change is journaled so that undo
+// can write dup again; see restoreDuplicate.
+func resolveDuplicate(keep, dup, action string, cfg FilesMoveConfiguration) error {
+	info, err := os.Stat(dup)
+	if err != nil {
+		return newOpError("stat", dup, err)
+	}
+	same, err := sameStreams(keep, dup)
+	if err != nil {
+		return fmt.Errorf("failed to compare %q with %q: %w", dup, keep, err)
+	}
+	if !same {
+		return fmt.Errorf("%q no longer holds the content of %q", dup, keep)
+	}
+	if action == DedupeActionHardlink {
+		err = replaceWithHardlink(keep, dup, cfg)
+	} else {
+		err = newOpError("remove duplicate", dup, cfg.FS.Remove(dup))
+	}
+	if err == nil {
+		cfg.Journal.recordDedupe(dup, keep, info, action, cfg)
+	}
+	return err
+}
+
+// restoreDuplicate reverts a dedupe entry: the duplicate at entry.Source is
+// written again as a copy of the kept file, in place of the hardlink that
+// replaced it, and gets its time back. A file that is no longer that
+// hardlink was changed since, and is left alone.
+func restoreDuplicate(ctx context.Context, entry JournalEntry, cfg FilesMoveConfiguration) error {
+	info, err := os.Stat(entry.Destination)
+	if err != nil {
+		return newOpError("find kept file", entry.Destination, err)
+	}
+	if current, err := os.Stat(entry.Source); err == nil {
+		if entry.Dedupe != DedupeActionHardlink || !os.SameFile(current, info) {
+			return fmt.Errorf("cannot restore %q: a file already exists there", entry.Source)
+		}
+	}
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would restore duplicate: %s => %s", entry.Destination, entry.Source)
+		return nil
+	}
+	if err := cfg.FS.MkdirAll(filepath.Dir(entry.Source), 0755); err != nil {
+		return newOpError("recreate source directory", filepath.Dir(entry.Source), err)
+	}
+	if err := copyFilePreserve(ctx, entry.Destination, entry.Source, info, cfg); err != nil {
+		return err
+	}
+	return preserveTimes(entry.Source, entry.ModTime, cfg)
+}
+
+// replaceWithHardlink atomically swaps dup for a hardlink to keep.
+func replaceWithHardlink(keep, dup string, cfg FilesMoveConfiguration) error {
+	tmp := filepath.Join(filepath.Dir(dup), ".structo-dedupe-"+filepath.Base(dup))
+	if err := cfg.FS.Link(keep, tmp); err != nil {
+		return newOpError("hardlink", tmp, err)
+	}
+	if err := cfg.FS.Rename(tmp, dup); err != nil {
+		cfg.FS.Remove(tmp)
+		return newOpError("replace duplicate", dup, err)
+	}
+	return nil
+}
+
+// printDuplicateReport lists every group and the space the duplicates occupy.
+func printDuplicateReport(w io.Writer, groups []duplicateGroup) {
+	var files int
+	var wasted int64
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s (%s)\n  keep: %s\n", group.Hash[:12], formatBytes(group.Size), group.Keep)
+		for _, dup := range group.Duplicates {
+			fmt.Fprintf(w, "  dup:  %s\n", dup)
+		}
+		files += group.Redundant
+		wasted += group.Size * int64(group.Redundant)
+	}
+	fmt.Fprintf(w, "%d duplicate groups, %d redundant files, %s reclaimable\n", len(groups), files, formatBytes(wasted))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diagEnvironment describes the machine a bundle was produced on.
+type diagEnvironment struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	GOOS        string    `json:"goos"`
+	GOARCH      string    `json:"goarch"`
+	GoVersion   string    `json:"go_version"`
+	NumCPU      int       `json:"num_cpu"`
+	Redacted    bool      `json:"redacted"`
+}
+
+var (
+	// logHeaderPattern matches the date, time and source file the logger
+	// puts before every message; the date's slashes are not a path.
+	logHeaderPattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? (?:[\w.-]+\.go:\d+: )?`)
+	// quotedPattern matches a %q string, escapes included, or a single-quoted
+	// path.
+	quotedPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'[^']*[/\\][^']*'`)
+	// pathStartPattern matches where an unquoted path begins: an absolute
+	// path, a UNC share, or a relative path whose first component is
+	// followed by a separator.
+	pathStartPattern = regexp.MustCompile(`(?:^|\s)(?:[A-Za-z]:\\|\\\\|/|[^\s"'/\\]+[/\\])`)
+	// trailingNotePattern matches the parenthesized detail some messages end
+	// with, e.g. a size after the path.
+	trailingNotePattern = regexp.MustCompile(` \([^()]*\)$`)
+)
+
+// pathEnds are what ends an unquoted path in a log line. Paths may contain
+// spaces, so a path otherwise runs to the end of the line.
+var pathEnds = []string{": ", " => ", " -> ", `"`}
+
+// hashPath replaces a path with a stable token so lines can still be correlated.
+func hashPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return "<path:" + hex.EncodeToString(sum[:6]) + ">"
+}
+
+// redactOverride hashes the source path of an overrides entry, "source =>
+// folder"; entries matching by content name no path and are kept.
+func redactOverride(entry string) string {
+	source, folder, ok := strings.Cut(entry, " => ")
+	if !ok || strings.HasPrefix(source, overrideHashPrefix) {
+		return entry
+	}
+	return hashPath(source) + " => " + folder
+}
+
+// redactLine hashes every quoted string and every path in a log line. A
+// quoted string is hashed as a whole, whatever it holds; an unquoted path
+// runs from its start to the next pathEnds, so names with spaces are
+// hashed whole too.
+func redactLine(line string) string {
+	header := logHeaderPattern.FindString(line)
+	line = quotedPattern.ReplaceAllStringFunc(line[len(header):], func(quoted string) string {
+		mark := quoted[:1]
+		if unquoted, err := strconv.Unquote(quoted); err == nil {
+			quoted = unquoted
+		} else {
+			quoted = quoted[1 : len(quoted)-1]
+		}
+		return mark + hashPath(quoted) + mark
+	})
+	return header + redactBarePaths(line)
+}
+
+// redactBarePaths hashes the unquoted paths of a log message.
+func redactBarePaths(line string) string {
+	var out strings.Builder
+	for {
+		loc := pathStartPattern.FindStringIndex(line)
+		if loc == nil {
+			out.WriteString(line)
+			return out.String()
+		}
+		start := loc[0]
+		if line[start] == ' ' || line[start] == '\t' {
+			start++
+		}
+		end := len(line)
+		for _, sep := range pathEnds {
+			if i := strings.Index(line[start:], sep); i >= 0 && start+i < end {
+				end = start + i
+			}
+		}
+		note := ""
+		if end == len(line) {
+			note = trailingNotePattern.FindString(line[start:])
+			end -= len(note)
+		}
+		out.WriteString(line[:start])
+		out.WriteString(hashPath(line[start:end]))
+		out.WriteString(note)
+		line = line[end+len(note):]
+	}
+}
+
+// latestArtifact returns the newest file of the output folder named prefix, a
+// timestamp and ext, see artifactFiles.
+func latestArtifact(folder, prefix, ext string) (string, bool) {
+	paths, err := artifactFiles(folder, prefix, ext)
+	if err != nil || len(paths) == 0 {
+		return "", false
+	}
+	return paths[len(paths)-1], true
+}
+
+// buildDiagBundle zips the last run's log and summary, the run configuration and
+// environment details. Paths are hashed unless includeFilenames is set. The
+// bundle is written through fsys, so --no-write refuses it.
+func buildDiagBundle(fsys FileSystem, folder, out string, includeFilenames bool) error {
+	zipFile, err := fsys.Create(out)
+	if err != nil {
+		return newOpError("create bundle", out, err)
+	}
+	defer zipFile.Close()
+	archive := zip.NewWriter(zipFile)
+
+	redact := !includeFilenames
+	if logPath, ok := latestArtifact(folder, logFilePrefix, ".log"); ok {
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			return newOpError("read log", logPath, err)
+		}
+		if redact {
+			lines := strings.Split(string(data), "\n")
+			for i, line := range lines {
+				lines[i] = redactLine(line)
+			}
+			data = []byte(strings.Join(lines, "\n"))
+		}
+		if err := addZipEntry(archive, "last-run.log", data); err != nil {
+			return err
+		}
+	}
+
+	if summaryPath, ok := latestArtifact(folder, summaryPrefix, ".json"); ok {
+		data, err := os.ReadFile(summaryPath)
+		if err != nil {
+			return newOpError("read summary", summaryPath, err)
+		}
+		var record RunRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("invalid summary %q: %w", summaryPath, err)
+		}
+		// The configuration always has its paths hashed; it is useful without them.
+		record.Config.Input = hashPath(record.Config.Input)
+		record.Config.Output = hashPath(record.Config.Output)
+		if record.Config.ConfigFile != "" {
+			record.Config.ConfigFile = hashPath(record.Config.ConfigFile)
+		}
+		if record.Config.SyncTo != "" {
+			record.Config.SyncTo = hashPath(record.Config.SyncTo)
+		}
+		if redact {
+			for i, entry := range record.Config.Overrides {
+				record.Config.Overrides[i] = redactOverride(entry)
+			}
+			for i := range record.Failures {
+				record.Failures[i].Path = hashPath(record.Failures[i].Path)
+				record.Failures[i].Error = redactLine(record.Failures[i].Error)
+			}
+			for i := range record.MetadataWarnings {
+				record.MetadataWarnings[i].Path = hashPath(record.MetadataWarnings[i].Path)
+				record.MetadataWarnings[i].Error = redactLine(record.MetadataWarnings[i].Error)
+			}
+		}
+		if err := addZipJSON(archive, "config.json", record.Config); err != nil {
+			return err
+		}
+		if err := addZipJSON(archive, "summary.json", record); err != nil {
+			return err
+		}
+	}
+
+	env := diagEnvironment{
+		GeneratedAt: time.Now(),
+		GOOS:        runtime.GOOS,
+		GOARCH:      runtime.GOARCH,
+		GoVersion:   runtime.Version(),
+		NumCPU:      runtime.NumCPU(),
+		Redacted:    redact,
+	}
+	if err := addZipJSON(archive, "environment.json", env); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return newOpError("write bundle", out, err)
+	}
+	return nil
+}
+
+func addZipJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func addZipEntry(archive *zip.Writer, name string, data []byte) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// createdDirs holds the destination folders known to exist during this run,
+// so each is created once instead of once per file moved into it.
+var createdDirs = struct {
+	sync.Mutex
+	known map[string]bool
+}{known: map[string]bool{}}
+
+// ensureDir creates dir and its parents unless this run already did.
+func ensureDir(dir string, cfg FilesMoveConfiguration) error {
+	createdDirs.Lock()
+	known := createdDirs.known[dir]
+	createdDirs.Unlock()
+	if known {
+		return nil
+	}
+	if err := cfg.FS.MkdirAll(dir, 0755); err != nil {
+		return newOpError("create target directory", dir, err)
+	}
+	createdDirs.Lock()
+	defer createdDirs.Unlock()
+	for d := dir; !createdDirs.known[d]; d = filepath.Dir(d) {
+		createdDirs.known[d] = true
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return nil
+}
+
+// forgetCreatedDirs drops what ensureDir remembers. Long-running commands
+// call it between batches, as folders may have been removed meanwhile.
+func forgetCreatedDirs() {
+	createdDirs.Lock()
+	defer createdDirs.Unlock()
+	createdDirs.known = map[string]bool{}
+}
+
+// precreateDirs creates the destination folders of moves in one sorted
+// batch. Only the deepest folders are created, since MkdirAll makes their
+// parents too. A folder that cannot be created is only logged here: the moves
+// into it fail, and are reported, when they run.
+func precreateDirs(moves []PlannedMove, cfg FilesMoveConfiguration) {
+	if cfg.DryRun || len(moves) == 0 {
+		return
+	}
+	unique := map[string]bool{}
+	for _, move := range moves {
+		unique[filepath.Dir(move.Destination)] = true
+	}
+	dirs := make([]string, 0, len(unique))
+	for dir := range unique {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	created := 0
+	for i, dir := range dirs {
+		if i+1 < len(dirs) && strings.HasPrefix(dirs[i+1], dir+string(filepath.Separator)) {
+			continue
+		}
+		if err := ensureYearDataset(dir, cfg); err != nil {
+			logError("error_organizing", cfg.Language, err)
+			continue
+		}
+		if err := ensureDir(dir, cfg); err != nil {
+			logError("error_organizing", cfg.Language, err)
+			continue
+		}
+		created++
+	}
+	log.Printf(locMsg("dirs_precreated", cfg.Language), created, len(moves))
+}
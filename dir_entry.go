@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// lazyFileInfo is the os.FileInfo of a directory entry found by the walk.
+// The name and file type come from the directory listing itself; the file is
+// only stat'ed the first time its size, times, permissions or system data are
+// asked for. Filters that only look at names therefore never stat a file,
+// which matters on network shares where every stat is a round trip.
+//
+// When the stat fails, for example because the file was removed after the
+// listing, the zero values are returned and the error surfaces when the file
+// is opened or moved.
+type lazyFileInfo struct {
+	entry fs.DirEntry
+	once  sync.Once
+	info  fs.FileInfo
+}
+
+func newLazyFileInfo(entry fs.DirEntry) *lazyFileInfo {
+	return &lazyFileInfo{entry: entry}
+}
+
+func (l *lazyFileInfo) stat() fs.FileInfo {
+	l.once.Do(func() {
+		l.info, _ = l.entry.Info()
+	})
+	return l.info
+}
+
+func (l *lazyFileInfo) Name() string { return l.entry.Name() }
+func (l *lazyFileInfo) IsDir() bool  { return l.entry.IsDir() }
+
+func (l *lazyFileInfo) Size() int64 {
+	if info := l.stat(); info != nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (l *lazyFileInfo) Mode() os.FileMode {
+	if info := l.stat(); info != nil {
+		return info.Mode()
+	}
+	return l.entry.Type()
+}
+
+func (l *lazyFileInfo) ModTime() time.Time {
+	if info := l.stat(); info != nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (l *lazyFileInfo) Sys() any {
+	if info := l.stat(); info != nil {
+		return info.Sys()
+	}
+	return nil
+}
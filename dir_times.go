@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DirTimes remembers, for --preserve-structure, which input folder each
+// mirrored destination folder stands for. Placing files stamps a folder
+// with the current time, so the input folders' times are put back once the
+// run is done.
+type DirTimes struct {
+	mu   sync.Mutex
+	dirs map[string]os.FileInfo
+}
+
+// withDirTimes sets up DirTimes for runs that mirror input folders.
+func withDirTimes(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.PreserveStructure && !cfg.DryRun {
+		cfg.DirTimes = &DirTimes{dirs: map[string]os.FileInfo{}}
+	}
+	return cfg
+}
+
+// record notes the destination folders of a file placed under
+// --preserve-structure that do not exist yet, pairing each with its input
+// folder. The input folder is read the first time it is seen, before any
+// file has left it, since moving files out changes its time too.
+func (d *DirTimes) record(src, dst string, cfg FilesMoveConfiguration) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	srcDir, dstDir := filepath.Dir(src), filepath.Dir(dst)
+	for isBelow(srcDir, cfg.InputFolder) {
+		if _, seen := d.dirs[dstDir]; !seen {
+			if fileExists(dstDir) {
+				// It predates the run, and so do its parents.
+				return
+			}
+			info, err := os.Stat(srcDir)
+			if err != nil {
+				return
+			}
+			d.dirs[dstDir] = info
+		}
+		srcDir, dstDir = filepath.Dir(srcDir), filepath.Dir(dstDir)
+	}
+}
+
+// isBelow reports whether path lies inside, and is not, root.
+func isBelow(path, root string) bool {
+	rel, ok := relWithin(root, path)
+	return ok && rel != "."
+}
+
+// relWithin returns path relative to root, and whether path is root or lies
+// inside it. A name starting with "..", such as "..notes", is inside.
+func relWithin(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// restoreDirTimes stamps the folders created for --preserve-structure with
+// their input folders' times, and with --preserve-dir-mode their permissions,
+// deepest first so that stamping a folder is not undone by its children.
+func restoreDirTimes(cfg FilesMoveConfiguration) error {
+	d := cfg.DirTimes
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dirs := make([]string, 0, len(d.dirs))
+	for dir := range d.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	var errs []error
+	restored := 0
+	for _, dir := range dirs {
+		if !fileExists(dir) {
+			// Nothing was placed there in the end, for example after a failure.
+			continue
+		}
+		info := d.dirs[dir]
+		if cfg.PreserveDirMode {
+			if err := metadataResult(dir, newOpError("preserve permissions", dir, cfg.FS.Chmod(dir, info.Mode().Perm())), cfg); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if err := preserveTimes(dir, info.ModTime(), cfg); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Printf(locMsg("dir_times_restored", cfg.Language), restored)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Error categories used to give users actionable remediation hints.
+// They are matched with errors.Is against any *OrganizeError.
+var (
+	ErrCrossDevice = errors.New("source and destination are on different devices")
+	ErrPermission  = errors.New("permission denied")
+	ErrNameInvalid = errors.New("file name not accepted by the destination")
+	ErrDiskFull    = errors.New("destination is full")
+	ErrNotFound    = errors.New("file or folder not found")
+	ErrTransient   = errors.New("temporary error")
+	// ErrQuotaExceeded reports a file that would grow the output past
+	// --output-quota. It stops the run, even with --keep-going.
+	ErrQuotaExceeded = errors.New("output quota reached")
+)
+
+// OrganizeError records which operation failed on which path, the category
+// it falls in, and the underlying error.
+type OrganizeError struct {
+	Op   string
+	Path string
+	Kind error
+	Err  error
+}
+
+func (e *OrganizeError) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Op, e.Path, e.Err)
+}
+
+// Unwrap exposes both the category and the cause to errors.Is / errors.As.
+func (e *OrganizeError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newOpError wraps err with the failed operation and a category derived from it.
+func newOpError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OrganizeError{Op: op, Path: path, Kind: classifyError(err), Err: err}
+}
+
+// classifyError maps OS-level failures onto the error categories above.
+func classifyError(err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if kind := classifyErrno(errno); kind != nil {
+			return kind
+		}
+	}
+	switch {
+	case errors.Is(err, ErrReadOnly), errors.Is(err, os.ErrPermission):
+		return ErrPermission
+	case errors.Is(err, os.ErrNotExist):
+		return ErrNotFound
+	}
+	return nil
+}
+
+// errorKindKeys maps each category to its localized remediation hint.
+var errorKindKeys = []struct {
+	kind error
+	key  string
+}{
+	{ErrCrossDevice, "hint_cross_device"},
+	{ErrPermission, "hint_permission"},
+	{ErrNameInvalid, "hint_name_invalid"},
+	{ErrDiskFull, "hint_disk_full"},
+	{ErrNotFound, "hint_not_found"},
+	{ErrTransient, "hint_transient"},
+	{ErrQuotaExceeded, "hint_quota"},
+}
+
+// remediationHint returns a localized suggestion for err, or "" when none applies.
+func remediationHint(err error, lang string) string {
+	if errors.Is(err, ErrReadOnly) {
+		return locMsg("hint_read_only", lang)
+	}
+	for _, entry := range errorKindKeys {
+		if errors.Is(err, entry.kind) {
+			return locMsg(entry.key, lang)
+		}
+	}
+	return ""
+}
+
+// errorKindName is the short category name shown in summaries.
+func errorKindName(err error) string {
+	for _, entry := range errorKindKeys {
+		if errors.Is(err, entry.kind) {
+			return entry.kind.Error()
+		}
+	}
+	return "unexpected error"
+}
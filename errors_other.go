@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import "syscall"
+
+func classifyErrno(errno syscall.Errno) error {
+	return nil
+}
+
+// isLockedError reports whether err is about a file another program holds
+// open without sharing it, which only Windows refuses.
+func isLockedError(err error) bool {
+	return false
+}
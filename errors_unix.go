@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func classifyErrno(errno syscall.Errno) error {
+	switch errno {
+	case syscall.EXDEV:
+		return ErrCrossDevice
+	case syscall.EACCES, syscall.EPERM, syscall.EROFS:
+		return ErrPermission
+	case syscall.ENAMETOOLONG, syscall.EILSEQ:
+		return ErrNameInvalid
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return ErrDiskFull
+	case syscall.ENOENT:
+		return ErrNotFound
+	case syscall.EAGAIN, syscall.EBUSY, syscall.ETXTBSY, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE,
+		syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ENETDOWN, syscall.ENETUNREACH, syscall.EHOSTUNREACH:
+		return ErrTransient
+	}
+	return nil
+}
+
+// isLockedError reports whether err is about a file another program holds
+// open without sharing it, which only Windows refuses.
+func isLockedError(err error) bool {
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Win32 error codes not exported by the syscall package.
+const (
+	errorHandleDiskFull     syscall.Errno = 39
+	errorDiskFull           syscall.Errno = 112
+	errorInvalidName        syscall.Errno = 123
+	errorFilenameExcedRange syscall.Errno = 206
+	errorNotSameDevice      syscall.Errno = 17
+	errorWriteProtect       syscall.Errno = 19
+	errorSharingViolation   syscall.Errno = 32
+	errorLockViolation      syscall.Errno = 33
+	errorUnexpNetErr        syscall.Errno = 59
+	errorSemTimeout         syscall.Errno = 121
+)
+
+func classifyErrno(errno syscall.Errno) error {
+	switch errno {
+	case errorNotSameDevice:
+		return ErrCrossDevice
+	case syscall.ERROR_ACCESS_DENIED, errorWriteProtect:
+		return ErrPermission
+	case errorInvalidName, errorFilenameExcedRange:
+		return ErrNameInvalid
+	case errorDiskFull, errorHandleDiskFull:
+		return ErrDiskFull
+	case syscall.ERROR_FILE_NOT_FOUND, syscall.ERROR_PATH_NOT_FOUND:
+		return ErrNotFound
+	case errorSharingViolation, errorLockViolation, errorUnexpNetErr, errorSemTimeout, syscall.ERROR_NETNAME_DELETED:
+		return ErrTransient
+	}
+	return nil
+}
+
+// isLockedError reports whether err is about a file another program holds
+// open without sharing it.
+func isLockedError(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == errorSharingViolation || errno == errorLockViolation)
+}
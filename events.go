@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultEventGap is how far apart two files may be and still belong to the
+// same event unless --event-gap says otherwise.
+const defaultEventGap = 4 * time.Hour
+
+// event is a run of files no more than the event gap apart.
+type event struct {
+	start, end time.Time
+	// day is the day the event started on, after --bucket-offset.
+	day time.Time
+	// number counts the events of its day, from 1 in the order they start.
+	number int
+}
+
+// eventClusters groups the dates of the files into events, so the events
+// folder format can give each one a folder. Like periodDensity it is built
+// once before placing anything. Dates it has not seen, such as those of
+// files watch finds later, join the event they are close enough to, or
+// start an event of their own numbered after the others of their day.
+type eventClusters struct {
+	mu  sync.Mutex
+	gap time.Duration
+	// events are sorted by their start.
+	events []*event
+	// perDay holds the highest event number of each day, see dayKey.
+	perDay map[string]int
+}
+
+// eventFolderName matches the event folders createEventFolder makes, in
+// either language.
+var eventFolderName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})_(?:event|evento)-(\d+)$`)
+
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func newEventClusters(cfg FilesMoveConfiguration) *eventClusters {
+	return &eventClusters{gap: cfg.EventGap, perDay: map[string]int{}}
+}
+
+// collectEvents groups the dates of the input files and of the files already
+// in the output tree, see collectDates, into events: sorted by time,
+// a date more than cfg.EventGap after the one before starts a new event.
+// With keepFolders the event folders of the output stay as they are, so
+// a run adding to an archive never renumbers them: a file already in one
+// belongs to it, and other files join the event they are close to or get
+// numbers after those of their day.
+func collectEvents(cfg FilesMoveConfiguration, keepFolders bool) *eventClusters {
+	clusters := newEventClusters(cfg)
+	kept := map[string]*event{}
+	var dates []time.Time
+	for _, file := range withCollectedDates(cfg).Dates.files {
+		if keepFolders && clusters.keep(kept, file.path, file.date, cfg) {
+			continue
+		}
+		dates = append(dates, file.date)
+	}
+	for _, ev := range kept {
+		clusters.events = append(clusters.events, ev)
+	}
+	sort.Slice(clusters.events, func(i, j int) bool { return clusters.events[i].start.Before(clusters.events[j].start) })
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	for _, date := range dates {
+		clusters.find(date, cfg)
+	}
+	return clusters
+}
+
+// keep adds date to the event of the output folder path sits in, when that
+// is an event folder, and reports whether it was.
+func (c *eventClusters) keep(kept map[string]*event, path string, date time.Time, cfg FilesMoveConfiguration) bool {
+	rel, ok := relBelow(cfg.OutputFolder, path)
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 {
+		return false
+	}
+	match := eventFolderName.FindStringSubmatch(parts[1])
+	if match == nil || !strings.HasPrefix(match[1], parts[0]+"-") {
+		return false
+	}
+	zone := time.Local
+	if cfg.TimeZone != nil {
+		zone = cfg.TimeZone
+	}
+	day, err := time.ParseInLocation("2006-01-02", match[1], zone)
+	number, _ := strconv.Atoi(match[2])
+	if err != nil || number < 1 {
+		return false
+	}
+	key := parts[1]
+	ev, ok := kept[key]
+	if !ok {
+		ev = &event{start: date, end: date, day: day, number: number}
+		kept[key] = ev
+		c.perDay[match[1]] = max(c.perDay[match[1]], number)
+	}
+	if date.Before(ev.start) {
+		ev.start = date
+	}
+	if date.After(ev.end) {
+		ev.end = date
+	}
+	return true
+}
+
+// newEvent starts an event at date, numbered after the events of its day.
+func (c *eventClusters) newEvent(date time.Time, cfg FilesMoveConfiguration) *event {
+	day := bucketDate(date, cfg)
+	c.perDay[dayKey(day)]++
+	return &event{start: date, end: date, day: day, number: c.perDay[dayKey(day)]}
+}
+
+// find returns the event of date, adding date to the events when it was
+// not seen while clustering.
+func (c *eventClusters) find(date time.Time, cfg FilesMoveConfiguration) *event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := sort.Search(len(c.events), func(i int) bool { return c.events[i].start.After(date) })
+	if i > 0 && date.Sub(c.events[i-1].end) <= c.gap {
+		prev := c.events[i-1]
+		if date.After(prev.end) {
+			prev.end = date
+		}
+		return prev
+	}
+	if i < len(c.events) && c.events[i].start.Sub(date) <= c.gap {
+		c.events[i].start = date
+		return c.events[i]
+	}
+	added := c.newEvent(date, cfg)
+	c.events = append(c.events[:i], append([]*event{added}, c.events[i:]...)...)
+	return added
+}
+
+// withEventClusters attaches the events when the events format is used.
+func withEventClusters(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.FolderFormat == Events && cfg.Events == nil {
+		cfg = withCollectedDates(cfg)
+		cfg.Events = collectEvents(cfg, true)
+	}
+	return cfg
+}
+
+// name names the event after the day it started on, as YYYY-MM-DD_event-NN.
+func (ev *event) name(lang string) string {
+	labels := map[string]string{"en": "event", "es": "evento"}
+	label, ok := labels[lang]
+	if !ok {
+		label = labels["en"]
+	}
+	return fmt.Sprintf("%s_%s-%02d", dayKey(ev.day), label, ev.number)
+}
+
+// createEventFolder constructs a directory path like
+// <outputRoot>/YYYY/YYYY-MM-DD_event-NN for the event of date, named after
+// the day it started on.
+func createEventFolder(outputRoot string, date time.Time, cfg FilesMoveConfiguration) (string, error) {
+	if cfg.Events == nil {
+		cfg.Events = newEventClusters(cfg)
+	}
+	ev := cfg.Events.find(date, cfg)
+	year, month, day := ev.day.Date()
+	if !isValidDate(year, month, day) {
+		return "", fmt.Errorf("invalid date in modTime: %v", date)
+	}
+	return filepath.Join(outputRoot, fmt.Sprintf("%d", year), ev.name(cfg.Language)), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// exifCacheBucket maps a file version, see versionKey, to its EXIF
+	// DateTimeOriginal, its offset, Make and Model as written, see
+	// encodeExifTags, or to
+	// "!" and the error reading it gave. Its name changes when structo
+	// learns to read more formats or tags, so what was cached without them
+	// is not kept.
+	exifCacheBucket = []byte("exif-4")
+	// staleExifCacheBuckets are the buckets of earlier formats, removed
+	// when the cache is opened for writing.
+	staleExifCacheBuckets = [][]byte{[]byte("exif"), []byte("exif-2"), []byte("exif-3")}
+)
+
+const (
+	// exifCacheName is the cache file in the structo folder of the user
+	// cache folder, used unless --exif-cache names another.
+	exifCacheName = "exif-cache.db"
+	// exifCacheFlushEvery is how many parsed files are written to the cache
+	// in one transaction.
+	exifCacheFlushEvery = 1000
+	// exifCacheFailed marks a cached error instead of a date.
+	exifCacheFailed = "!"
+)
+
+// ExifCache remembers the EXIF date of every image version it parsed,
+// keyed by path, size and modification time, across runs and output
+// folders. Finding an image's EXIF data means opening it and reading its
+// header; with the cache a real run after a dry run, and every later run,
+// parses only new and changed images. Files without an EXIF date are
+// remembered too. A nil cache parses every file.
+type ExifCache struct {
+	mu       sync.Mutex
+	db       *bolt.DB
+	readOnly bool
+	// pending holds the parsed dates not yet written.
+	pending map[string]string
+	// hits counts the parses the cache saved this run.
+	hits int
+	// err holds the first failed write, reported by close.
+	err error
+}
+
+// defaultExifCachePath returns where the cache lives by default, or "" when
+// the system has no user cache folder.
+func defaultExifCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "structo", exifCacheName)
+}
+
+// openExifCache opens the cache at path, creating it unless readOnly. A
+// read-only cache that does not exist yet is empty, and nil is returned.
+func openExifCache(path string, readOnly bool) (*ExifCache, error) {
+	if readOnly && !fileExists(path) {
+		return nil, nil
+	}
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, newOpError("create EXIF cache folder", filepath.Dir(path), err)
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: indexLockTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("EXIF cache %q is in use by another run", path)
+	}
+	if err != nil {
+		return nil, newOpError("open EXIF cache", path, err)
+	}
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, stale := range staleExifCacheBuckets {
+				if err := tx.DeleteBucket(stale); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+					return err
+				}
+			}
+			_, err := tx.CreateBucketIfNotExists(exifCacheBucket)
+			return err
+		})
+		if err != nil {
+			db.Close()
+			return nil, newOpError("initialize EXIF cache", path, err)
+		}
+	}
+	return &ExifCache{db: db, readOnly: readOnly, pending: map[string]string{}}, nil
+}
+
+// dateTaken returns the EXIF date of the image at path like GetDateTaken,
+// from the cache when this version of the file was parsed before.
+func (c *ExifCache) dateTaken(path string, info os.FileInfo) (*time.Time, error) {
+	tags, err := c.tags(path, info)
+	if err != nil {
+		return nil, err
+	}
+	return parseDateTaken(tags.dateTaken, tags.offset)
+}
+
+// tags returns the EXIF tags of the image at path, from the cache when this
+// version of the file was parsed before. Files that cannot be read are not
+// cached, so they are tried again next time. A nil cache parses the file.
+func (c *ExifCache) tags(path string, info os.FileInfo) (exifTags, error) {
+	if c == nil {
+		return readExifTags(path)
+	}
+	key := string(versionKey(historyKey(path), info.Size(), info.ModTime()))
+	if value, ok := c.get(key); ok {
+		c.mu.Lock()
+		c.hits++
+		c.mu.Unlock()
+		if message, failed := strings.CutPrefix(value, exifCacheFailed); failed {
+			return exifTags{}, errors.New(message)
+		}
+		return decodeExifTags(value), nil
+	}
+	tags, err := readExifTags(path)
+	var readErr *fs.PathError
+	if errors.As(err, &readErr) {
+		return exifTags{}, err
+	}
+	value := encodeExifTags(tags)
+	if err != nil {
+		value = exifCacheFailed + err.Error()
+	}
+	c.put(key, value)
+	return tags, err
+}
+
+// encodeExifTags writes tags as a cache value: the date, offset, make and
+// model separated by NULs, which EXIF text cannot hold.
+func encodeExifTags(tags exifTags) string {
+	return strings.Join([]string{tags.dateTaken, tags.offset, tags.make, tags.model}, "\x00")
+}
+
+// decodeExifTags reads a cache value written by encodeExifTags.
+func decodeExifTags(value string) exifTags {
+	fields := strings.SplitN(value, "\x00", 4)
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	return exifTags{dateTaken: fields[0], offset: fields[1], make: fields[2], model: fields[3]}
+}
+
+// get looks a file version up, among the pending dates first.
+func (c *ExifCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	value, ok := c.pending[key]
+	c.mu.Unlock()
+	if ok {
+		return value, true
+	}
+	c.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(exifCacheBucket); b != nil {
+			if data := b.Get([]byte(key)); data != nil {
+				value, ok = string(data), true
+			}
+		}
+		return nil
+	})
+	return value, ok
+}
+
+// put remembers a parsed file, written out with the next flush. A failed
+// write only costs parsing again, and is kept for close to report.
+func (c *ExifCache) put(key, value string) {
+	if c.readOnly {
+		return
+	}
+	c.mu.Lock()
+	c.pending[key] = value
+	full := len(c.pending) >= exifCacheFlushEvery
+	c.mu.Unlock()
+	if !full {
+		return
+	}
+	if err := c.flush(); err != nil {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+	}
+}
+
+// flush writes the pending dates in one transaction.
+func (c *ExifCache) flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]string{}
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(exifCacheBucket)
+		for key, value := range pending {
+			if err := b.Put([]byte(key), []byte(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// carry remembers the date of a file placed at dst under its new path, so
+// runs over the organized tree find it too. Only a placement that kept the
+// file's size and modification time can be carried over.
+func (c *ExifCache) carry(src, dst string, info os.FileInfo) {
+	if c == nil || c.readOnly {
+		return
+	}
+	placed, err := os.Stat(dst)
+	if err != nil || placed.Size() != info.Size() || !placed.ModTime().Equal(info.ModTime()) {
+		return
+	}
+	value, ok := c.get(string(versionKey(historyKey(src), info.Size(), info.ModTime())))
+	if !ok {
+		return
+	}
+	c.put(string(versionKey(historyKey(dst), info.Size(), info.ModTime())), value)
+}
+
+// close writes out the pending dates and closes the cache.
+func (c *ExifCache) close() error {
+	if c == nil {
+		return nil
+	}
+	err := c.err
+	if !c.readOnly {
+		err = errors.Join(err, c.flush())
+	}
+	return errors.Join(err, c.db.Close())
+}
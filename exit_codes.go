@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// Exit codes, so that scripts can tell the outcomes of a run apart.
+const (
+	// exitOK: every file that matched was placed.
+	exitOK = 0
+	// exitFatal: the configuration or the setup was wrong; see the log.
+	exitFatal = 1
+	// exitFileErrors: some files failed, and the run either stopped at the
+	// first one or, with --keep-going, went on past them.
+	exitFileErrors = 2
+	// exitNothingMatched: no file was placed, because none matched or all
+	// were already in place.
+	exitNothingMatched = 3
+	// exitInterrupted: the run was cancelled, the conventional 128+SIGINT.
+	exitInterrupted = 130
+)
+
+// exitStatus returns the exit code of a run that went through: exitFileErrors
+// when any file failed, exitNothingMatched when none was placed.
+func (s *RunSummary) exitStatus() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(s.Failures) > 0:
+		return exitFileErrors
+	case s.Transferred == 0 && s.Quarantined == 0:
+		return exitNothingMatched
+	}
+	return exitOK
+}
+
+// exitAfterRun ends a run that placed files with the exit code its outcome
+// calls for, once the journal and summary are saved. msgKey names the error
+// message logged when err is set. It returns only when every file was placed.
+func exitAfterRun(err error, msgKey string, cfg FilesMoveConfiguration) {
+	if err != nil {
+		exitIfInterrupted(err, cfg.Language)
+		log.Printf(locMsg(msgKey, cfg.Language)+": %v", err)
+		os.Exit(exitFileErrors)
+	}
+	switch code := cfg.Summary.exitStatus(); code {
+	case exitOK:
+		return
+	case exitNothingMatched:
+		log.Println(locMsg("nothing_matched", cfg.Language))
+		os.Exit(code)
+	default:
+		os.Exit(code)
+	}
+}
@@ -1,63 +1,353 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
 // organizeFiles walks the input folder, determines each file's year/quarter
-// from its modification time, and moves it into a subfolder in the output folder.
-func organizeFiles(cfg FilesMoveConfiguration) error {
-	return filepath.Walk(cfg.InputFolder, func(path string, info os.FileInfo, err error) error {
+// from its configured date sources, and moves it into a subfolder in the output folder.
+func organizeFiles(ctx context.Context, cfg FilesMoveConfiguration) error {
+	cfg = withEventClusters(withPeriodDensity(cfg))
+	var err error
+	if cfg.Workers > 1 {
+		err = organizeConcurrently(ctx, cfg)
+	} else {
+		err = organizeSequentially(ctx, cfg)
+	}
+	err = retryLockedFiles(ctx, cfg, err)
+	if err == nil && cfg.KeepGoing {
+		err = cfg.Summary.filesFailed()
+	}
+	return err
+}
+
+func organizeSequentially(ctx context.Context, cfg FilesMoveConfiguration) error {
+	var deferred []fileTask
+	err := walkInputGroups(cfg, func(task fileTask) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if cfg.LargeFileQueue && isLargeFile(task.info, cfg) {
+			log.Printf(locMsg("large_file_deferred", cfg.Language), task.path, formatBytes(task.info.Size()))
+			deferred = append(deferred, task)
+			return nil
+		}
+		return organizeTask(ctx, task, cfg)
+	})
+	for _, task := range deferred {
+		if err != nil {
+			break
+		}
+		if err = ctx.Err(); err == nil {
+			err = organizeTask(ctx, task, cfg)
+		}
+	}
+	return err
+}
+
+// organizeTask organizes a walked file, then its companions beside it. A
+// companion whose file was not placed, being a duplicate or skipped, is
+// organized on its own.
+func organizeTask(ctx context.Context, task fileTask, cfg FilesMoveConfiguration) error {
+	leader, err := placeFile(ctx, task.path, task.info, nil, cfg)
+	for _, companion := range task.companions {
+		if err != nil {
+			break
+		}
+		_, err = placeFile(ctx, companion.path, companion.info, leader, cfg)
+	}
+	return err
+}
+
+// organizeFile plans and executes the move of a single file. Failures are
+// counted across runs so a file that keeps failing can be quarantined.
+func organizeFile(ctx context.Context, path string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	_, err := placeFile(ctx, path, info, nil, cfg)
+	return err
+}
+
+// placeFile is organizeFile for a file that follows leader, the move of its
+// file, when leader is not nil. It returns the move it made, with the path
+// the file was placed at, or nil when it placed nothing.
+func placeFile(ctx context.Context, path string, info os.FileInfo, leader *PlannedMove, cfg FilesMoveConfiguration) (*PlannedMove, error) {
+	defer cfg.Progress.advance(info.Size())
+	if dst := cfg.Failures.quarantined(path, info); dst != "" {
+		log.Printf(locMsg("skipping_quarantined", cfg.Language), path, dst)
+		cfg.Summary.recordSkipped()
+		return nil, nil
+	}
+	if cfg.Incremental && cfg.Index.processed(path, info, cfg) {
+		cfg.Summary.recordSkipped()
+		return nil, nil
+	}
+	if cfg.Tree.unchanged(path, info) || cfg.Index.unchanged(path, info, cfg) {
+		cfg.Summary.recordSkipped()
+		return nil, nil
+	}
+	var move PlannedMove
+	var skip bool
+	var err error
+	if leader != nil {
+		move, skip, err = planCompanion(path, info, *leader, cfg)
+	} else {
+		move, skip, err = planFile(path, info, cfg)
+	}
+	// A companion goes with its file even when the index holds its content
+	// elsewhere; skipping it would split the pair.
+	if err == nil && !skip && leader == nil {
+		if skip, err = cfg.Index.duplicate(&move, info, cfg); skip {
+			cfg.Summary.recordSkipped()
+		} else if err != nil {
+			cfg.Summary.recordFailure(path, err, cfg.Language)
+		}
+	}
+	if skip {
+		if err == nil {
+			cfg.Tree.settle(path, info, cfg)
+		}
+		return nil, err
+	}
+	var placed string
+	if err == nil {
+		if err = cfg.Quota.reserve(move, info, cfg); err != nil {
+			log.Printf(locMsg("quota_reached", cfg.Language), path, err)
+			cfg.Summary.recordFailure(path, err, cfg.Language)
+			return nil, err
+		}
+		if placed, err = executeMove(ctx, move, info, cfg); placed == "" {
+			cfg.Quota.release(move, info, cfg)
+		}
+	}
+	if err == nil {
+		cfg.Failures.clear(path)
+		cfg.Tree.settle(path, info, cfg)
+		if placed == "" {
+			return nil, nil
+		}
+		move.Destination = placed
+		return &move, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if cfg.Locked.postpone(path, info, move, err, cfg) {
+		return nil, nil
+	}
+	err = handleFileFailure(ctx, path, info, err, cfg)
+	if err != nil && cfg.KeepGoing {
+		// The failure is in the summary and reported once the run is over.
+		return nil, nil
+	}
+	return nil, err
+}
+
+// walkInputFiles calls fn for every regular file under the input folder,
+// pruning folders that structo itself owns. With --files-from, only the
+// listed files are visited.
+func walkInputFiles(cfg FilesMoveConfiguration, fn func(path string, info os.FileInfo) error) error {
+	if cfg.FilesFrom != nil {
+		return walkListedFiles(cfg, fn)
+	}
+	root := cfg.InputFolder
+	if info, err := os.Lstat(root); err == nil && isMountPoint(info) {
+		// An input folder that is a junction itself was asked for.
+		root += string(filepath.Separator)
+	}
+	return walkTree(root, cfg, map[string]bool{}, fn)
+}
+
+// walkTree is walkInputFiles below root, which is the input folder or a
+// junction it follows. followed holds the targets of the junctions followed
+// so far.
+func walkTree(root string, cfg FilesMoveConfiguration, followed map[string]bool, fn func(path string, info os.FileInfo) error) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
 		path = strings.TrimSpace(path)
 		if err != nil {
 			logError("error_organizing", cfg.Language, err)
 			return nil
 		}
 
-		if info.IsDir() {
+		info := newLazyFileInfo(entry)
+		if entry.IsDir() {
+			if isPrunedDir(path, info, cfg) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-
-		if skip, skipErr := applySkipFilters(path, info, cfg); skip || skipErr != nil {
-			return skipErr
+		if entry.Type()&fs.ModeIrregular != 0 && isMountPoint(info) {
+			return walkMountPoint(path, info, cfg, followed, fn)
 		}
 
-		targetPath, dirErr := determineTargetPath(path, info, cfg)
-		if dirErr != nil {
-			return dirErr
+		return fn(path, info)
+	})
+}
+
+// walkMountPoint deals with a junction or mount point met by the walk as
+// --junctions says. A junction is followed at most once, and never when it
+// leads back to a folder the walk is already in.
+func walkMountPoint(path string, info os.FileInfo, cfg FilesMoveConfiguration, followed map[string]bool, fn func(path string, info os.FileInfo) error) error {
+	switch cfg.Junctions {
+	case JunctionFail:
+		return fmt.Errorf("%q is a junction or mount point; pass --junctions skip or follow", path)
+	case JunctionFollow:
+		if isPrunedDir(path, info, cfg) {
+			return nil
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			target, err = filepath.Abs(target)
+		}
+		if err != nil {
+			logError("error_organizing", cfg.Language, newOpError("resolve junction", path, err))
+			return nil
 		}
+		absPath, err := filepath.Abs(path)
+		if err != nil || followed[target] || isBelow(absPath, target) || absPath == target {
+			log.Printf(locMsg("junction_loop", cfg.Language), path, target)
+			return nil
+		}
+		followed[target] = true
+		log.Printf(locMsg("junction_followed", cfg.Language), path, target)
+		// The trailing separator has the walk resolve the junction itself.
+		return walkTree(path+string(filepath.Separator), cfg, followed, fn)
+	default:
+		log.Printf(locMsg("junction_skipped", cfg.Language), path)
+		return nil
+	}
+}
+
+// isPrunedDir reports whether the walk leaves out a folder and everything
+// below it: folders structo owns, and folders excluded by the filters.
+func isPrunedDir(path string, info os.FileInfo, cfg FilesMoveConfiguration) bool {
+	return isChunkStorePath(path, cfg) || isQuarantinePath(path, cfg) || isGalleryPath(path, cfg) || isMetadataPath(path) || isLinkedOutputPath(path, cfg) ||
+		isExcludedDir(path, cfg) || isSkippedMediaDir(path, info, cfg)
+}
 
-		if mkErr := ensureTargetDirectory(targetPath, cfg.DryRun); mkErr != nil {
-			return mkErr
+// planFile applies the skip filters and works out where path belongs.
+// It reports skip=true for files that should be left alone.
+func planFile(path string, info os.FileInfo, cfg FilesMoveConfiguration) (PlannedMove, bool, error) {
+	if skip, skipErr := applyInPlaceFilters(path, info, cfg); skip || skipErr != nil {
+		if skip {
+			cfg.Summary.recordSkipped()
+		} else {
+			cfg.Summary.recordFailure(path, skipErr, cfg.Language)
 		}
+		return PlannedMove{}, skip, skipErr
+	}
+
+	move, skip, dirErr := determineTargetPath(path, info, cfg)
+	if dirErr != nil {
+		cfg.Summary.recordFailure(path, dirErr, cfg.Language)
+		return PlannedMove{}, false, dirErr
+	}
+	if skip {
+		cfg.Summary.recordSkipped()
+	}
+	return move, skip, nil
+}
 
-		if moveErr := moveFile(path, targetPath, info, cfg.DryRun); moveErr != nil {
-			logMoveError(path, targetPath, cfg.Language, moveErr)
-			return moveErr
+// planCompanion plans the move of a companion beside leader, the move of
+// its file, under the same name and with the same date.
+func planCompanion(path string, info os.FileInfo, leader PlannedMove, cfg FilesMoveConfiguration) (PlannedMove, bool, error) {
+	if skip, skipErr := applyInPlaceFilters(path, info, cfg); skip || skipErr != nil {
+		if skip {
+			cfg.Summary.recordSkipped()
+		} else {
+			cfg.Summary.recordFailure(path, skipErr, cfg.Language)
 		}
+		return PlannedMove{}, skip, skipErr
+	}
+	log.Printf(locMsg("companion_follows", cfg.Language), path, leader.Source)
+	move := leader
+	move.Source = path
+	move.Destination = companionDestination(path, leader.Source, leader.Destination, cfg)
+	move.Size, move.ModTime, move.Hash = info.Size(), info.ModTime(), ""
+	if relocated, _ := isPathAlreadyRelocated(path, move.Destination); relocated {
+		log.Printf(locMsg("skipping_file", cfg.Language), path)
+		cfg.Summary.recordSkipped()
+		return PlannedMove{}, true, nil
+	}
+	return move, false, nil
+}
 
-		if !cfg.DryRun {
-			logMovedFile(path, targetPath, cfg.Language)
+// executeMove carries out a planned move, journaling it on success, and
+// returns where the file was placed, or "" when it was skipped. A move cut
+// short by cancellation is not a per-file failure; the cancellation is returned.
+func executeMove(ctx context.Context, move PlannedMove, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	path, targetPath := move.Source, move.Destination
+	if mkErr := ensureTargetDirectory(targetPath, cfg); mkErr != nil {
+		cfg.Summary.recordFailure(path, mkErr, cfg.Language)
+		return "", mkErr
+	}
+
+	finalPath, retries, moveErr := transferWithRetries(ctx, path, targetPath, info, cfg)
+	if ctxErr := ctx.Err(); moveErr != nil && ctxErr != nil {
+		return "", ctxErr
+	}
+	var duplicate *duplicateFileError
+	if errors.As(moveErr, &duplicate) {
+		log.Printf(locMsg("duplicate_skipped", cfg.Language), path, duplicate.Existing)
+		cfg.Summary.recordSkipped()
+		return "", nil
+	}
+	var conflict *conflictSkippedError
+	if errors.As(moveErr, &conflict) {
+		log.Printf(locMsg("conflict_skipped", cfg.Language), path, conflict.Existing)
+		cfg.Summary.recordSkipped()
+		return "", nil
+	}
+	if moveErr != nil {
+		logMoveError(path, targetPath, cfg.Language, moveErr)
+		cfg.Summary.recordFailure(path, moveErr, cfg.Language)
+		return "", moveErr
+	}
+
+	cfg.Summary.recordTransferred()
+	cfg.Summary.recordDay(bucketDate(move.Date, cfg))
+	if !cfg.DryRun {
+		cfg.Journal.record(path, finalPath, info, retries, cfg)
+		if err := cfg.Index.record(move, finalPath, info); err != nil {
+			log.Printf(locMsg("index_error", cfg.Language), err)
 		}
-		return nil
-	})
+		cfg.ExifCache.carry(path, finalPath, info)
+		logTransferredFile(path, finalPath, cfg)
+	}
+	return finalPath, nil
 }
 
 func logError(msgKey, language string, err error) {
-	log.Println(locMsg(msgKey, language)+": %v", err)
+	log.Printf(locMsg(msgKey, language)+": %v", err)
 }
 
-func applySkipFilters(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+// applyInPlaceFilters holds the filters that apply to every command touching
+// the input, including those that do not organize into date folders.
+func applyInPlaceFilters(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
 	filters := []func(string, os.FileInfo, FilesMoveConfiguration) (bool, error){
-		isPathAlreadyRelocatedFilter,
 		isLoggerPathFilter,
+		isStructoArtifactFilter,
+		isHiddenFilter,
+		isExtensionFilter,
+		isPathPatternFilter,
+		isChunkManifestFilter,
+		// The filters below need the file's size or times, so they come last
+		// and a file skipped by name is never stat'ed. determineTargetPath
+		// checks whether the file is already in place once it has resolved
+		// the file's date, after all of them.
 		isFilterByBeforeConfiguration,
+		isFilterByAfterConfiguration,
+		isSizeFilter,
+		isMinAgeFilter,
+		isUnstableFileFilter,
 	}
 
 	for _, filter := range filters {
@@ -68,17 +358,6 @@ func applySkipFilters(path string, info os.FileInfo, cfg FilesMoveConfiguration)
 	return false, nil
 }
 
-func isPathAlreadyRelocatedFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
-	skip, skipErr := isPathAlreadyRelocated(path, determineTargetPathUnsafe(path, info, cfg))
-	if skipErr != nil {
-		return false, skipErr
-	}
-	if skip {
-		log.Printf(locMsg("skipping_file", cfg.Language), path)
-	}
-	return skip, nil
-}
-
 func isLoggerPathFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
 	if isPathTheLogger(path, cfg) {
 		log.Printf(locMsg("skipping_file", cfg.Language), path)
@@ -102,69 +381,178 @@ func isFilterByBeforeConfiguration(path string, info os.FileInfo, cfg FilesMoveC
 	return isFiltered, nil
 }
 
+func isFilterByAfterConfiguration(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.After == nil {
+		return false, nil
+	}
+	afterDate, parseErr := time.Parse("2006-01-02", *cfg.After)
+	if parseErr != nil {
+		return false, fmt.Errorf("invalid 'after' date format: %w", parseErr)
+	}
+	isFiltered := info.ModTime().Before(afterDate)
+	if isFiltered {
+		log.Printf("[INFO] Skipping file: '%s'. Reason: Modified on '%s', which is before the specified 'after' date '%s'.", path, info.ModTime().Format("2006-01-02"), *cfg.After)
+	}
+	return isFiltered, nil
+}
+
+// isExtensionFilter skips files whose extension is excluded, or not included
+// when an include list is given. Extensions compare case-insensitively.
+func isExtensionFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if len(cfg.IncludeExt) == 0 && len(cfg.ExcludeExt) == 0 {
+		return false, nil
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(info.Name()), "."))
+	excluded := slices.Contains(cfg.ExcludeExt, ext)
+	if !excluded && len(cfg.IncludeExt) > 0 {
+		excluded = !slices.Contains(cfg.IncludeExt, ext)
+	}
+	if excluded {
+		log.Printf(locMsg("skipping_extension", cfg.Language), path)
+	}
+	return excluded, nil
+}
+
+// isLinkedOutputPath reports whether path is the output folder of a link-mode run
+// nested inside the input; its contents are links we created and must not be re-linked.
+func isLinkedOutputPath(path string, cfg FilesMoveConfiguration) bool {
+	if !cfg.Mode.IsLink() {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absOutput, err := filepath.Abs(cfg.OutputFolder)
+	if err != nil {
+		return false
+	}
+	return absPath == absOutput
+}
+
+func isStructoArtifactFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if isStructoArtifact(path) {
+		log.Printf(locMsg("skipping_file", cfg.Language), path)
+		return true, nil
+	}
+	return false, nil
+}
+
+func isChunkManifestFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.Backend == BackendChunkStore && strings.HasSuffix(path, manifestExt) {
+		log.Printf(locMsg("skipping_file", cfg.Language), path)
+		return true, nil
+	}
+	return false, nil
+}
+
 func isImageFile(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif", ".webp", ".svg":
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif", ".webp", ".svg",
+		".heic", ".heif", ".avif", ".raw", ".cr2", ".cr3", ".nef", ".arw", ".dng", ".orf", ".rw2":
 		return true
 	default:
 		return false
 	}
 }
 
-func determineTargetPath(path string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
-	var dateTaken *time.Time
-	if isImageFile(path) {
-		dateTaken, _ = GetDateTaken(path)
+// determineTargetPath works out where path belongs, resolving its date once.
+// It reports skip=true, with the reason logged, for a file no date source
+// can date and for a file already where it belongs; the folder is only
+// created for a file that is going to move.
+func determineTargetPath(path string, info os.FileInfo, cfg FilesMoveConfiguration) (PlannedMove, bool, error) {
+	dated, datedInfo := folderFile(path, info, cfg)
+	if dated != path {
+		log.Printf(locMsg("companion_follows", cfg.Language), path, dated)
 	}
-	if dateTaken == nil {
-		modTime := info.ModTime()
-		dateTaken = &modTime
-	}
-	dir, dirErr := buildAndEnsureTargetDir(cfg.OutputFolder, *dateTaken, cfg)
-	if dirErr != nil {
-		return "", dirErr
+	pinned, overridden, err := overriddenFolder(path, info, dated, datedInfo, cfg)
+	if err != nil {
+		return PlannedMove{}, false, err
+	}
+	date, source, dateErr := resolveFileDate(dated, datedInfo, cfg)
+	if dateErr != nil && !overridden {
+		log.Printf(locMsg("skipping_undated", cfg.Language), path)
+		return PlannedMove{}, true, nil
+	}
+	if dateErr != nil {
+		// The date only feeds the summary of a pinned file.
+		date, source = info.ModTime(), DateSourceMtime
+	}
+	log.Printf(locMsg("date_source_used", cfg.Language), path, source, date.Format(time.RFC3339))
+	dir := pinned
+	if overridden {
+		log.Printf(locMsg("override_used", cfg.Language), path, pinned)
+	} else if dir, err = buildTargetDir(cfg.OutputFolder, dated, datedInfo, date, cfg); err != nil {
+		return PlannedMove{}, false, err
+	}
+	move := PlannedMove{
+		Source:      path,
+		Destination: filepath.Join(dir, sanitizeFileName(info.Name(), cfg.Capabilities)),
+		Mode:        cfg.Mode.String(),
+		Backend:     cfg.Backend.String(),
+		OnConflict:  cfg.OnConflict.String(),
+		DateSource:  source.String(),
+		Date:        date,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}
+	preserved := !overridden && cfg.PreserveStructure
+	if preserved {
+		relPath, relErr := filepath.Rel(cfg.InputFolder, path)
+		if relErr != nil {
+			return PlannedMove{}, false, fmt.Errorf("failed to determine relative path: %w", relErr)
+		}
+		move.Destination = filepath.Join(dir, sanitizeRelPath(chatExportRelPath(cfg.InputFolder, relPath), cfg.Capabilities))
 	}
-	if !cfg.PreserveStructure {
-		return filepath.Join(dir, info.Name()), nil
+	// This also catches a file pinned by --overrides that already sits in
+	// its folder.
+	if relocated, _ := isPathAlreadyRelocated(path, move.Destination); relocated {
+		log.Printf(locMsg("skipping_file", cfg.Language), path)
+		return PlannedMove{}, true, nil
 	}
-	relPath, relErr := filepath.Rel(cfg.InputFolder, path)
-	if relErr != nil {
-		return "", fmt.Errorf("failed to determine relative path: %w", relErr)
+	if !overridden {
+		if err := ensureTargetDir(dir, cfg); err != nil {
+			return PlannedMove{}, false, err
+		}
 	}
-	return filepath.Join(dir, relPath), nil
-}
-
-func determineTargetPathUnsafe(path string, info os.FileInfo, cfg FilesMoveConfiguration) string {
-	dir, _ := buildAndEnsureTargetDir(cfg.OutputFolder, info.ModTime(), cfg)
-	if !cfg.PreserveStructure {
-		return filepath.Join(dir, info.Name())
+	if preserved {
+		cfg.DirTimes.record(path, move.Destination, cfg)
 	}
-	relPath, _ := filepath.Rel(cfg.InputFolder, path)
-	return filepath.Join(dir, relPath)
+	return move, false, nil
 }
 
-func ensureTargetDirectory(targetPath string, dryRun bool) error {
-	if dryRun {
+func ensureTargetDirectory(targetPath string, cfg FilesMoveConfiguration) error {
+	if cfg.DryRun {
 		return nil
 	}
 	dir := filepath.Dir(targetPath)
 
-	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
-		return fmt.Errorf("failed to create target directory for %q: %w", targetPath, mkErr)
+	if dsErr := ensureYearDataset(dir, cfg); dsErr != nil {
+		return dsErr
 	}
-	return nil
+	return ensureDir(dir, cfg)
 }
 
 func logMoveError(path, targetPath, language string, err error) {
 	log.Printf(locMsg("move_error", language), path, targetPath, err)
 }
 
-func logMovedFile(path, targetPath, language string) {
-	log.Printf(locMsg("moved_file", language), path, targetPath)
+func logTransferredFile(path, targetPath string, cfg FilesMoveConfiguration) {
+	switch cfg.Mode {
+	case ModeCopy:
+		log.Printf(locMsg("copied_file", cfg.Language), path, targetPath)
+	case ModeSymlink, ModeHardlink:
+		log.Printf(locMsg("linked_file", cfg.Language), cfg.Mode, path, targetPath)
+	default:
+		log.Printf(locMsg("moved_file", cfg.Language), path, targetPath)
+	}
 }
 
 func isPathTheLogger(path string, config FilesMoveConfiguration) bool {
+	if config.Logger == nil {
+		return false
+	}
 	loggerPath := config.Logger.Name()
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -181,29 +569,54 @@ func isPathTheLogger(path string, config FilesMoveConfiguration) bool {
 	return absPath == absLoggerPath
 }
 
-// buildAndEnsureTargetDir determines the correct quarter/year folder, then creates
-// the directory if necessary. It returns the final path where files should go.
-func buildAndEnsureTargetDir(outputFolder string, modTime time.Time, cfg FilesMoveConfiguration) (string, error) {
-	dir, err := createFolderFormatDirectory(outputFolder, modTime, cfg)
-	if err != nil {
-		return "", fmt.Errorf("failed to build quarter folder: %w", err)
+// ensureTargetDir creates the folder buildTargetDir chose, and the dataset of
+// its year, if necessary.
+func ensureTargetDir(dir string, cfg FilesMoveConfiguration) error {
+	if dsErr := ensureYearDataset(dir, cfg); dsErr != nil {
+		return dsErr
 	}
 
 	if cfg.DryRun {
-		return dir, nil
+		return nil
 	}
 
-	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
-		return "", fmt.Errorf("failed to create target directory %q: %w", dir, mkErr)
+	return ensureDir(dir, cfg)
+}
+
+// buildTargetDir determines the correct quarter/year folder for the file,
+// without creating anything.
+func buildTargetDir(outputFolder, path string, info os.FileInfo, modTime time.Time, cfg FilesMoveConfiguration) (string, error) {
+	dir, err := createFolderFormatDirectory(outputFolder, info.Name(), modTime, cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to build quarter folder: %w", err)
 	}
-	return dir, nil
+	return resolveEquivalentFolders(outputFolder, cameraFolder(dir, path, info, cfg)), nil
+}
+
+// duplicateFileError reports that a file with identical content already exists
+// at the destination, so the source does not need to be placed again.
+type duplicateFileError struct {
+	Source   string
+	Existing string
+}
+
+func (e *duplicateFileError) Error() string {
+	return fmt.Sprintf("%q is identical to %q", e.Source, e.Existing)
 }
 
 // ensureUniquePath checks if path already exists, and if so, appends (1), (2), etc.
 // until we find a free name. Returns the final path that doesn't conflict.
-func ensureUniquePath(path string) (string, error) {
-	if !fileExists(path) {
-		return path, nil
+// When src is given, each existing candidate is compared with it, by size and
+// then by cfg.ConflictHash or, for large files, byte by byte (see
+// sameContent), and a *duplicateFileError is returned if one already holds the same content.
+// The chosen name is reserved for the rest of the run so concurrent workers
+// (and dry runs) never pick the same one. With src, it stays in flight until
+// the caller settles or releases it, and a candidate another worker is still
+// writing is waited for, then compared once it has landed.
+func ensureUniquePath(src, path string, cfg FilesMoveConfiguration) (string, error) {
+	reserve := cfg.Reservations.reserve
+	if src != "" {
+		reserve = cfg.Reservations.reserveInFlight
 	}
 
 	dir := filepath.Dir(path)
@@ -211,92 +624,275 @@ func ensureUniquePath(path string) (string, error) {
 	ext := filepath.Ext(base)
 	name := base[:len(base)-len(ext)]
 
-	i := 1
-	for {
-		// e.g. "document(1).pdf", "document(2).pdf"
-		newBase := fmt.Sprintf("%s(%d)%s", name, i, ext)
-		newPath := filepath.Join(dir, newBase)
-		if !fileExists(newPath) {
-			return newPath, nil
+	candidate := path
+	for i := 1; ; {
+		if reserve(candidate) {
+			return candidate, nil
 		}
+		if src != "" && cfg.Reservations.wait(candidate) {
+			// Landed or given up meanwhile: look at the name again.
+			continue
+		}
+		if err := checkDuplicate(src, candidate, cfg.ConflictHash); err != nil {
+			return "", err
+		}
+		// e.g. "document(1).pdf", "document(2).pdf"
+		candidate = filepath.Join(dir, fmt.Sprintf("%s(%d)%s", name, i, ext))
 		i++
 	}
 }
 
+// checkDuplicate returns a *duplicateFileError when existing has the same content as src.
+func checkDuplicate(src, existing string, algo HashAlgorithm) error {
+	if src == "" || !fileExists(existing) {
+		// Names only reserved, as in a dry run, have no content to compare.
+		return nil
+	}
+	same, err := sameContent(src, existing, algo)
+	if err != nil {
+		return fmt.Errorf("failed to compare %q with %q: %w", src, existing, err)
+	}
+	if same {
+		return &duplicateFileError{Source: src, Existing: existing}
+	}
+	return nil
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
 
+// transferFile places src at dst using the configured output backend and returns
+// the final destination, which may carry a "(1)" suffix. Once the file has
+// landed, workers waiting to compare with it are let go.
+func transferFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	finalPath, err := transferToBackend(ctx, src, dst, info, cfg)
+	if err == nil {
+		cfg.Reservations.settle(finalPath)
+	}
+	return finalPath, err
+}
+
+func transferToBackend(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	switch cfg.Backend {
+	case BackendChunkStore:
+		return storeInChunkStore(ctx, src, dst, info, cfg)
+	default:
+		switch cfg.Mode {
+		case ModeCopy:
+			return transferLinked(ctx, src, dst, info, cfg, func() (string, error) {
+				return copyFile(ctx, src, dst, info, cfg)
+			})
+		case ModeSymlink, ModeHardlink:
+			return linkFile(src, dst, cfg)
+		default:
+			return transferLinked(ctx, src, dst, info, cfg, func() (string, error) {
+				return moveFile(ctx, src, dst, info, cfg)
+			})
+		}
+	}
+}
+
 // In your moveFile function, before actually renaming/copying:
-func moveFile(src, dst string, info os.FileInfo, dryRun bool) error {
-	uniqueDst, err := ensureUniquePath(dst)
+func moveFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	uniqueDst, err := resolveDestination(src, dst, cfg)
 	if err != nil {
-		return fmt.Errorf("error ensuring unique path: %w", err)
+		return "", err
 	}
 
-	if dryRun {
+	if cfg.DryRun {
 		log.Printf("[DRY RUN] Would move: %s => %s", src, uniqueDst)
-		return nil
+		return uniqueDst, nil
 	}
 
-	err = os.Rename(src, uniqueDst)
+	finalPath, err := relocateFile(ctx, src, uniqueDst, info, cfg)
+	if err != nil {
+		abandonDestination(uniqueDst, cfg)
+	}
+	return finalPath, err
+}
+
+// relocateFile renames src to dst, falling back to copy and remove when a rename
+// is not possible (e.g. across drives). The original is only removed once the
+// copy is complete, so a cancelled fallback never loses it.
+func relocateFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	err := cfg.FS.Rename(src, dst)
 	if err == nil {
 		// Rename succeeded
-		return nil
+		return dst, nil
 	}
 
-	log.Printf("Rename failed, falling back to copy: %s => %s (err=%v)", src, uniqueDst, err)
+	log.Printf("Rename failed, falling back to copy: %s => %s (err=%v)", src, dst, err)
 
 	// Copy fallback
-	if copyErr := copyFilePreserve(src, uniqueDst, info, dryRun); copyErr != nil {
-		return fmt.Errorf("copy fallback failed: %w", copyErr)
+	if copyErr := copyFilePreserve(ctx, src, dst, info, cfg); copyErr != nil {
+		return "", fmt.Errorf("copy fallback failed: %w", copyErr)
 	}
 
-	// Remove the original (only if not a dry run)
-	if dryRun {
-		log.Printf("[DRY RUN] Would remove original: %s", src)
-	} else if rmErr := os.Remove(src); rmErr != nil {
-		return fmt.Errorf("failed removing original %q: %w", src, rmErr)
+	if rmErr := removeOriginal(src, cfg); rmErr != nil {
+		return "", rmErr
 	}
 
-	return nil
+	return dst, nil
 }
 
-// copyFilePreserve copies src into dst, then sets mod/acc times
-// to match the original file.
-func copyFilePreserve(src, dst string, info os.FileInfo, dryRun bool) error {
-	if dryRun {
+// copyFile copies src to a conflict-free name at dst and leaves the original in place.
+func copyFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	uniqueDst, err := resolveDestination(src, dst, cfg)
+	if err != nil {
+		return "", err
+	}
+	if copyErr := copyFilePreserve(ctx, src, uniqueDst, info, cfg); copyErr != nil {
+		abandonDestination(uniqueDst, cfg)
+		return "", fmt.Errorf("copy failed: %w", copyErr)
+	}
+	return uniqueDst, nil
+}
+
+// linkFile creates a symlink or hardlink at a conflict-free name at dst pointing to src.
+// Symlinks use the absolute source path so the organized view survives being moved.
+func linkFile(src, dst string, cfg FilesMoveConfiguration) (string, error) {
+	uniqueDst, err := resolveDestination(src, dst, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would %s: %s => %s", cfg.Mode, src, uniqueDst)
+		return uniqueDst, nil
+	}
+	if err := placeLink(src, uniqueDst, cfg); err != nil {
+		abandonDestination(uniqueDst, cfg)
+		return "", err
+	}
+	return uniqueDst, nil
+}
+
+func placeLink(src, dst string, cfg FilesMoveConfiguration) error {
+	if cfg.OnConflict == ConflictOverwrite && fileExists(dst) {
+		// Links cannot replace a file the way a rename does.
+		if rmErr := cfg.FS.Remove(dst); rmErr != nil {
+			return newOpError("remove overwritten file", dst, rmErr)
+		}
+	}
+
+	if cfg.Mode == ModeHardlink {
+		return newOpError("hardlink", dst, cfg.FS.Link(src, dst))
+	}
+
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for %q: %w", src, err)
+	}
+	return newOpError("symlink", dst, cfg.FS.Symlink(absSrc, dst))
+}
+
+// copyFilePreserve copies src into dst, then sets mod/acc times to match the
+// original file. The copy is written to dst+partialSuffix and only renamed to
+// dst once complete, and flushed first with --fsync, so a crash never leaves
+// a truncated file under the final name for later runs to mistake for a
+// finished copy. If the copy fails or ctx is cancelled midway, the partial
+// file is removed.
+func copyFilePreserve(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	if cfg.DryRun {
 		log.Printf("[DRY RUN] Would copy: %s => %s", src, dst)
 		return nil
 	}
 
-	srcFile, err := os.Open(src)
+	if isLargeFile(info, cfg) {
+		return copyLargeFile(ctx, src, dst, info, cfg)
+	}
+	partial := dst + partialSuffix
+	if err := copyToPartial(ctx, src, partial, info, cfg); err != nil {
+		cfg.FS.Remove(partial)
+		return err
+	}
+	if err := preserveAttributes(src, partial, dst, info, cfg); err != nil {
+		cfg.FS.Remove(partial)
+		return err
+	}
+	if err := cfg.FS.Rename(partial, dst); err != nil {
+		cfg.FS.Remove(partial)
+		return newOpError("rename", partial, err)
+	}
+
+	// Preserve mod/access time, rounded the way the destination would store it
+	modTime := roundToGranularity(info.ModTime(), cfg.Capabilities.TimeGranularity)
+	return preserveTimes(dst, modTime, cfg)
+}
+
+// copyToPartial writes the contents of src to partial, cloning it or letting
+// the storage do the copy where it can. A sparse file is copied region by
+// region so its holes stay holes; a server-side copy would fill them in.
+func copyToPartial(ctx context.Context, src, partial string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	sparse := isSparseFile(info)
+	offloaded, err := reflinkFile(src, partial, cfg)
+	if err == nil && !offloaded && !sparse {
+		offloaded, err = offloadFile(ctx, src, partial, info, cfg)
+	}
 	if err != nil {
 		return err
 	}
+	if offloaded {
+		if !cfg.Fsync {
+			return nil
+		}
+		f, err := cfg.FS.OpenFile(partial, os.O_WRONLY, 0)
+		if err != nil {
+			return newOpError("flush", partial, err)
+		}
+		defer f.Close()
+		return newOpError("flush", partial, f.Sync())
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return newOpError("open source", src, err)
+	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	dstFile, err := cfg.FS.Create(partial)
 	if err != nil {
-		return err
+		return newOpError("create destination", partial, err)
 	}
 	defer dstFile.Close()
 
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		return err
+	if sparse {
+		if _, err := copyRange(ctx, dstFile, srcFile, 0, info.Size(), true, cfg); err != nil {
+			return newOpError("copy", partial, err)
+		}
+		if err := dstFile.Truncate(info.Size()); err != nil {
+			return newOpError("copy", partial, err)
+		}
+	} else if _, err := copyBuffered(dstFile, contextReader{ctx, srcFile}, cfg); err != nil {
+		return newOpError("copy", partial, err)
+	}
+	if cfg.Fsync {
+		if err := dstFile.Sync(); err != nil {
+			return newOpError("flush", partial, err)
+		}
 	}
+	return newOpError("close", partial, dstFile.Close())
+}
 
-	// Close to allow time changes
-	srcFile.Close()
-	dstFile.Close()
+// contextReader stops a copy between reads once its context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
 
-	// Preserve mod/access time
-	modTime := info.ModTime()
-	if err := os.Chtimes(dst, modTime, modTime); err != nil {
-		return err
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
 	}
-	return nil
+	return cr.r.Read(p)
+}
+
+// preserveTimes sets the times of a placed file. A failure is only a warning;
+// see metadataResult.
+func preserveTimes(path string, t time.Time, cfg FilesMoveConfiguration) error {
+	return metadataResult(path, newOpError("preserve times", path, cfg.FS.Chtimes(path, t, t)), cfg)
 }
 
 // checkFolderExists ensures the given folder is actually a directory.
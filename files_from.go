@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readFileList reads the paths given to --files-from, from stdin when name is
+// "-". Paths are one per line, or NUL-separated as written by
+// "find -print0"; blank lines are ignored.
+func readFileList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, newOpError("read file list", name, err)
+	}
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	paths := []string{}
+	for _, line := range bytes.Split(data, sep) {
+		if path := strings.TrimRight(string(line), "\r"); strings.TrimSpace(path) != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// walkListedFiles calls fn for every file of --files-from instead of walking
+// the input folder. Listed files must lie below the input folder, and files in
+// folders the walk would prune are left out, so a list behaves like a walk
+// restricted to known files. Folders in the list are ignored.
+func walkListedFiles(cfg FilesMoveConfiguration, fn func(path string, info os.FileInfo) error) error {
+	absInput, err := filepath.Abs(cfg.InputFolder)
+	if err != nil {
+		return err
+	}
+	pruned := map[string]bool{}
+	for _, listed := range cfg.FilesFrom {
+		abs, err := filepath.Abs(listed)
+		if err != nil {
+			logError("error_organizing", cfg.Language, err)
+			continue
+		}
+		rel, ok := relBelow(absInput, abs)
+		if !ok {
+			log.Printf(locMsg("listed_outside_input", cfg.Language), listed, cfg.InputFolder)
+			continue
+		}
+		path := filepath.Join(cfg.InputFolder, filepath.FromSlash(rel))
+		info, err := os.Lstat(path)
+		if err != nil {
+			logError("error_organizing", cfg.Language, err)
+			continue
+		}
+		if info.IsDir() || isInPrunedDir(path, cfg, pruned) {
+			continue
+		}
+		if err := fn(path, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInPrunedDir reports whether any folder between the input folder and path
+// would be skipped by the walk. Answers are cached in pruned, keyed by folder.
+func isInPrunedDir(path string, cfg FilesMoveConfiguration, pruned map[string]bool) bool {
+	dir := filepath.Dir(path)
+	if filepath.Clean(dir) == filepath.Clean(cfg.InputFolder) {
+		return false
+	}
+	skip, ok := pruned[dir]
+	if !ok {
+		skip = isInPrunedDir(dir, cfg, pruned)
+		if !skip {
+			info, err := os.Stat(dir)
+			skip = err != nil || isPrunedDir(dir, info, cfg)
+		}
+		pruned[dir] = skip
+	}
+	return skip
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// flattenFiles pulls every file under the input folder into the output folder
+// itself, the inverse of organizing. With origins, as read by
+// recordedOrigins, files go back to their recorded paths below the output
+// folder instead, and only files without one are flattened. Names stay
+// conflict-safe through executeMove, and every placement is journaled for
+// undo. Folders emptied by moving are removed.
+func flattenFiles(ctx context.Context, cfg FilesMoveConfiguration, origins map[string]string) error {
+	tasks, err := collectInputFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	flatDir := filepath.Clean(cfg.OutputFolder)
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		destination := filepath.Join(flatDir, sanitizeFileName(task.info.Name(), cfg.Capabilities))
+		if origin, ok := origins[relSlashPath(cfg.InputFolder, task.path)]; ok {
+			destination = filepath.Join(flatDir, sanitizeRelPath(origin, cfg.Capabilities))
+		}
+		if filepath.Dir(task.path) == filepath.Dir(destination) {
+			cfg.Summary.recordSkipped()
+			continue
+		}
+		move := PlannedMove{
+			Source:      task.path,
+			Destination: destination,
+			Mode:        cfg.Mode.String(),
+			Backend:     cfg.Backend.String(),
+			OnConflict:  cfg.OnConflict.String(),
+			Size:        task.info.Size(),
+			ModTime:     task.info.ModTime(),
+		}
+		if _, err := executeMove(ctx, move, task.info, cfg); err != nil {
+			return err
+		}
+		if !cfg.DryRun && !cfg.Mode.KeepsSource() {
+			removeEmptyParents(filepath.Dir(task.path), cfg.InputFolder, cfg)
+		}
+	}
+	return nil
+}
+
+// recordedOrigins reads the journals in the root of an organized tree and
+// returns, for each file they placed there, the path it had below its run's
+// input folder, both relative to their roots and with forward slashes. A file
+// moved again within the tree, by a later organize or repair, keeps the path
+// it had before the first move. Undone journals are left out.
+func recordedOrigins(root string) (map[string]string, int, error) {
+	// Journal names hold their start time, so journals are read oldest first.
+	paths, err := artifactFiles(root, journalPrefix, ".json")
+	if err != nil {
+		return nil, 0, err
+	}
+	origins := map[string]string{}
+	read := 0
+	for _, path := range paths {
+		journal, err := loadJournal(path)
+		if err != nil {
+			return nil, read, err
+		}
+		if journal.UndoneAt != nil {
+			continue
+		}
+		read++
+		for _, entry := range journal.Entries {
+			if entry.Undone != nil || entry.Dedupe != "" {
+				continue
+			}
+			dst, ok := relBelow(journal.Output, entry.Destination)
+			if !ok {
+				continue
+			}
+			if src, ok := relBelow(journal.Output, entry.Source); ok && origins[src] != "" {
+				origins[dst] = origins[src]
+				if entry.Mode == ModeNameMove {
+					delete(origins, src)
+				}
+				continue
+			}
+			if src, ok := relBelow(journal.Input, entry.Source); ok {
+				origins[dst] = src
+			}
+		}
+	}
+	return origins, read, nil
+}
+
+// relBelow returns path relative to root with forward slashes, if it lies below root.
+func relBelow(root, path string) (string, bool) {
+	if !isBelow(path, root) {
+		return "", false
+	}
+	rel, _ := filepath.Rel(root, path)
+	return filepath.ToSlash(rel), true
+}
+
+// validateFlattenConfig rejects backends that cannot produce a flat folder of files.
+func validateFlattenConfig(cfg FilesMoveConfiguration) error {
+	if cfg.Backend == BackendChunkStore {
+		return fmt.Errorf("flatten does not support the %s backend", cfg.Backend)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,10 @@ const (
 	YearThenQuarters FolderFormat = iota
 	DayThenHours
 	HalfYears
+	HalfMonths
+	SchoolYears
+	AnchorYears
+	Events
 )
 
 const (
@@ -22,12 +27,24 @@ const (
 	SpanishFormatYearQuarters = "a\u00f1o-luego-cuartos"
 	SpanishFormatDayHours     = "dia-luego-horas"
 	SpanishHalfYears          = "medios-a\u00f1os"
+	FormatHalfMonths          = "half-month"
+	SpanishHalfMonths         = "quincenas"
+	FormatSchoolYears         = "school-year"
+	SpanishSchoolYears        = "curso-escolar"
+	FormatAnchorYears         = "anchor-years"
+	SpanishAnchorYears        = "a\u00f1os-desde-fecha"
+	FormatEvents              = "events"
+	SpanishEvents             = "eventos"
 )
 
 var stateName = map[FolderFormat]string{
 	YearThenQuarters: FormatYearQuarters,
 	DayThenHours:     FormatDayHours,
 	HalfYears:        FormatHalfYears,
+	HalfMonths:       FormatHalfMonths,
+	SchoolYears:      FormatSchoolYears,
+	AnchorYears:      FormatAnchorYears,
+	Events:           FormatEvents,
 }
 
 var reverseStateName = map[string]FolderFormat{
@@ -37,6 +54,14 @@ var reverseStateName = map[string]FolderFormat{
 	SpanishFormatDayHours:     DayThenHours,
 	FormatHalfYears:           HalfYears,
 	SpanishHalfYears:          HalfYears,
+	FormatHalfMonths:          HalfMonths,
+	SpanishHalfMonths:         HalfMonths,
+	FormatSchoolYears:         SchoolYears,
+	SpanishSchoolYears:        SchoolYears,
+	FormatAnchorYears:         AnchorYears,
+	SpanishAnchorYears:        AnchorYears,
+	FormatEvents:              Events,
+	SpanishEvents:             Events,
 }
 
 // String returns the string representation of FolderFormat.
@@ -44,28 +69,125 @@ func (ss FolderFormat) String() string {
 	return stateName[ss]
 }
 
+// deprecatedStateName holds names that are still accepted with a warning so saved
+// scripts keep working. Older builds shipped the Spanish names mis-encoded
+// ("a√±o" / "aÃ±o" instead of "año").
+var deprecatedStateName = map[string]FolderFormat{
+	"a\u221a\u00b1o-luego-cuartos": YearThenQuarters,
+	"a\u00c3\u00b1o-luego-cuartos": YearThenQuarters,
+	"ano-luego-cuartos":            YearThenQuarters,
+	"medios-a\u221a\u00b1os":       HalfYears,
+	"medios-a\u00c3\u00b1os":       HalfYears,
+	"medios-anos":                  HalfYears,
+}
+
 // ParseFolderFormat parses a string into a FolderFormat.
 func ParseFolderFormat(input string) (FolderFormat, error) {
+	format, _, err := resolveFolderFormat(input, nil)
+	return format, err
+}
+
+// resolveFolderFormat parses input using the built-in names, then user aliases,
+// then deprecated names. The returned warning is non-empty for deprecated names.
+func resolveFolderFormat(input string, aliases map[string]string) (FolderFormat, string, error) {
 	if format, ok := reverseStateName[input]; ok {
-		return format, nil
+		return format, "", nil
+	}
+	if target, ok := aliases[input]; ok {
+		format, warning, err := resolveFolderFormat(target, nil)
+		if err != nil {
+			return 0, "", fmt.Errorf("alias %q points to an invalid format: %w", input, err)
+		}
+		return format, warning, nil
+	}
+	if format, ok := deprecatedStateName[input]; ok {
+		return format, fmt.Sprintf("folder format %q is deprecated; use %q instead", input, format), nil
 	}
-	return 0, fmt.Errorf("invalid FolderFormat: %s", input)
+	return 0, "", fmt.Errorf("invalid FolderFormat: %s", input)
 }
 
-// createFolderFormatDirectory constructs a directory path based on the given FolderFormat.
-func createFolderFormatDirectory(outputRoot string, modTime time.Time, cfg FilesMoveConfiguration) (string, error) {
+// parseFolderFormatAliases parses "alias=format" pairs. Aliases may not shadow
+// built-in names, and must point at a format this build understands.
+func parseFolderFormatAliases(pairs []string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, pair := range pairs {
+		alias, target, ok := strings.Cut(pair, "=")
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		if !ok || alias == "" || target == "" {
+			return nil, fmt.Errorf("expected alias=format, got %q", pair)
+		}
+		if _, builtin := reverseStateName[alias]; builtin {
+			return nil, fmt.Errorf("alias %q shadows a built-in folder format", alias)
+		}
+		if _, _, err := resolveFolderFormat(target, nil); err != nil {
+			return nil, fmt.Errorf("alias %q: %w", alias, err)
+		}
+		aliases[alias] = target
+	}
+	return aliases, nil
+}
+
+// bucketDate returns the date that decides which day, month and quarter a
+// file belongs to. With --bucket-offset 4h a day runs from 04:00 to 04:00, so
+// a late-night file stays with the evening before; with -7h it runs from
+// 17:00 the day before, so work after closing counts as the next day, and on
+// the last day of a quarter, as the next quarter.
+func bucketDate(date time.Time, cfg FilesMoveConfiguration) time.Time {
+	return date.Add(-cfg.BucketOffset)
+}
+
+// createFolderFormatDirectory constructs a directory path based on the given
+// FolderFormat, or on --folder-template; name is the file's base name.
+func createFolderFormatDirectory(outputRoot, name string, modTime time.Time, cfg FilesMoveConfiguration) (string, error) {
+	bucket := bucketDate(modTime, cfg)
+	if dir, ok := customPeriodFolder(outputRoot, bucket, cfg); ok {
+		return dir, nil
+	}
+	if cfg.FolderTemplate != "" {
+		return createTemplateFolder(outputRoot, name, bucket, cfg)
+	}
 	switch cfg.FolderFormat {
 	case YearThenQuarters:
-		return createYearThenQuartersFolder(outputRoot, modTime, cfg.Language)
+		dir, err := createYearThenQuartersFolder(outputRoot, bucket, cfg.Language)
+		if err != nil {
+			return "", err
+		}
+		return refineDensePeriod(dir, bucket, cfg), nil
 	case DayThenHours:
-		return createDayThenHoursFolder(outputRoot, modTime)
+		return createDayThenHoursFolder(outputRoot, bucket, modTime, cfg.HourFormat)
 	case HalfYears:
-		return createHalfYearsFolder(outputRoot, modTime, cfg.Language)
+		return createHalfYearsFolder(outputRoot, bucket, cfg.Language)
+	case HalfMonths:
+		return createHalfMonthFolder(outputRoot, bucket, cfg.Language)
+	case SchoolYears:
+		return createSchoolYearFolder(outputRoot, bucket, cfg.SchoolYearStart)
+	case AnchorYears:
+		return createAnchorYearsFolder(outputRoot, bucket, cfg.AnchorDate, cfg.Language)
+	case Events:
+		return createEventFolder(outputRoot, modTime, cfg)
 	default:
 		return "", errors.New("unsupported FolderFormat")
 	}
 }
 
+// createTemplateFolder renders --folder-template for one file. Every folder
+// of the result is sanitized for the destination, and one that came out
+// empty, for example a slugified name with no letters, fails the file.
+func createTemplateFolder(outputRoot, name string, bucket time.Time, cfg FilesMoveConfiguration) (string, error) {
+	rendered, err := renderTemplate(cfg.FolderTemplate, templateValues(name, bucket), cfg.Language)
+	if err != nil {
+		return "", fmt.Errorf("folder template %q: %w", cfg.FolderTemplate, err)
+	}
+	parts := strings.Split(rendered, "/")
+	for i, part := range parts {
+		if err := validateRenderedName(part, cfg.FolderTemplate); err != nil {
+			return "", err
+		}
+		parts[i] = sanitizeFileName(part, cfg.Capabilities)
+	}
+	return filepath.Join(append([]string{outputRoot}, parts...)...), nil
+}
+
 // createYearThenQuartersFolder constructs a directory path like <outputRoot>/YYYY/Q<number>_monthRange.
 func createYearThenQuartersFolder(outputRoot string, modTime time.Time, lang string) (string, error) {
 	year := modTime.Year()
@@ -77,10 +199,13 @@ func createYearThenQuartersFolder(outputRoot string, modTime time.Time, lang str
 	return filepath.Join(outputRoot, fmt.Sprintf("%d", year), qFolder), nil
 }
 
-// createDayThenHoursFolder constructs a directory path like <outputFolder>/YYYY-MM-dd/HHa.
-func createDayThenHoursFolder(outputFolder string, modTime time.Time) (string, error) {
-	year, month, day := modTime.Date()
-	hourLabel := modTime.Format("03PM")
+// createDayThenHoursFolder constructs a directory path like <outputFolder>/YYYY-MM-dd/HHa,
+// or <outputFolder>/YYYY-MM-dd/HH with the 24h hour format. The day comes
+// from bucket and the hour from the file's own time, so a file from 02:00
+// moved to the previous day by --bucket-offset is still under 02AM.
+func createDayThenHoursFolder(outputFolder string, bucket, modTime time.Time, hours HourFormat) (string, error) {
+	year, month, day := bucket.Date()
+	hourLabel := hours.label(modTime)
 
 	if !isValidDate(year, month, day) {
 		return "", fmt.Errorf("invalid date in modTime: %v", modTime)
@@ -145,3 +270,74 @@ func semesterInfoForMonth(month int, lang string) (int, string) {
 	}
 	return semesterNum, semesterLabels[semesterNum-1]
 }
+
+// createHalfMonthFolder constructs a directory path like <outputRoot>/YYYY/MM_early
+// for days 1 to 15 and <outputRoot>/YYYY/MM_late for the rest of the month.
+func createHalfMonthFolder(outputRoot string, modTime time.Time, lang string) (string, error) {
+	halves := map[string][2]string{
+		"en": {"early", "late"},
+		"es": {"inicio", "fin"},
+	}
+	year, month, day := modTime.Date()
+	if !isValidDate(year, month, day) {
+		return "", fmt.Errorf("invalid date in modTime: %v", modTime)
+	}
+	labels, ok := halves[lang]
+	if !ok {
+		labels = halves["en"]
+	}
+	label := labels[0]
+	if day > 15 {
+		label = labels[1]
+	}
+	return filepath.Join(outputRoot, fmt.Sprintf("%d", year), fmt.Sprintf("%02d_%s", int(month), label)), nil
+}
+
+// defaultSchoolYearStart is the month school years start in unless
+// --school-year-start says otherwise.
+const defaultSchoolYearStart = time.September
+
+// createSchoolYearFolder constructs a directory path like <outputRoot>/2023-2024
+// for a school year starting in start. Months before start belong to the year
+// that began the calendar year before. A year starting in January is just the
+// calendar year, <outputRoot>/2024.
+func createSchoolYearFolder(outputRoot string, modTime time.Time, start time.Month) (string, error) {
+	year, month, day := modTime.Date()
+	if !isValidDate(year, month, day) {
+		return "", fmt.Errorf("invalid date in modTime: %v", modTime)
+	}
+	if start <= time.January {
+		return filepath.Join(outputRoot, fmt.Sprintf("%d", year)), nil
+	}
+	if month < start {
+		year--
+	}
+	return filepath.Join(outputRoot, fmt.Sprintf("%d-%d", year, year+1)), nil
+}
+
+// createAnchorYearsFolder constructs a directory path like <outputRoot>/Year_03,
+// counting whole years since anchor the way ages are counted: a file from
+// a child's third birthday up to the day before the fourth goes to Year_03.
+// Files from before the anchor go to <outputRoot>/Before.
+func createAnchorYearsFolder(outputRoot string, modTime, anchor time.Time, lang string) (string, error) {
+	labels := map[string][2]string{
+		"en": {"Year", "Before"},
+		"es": {"A\u00f1o", "Antes"},
+	}
+	year, month, day := modTime.Date()
+	if !isValidDate(year, month, day) {
+		return "", fmt.Errorf("invalid date in modTime: %v", modTime)
+	}
+	label, ok := labels[lang]
+	if !ok {
+		label = labels["en"]
+	}
+	years := year - anchor.Year()
+	if month < anchor.Month() || (month == anchor.Month() && day < anchor.Day()) {
+		years--
+	}
+	if years < 0 {
+		return filepath.Join(outputRoot, label[1]), nil
+	}
+	return filepath.Join(outputRoot, fmt.Sprintf("%s_%02d", label[0], years)), nil
+}
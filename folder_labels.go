@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supportedLanguages lists the languages folder labels and messages exist in.
+var supportedLanguages = []string{"en", "es"}
+
+// monthByLabel maps upper-case month abbreviations of every supported
+// language, as used in folder names, to their month.
+var monthByLabel = func() map[string]time.Month {
+	labels := map[string]time.Month{}
+	for _, lang := range supportedLanguages {
+		for month := time.January; month <= time.December; month++ {
+			_, label, _ := strings.Cut(formatMonthFolder(month, lang), "_")
+			labels[strings.ToUpper(label)] = month
+		}
+	}
+	return labels
+}()
+
+var (
+	quarterFolderPattern  = regexp.MustCompile(`(?i)^Q([1-4])_[A-Z-]+$`)
+	monthFolderPattern    = regexp.MustCompile(`^(\d{2})_([A-Za-z]+)$`)
+	halfYearFolderPattern = regexp.MustCompile(`^(\d{4})-([A-Za-z]+(?:-[A-Za-z]+)*)$`)
+)
+
+// periodFolderKey returns a language-independent key for a period folder
+// name, so "Q1_Jan-Mar", "Q1_Ene-Mar" and "Q1_ENE-FEB-MAR" all map to "Q1"
+// and "2021-JAN-FEB-MAR-APR-MAY-JUN" maps to "2021-H1". Names that are not
+// period folders report false.
+func periodFolderKey(name string) (string, bool) {
+	if m := quarterFolderPattern.FindStringSubmatch(name); m != nil {
+		return "Q" + m[1], true
+	}
+	if m := monthFolderPattern.FindStringSubmatch(name); m != nil {
+		number, _ := strconv.Atoi(m[1])
+		if month, ok := monthByLabel[strings.ToUpper(m[2])]; ok && int(month) == number {
+			return "M" + m[1], true
+		}
+		return "", false
+	}
+	if m := halfYearFolderPattern.FindStringSubmatch(name); m != nil {
+		var first time.Month
+		for _, label := range strings.Split(m[2], "-") {
+			month, ok := monthByLabel[strings.ToUpper(label)]
+			if !ok {
+				return "", false
+			}
+			if first == 0 {
+				first = month
+			}
+		}
+		if first <= time.June {
+			return m[1] + "-H1", true
+		}
+		return m[1] + "-H2", true
+	}
+	return "", false
+}
+
+// resolveEquivalentFolders rewrites the period folders of dir, below root,
+// to an existing folder for the same period labelled in another language.
+// Re-running in Spanish over an English archive then fills Q1_Jan-Mar
+// instead of creating a parallel Q1_Ene-Mar.
+func resolveEquivalentFolders(root, dir string) string {
+	rel, ok := relWithin(root, dir)
+	if !ok || rel == "." {
+		return dir
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	resolved := root
+	for i, part := range parts {
+		next := filepath.Join(resolved, part)
+		if _, err := os.Stat(next); err != nil {
+			existing, ok := equivalentFolder(resolved, part)
+			if !ok {
+				// Nothing below a missing folder can exist either.
+				return filepath.Join(append([]string{resolved}, parts[i:]...)...)
+			}
+			next = filepath.Join(resolved, existing)
+		}
+		resolved = next
+	}
+	return resolved
+}
+
+// equivalentFolder finds a folder in parent for the same period as name.
+func equivalentFolder(parent, name string) (string, bool) {
+	key, ok := periodFolderKey(name)
+	if !ok {
+		return "", false
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if other, ok := periodFolderKey(entry.Name()); ok && other == key {
+			return entry.Name(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ErrReadOnly is returned by every mutating call when running with --no-write.
+var ErrReadOnly = errors.New("write refused: running in --no-write mode")
+
+// FileSystem is the single entry point for every call that can mutate data.
+// Analysis-only code paths run against readOnlyFileSystem, so even a bug in
+// them cannot move, create, or delete anything.
+type FileSystem interface {
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	Create(path string) (*os.File, error)
+	OpenFile(path string, flag int, perm os.FileMode) (*os.File, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	Chtimes(path string, atime, mtime time.Time) error
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid, gid int) error
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	RunCommand(cmd *exec.Cmd) ([]byte, error)
+	ReadOnly() bool
+}
+
+// osFileSystem performs real writes through the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFileSystem) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (osFileSystem) Remove(path string) error                     { return os.Remove(path) }
+func (osFileSystem) Create(path string) (*os.File, error)         { return os.Create(path) }
+func (osFileSystem) OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag, perm)
+}
+func (osFileSystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+func (osFileSystem) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+func (osFileSystem) Chmod(path string, mode os.FileMode) error { return os.Chmod(path, mode) }
+func (osFileSystem) Chown(path string, uid, gid int) error     { return os.Chown(path, uid, gid) }
+func (osFileSystem) Symlink(oldname, newname string) error     { return os.Symlink(oldname, newname) }
+func (osFileSystem) Link(oldname, newname string) error        { return os.Link(oldname, newname) }
+func (osFileSystem) RunCommand(cmd *exec.Cmd) ([]byte, error)  { return cmd.CombinedOutput() }
+func (osFileSystem) ReadOnly() bool                            { return false }
+
+// readOnlyFileSystem refuses every mutation. MkdirAll on an existing directory
+// and read-only opens are allowed because they do not change anything on disk.
+type readOnlyFileSystem struct{}
+
+func (readOnlyFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil
+	}
+	return refuse("mkdir", path)
+}
+func (readOnlyFileSystem) Rename(oldpath, newpath string) error { return refuse("rename", oldpath) }
+func (readOnlyFileSystem) Remove(path string) error             { return refuse("remove", path) }
+func (readOnlyFileSystem) Create(path string) (*os.File, error) { return nil, refuse("create", path) }
+func (readOnlyFileSystem) OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0 {
+		return nil, refuse("open for writing", path)
+	}
+	return os.OpenFile(path, flag, perm)
+}
+func (readOnlyFileSystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return refuse("write", path)
+}
+func (readOnlyFileSystem) Chtimes(path string, atime, mtime time.Time) error {
+	return refuse("chtimes", path)
+}
+func (readOnlyFileSystem) Chmod(path string, mode os.FileMode) error {
+	return refuse("chmod", path)
+}
+func (readOnlyFileSystem) Chown(path string, uid, gid int) error { return refuse("chown", path) }
+func (readOnlyFileSystem) Symlink(oldname, newname string) error {
+	return refuse("symlink", newname)
+}
+func (readOnlyFileSystem) Link(oldname, newname string) error { return refuse("link", newname) }
+func (readOnlyFileSystem) RunCommand(cmd *exec.Cmd) ([]byte, error) {
+	return nil, refuse("run", cmd.Path)
+}
+func (readOnlyFileSystem) ReadOnly() bool { return true }
+
+func refuse(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: ErrReadOnly}
+}
+
+// newFileSystem returns the filesystem implementation for the requested write mode.
+func newFileSystem(noWrite bool) FileSystem {
+	if noWrite {
+		return readOnlyFileSystem{}
+	}
+	return osFileSystem{}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runMainEnv makes the test binary run structo's main instead of the tests,
+// so that commands, which exit the process, can be run as in a shell.
+const runMainEnv = "STRUCTO_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runStructo runs structo with args in root, with the home, config, cache
+// and temporary folders inside root, and returns its output. With stopAfter,
+// the command is interrupted then, as Ctrl+C would.
+func runStructo(t *testing.T, root string, stopAfter time.Duration, args ...string) string {
+	t.Helper()
+	ctx := context.Background()
+	if stopAfter > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, stopAfter)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, os.Args[0], args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	cmd.Dir = root
+	home := filepath.Join(root, "home")
+	cmd.Env = append(os.Environ(),
+		runMainEnv+"=1",
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, ".config"),
+		"XDG_CACHE_HOME="+filepath.Join(home, ".cache"),
+		"XDG_DATA_HOME="+filepath.Join(home, ".local", "share"),
+		"TMPDIR="+filepath.Join(root, "tmp"),
+	)
+	out, _ := cmd.CombinedOutput()
+	return string(out)
+}
+
+// treeState describes every file and folder under a root, by relative path.
+type treeState map[string]string
+
+func snapshotTree(t *testing.T, root string) treeState {
+	t.Helper()
+	state := treeState{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		desc := fmt.Sprintf("%v %d", info.Mode(), info.ModTime().UnixNano())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(path)
+			desc += " -> " + target
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			desc += fmt.Sprintf(" %x", sha256.Sum256(data))
+		}
+		state[rel] = desc
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// diffTrees lists what changed from before to after.
+func diffTrees(before, after treeState) []string {
+	var diffs []string
+	for path, desc := range before {
+		if now, ok := after[path]; !ok {
+			diffs = append(diffs, "removed "+path)
+		} else if now != desc {
+			diffs = append(diffs, "changed "+path)
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			diffs = append(diffs, "created "+path)
+		}
+	}
+	return diffs
+}
+
+// writeTestFile writes a file of the --no-write fixture with a set time.
+func writeTestFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNoWriteLeavesTreeUntouched runs every command with --no-write, and
+// --no-dry-run where it applies, over an organized tree with a journal, a
+// plan and a config to migrate, and checks that nothing under the tree, the
+// home folder or the temporary folder changed.
+func TestNoWriteLeavesTreeUntouched(t *testing.T) {
+	root := t.TempDir()
+	in, out := filepath.Join(root, "in"), filepath.Join(root, "out")
+	for _, dir := range []string{"home", "tmp", "backup", "selftest"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 64; x++ {
+		img.Set(x, x%48, color.RGBA{R: 200, A: 255})
+	}
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	may2023 := time.Date(2023, 5, 14, 12, 0, 0, 0, time.Local)
+	writeTestFile(t, filepath.Join(in, "photo.jpg"), photo.Bytes(), may2023)
+	writeTestFile(t, filepath.Join(in, "docs", "notes.txt"), []byte("notes"), may2023)
+	writeTestFile(t, filepath.Join(in, "docs", "notes copy.txt"), []byte("notes"), may2023)
+	writeTestFile(t, filepath.Join(in, "old", "letter.txt"), []byte("letter"), time.Date(2021, 2, 3, 9, 0, 0, 0, time.Local))
+	writeTestFile(t, filepath.Join(root, "old.yaml"), []byte("folder_format: medios-anos\n"), may2023)
+
+	runStructo(t, root, 0, "--input", in, "--output", out, "--mode", "copy", "--no-dry-run")
+	runStructo(t, root, 0, "--input", in, "--output", out, "plan", "--out", filepath.Join(root, "plan.json"))
+	writeTestFile(t, filepath.Join(out, "2020", "Q1_Jan-Mar", "misplaced.txt"), []byte("misplaced"), may2023)
+	journals, _ := filepath.Glob(filepath.Join(out, metadataDirName, journalPrefix+"*.json"))
+	if len(journals) != 1 {
+		t.Fatalf("expected one journal in %s, found %q", out, journals)
+	}
+
+	commands := []struct {
+		name      string
+		args      []string
+		stopAfter time.Duration
+	}{
+		{"organize", []string{"organize"}, 0},
+		{"organize move", []string{"organize", "--mode", "move", "--prune-empty", "--index", filepath.Join(root, "index.db"), "--heatmap", filepath.Join(root, "heatmap.html")}, 0},
+		{"undo", []string{"undo", journals[0]}, 0},
+		{"plan", []string{"plan", "--out", filepath.Join(root, "plan2.json")}, 0},
+		{"apply", []string{"apply", filepath.Join(root, "plan.json")}, 0},
+		{"diag", []string{"diag", "--out", filepath.Join(root, "diag.zip")}, 0},
+		{"compare-layouts", []string{"compare-layouts", "--formats", "year-then-quarters,half-years"}, 0},
+		{"dedupe remove", []string{"dedupe", "--action", "remove"}, 0},
+		{"dedupe hardlink", []string{"dedupe", "--action", "hardlink"}, 0},
+		{"rename", []string{"rename", "--pattern", "{date}_{name}{ext}"}, 0},
+		{"tag xattr", []string{"tag"}, 0},
+		{"tag db", []string{"tag", "--store", "db"}, 0},
+		{"flatten", []string{"flatten"}, 0},
+		{"flatten restore", []string{"flatten", "--restore-paths"}, 0},
+		{"watch", []string{"watch", "--debounce", "100ms"}, 2 * time.Second},
+		{"test-rules", []string{"test-rules", "--sample", in}, 0},
+		{"config schema", []string{"config", "schema"}, 0},
+		{"config migrate", []string{"config", "migrate", filepath.Join(root, "old.yaml")}, 0},
+		{"verify", []string{"verify", "--duplicates"}, 0},
+		{"repair", []string{"repair"}, 0},
+		{"audit", []string{"audit", "--source", in, "--out", filepath.Join(root, "missing.txt")}, 0},
+		{"stats", []string{"stats"}, 0},
+		{"gallery", []string{"gallery", out}, 0},
+		{"sync", []string{"sync", out, "--to", filepath.Join(root, "backup"), "--transport", "builtin"}, 0},
+		{"selftest", []string{"selftest", "--dir", filepath.Join(root, "selftest")}, 0},
+	}
+	for _, c := range commands {
+		t.Run(c.name, func(t *testing.T) {
+			before := snapshotTree(t, root)
+			args := append([]string{"--no-write", "--no-dry-run", "--input", in, "--output", out}, c.args...)
+			output := runStructo(t, root, c.stopAfter, args...)
+			if diffs := diffTrees(before, snapshotTree(t, root)); len(diffs) > 0 {
+				t.Errorf("structo %s with --no-write changed the tree: %q\n%s", c.name, diffs, output)
+			}
+		})
+	}
+}
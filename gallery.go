@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+// galleryDirName is the folder in the root of an organized tree that
+// receives its gallery unless --out says otherwise.
+const galleryDirName = ".structo-gallery"
+
+// galleryThumbQuality is the JPEG quality of thumbnails.
+const galleryThumbQuality = 80
+
+// galleryPhoto is one image of a gallery page. Thumb is empty for images
+// the standard decoders cannot read, such as HEIC and RAW files, which are
+// shown by name.
+type galleryPhoto struct {
+	Name  string
+	Path  string
+	Thumb string
+}
+
+// galleryFolder is one page of the gallery: the images directly in a folder
+// of the tree, usually a quarter.
+type galleryFolder struct {
+	Rel    string
+	Photos []galleryPhoto
+}
+
+// isGalleryPath reports whether path is the gallery folder of the tree or
+// lives inside it.
+func isGalleryPath(path string, cfg FilesMoveConfiguration) bool {
+	_, ok := relWithin(filepath.Join(cfg.OutputFolder, galleryDirName), path)
+	return ok
+}
+
+// collectGalleryFolders groups the images of the tree at cfg.InputFolder by
+// the folder they sit in, leaving out those of the gallery at out.
+func collectGalleryFolders(cfg FilesMoveConfiguration, out string) ([]*galleryFolder, error) {
+	folders := map[string]*galleryFolder{}
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+		if isStructoArtifact(path) || extCategories[ext] != "images" || isBelow(path, out) {
+			return nil
+		}
+		rel := relSlashPath(cfg.InputFolder, filepath.Dir(path))
+		if folders[rel] == nil {
+			folders[rel] = &galleryFolder{Rel: rel}
+		}
+		folders[rel].Photos = append(folders[rel].Photos, galleryPhoto{Name: info.Name(), Path: path})
+		return nil
+	})
+	list := make([]*galleryFolder, 0, len(folders))
+	for _, folder := range folders {
+		sort.Slice(folder.Photos, func(i, j int) bool { return folder.Photos[i].Name < folder.Photos[j].Name })
+		list = append(list, folder)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Rel < list[j].Rel })
+	return list, err
+}
+
+// buildGallery writes an index page and one page per folder of images to
+// out, with a thumbnail of every image that can be decoded. Thumbnails are
+// named after the image's path, size and modification time, so a rebuild
+// only makes those of new or changed images. Everything is written through
+// fsys, so --no-write refuses it.
+func buildGallery(fsys FileSystem, folders []*galleryFolder, out string, size int, lang string) error {
+	thumbDir := filepath.Join(out, "thumbs")
+	if err := fsys.MkdirAll(thumbDir, 0755); err != nil {
+		return newOpError("create gallery folder", thumbDir, err)
+	}
+	var photos []*galleryPhoto
+	for _, folder := range folders {
+		for i := range folder.Photos {
+			photos = append(photos, &folder.Photos[i])
+		}
+	}
+	makeThumbnails(fsys, photos, thumbDir, size, lang)
+
+	for _, folder := range folders {
+		if folder.Rel == topLevelName {
+			// Images in the root of the tree are shown on the index page.
+			continue
+		}
+		page := filepath.Join(out, filepath.FromSlash(folder.Rel), "index.html")
+		if err := writeGalleryPage(fsys, page, galleryFolderTemplate, folderPageData(folder, page, out, lang)); err != nil {
+			return err
+		}
+	}
+	index := filepath.Join(out, "index.html")
+	return writeGalleryPage(fsys, index, galleryIndexTemplate, indexPageData(folders, index, out, lang))
+}
+
+// makeThumbnails writes the missing thumbnails on one goroutine per CPU and
+// fills in each photo's Thumb. Images that cannot be decoded keep no thumbnail.
+func makeThumbnails(fsys FileSystem, photos []*galleryPhoto, thumbDir string, size int, lang string) {
+	var wg sync.WaitGroup
+	queue := make(chan *galleryPhoto)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for photo := range queue {
+				thumb, err := ensureThumbnail(fsys, photo.Path, thumbDir, size)
+				if err != nil {
+					log.Printf(locMsg("gallery_thumb_error", lang), photo.Path, err)
+					continue
+				}
+				photo.Thumb = thumb
+			}
+		}()
+	}
+	for _, photo := range photos {
+		queue <- photo
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// ensureThumbnail returns the thumbnail of path in thumbDir, making it
+// first unless a thumbnail of this version of the file already exists.
+func ensureThumbnail(fsys FileSystem, path, thumbDir string, size int) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%d", historyKey(path), info.Size(), info.ModTime().UnixNano(), size)
+	thumb := filepath.Join(thumbDir, encodeHash128(xxh3.HashString128(key))+".jpg")
+	if fileExists(thumb) {
+		return thumb, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, shrinkImage(img, size), &jpeg.Options{Quality: galleryThumbQuality}); err != nil {
+		return "", err
+	}
+	tmp := thumb + ".tmp"
+	if err := fsys.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return thumb, fsys.Rename(tmp, thumb)
+}
+
+// shrinkImage scales img down to fit in a size×size square, averaging the
+// source pixels each thumbnail pixel covers. Smaller images are kept as they are.
+func shrinkImage(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := bounds.Min.Y+ty*h/th, bounds.Min.Y+(ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := bounds.Min.X+tx*w/tw, bounds.Min.X+(tx+1)*w/tw
+			var r, g, b, n uint64
+			for y := y0; y < max(y1, y0+1); y++ {
+				for x := x0; x < max(x1, x0+1); x++ {
+					cr, cg, cb, _ := img.At(x, y).RGBA()
+					r, g, b, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), n+1
+				}
+			}
+			i := thumb.PixOffset(tx, ty)
+			thumb.Pix[i], thumb.Pix[i+1], thumb.Pix[i+2], thumb.Pix[i+3] = uint8(r/n>>8), uint8(g/n>>8), uint8(b/n>>8), 0xff
+		}
+	}
+	return thumb
+}
+
+// galleryLink returns the URL of target relative to the page at page.
+func galleryLink(page, target string) string {
+	rel, err := filepath.Rel(filepath.Dir(page), target)
+	if err != nil {
+		rel = target
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+type galleryTile struct {
+	Name, Link, Thumb string
+}
+
+type galleryPage struct {
+	Lang, Title, Home string
+	Tiles             []galleryTile
+	Sections          []gallerySection
+}
+
+type gallerySection struct {
+	Title string
+	Tiles []galleryTile
+}
+
+func photoTiles(photos []galleryPhoto, page string) []galleryTile {
+	tiles := make([]galleryTile, 0, len(photos))
+	for _, photo := range photos {
+		tile := galleryTile{Name: photo.Name, Link: galleryLink(page, photo.Path)}
+		if photo.Thumb != "" {
+			tile.Thumb = galleryLink(page, photo.Thumb)
+		}
+		tiles = append(tiles, tile)
+	}
+	return tiles
+}
+
+func folderPageData(folder *galleryFolder, page, out, lang string) galleryPage {
+	return galleryPage{
+		Lang:  lang,
+		Title: folder.Rel,
+		Home:  galleryLink(page, filepath.Join(out, "index.html")),
+		Tiles: photoTiles(folder.Photos, page),
+	}
+}
+
+// indexPageData lists the folders grouped by their first segment, the year
+// in most folder formats, each with its first image as cover, followed by
+// the images in the root of the tree.
+func indexPageData(folders []*galleryFolder, page, out, lang string) galleryPage {
+	data := galleryPage{Lang: lang, Title: "Gallery"}
+	for _, folder := range folders {
+		if folder.Rel == topLevelName {
+			data.Tiles = photoTiles(folder.Photos, page)
+			continue
+		}
+		year := strings.SplitN(folder.Rel, "/", 2)[0]
+		if len(data.Sections) == 0 || data.Sections[len(data.Sections)-1].Title != year {
+			data.Sections = append(data.Sections, gallerySection{Title: year})
+		}
+		tile := galleryTile{
+			Name: fmt.Sprintf("%s (%d)", folder.Rel, len(folder.Photos)),
+			Link: galleryLink(page, filepath.Join(out, filepath.FromSlash(folder.Rel), "index.html")),
+		}
+		for _, photo := range folder.Photos {
+			if photo.Thumb != "" {
+				tile.Thumb = galleryLink(page, photo.Thumb)
+				break
+			}
+		}
+		section := &data.Sections[len(data.Sections)-1]
+		section.Tiles = append(section.Tiles, tile)
+	}
+	return data
+}
+
+func writeGalleryPage(fsys FileSystem, path string, tmpl *template.Template, data galleryPage) error {
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return newOpError("create gallery folder", filepath.Dir(path), err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	return newOpError("write gallery page", path, fsys.WriteFile(path, buf.Bytes(), 0644))
+}
+
+const galleryStyle = `<style>
+body{font-family:system-ui,sans-serif;margin:1.5rem;background:#fafafa;color:#222}
+a{color:inherit}
+.grid{display:grid;grid-template-columns:repeat(auto-fill,minmax(180px,1fr));gap:.75rem}
+.tile{display:block;text-decoration:none;background:#fff;border-radius:6px;overflow:hidden;box-shadow:0 1px 3px #0002}
+.tile img{display:block;width:100%;aspect-ratio:1;object-fit:cover;background:#ddd}
+.tile .none{display:flex;align-items:center;justify-content:center;aspect-ratio:1;background:#ddd;font-size:.8rem;padding:.5rem;word-break:break-all}
+.tile span{display:block;padding:.3rem .5rem;font-size:.8rem;white-space:nowrap;overflow:hidden;text-overflow:ellipsis}
+</style>`
+
+const galleryTileHTML = `{{define "tile"}}<a class="tile" href="{{.Link}}">{{if .Thumb}}<img src="{{.Thumb}}" loading="lazy" alt="{{.Name}}">{{else}}<div class="none">{{.Name}}</div>{{end}}<span>{{.Name}}</span></a>{{end}}`
+
+var galleryFolderTemplate = template.Must(template.New("folder").Parse(galleryTileHTML + `<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Title}}</title>` + galleryStyle + `</head><body>
+<p><a href="{{.Home}}">&larr; Gallery</a></p>
+<h1>{{.Title}}</h1>
+<div class="grid">{{range .Tiles}}{{template "tile" .}}{{end}}</div>
+</body></html>
+`))
+
+var galleryIndexTemplate = template.Must(template.New("index").Parse(galleryTileHTML + `<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Title}}</title>` + galleryStyle + `</head><body>
+<h1>{{.Title}}</h1>
+{{range .Sections}}<h2>{{.Title}}</h2>
+<div class="grid">{{range .Tiles}}{{template "tile" .}}{{end}}</div>
+{{end}}{{if .Tiles}}<h2>/</h2>
+<div class="grid">{{range .Tiles}}{{template "tile" .}}{{end}}</div>
+{{end}}</body></html>
+`))
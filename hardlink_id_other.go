@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// hardlinkID is not supported on this platform; every file is placed on
+// its own.
+func hardlinkID(info os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+// fileID tells a file apart from every other one on the machine, whatever
+// its path: its device and inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// HardlinkSets keeps the files of a run that are hardlinks of each other
+// together. The first path of a set to be placed is copied or moved as
+// usual; every later path of the set is linked to where the first one went,
+// so a set copied to another drive, or by --copy, is still one file there
+// and takes its space once. A nil HardlinkSets places every path on its own.
+type HardlinkSets struct {
+	mu   sync.Mutex
+	sets map[fileID]*hardlinkSet
+}
+
+// hardlinkSet is a set of hardlinks being placed by this run.
+type hardlinkSet struct {
+	// placing is set while a path of the set is being placed, and done is
+	// closed once it was, or failed.
+	placing bool
+	done    chan struct{}
+	// path is where the first path of the set was placed, "" while none was.
+	path string
+	// left counts the paths of the set not seen yet. Moving the first path
+	// away leaves the others with fewer links, so they are told by their
+	// inode alone, and the set is forgotten once all were seen, before the
+	// inode can be reused.
+	left uint64
+}
+
+// withHardlinkSets sets up HardlinkSets, unless --no-hardlink-sets.
+func withHardlinkSets(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.HardlinkSets {
+		cfg.Hardlinks = &HardlinkSets{sets: map[fileID]*hardlinkSet{}}
+	}
+	return cfg
+}
+
+// join returns where another path of the set of the file described by info
+// was placed by this run, waiting while one is being placed. When none was,
+// the caller places the set and must call done with where it placed the
+// file, or "" when it did not.
+func (h *HardlinkSets) join(info os.FileInfo) (placed string, done func(path string)) {
+	noop := func(string) {}
+	if h == nil {
+		return "", noop
+	}
+	id, links, ok := hardlinkID(info)
+	if !ok {
+		return "", noop
+	}
+	h.mu.Lock()
+	set := h.sets[id]
+	if set == nil && links < 2 {
+		h.mu.Unlock()
+		return "", noop
+	}
+	if set == nil {
+		set = &hardlinkSet{done: make(chan struct{}), left: links}
+		h.sets[id] = set
+	}
+	if set.left--; set.left == 0 {
+		delete(h.sets, id)
+	}
+	h.mu.Unlock()
+	for {
+		h.mu.Lock()
+		if path := set.path; path != "" {
+			h.mu.Unlock()
+			return path, noop
+		}
+		if !set.placing {
+			set.placing = true
+			h.mu.Unlock()
+			return "", func(path string) {
+				h.mu.Lock()
+				done := set.done
+				set.path, set.placing = path, false
+				if path == "" {
+					// The next path of the set is placed in its stead.
+					set.done = make(chan struct{})
+				}
+				h.mu.Unlock()
+				close(done)
+			}
+		}
+		done := set.done
+		h.mu.Unlock()
+		<-done
+	}
+}
+
+// placedAt returns where this run placed the set of the file described by
+// info, or "".
+func (h *HardlinkSets) placedAt(info os.FileInfo) string {
+	if h == nil {
+		return ""
+	}
+	id, _, ok := hardlinkID(info)
+	if !ok {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if set := h.sets[id]; set != nil {
+		return set.path
+	}
+	return ""
+}
+
+// transferLinked places src at dst, a path of a set of hardlinks, and the
+// other paths of its set this run places after it as hardlinks of it.
+func transferLinked(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration, transfer func() (string, error)) (string, error) {
+	placed, done := cfg.Hardlinks.join(info)
+	if placed == "" {
+		finalPath, err := transfer()
+		done(finalPath)
+		return finalPath, err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	uniqueDst, err := resolveDestination(src, dst, cfg)
+	if err != nil {
+		return "", err
+	}
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would link: %s => %s (same file as %s)", src, uniqueDst, placed)
+		return uniqueDst, nil
+	}
+	if err := cfg.FS.Link(placed, uniqueDst); err != nil {
+		// Another drive or dataset than the first path: placed on its own.
+		abandonDestination(uniqueDst, cfg)
+		log.Printf(locMsg("hardlink_failed", cfg.Language), src, placed, err)
+		return transfer()
+	}
+	log.Printf(locMsg("hardlink_kept", cfg.Language), src, uniqueDst, placed)
+	if cfg.Mode == ModeCopy {
+		return uniqueDst, nil
+	}
+	// The content is safe at placed, so the link is removed, not trashed.
+	if err := cfg.FS.Remove(src); err != nil {
+		cfg.FS.Remove(uniqueDst)
+		abandonDestination(uniqueDst, cfg)
+		return "", newOpError("remove original", src, err)
+	}
+	return uniqueDst, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// HashAlgorithm is the content hash used to compare files, chosen with --hash.
+type HashAlgorithm int
+
+const (
+	// HashXXH3 is the 128-bit XXH3. It is not cryptographic, but its
+	// collisions are vanishingly rare and it hashes at memory speed, which
+	// keeps scans of large archives disk-bound.
+	HashXXH3 HashAlgorithm = iota
+	// HashSHA256 is the widely available cryptographic hash.
+	HashSHA256
+	// HashBLAKE3 is cryptographic too, but SIMD-accelerated and close to
+	// XXH3 in speed on modern CPUs.
+	HashBLAKE3
+)
+
+const (
+	HashNameXXH3   = "xxh3"
+	HashNameSHA256 = "sha256"
+	HashNameBLAKE3 = "blake3"
+)
+
+var hashAlgorithmName = map[HashAlgorithm]string{
+	HashXXH3:   HashNameXXH3,
+	HashSHA256: HashNameSHA256,
+	HashBLAKE3: HashNameBLAKE3,
+}
+
+var reverseHashAlgorithmName = map[string]HashAlgorithm{
+	HashNameXXH3:   HashXXH3,
+	HashNameSHA256: HashSHA256,
+	HashNameBLAKE3: HashBLAKE3,
+}
+
+// String returns the string representation of HashAlgorithm.
+func (a HashAlgorithm) String() string {
+	return hashAlgorithmName[a]
+}
+
+// ParseHashAlgorithm parses a string into a HashAlgorithm.
+func ParseHashAlgorithm(input string) (HashAlgorithm, error) {
+	if algo, ok := reverseHashAlgorithmName[input]; ok {
+		return algo, nil
+	}
+	return 0, fmt.Errorf("invalid HashAlgorithm: %s (expected xxh3, sha256 or blake3)", input)
+}
+
+// New returns a fresh hasher for the algorithm.
+func (a HashAlgorithm) New() hash.Hash {
+	switch a {
+	case HashSHA256:
+		return sha256.New()
+	case HashBLAKE3:
+		return blake3.New()
+	default:
+		return xxh3Hash128{xxh3.New()}
+	}
+}
+
+// xxh3Hash128 makes the XXH3 hasher sum to its 128-bit value, which
+// xxh3.Hasher only offers through Sum128.
+type xxh3Hash128 struct {
+	*xxh3.Hasher
+}
+
+func (h xxh3Hash128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
+
+func (h xxh3Hash128) Size() int { return 16 }
+
+// errNoMmap reports that a file could not be memory-mapped; hashing falls
+// back to reading it.
+var errNoMmap = errors.New("memory mapping is not available")
+
+// hashFile returns the hex-encoded hash of the file's contents.
+// With useMmap the file is memory-mapped instead of read, which is faster on
+// local SSDs; files that cannot be mapped are read as usual.
+func hashFile(path string, algo HashAlgorithm, useMmap bool) (string, error) {
+	if useMmap {
+		sum, err := hashMapped(path, algo)
+		if err == nil {
+			return sum, nil
+		}
+		if !errors.Is(err, errNoMmap) {
+			return "", err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := algo.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashBytes returns the hex-encoded hash of data.
+func hashBytes(data []byte, algo HashAlgorithm) string {
+	h := algo.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func encodeHash128(sum xxh3.Uint128) string {
+	b := sum.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+// hashFiles hashes paths on up to workers goroutines, so that a CPU-bound
+// hash such as SHA-256 uses every core and BLAKE3 keeps up with fast disks.
+// Files that cannot be hashed are left out and passed to onError.
+func hashFiles(paths []string, algo HashAlgorithm, useMmap bool, workers int, onError func(path string, err error)) map[string]string {
+	hashes := make(map[string]string, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				sum, err := hashFile(path, algo, useMmap)
+				mu.Lock()
+				if err != nil {
+					onError(path, err)
+				} else {
+					hashes[path] = sum
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+	return hashes
+}
+
+// sameContent reports whether two files have identical contents. Sizes are
+// compared first so differing files are almost never hashed with algo, and
+// large files are compared as streams instead.
+func sameContent(a, b string, algo HashAlgorithm) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	if infoA.Size() >= compareChunkSize {
+		return sameStreams(a, b)
+	}
+	hashA, err := hashFile(a, algo, false)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := hashFile(b, algo, false)
+	if err != nil {
+		return false, err
+	}
+	return hashA == hashB, nil
+}
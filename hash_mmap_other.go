@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// hashMapped is not supported on this platform; files are read instead.
+func hashMapped(path string, algo HashAlgorithm) (string, error) {
+	return "", errNoMmap
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	heatmapCell     = 11
+	heatmapGap      = 2
+	dayKeyLayout    = "2006-01-02"
+	heatmapTopDays  = 10
+	heatmapSpikeMul = 10
+)
+
+var heatmapColors = []string{"#ebedf0", "#c6e48b", "#7bc96f", "#239a3b", "#196127"}
+
+// heatmapFromMoves counts planned moves per day.
+func heatmapFromMoves(moves []PlannedMove) map[string]int {
+	days := map[string]int{}
+	for _, move := range moves {
+		days[move.Date.Format(dayKeyLayout)]++
+	}
+	return days
+}
+
+// writeHeatmap writes an HTML report with one calendar heatmap (as inline SVG)
+// per year, a per-quarter table and the busiest days. Days far above the
+// typical day are outlined in red: a spike of that size is often a batch of
+// files carrying a wrong date. Like a plan, the report is the command's own
+// output and is written in dry runs too; only --no-write refuses it.
+func writeHeatmap(fsys FileSystem, path string, days map[string]int, lang string) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>structo</title>\n")
+	b.WriteString("<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:.3em .8em;text-align:right}</style>\n")
+	fmt.Fprintf(&b, "</head><body>\n<h1>%s</h1>\n", html.EscapeString(locMsg("heatmap_title", lang)))
+
+	spike := spikeThreshold(days)
+	years := heatmapYears(days)
+	for _, year := range years {
+		fmt.Fprintf(&b, "<h2>%d</h2>\n", year)
+		writeYearSVG(&b, year, days, spike)
+	}
+
+	fmt.Fprintf(&b, "<h2>%s</h2>\n<table><tr><th></th><th>Q1</th><th>Q2</th><th>Q3</th><th>Q4</th></tr>\n", html.EscapeString(locMsg("heatmap_quarters", lang)))
+	for _, year := range years {
+		var quarters [4]int
+		for day, count := range days {
+			if t, err := time.Parse(dayKeyLayout, day); err == nil && t.Year() == year {
+				quarters[(int(t.Month())-1)/3] += count
+			}
+		}
+		fmt.Fprintf(&b, "<tr><th>%d</th><td>%d</td><td>%d</td><td>%d</td><td>%d</td></tr>\n", year, quarters[0], quarters[1], quarters[2], quarters[3])
+	}
+	b.WriteString("</table>\n")
+
+	fmt.Fprintf(&b, "<h2>%s</h2>\n<ol>\n", html.EscapeString(locMsg("heatmap_busiest", lang)))
+	for _, day := range busiestDays(days, heatmapTopDays) {
+		marker := ""
+		if spike > 0 && days[day] >= spike {
+			marker = " &#9888;"
+		}
+		fmt.Fprintf(&b, "<li>%s: %d%s</li>\n", day, days[day], marker)
+	}
+	b.WriteString("</ol>\n</body></html>\n")
+
+	return newOpError("write heatmap", path, fsys.WriteFile(path, []byte(b.String()), 0644))
+}
+
+// writeYearSVG draws a week-by-weekday grid for one year.
+func writeYearSVG(b *strings.Builder, year int, days map[string]int, spike int) {
+	step := heatmapCell + heatmapGap
+	fmt.Fprintf(b, "<svg width=\"%d\" height=\"%d\">\n", 54*step, 7*step)
+	maxCount := 0
+	for day, count := range days {
+		if strings.HasPrefix(day, fmt.Sprintf("%d-", year)) {
+			maxCount = max(maxCount, count)
+		}
+	}
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	offset := int(start.Weekday())
+	for t := start; t.Year() == year; t = t.AddDate(0, 0, 1) {
+		index := offset + t.YearDay() - 1
+		x, y := (index/7)*step, (index%7)*step
+		key := t.Format(dayKeyLayout)
+		count := days[key]
+		stroke := ""
+		if spike > 0 && count >= spike {
+			stroke = ` stroke="#d00" stroke-width="2"`
+		}
+		fmt.Fprintf(b, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"%s><title>%s: %d</title></rect>\n",
+			x, y, heatmapCell, heatmapCell, heatmapColor(count, maxCount), stroke, key, count)
+	}
+	b.WriteString("</svg>\n")
+}
+
+// heatmapColor maps a count to one of five shades relative to the busiest day.
+func heatmapColor(count, maxCount int) string {
+	if count == 0 || maxCount == 0 {
+		return heatmapColors[0]
+	}
+	level := (count*(len(heatmapColors)-1) + maxCount - 1) / maxCount
+	return heatmapColors[min(level, len(heatmapColors)-1)]
+}
+
+// spikeThreshold is a multiple of the median of the days that have files; 0
+// when there are too few days to tell what is typical.
+func spikeThreshold(days map[string]int) int {
+	if len(days) < 5 {
+		return 0
+	}
+	counts := make([]int, 0, len(days))
+	for _, count := range days {
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	return max(counts[len(counts)/2]*heatmapSpikeMul, 2)
+}
+
+func heatmapYears(days map[string]int) []int {
+	seen := map[int]bool{}
+	var years []int
+	for day := range days {
+		if t, err := time.Parse(dayKeyLayout, day); err == nil && !seen[t.Year()] {
+			seen[t.Year()] = true
+			years = append(years, t.Year())
+		}
+	}
+	sort.Ints(years)
+	return years
+}
+
+func busiestDays(days map[string]int, n int) []string {
+	keys := make([]string, 0, len(days))
+	for day := range days {
+		keys = append(keys, day)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if days[keys[i]] != days[keys[j]] {
+			return days[keys[i]] > days[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// noMediaMarker is the file Android places in folders, such as thumbnail
+// caches, that media apps should not index. Folders holding it are never
+// organized.
+const noMediaMarker = ".nomedia"
+
+// isHidden reports whether a file is hidden: a dotfile, or a file carrying
+// the platform's hidden attribute.
+func isHidden(path string, info os.FileInfo) bool {
+	name := filepath.Base(path)
+	return (strings.HasPrefix(name, ".") && name != "." && name != "..") || hasHiddenAttribute(path, info)
+}
+
+// isHiddenFilter skips .nomedia markers, and hidden files with --skip-hidden.
+func isHiddenFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if filepath.Base(path) == noMediaMarker || (cfg.SkipHidden && isHidden(path, info)) {
+		log.Printf(locMsg("skipping_hidden", cfg.Language), path)
+		return true, nil
+	}
+	return false, nil
+}
+
+// isSkippedMediaDir reports whether the walk should leave out dir: it holds a
+// .nomedia marker, or it is hidden and --skip-hidden is set. The input folder
+// itself is always walked.
+func isSkippedMediaDir(dir string, info os.FileInfo, cfg FilesMoveConfiguration) bool {
+	if filepath.Clean(dir) == filepath.Clean(cfg.InputFolder) {
+		return false
+	}
+	if cfg.SkipHidden && isHidden(dir, info) {
+		log.Printf(locMsg("skipping_hidden", cfg.Language), dir)
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(dir, noMediaMarker)); err == nil {
+		log.Printf(locMsg("skipping_nomedia", cfg.Language), dir)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ufHidden is the UF_HIDDEN file flag (chflags hidden), which Finder honors.
+const ufHidden = 0x8000
+
+// hasHiddenAttribute reports whether the file has the UF_HIDDEN flag set.
+func hasHiddenAttribute(path string, info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Flags&ufHidden != 0
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package main
+
+import "os"
+
+// hasHiddenAttribute is false here: only dotfiles count as hidden.
+func hasHiddenAttribute(path string, info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// HourFormat decides how the day-then-hours folder format names its hour
+// folders.
+type HourFormat int
+
+const (
+	// Hour24 names hour folders like "15", from "00" to "23", which sort
+	// in the order of the day. It is the default.
+	Hour24 HourFormat = iota
+	// HourAMPM names hour folders like "03PM", as structo did before. File
+	// browsers sort them by name, so "03PM" comes before "09AM"; it is kept
+	// for archives organized that way.
+	HourAMPM
+)
+
+const (
+	HourFormatNameAMPM = "ampm"
+	HourFormatName24   = "24h"
+)
+
+var hourFormatName = map[HourFormat]string{
+	Hour24:   HourFormatName24,
+	HourAMPM: HourFormatNameAMPM,
+}
+
+var reverseHourFormatName = map[string]HourFormat{
+	HourFormatNameAMPM: HourAMPM,
+	HourFormatName24:   Hour24,
+}
+
+// hourLayouts are the time layouts of the hour folders, zero-padded so
+// every label has the same width.
+var hourLayouts = map[HourFormat]string{
+	Hour24:   "15",
+	HourAMPM: "03PM",
+}
+
+// String returns the string representation of HourFormat.
+func (f HourFormat) String() string {
+	return hourFormatName[f]
+}
+
+// ParseHourFormat parses a string into an HourFormat.
+func ParseHourFormat(input string) (HourFormat, error) {
+	if format, ok := reverseHourFormatName[input]; ok {
+		return format, nil
+	}
+	return 0, fmt.Errorf("invalid HourFormat: %s (expected 24h or ampm)", input)
+}
+
+// label names the hour folder of t.
+func (f HourFormat) label(t time.Time) string {
+	return t.Format(hourLayouts[f])
+}
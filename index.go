@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// indexPlaced maps each placed file, by destination, to its indexRecord.
+	indexPlaced = []byte("placed")
+	// indexSources maps a source version, see versionKey, to where it
+	// was placed.
+	indexSources = []byte("sources")
+	// indexHashes maps a content hash to the first destination holding it.
+	indexHashes = []byte("hashes")
+	// indexSeen maps every source version the index hashed, placed or
+	// skipped as a duplicate, to its content hash; --incremental skips them.
+	indexSeen = []byte("seen")
+)
+
+const (
+	// indexFlushEvery is how many placements are written to the index in one
+	// transaction, so a run does not wait for the disk after every file.
+	indexFlushEvery = 1000
+	// indexLockTimeout bounds the wait for another run holding the index.
+	indexLockTimeout = time.Second
+)
+
+// indexRecord is what the index keeps about one placed file. Hash is the
+// 128-bit XXH3 of its content, as --overrides uses.
+type indexRecord struct {
+	Source     string    `json:"source"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Hash       string    `json:"hash,omitempty"`
+	DateSource string    `json:"date_source,omitempty"`
+	Date       time.Time `json:"date"`
+	PlacedAt   time.Time `json:"placed_at"`
+}
+
+// FileIndex is the database given with --index, which outlives runs and
+// output folders. It records where every file was placed, which date source
+// placed it and the hash of its content. A later run then skips source
+// files it already placed and files whose content is already in a recorded
+// destination, and verify --duplicates reuses the recorded hashes instead
+// of reading every file again. With --incremental, every source it already
+// processed is skipped before anything else. A nil index records nothing.
+type FileIndex struct {
+	mu       sync.Mutex
+	db       *bolt.DB
+	readOnly bool
+	// pending holds placements not yet written, by destination, and
+	// forgotten the undone ones not yet removed; hashes indexes pending
+	// placements by content so duplicates within a run are found too, and
+	// seen holds the hashes of source versions not yet written.
+	pending   map[string]indexRecord
+	forgotten map[string]bool
+	hashes    map[string]string
+	seen      map[string]string
+	// known is a Bloom filter of every content hash recorded, so duplicate
+	// looks up in the database only hashes it may hold. Most files of a run
+	// are new, and each lookup is a read transaction.
+	known *bloomFilter
+	// skippedProcessed, skippedUnchanged and skippedDuplicates count the
+	// files the index let this run skip.
+	skippedProcessed  int
+	skippedUnchanged  int
+	skippedDuplicates int
+}
+
+// openFileIndex opens the index at path, creating it unless readOnly. A
+// read-only index that does not exist yet is empty, and nil is returned.
+func openFileIndex(path string, readOnly bool) (*FileIndex, error) {
+	if readOnly && !fileExists(path) {
+		return nil, nil
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: indexLockTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("index %q is in use by another run", path)
+	}
+	if err != nil {
+		return nil, newOpError("open index", path, err)
+	}
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, bucket := range [][]byte{indexPlaced, indexSources, indexHashes, indexSeen} {
+				if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, newOpError("initialize index", path, err)
+		}
+	}
+	return &FileIndex{
+		db:        db,
+		readOnly:  readOnly,
+		pending:   map[string]indexRecord{},
+		forgotten: map[string]bool{},
+		hashes:    map[string]string{},
+		seen:      map[string]string{},
+		known:     loadIndexHashFilter(db),
+	}, nil
+}
+
+// loadIndexHashFilter reads the recorded content hashes into a new filter.
+// When they cannot be read it returns nil, so every hash is looked up.
+func loadIndexHashFilter(db *bolt.DB) *bloomFilter {
+	var keys []bloomKey
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(indexHashes)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(hash, _ []byte) error {
+			keys = append(keys, newBloomKey(string(hash)))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil
+	}
+	filter := newBloomFilter(len(keys) + bloomHeadroom)
+	for _, k := range keys {
+		filter.addKey(k)
+	}
+	return filter
+}
+
+// versionKey identifies one version of a source file by its absolute path,
+// size and modification time.
+func versionKey(source string, size int64, modTime time.Time) []byte {
+	return []byte(source + "\x00" + strconv.FormatInt(size, 10) + "\x00" + strconv.FormatInt(modTime.UnixNano(), 10))
+}
+
+// get reads a key of bucket; it returns nil when either is missing.
+func (x *FileIndex) get(bucket, key []byte) []byte {
+	var value []byte
+	x.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			value = append([]byte(nil), b.Get(key)...)
+		}
+		return nil
+	})
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
+
+// processed reports whether this version of path, by size and modification
+// time, was hashed and then placed or skipped as a duplicate by an earlier
+// run. Neither the file nor its destination is read, which is what lets
+// --incremental go over a large, mostly static source in seconds.
+func (x *FileIndex) processed(path string, info os.FileInfo, cfg FilesMoveConfiguration) bool {
+	if x == nil {
+		return false
+	}
+	key := versionKey(historyKey(path), info.Size(), info.ModTime())
+	x.mu.Lock()
+	_, ok := x.seen[string(key)]
+	x.mu.Unlock()
+	if !ok && x.get(indexSeen, key) == nil {
+		return false
+	}
+	log.Printf(locMsg("index_processed", cfg.Language), path)
+	x.mu.Lock()
+	x.skippedProcessed++
+	x.mu.Unlock()
+	return true
+}
+
+// unchanged reports whether this version of path was already placed by an
+// earlier run, and is still at its destination. Copies and links leave
+// their sources behind, and such files are not placed twice.
+func (x *FileIndex) unchanged(path string, info os.FileInfo, cfg FilesMoveConfiguration) bool {
+	if x == nil {
+		return false
+	}
+	dst := x.get(indexSources, versionKey(historyKey(path), info.Size(), info.ModTime()))
+	if dst == nil || !fileExists(string(dst)) {
+		return false
+	}
+	log.Printf(locMsg("index_unchanged", cfg.Language), path, dst)
+	x.mu.Lock()
+	x.skippedUnchanged++
+	x.mu.Unlock()
+	return true
+}
+
+// duplicate hashes the source of move and reports whether a recorded
+// destination, placed by this run or an earlier one, already holds the same
+// content. Otherwise the hash is kept in move for record.
+func (x *FileIndex) duplicate(move *PlannedMove, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if x == nil {
+		return false, nil
+	}
+	hash, err := hashFile(move.Source, HashXXH3, cfg.HashMmap)
+	if err != nil {
+		return false, newOpError("hash", move.Source, err)
+	}
+	move.Hash = hash
+	x.mu.Lock()
+	existing, ok := x.hashes[hash]
+	x.mu.Unlock()
+	if !ok && x.known.mayContain(hash) {
+		existing = string(x.get(indexHashes, []byte(hash)))
+	}
+	if existing == "" || historyKey(existing) == historyKey(move.Source) {
+		return false, nil
+	}
+	if stat, err := os.Stat(existing); err != nil || stat.Size() != info.Size() {
+		return false, nil
+	}
+	if placed := cfg.Hardlinks.placedAt(info); placed != "" && historyKey(placed) == historyKey(existing) {
+		// Another link of the same file, placed beside it as one.
+		return false, nil
+	}
+	log.Printf(locMsg("index_duplicate", cfg.Language), move.Source, existing)
+	x.mu.Lock()
+	x.skippedDuplicates++
+	full := x.see(move.Source, info, hash)
+	x.mu.Unlock()
+	if full {
+		// As in executeMove, a failed index write does not fail the file.
+		if err := x.flush(); err != nil {
+			log.Printf(locMsg("index_error", cfg.Language), err)
+		}
+	}
+	return true, nil
+}
+
+// see notes that a source version was hashed, for processed; it reports
+// whether enough is pending to flush. x.mu must be held.
+func (x *FileIndex) see(source string, info os.FileInfo, hash string) bool {
+	if x.readOnly {
+		return false
+	}
+	x.seen[string(versionKey(historyKey(source), info.Size(), info.ModTime()))] = hash
+	return x.pendingCount() >= indexFlushEvery
+}
+
+// pendingCount is how many changes wait for the next flush. x.mu must be held.
+func (x *FileIndex) pendingCount() int {
+	return len(x.pending) + len(x.forgotten) + len(x.seen)
+}
+
+// record notes a completed placement, written out with the next flush.
+func (x *FileIndex) record(move PlannedMove, finalPath string, info os.FileInfo) error {
+	if x == nil || x.readOnly {
+		return nil
+	}
+	dst := historyKey(finalPath)
+	x.mu.Lock()
+	x.pending[dst] = indexRecord{
+		Source:     historyKey(move.Source),
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		Hash:       move.Hash,
+		DateSource: move.DateSource,
+		Date:       move.Date,
+		PlacedAt:   time.Now(),
+	}
+	if move.Hash != "" {
+		x.known.add(move.Hash)
+		if _, ok := x.hashes[move.Hash]; !ok {
+			x.hashes[move.Hash] = dst
+		}
+		x.see(move.Source, info, move.Hash)
+	}
+	delete(x.forgotten, dst)
+	full := x.pendingCount() >= indexFlushEvery
+	x.mu.Unlock()
+	if full {
+		return x.flush()
+	}
+	return nil
+}
+
+// flush writes the pending placements, removals and processed sources in one
+// transaction.
+func (x *FileIndex) flush() error {
+	x.mu.Lock()
+	pending, forgotten, seenSources := x.pending, x.forgotten, x.seen
+	x.pending, x.forgotten, x.seen = map[string]indexRecord{}, map[string]bool{}, map[string]string{}
+	x.mu.Unlock()
+	if len(pending) == 0 && len(forgotten) == 0 && len(seenSources) == 0 {
+		return nil
+	}
+	return x.db.Update(func(tx *bolt.Tx) error {
+		placed, sources, hashes, seen := tx.Bucket(indexPlaced), tx.Bucket(indexSources), tx.Bucket(indexHashes), tx.Bucket(indexSeen)
+		for version, hash := range seenSources {
+			if err := seen.Put([]byte(version), []byte(hash)); err != nil {
+				return err
+			}
+		}
+		for dst, record := range pending {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := placed.Put([]byte(dst), data); err != nil {
+				return err
+			}
+			if err := sources.Put(versionKey(record.Source, record.Size, record.ModTime), []byte(dst)); err != nil {
+				return err
+			}
+			if record.Hash == "" {
+				continue
+			}
+			if holder := hashes.Get([]byte(record.Hash)); holder == nil || !fileExists(string(holder)) {
+				if err := hashes.Put([]byte(record.Hash), []byte(dst)); err != nil {
+					return err
+				}
+			}
+		}
+		for dst := range forgotten {
+			var record indexRecord
+			if data := placed.Get([]byte(dst)); data == nil || json.Unmarshal(data, &record) != nil {
+				continue
+			}
+			version := versionKey(record.Source, record.Size, record.ModTime)
+			if err := sources.Delete(version); err != nil {
+				return err
+			}
+			if err := seen.Delete(version); err != nil {
+				return err
+			}
+			if record.Hash != "" && string(hashes.Get([]byte(record.Hash))) == dst {
+				if err := hashes.Delete([]byte(record.Hash)); err != nil {
+					return err
+				}
+			}
+			if err := placed.Delete([]byte(dst)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forget drops the record of a placement that was undone, with the next flush.
+func (x *FileIndex) forget(dst string) error {
+	if x == nil || x.readOnly {
+		return nil
+	}
+	key := historyKey(dst)
+	x.mu.Lock()
+	if record, ok := x.pending[key]; ok {
+		delete(x.pending, key)
+		delete(x.seen, string(versionKey(record.Source, record.Size, record.ModTime)))
+		if x.hashes[record.Hash] == key {
+			delete(x.hashes, record.Hash)
+		}
+	}
+	x.forgotten[key] = true
+	full := x.pendingCount() >= indexFlushEvery
+	x.mu.Unlock()
+	if full {
+		return x.flush()
+	}
+	return nil
+}
+
+// knownHash returns the recorded hash of a placed file, if the file still
+// has the size and modification time it was placed with.
+func (x *FileIndex) knownHash(path string, info os.FileInfo) (string, bool) {
+	if x == nil {
+		return "", false
+	}
+	data := x.get(indexPlaced, []byte(historyKey(path)))
+	var record indexRecord
+	if data == nil || json.Unmarshal(data, &record) != nil || record.Hash == "" {
+		return "", false
+	}
+	if record.Size != info.Size() || !record.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return record.Hash, true
+}
+
+// close flushes the pending placements and closes the database.
+func (x *FileIndex) close() error {
+	if x == nil {
+		return nil
+	}
+	var err error
+	if !x.readOnly {
+		err = x.flush()
+	}
+	return errors.Join(err, x.db.Close())
+}
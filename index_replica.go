@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexReplicaName is the replica of the index in the metadata folder.
+const indexReplicaName = "index.db"
+
+// indexReplicaPath returns where the replica of the index lives in an
+// output folder.
+func indexReplicaPath(outputFolder string) string {
+	return filepath.Join(metadataDir(outputFolder), indexReplicaName)
+}
+
+// replicate writes the index records of the files placed under output to
+// its replica, keyed by their paths relative to output, so the archive
+// carries them wherever it is moved. The replica is written beside the old
+// one and renamed over it, and holds only the placed records: reconcile
+// rebuilds the rest from them.
+func (x *FileIndex) replicate(output string) error {
+	if x == nil || x.readOnly || output == "" || !fileExists(output) {
+		return nil
+	}
+	if err := x.flush(); err != nil {
+		return err
+	}
+	output = historyKey(output)
+	replica := indexReplicaPath(output)
+	if err := os.MkdirAll(filepath.Dir(replica), 0755); err != nil {
+		return newOpError("create metadata folder", filepath.Dir(replica), err)
+	}
+	tmp := replica + ".tmp"
+	os.Remove(tmp)
+	db, err := bolt.Open(tmp, 0644, &bolt.Options{Timeout: indexLockTimeout})
+	if err != nil {
+		return newOpError("create index replica", tmp, err)
+	}
+	err = db.Update(func(out *bolt.Tx) error {
+		placed, err := out.CreateBucketIfNotExists(indexPlaced)
+		if err != nil {
+			return err
+		}
+		return x.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(indexPlaced).ForEach(func(dst, data []byte) error {
+				rel, ok := relBelow(output, string(dst))
+				if !ok {
+					return nil
+				}
+				return placed.Put([]byte(rel), data)
+			})
+		})
+	})
+	err = errors.Join(err, db.Close())
+	if err != nil {
+		os.Remove(tmp)
+		return newOpError("write index replica", tmp, err)
+	}
+	return newOpError("commit index replica", replica, os.Rename(tmp, replica))
+}
+
+// reconcile adds to the index the records of the replica in output that it
+// lacks, for files still in place there: after the archive was moved to
+// another machine or folder, or the index was lost, the index again knows
+// the files placed in it. It returns how many records were added.
+func (x *FileIndex) reconcile(output string) (int, error) {
+	if x == nil || x.readOnly || output == "" {
+		return 0, nil
+	}
+	output = historyKey(output)
+	replica := indexReplicaPath(output)
+	if !fileExists(replica) {
+		return 0, nil
+	}
+	db, err := bolt.Open(replica, 0644, &bolt.Options{Timeout: indexLockTimeout, ReadOnly: true})
+	if err != nil {
+		return 0, newOpError("open index replica", replica, err)
+	}
+	defer db.Close()
+	added := 0
+	err = db.View(func(in *bolt.Tx) error {
+		recorded := in.Bucket(indexPlaced)
+		if recorded == nil {
+			return nil
+		}
+		return x.db.Update(func(tx *bolt.Tx) error {
+			placed, sources, hashes, seen := tx.Bucket(indexPlaced), tx.Bucket(indexSources), tx.Bucket(indexHashes), tx.Bucket(indexSeen)
+			return recorded.ForEach(func(rel, data []byte) error {
+				dst := filepath.Join(output, filepath.FromSlash(string(rel)))
+				if placed.Get([]byte(dst)) != nil || !fileExists(dst) {
+					return nil
+				}
+				var record indexRecord
+				if json.Unmarshal(data, &record) != nil {
+					return nil
+				}
+				if err := placed.Put([]byte(dst), data); err != nil {
+					return err
+				}
+				version := versionKey(record.Source, record.Size, record.ModTime)
+				if err := sources.Put(version, []byte(dst)); err != nil {
+					return err
+				}
+				if record.Hash != "" {
+					if err := seen.Put(version, []byte(record.Hash)); err != nil {
+						return err
+					}
+					if holder := hashes.Get([]byte(record.Hash)); holder == nil || !fileExists(string(holder)) {
+						if err := hashes.Put([]byte(record.Hash), []byte(dst)); err != nil {
+							return err
+						}
+					}
+				}
+				added++
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return 0, newOpError("reconcile index replica", replica, err)
+	}
+	return added, nil
+}
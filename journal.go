@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	journalPrefix    = "journal-"
+	logFilePrefix    = "log-"
+	journalTimestamp = "2006-01-02_15-04-05"
+)
+
+// JournalEntry records one completed placement so it can be reverted later.
+// Destination is the final path, including any "(1)" suffix from ensureUniquePath.
+// ModTime is the precise source time, which coarse destinations such as FAT cannot hold.
+// Retries lists the failed attempts that came before, with --retries.
+// Replaced is where the file an --on-conflict overwrite replaced was set aside.
+// Dedupe is the dedupe action that removed Source, or replaced it with a
+// hardlink, as a duplicate of Destination; see restoreDuplicate.
+// Undone is when undo reverted the entry; an undo cut short leaves the
+// entries it did not get to without one.
+type JournalEntry struct {
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Mode        string         `json:"mode"`
+	Backend     string         `json:"backend"`
+	Time        time.Time      `json:"time"`
+	ModTime     time.Time      `json:"mod_time"`
+	Retries     []RetryAttempt `json:"retries,omitempty"`
+	Replaced    string         `json:"replaced,omitempty"`
+	Dedupe      string         `json:"dedupe,omitempty"`
+	Undone      *time.Time     `json:"undone,omitempty"`
+}
+
+// journalVersion marks journals written a line at a time. Earlier versions
+// wrote the whole journal as one JSON document at the end of the run.
+const journalVersion = 2
+
+// journalLine is one line of a journal file. The first is the header of the
+// run; each later one holds an entry placed, the index of an entry undone
+// and when, or, alone, when undo finished with the journal.
+type journalLine struct {
+	Version   int           `json:"journal,omitempty"`
+	StartedAt *time.Time    `json:"started_at,omitempty"`
+	Input     string        `json:"input,omitempty"`
+	Output    string        `json:"output,omitempty"`
+	Entry     *JournalEntry `json:"entry,omitempty"`
+	Undone    *int          `json:"undone,omitempty"`
+	UndoneAt  *time.Time    `json:"undone_at,omitempty"`
+	// Entries holds the entries of a journal of an earlier version.
+	Entries []JournalEntry `json:"entries,omitempty"`
+}
+
+// Journal is the operations log of a single run, written to
+// "journal-<timestamp>.json" in the metadata folder of the output. Every
+// entry is appended to the file as soon as it is recorded, so a run that
+// was killed or crashed can still be undone up to its last placement.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	// file is the journal file open for appending, from the first line
+	// written until save.
+	file *os.File
+	// rewrite marks a journal whose file is written anew before anything
+	// is appended: one of an earlier version, or one cut short mid-line.
+	rewrite bool
+	// err holds the first failed write, reported by save.
+	err       error
+	StartedAt time.Time
+	Input     string
+	Output    string
+	UndoneAt  *time.Time
+	Entries   []JournalEntry
+}
+
+// newJournal starts the journal of a run. Its folders and the paths of its
+// entries are kept absolute, so undo works from any working directory.
+func newJournal(cfg FilesMoveConfiguration) *Journal {
+	startedAt := time.Now()
+	name := journalPrefix + startedAt.Format(journalTimestamp) + ".json"
+	return &Journal{
+		path:      filepath.Join(metadataDir(cfg.OutputFolder), name),
+		StartedAt: startedAt,
+		Input:     historyKey(cfg.InputFolder),
+		Output:    historyKey(cfg.OutputFolder),
+	}
+}
+
+// record appends a completed operation, and writes it to the journal file
+// right away. It is safe for concurrent use.
+func (j *Journal) record(src, dst string, info os.FileInfo, retries []RetryAttempt, cfg FilesMoveConfiguration) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := JournalEntry{
+		Source:      historyKey(src),
+		Destination: historyKey(dst),
+		Mode:        cfg.Mode.String(),
+		Backend:     cfg.Backend.String(),
+		Time:        time.Now(),
+		ModTime:     info.ModTime(),
+		Retries:     retries,
+	}
+	if aside := cfg.Reservations.takeAside(dst); aside != "" {
+		entry.Replaced = historyKey(aside)
+	}
+	j.appendLine(journalLine{Entry: &entry}, cfg)
+	j.Entries = append(j.Entries, entry)
+}
+
+// recordDedupe appends the removal of dup, or its replacement by a hardlink
+// as action says, for being a duplicate of keep.
+func (j *Journal) recordDedupe(dup, keep string, info os.FileInfo, action string, cfg FilesMoveConfiguration) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := JournalEntry{
+		Source:      historyKey(dup),
+		Destination: historyKey(keep),
+		Time:        time.Now(),
+		ModTime:     info.ModTime(),
+		Dedupe:      action,
+	}
+	j.appendLine(journalLine{Entry: &entry}, cfg)
+	j.Entries = append(j.Entries, entry)
+}
+
+// markUndone records that undo reverted the entry at index i.
+func (j *Journal) markUndone(i int, cfg FilesMoveConfiguration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	undone := time.Now()
+	j.appendLine(journalLine{Undone: &i, UndoneAt: &undone}, cfg)
+	j.Entries[i].Undone = &undone
+}
+
+// markAllUndone records that undo reverted the whole journal.
+func (j *Journal) markAllUndone(cfg FilesMoveConfiguration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	undoneAt := time.Now()
+	j.appendLine(journalLine{UndoneAt: &undoneAt}, cfg)
+	j.UndoneAt = &undoneAt
+}
+
+// appendLine writes line to the journal file, opening it first when needed.
+// A failed write is kept for save to report; the run goes on. The caller
+// holds j.mu.
+func (j *Journal) appendLine(line journalLine, cfg FilesMoveConfiguration) {
+	if j.err != nil {
+		return
+	}
+	data, err := json.Marshal(line)
+	if err == nil && j.file == nil {
+		err = j.open(cfg.FS)
+	}
+	if err == nil {
+		_, err = j.file.Write(append(data, '\n'))
+	}
+	if err == nil && cfg.Fsync {
+		err = j.file.Sync()
+	}
+	j.err = newOpError("write journal", j.path, err)
+}
+
+// open opens the journal file for appending. A journal without a file yet,
+// or one to rewrite, is first written whole with what it holds so far.
+func (j *Journal) open(fsys FileSystem) error {
+	if j.rewrite || !fileExists(j.path) {
+		if err := writeArtifact(fsys, j.path, j.encode(), "journal"); err != nil {
+			return err
+		}
+		j.rewrite = false
+	}
+	file, err := fsys.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	return nil
+}
+
+// encode writes the journal as lines: the header, the entries, and what
+// undo reverted.
+func (j *Journal) encode() []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(journalLine{Version: journalVersion, StartedAt: &j.StartedAt, Input: j.Input, Output: j.Output})
+	for i := range j.Entries {
+		entry := j.Entries[i]
+		entry.Undone = nil
+		enc.Encode(journalLine{Entry: &entry})
+	}
+	for i, entry := range j.Entries {
+		if entry.Undone != nil {
+			enc.Encode(journalLine{Undone: &i, UndoneAt: entry.Undone})
+		}
+	}
+	if j.UndoneAt != nil {
+		enc.Encode(journalLine{UndoneAt: j.UndoneAt})
+	}
+	return buf.Bytes()
+}
+
+// save closes the journal file, and reports the first write that failed.
+// Dry runs record nothing and write no journal.
+func (j *Journal) save(fsys FileSystem) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file != nil {
+		if err := j.file.Close(); err != nil && j.err == nil {
+			j.err = newOpError("close journal", j.path, err)
+		}
+		j.file = nil
+	}
+	return j.err
+}
+
+// loadJournal reads a journal written by a previous run. A last line cut
+// short, by a crash mid-write, is left out.
+func loadJournal(path string) (*Journal, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, newOpError("read journal", path, err)
+	}
+	defer file.Close()
+	journal := &Journal{path: path}
+	dec := json.NewDecoder(file)
+	var header journalLine
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("invalid journal %q: %w", path, err)
+	}
+	if header.StartedAt != nil {
+		journal.StartedAt = *header.StartedAt
+	}
+	journal.Input, journal.Output = header.Input, header.Output
+	if header.Version < journalVersion {
+		journal.Entries, journal.UndoneAt, journal.rewrite = header.Entries, header.UndoneAt, true
+		return journal, nil
+	}
+	for {
+		var line journalLine
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			journal.rewrite = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal %q: %w", path, err)
+		}
+		switch {
+		case line.Entry != nil:
+			journal.Entries = append(journal.Entries, *line.Entry)
+		case line.Undone != nil:
+			if i := *line.Undone; i >= 0 && i < len(journal.Entries) {
+				journal.Entries[i].Undone = line.UndoneAt
+			}
+		case line.UndoneAt != nil:
+			journal.UndoneAt = line.UndoneAt
+		}
+	}
+	return journal, nil
+}
+
+// isStructoArtifactName reports whether name is a log, journal or summary written by
+// structo itself; such files must never be organized. The files in the metadata
+// folder are left out with the folder, see isMetadataPath; these are the names
+// they had in the output root before it, and the partial copies beside placed files.
+func isStructoArtifactName(name string) bool {
+	_, legacy := currentArtifactName(name)
+	return legacy ||
+		strings.HasSuffix(name, partialSuffix) ||
+		strings.HasSuffix(name, checkpointSuffix)
+}
+
+// isStructoArtifact reports whether path is a file structo wrote: see
+// isStructoArtifactName, or any file in a metadata folder.
+func isStructoArtifact(path string) bool {
+	return isStructoArtifactName(filepath.Base(path)) || inMetadataDir(path)
+}
+
+// undoJournal reverts every entry of a journal, newest first.
+func undoJournal(ctx context.Context, journal *Journal, cfg FilesMoveConfiguration) error {
+	if journal.UndoneAt != nil {
+		return fmt.Errorf("journal %q was already undone at %s", journal.path, journal.UndoneAt.Format(time.RFC3339))
+	}
+	failed := 0
+	for i := len(journal.Entries) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry := journal.Entries[i]
+		if entry.Undone != nil {
+			// Reverted by an earlier undo that was cut short.
+			continue
+		}
+		if err := undoEntry(ctx, entry, cfg); err != nil {
+			log.Printf(locMsg("undo_error", cfg.Language), entry.Destination, entry.Source, err)
+			cfg.Summary.recordFailure(entry.Destination, err, cfg.Language)
+			failed++
+			continue
+		}
+		cfg.Summary.recordTransferred()
+		if !cfg.DryRun {
+			journal.markUndone(i, cfg)
+			log.Printf(locMsg("undone_entry", cfg.Language), entry.Destination, entry.Source)
+			if entry.Dedupe != "" {
+				// The kept file stays where it is.
+				continue
+			}
+			if err := cfg.Index.forget(entry.Destination); err != nil {
+				log.Printf(locMsg("index_error", cfg.Language), err)
+			}
+			removeEmptyParents(filepath.Dir(entry.Destination), journal.Output, cfg)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d entries could not be undone", failed, len(journal.Entries))
+	}
+	if cfg.DryRun {
+		return nil
+	}
+	journal.markAllUndone(cfg)
+	return journal.save(cfg.FS)
+}
+
+// undoEntry reverts a single journal entry according to how it was created.
+func undoEntry(ctx context.Context, entry JournalEntry, cfg FilesMoveConfiguration) error {
+	if entry.Dedupe != "" {
+		return restoreDuplicate(ctx, entry, cfg)
+	}
+	if err := revertEntry(ctx, entry, cfg); err != nil {
+		return err
+	}
+	restoreReplaced(entry, cfg)
+	return nil
+}
+
+// restoreReplaced puts the file the entry overwrote back in its place, now
+// that the entry's own file left it. Failing that, the file stays set aside
+// in the metadata folder, where it is logged to be found.
+func restoreReplaced(entry JournalEntry, cfg FilesMoveConfiguration) {
+	if entry.Replaced == "" {
+		return
+	}
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would put back: %s => %s", entry.Replaced, entry.Destination)
+		return
+	}
+	if err := cfg.FS.Rename(entry.Replaced, entry.Destination); err != nil {
+		log.Printf(locMsg("overwritten_restore_error", cfg.Language), entry.Replaced, entry.Destination, err)
+		return
+	}
+	removeEmptyParents(filepath.Dir(entry.Replaced), filepath.Join(metadataDir(cfg.OutputFolder), overwrittenDirName), cfg)
+}
+
+// revertEntry moves, or for copies and links removes, the file of entry.
+func revertEntry(ctx context.Context, entry JournalEntry, cfg FilesMoveConfiguration) error {
+	if _, err := os.Lstat(entry.Destination); err != nil {
+		return newOpError("find organized file", entry.Destination, err)
+	}
+
+	keepsSource := entry.Mode != ModeNameMove
+	if !keepsSource && fileExists(entry.Source) {
+		return fmt.Errorf("cannot restore %q: a file already exists there", entry.Source)
+	}
+
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would undo: %s => %s", entry.Destination, entry.Source)
+		return nil
+	}
+
+	if entry.Backend == BackendNameChunkStore && !keepsSource {
+		if err := restoreFromChunkStore(entry.Destination, entry.Source, cfg.OutputFolder, cfg.FS); err != nil {
+			return err
+		}
+		return newOpError("remove manifest", entry.Destination, cfg.FS.Remove(entry.Destination))
+	}
+
+	if keepsSource {
+		// Copies and links are simply removed; the original never left.
+		return newOpError("remove", entry.Destination, cfg.FS.Remove(entry.Destination))
+	}
+
+	if err := cfg.FS.MkdirAll(filepath.Dir(entry.Source), 0755); err != nil {
+		return newOpError("recreate source directory", filepath.Dir(entry.Source), err)
+	}
+	info, err := os.Stat(entry.Destination)
+	if err != nil {
+		return newOpError("stat", entry.Destination, err)
+	}
+	if _, err := relocateFile(ctx, entry.Destination, entry.Source, info, cfg); err != nil {
+		return err
+	}
+	// Restore the precise time a coarse destination may have rounded away.
+	if !entry.ModTime.IsZero() && !entry.ModTime.Equal(info.ModTime()) {
+		return preserveTimes(entry.Source, entry.ModTime, cfg)
+	}
+	return nil
+}
+
+// throughMountPoint reports whether dir, below root, is a junction or mount
+// point or lies behind one. Removing it would remove the junction, or a
+// folder of whatever drive or folder it leads to.
+func throughMountPoint(dir, root string) bool {
+	for ; dir != root && isBelow(dir, root); dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(dir); err == nil && isMountPoint(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEmptyParents removes dir and its parents while they are empty, stopping at root.
+// It returns how many folders were removed. Nothing is removed without a
+// root, which would otherwise stand for the working directory.
+func removeEmptyParents(dir, root string, cfg FilesMoveConfiguration) int {
+	if root == "" {
+		return 0
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for {
+		absDir, err := filepath.Abs(dir)
+		if err != nil || !isBelow(absDir, absRoot) {
+			return removed
+		}
+		entries, err := os.ReadDir(absDir)
+		if err != nil || len(entries) > 0 || throughMountPoint(absDir, absRoot) {
+			return removed
+		}
+		if err := cfg.FS.Remove(absDir); err != nil {
+			return removed
+		}
+		removed++
+		dir = filepath.Dir(absDir)
+	}
+}
+
+// pruneEmptySources removes the input folders that the run's moves left
+// empty, with --prune-empty. Only folders a file was moved out of, and their
+// parents, are considered, so folders that were already empty stay.
+func pruneEmptySources(cfg FilesMoveConfiguration) {
+	if !cfg.PruneEmpty || cfg.DryRun || cfg.Journal == nil || cfg.InputFolder == "" {
+		return
+	}
+	dirs := map[string]bool{}
+	cfg.Journal.mu.Lock()
+	for _, entry := range cfg.Journal.Entries {
+		if entry.Mode == ModeNameMove {
+			dirs[filepath.Dir(entry.Source)] = true
+		}
+	}
+	cfg.Journal.mu.Unlock()
+	removed := 0
+	for dir := range dirs {
+		removed += removeEmptyParents(dir, cfg.InputFolder, cfg)
+	}
+	if removed > 0 {
+		log.Printf(locMsg("pruned_empty_dirs", cfg.Language), removed, cfg.InputFolder)
+	}
+}
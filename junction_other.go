@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// isMountPoint reports whether info describes a directory junction or a
+// volume mount point, which only Windows has.
+func isMountPoint(info os.FileInfo) bool {
+	return false
+}
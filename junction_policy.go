@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// JunctionPolicy decides what the walk of the input does with a directory
+// junction or volume mount point, a folder that Windows redirects to
+// another folder or drive.
+type JunctionPolicy int
+
+const (
+	// JunctionSkip leaves the junction and everything behind it alone.
+	JunctionSkip JunctionPolicy = iota
+	// JunctionFollow walks into the junction like into a folder.
+	JunctionFollow
+	// JunctionFail stops the run at the first junction.
+	JunctionFail
+)
+
+const (
+	JunctionNameSkip   = "skip"
+	JunctionNameFollow = "follow"
+	JunctionNameFail   = "fail"
+)
+
+var junctionPolicyName = map[JunctionPolicy]string{
+	JunctionSkip:   JunctionNameSkip,
+	JunctionFollow: JunctionNameFollow,
+	JunctionFail:   JunctionNameFail,
+}
+
+var reverseJunctionPolicyName = map[string]JunctionPolicy{
+	JunctionNameSkip:   JunctionSkip,
+	JunctionNameFollow: JunctionFollow,
+	JunctionNameFail:   JunctionFail,
+}
+
+// String returns the string representation of JunctionPolicy.
+func (p JunctionPolicy) String() string {
+	return junctionPolicyName[p]
+}
+
+// ParseJunctionPolicy parses a string into a JunctionPolicy.
+func ParseJunctionPolicy(input string) (JunctionPolicy, error) {
+	if policy, ok := reverseJunctionPolicyName[input]; ok {
+		return policy, nil
+	}
+	return 0, fmt.Errorf("invalid JunctionPolicy: %s", input)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// isMountPoint reports whether info, from Lstat, describes a directory
+// junction or a volume mount point. Go reports them as irregular files, not
+// folders, so a walk does not enter them; directory symlinks are left out,
+// being symlinks.
+func isMountPoint(info os.FileInfo) bool {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && info.Mode()&os.ModeIrregular != 0 &&
+		data.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT != 0 &&
+		data.FileAttributes&syscall.FILE_ATTRIBUTE_DIRECTORY != 0
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// partialSuffix marks a large file still being copied; it is renamed to
+	// its final name once complete.
+	partialSuffix = ".structo-partial"
+	// checkpointSuffix marks the sidecar recording how much of a partial copy
+	// is safely on disk.
+	checkpointSuffix = ".structo-checkpoint"
+	// checkpointInterval is how many bytes are copied between checkpoints.
+	checkpointInterval = 256 << 20
+	// compareChunkSize is the read size when comparing large files.
+	compareChunkSize = 4 << 20
+)
+
+// copyCheckpoint records a partial copy of Source. It only applies while the
+// source keeps the same size and modification time.
+type copyCheckpoint struct {
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Offset  int64     `json:"offset"`
+}
+
+// isLargeFile reports whether a file is at or over --large-file-threshold.
+func isLargeFile(info os.FileInfo, cfg FilesMoveConfiguration) bool {
+	return cfg.LargeFileThreshold > 0 && info.Size() >= cfg.LargeFileThreshold
+}
+
+// loadCheckpoint returns how much of dst's partial copy can be kept for resuming a copy
+// of src, or 0 when the copy has to start over.
+func loadCheckpoint(src, dst string, info os.FileInfo) int64 {
+	data, err := os.ReadFile(dst + checkpointSuffix)
+	if err != nil {
+		return 0
+	}
+	var cp copyCheckpoint
+	if json.Unmarshal(data, &cp) != nil || cp.Source != src || cp.Size != info.Size() || !cp.ModTime.Equal(info.ModTime()) {
+		return 0
+	}
+	written, err := os.Stat(dst + partialSuffix)
+	if err != nil || written.Size() < cp.Offset {
+		return 0
+	}
+	return cp.Offset
+}
+
+// copyLargeFile copies src to dst through dst+partialSuffix, flushing and
+// checkpointing every checkpointInterval bytes, and offloading the copy to the
+// storage where the platform can. The holes of a sparse file are kept, see
+// copyRange. When the copy is interrupted,
+// by an error or a cancellation, the partial file and its checkpoint are kept
+// and the next run resumes where the last checkpoint left off.
+func copyLargeFile(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	partial := dst + partialSuffix
+	offset := loadCheckpoint(src, dst, info)
+	if offset == 0 {
+		cloned, err := reflinkFile(src, partial, cfg)
+		if err != nil {
+			return err
+		}
+		if cloned {
+			return finishLargeFile(src, partial, dst, info, cfg)
+		}
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return newOpError("open source", src, err)
+	}
+	defer srcFile.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dstFile, err := cfg.FS.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return newOpError("create destination", partial, err)
+	}
+	defer dstFile.Close()
+
+	if offset > 0 {
+		log.Printf(locMsg("large_file_resumed", cfg.Language), src, formatBytes(offset), formatBytes(info.Size()))
+		if err := dstFile.Truncate(offset); err != nil {
+			return newOpError("resume copy", partial, err)
+		}
+		if _, err := dstFile.Seek(offset, io.SeekStart); err != nil {
+			return newOpError("resume copy", partial, err)
+		}
+		if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+			return newOpError("resume copy", src, err)
+		}
+	}
+	// The bar counts the bytes already copied; they are handed back once the
+	// file is done and counted as a whole.
+	cfg.Progress.copying(offset)
+	defer func() { cfg.Progress.copying(-offset) }()
+
+	sparse := isSparseFile(info)
+	offload := !cfg.NoCopyOffload && !sparse
+	for offset < info.Size() {
+		saved, chunk := offset, min(checkpointInterval, info.Size()-offset)
+		var n int64
+		if offload {
+			n, err = offloadRange(ctx, dstFile, srcFile, chunk)
+			offload = !errors.Is(err, errNoOffload)
+			cfg.Progress.copying(n)
+		}
+		if !offload {
+			n, err = copyRange(ctx, dstFile, srcFile, offset, chunk, sparse, cfg)
+		}
+		offset += n
+		if errors.Is(err, io.EOF) {
+			return newOpError("copy", src, errors.New("file shrank while being copied"))
+		}
+		if err != nil {
+			log.Printf(locMsg("large_file_kept", cfg.Language), partial, formatBytes(saved))
+			return newOpError("copy", partial, err)
+		}
+		// A chunk ending in a hole leaves the partial file short; extend it so
+		// the checkpoint matches its size.
+		if sparse {
+			if err := dstFile.Truncate(offset); err != nil {
+				return newOpError("copy", partial, err)
+			}
+		}
+		if err := dstFile.Sync(); err != nil {
+			return newOpError("flush", partial, err)
+		}
+		if err := writeCheckpoint(src, dst, info, offset, cfg); err != nil {
+			return err
+		}
+	}
+	if err := dstFile.Close(); err != nil {
+		return newOpError("close", partial, err)
+	}
+	return finishLargeFile(src, partial, dst, info, cfg)
+}
+
+// finishLargeFile gives a complete partial copy its metadata and final name.
+func finishLargeFile(src, partial, dst string, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	if err := preserveAttributes(src, partial, dst, info, cfg); err != nil {
+		return err
+	}
+	if err := cfg.FS.Rename(partial, dst); err != nil {
+		return newOpError("rename", partial, err)
+	}
+	cfg.FS.Remove(dst + checkpointSuffix)
+
+	modTime := roundToGranularity(info.ModTime(), cfg.Capabilities.TimeGranularity)
+	return preserveTimes(dst, modTime, cfg)
+}
+
+func writeCheckpoint(src, dst string, info os.FileInfo, offset int64, cfg FilesMoveConfiguration) error {
+	data, err := json.Marshal(copyCheckpoint{Source: src, Size: info.Size(), ModTime: info.ModTime(), Offset: offset})
+	if err != nil {
+		return err
+	}
+	path := dst + checkpointSuffix
+	return newOpError("write checkpoint", path, cfg.FS.WriteFile(path, data, 0644))
+}
+
+// progressReader reports bytes as they are read, so the progress bar keeps
+// moving during a long copy instead of jumping once the file is done.
+type progressReader struct {
+	r        io.Reader
+	progress *Progress
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.progress.copying(int64(n))
+	return n, err
+}
+
+// sameStreams compares two files of equal size chunk by chunk and stops at
+// the first difference, so two large files are never hashed in full.
+func sameStreams(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, compareChunkSize), make([]byte, compareChunkSize)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+	}
+}
@@ -33,10 +33,94 @@ func locMsg(key, lang string) string {
 			"en": "=== Finished at %s ===",
 			"es": "=== Finalizado a las %s ===",
 		},
+		"no_write_mode": {
+			"en": "Read-only mode: all filesystem writes are disabled.",
+			"es": "Modo de solo lectura: todas las escrituras al sistema de archivos están deshabilitadas.",
+		},
 		"skipping_file": {
 			"en": "Skipping file already in output folder: %s",
 			"es": "Saltando archivo, ya se encuentra en carpeta de salida: %s",
 		},
+		"skipping_extension": {
+			"en": "Skipping file filtered out by extension: %s",
+			"es": "Saltando archivo filtrado por extensión: %s",
+		},
+		"skipping_pattern": {
+			"en": "Skipping file filtered out by path pattern: %s",
+			"es": "Saltando archivo filtrado por patrón de ruta: %s",
+		},
+		"skipping_size": {
+			"en": "Skipping file outside the size limits: %s (%s)",
+			"es": "Saltando archivo fuera de los límites de tamaño: %s (%s)",
+		},
+		"skipping_too_new": {
+			"en": "Skipping file modified less than %[2]s ago: %[1]s",
+			"es": "Saltando archivo modificado hace menos de %[2]s: %[1]s",
+		},
+		"stability_wait": {
+			"en": "Waiting %s to check that %d recently modified files are no longer being written",
+			"es": "Esperando %s para comprobar que %d archivos modificados hace poco ya no se están escribiendo",
+		},
+		"skipping_unstable": {
+			"en": "Skipping file that is still being written: %s",
+			"es": "Saltando archivo que todavía se está escribiendo: %s",
+		},
+		"skipping_hidden": {
+			"en": "Skipping hidden file: %q",
+			"es": "Saltando archivo oculto: %q",
+		},
+		"skipping_nomedia": {
+			"en": "Skipping folder marked with .nomedia: %q",
+			"es": "Saltando carpeta marcada con .nomedia: %q",
+		},
+		"large_file_deferred": {
+			"en": "Queued large file behind the small ones: %q (%s)",
+			"es": "Archivo grande en cola detrás de los pequeños: %q (%s)",
+		},
+		"large_file_resumed": {
+			"en": "Resuming copy of %q at %s of %s",
+			"es": "Reanudando la copia de %q en %s de %s",
+		},
+		"large_file_kept": {
+			"en": "Kept partial copy %q (%s) to resume on the next run",
+			"es": "Se conserva la copia parcial %q (%s) para reanudarla en la próxima ejecución",
+		},
+		"listed_outside_input": {
+			"en": "Skipping listed file outside the input folder: %q (not in %q)",
+			"es": "Saltando archivo de la lista fuera de la carpeta de entrada: %q (no está en %q)",
+		},
+		"files_from_watch": {
+			"en": "--files-from cannot be combined with watch, which follows the input folder itself",
+			"es": "--files-from no se puede combinar con watch, que sigue la propia carpeta de entrada",
+		},
+		"auto_workers": {
+			"en": "Storage: input on %s, output on %s; moving %d file(s) at a time (override with --workers)",
+			"es": "Almacenamiento: entrada en %s, salida en %s; moviendo %d archivo(s) a la vez (cámbialo con --workers)",
+		},
+		"dirs_precreated": {
+			"en": "Created %d destination folder(s) up front for %d move(s)",
+			"es": "Se crearon de antemano %d carpeta(s) de destino para %d movimiento(s)",
+		},
+		"skipping_quarantined": {
+			"en": "Skipping file quarantined by an earlier run: %q (in %q)",
+			"es": "Saltando archivo puesto en cuarentena por una ejecución anterior: %q (en %q)",
+		},
+		"quarantined_file": {
+			"en": "Quarantined after %[2]d failures: %[1]q => %[3]q",
+			"es": "En cuarentena tras %[2]d fallos: %[1]q => %[3]q",
+		},
+		"quarantine_error": {
+			"en": "Could not quarantine %q: %v",
+			"es": "No se pudo poner en cuarentena %q: %v",
+		},
+		"failure_history_error": {
+			"en": "Could not use the failure history: %v",
+			"es": "No se pudo usar el historial de fallos: %v",
+		},
+		"summary_quarantined": {
+			"en": "Quarantined: %d file(s) that kept failing",
+			"es": "En cuarentena: %d archivo(s) que fallaban repetidamente",
+		},
 		"move_error": {
 			"en": "Error moving file %q to %q: %v",
 			"es": "Error al mover archivo %q a %q: %v",
@@ -45,6 +129,446 @@ func locMsg(key, lang string) string {
 			"en": "Moved: %q => %q",
 			"es": "Movido: %q => %q",
 		},
+		"copied_file": {
+			"en": "Copied: %q => %q",
+			"es": "Copiado: %q => %q",
+		},
+		"year_dataset_created": {
+			"en": "Created year dataset: %s",
+			"es": "Dataset del año creado: %s",
+		},
+		"linked_file": {
+			"en": "Linked (%s): %q => %q",
+			"es": "Enlazado (%s): %q => %q",
+		},
+		"summary_totals": {
+			"en": "Summary: %d transferred, %d skipped, %d failed",
+			"es": "Resumen: %d transferidos, %d omitidos, %d fallidos",
+		},
+		"nothing_matched": {
+			"en": "No file was placed: none matched, or all were already in place",
+			"es": "No se colocó ningún archivo: ninguno coincidió, o todos ya estaban en su lugar",
+		},
+		"summary_failure_kinds": {
+			"en": "Failures by kind: %s",
+			"es": "Fallos por tipo: %s",
+		},
+		"summary_failure": {
+			"en": "Failed: %q (%s): %s",
+			"es": "Falló: %q (%s): %s",
+		},
+		"interrupted": {
+			"en": "Interrupted: stopped cleanly, partial copies removed and the journal saved",
+			"es": "Interrumpido: detenido limpiamente, copias parciales eliminadas y diario guardado",
+		},
+		"flatten_origins": {
+			"en": "Found the original paths of %d files in %d journals",
+			"es": "Se encontraron las rutas originales de %d archivos en %d diarios",
+		},
+		"gallery_thumb_error": {
+			"en": "No thumbnail for '%s': %v",
+			"es": "Sin miniatura para '%s': %v",
+		},
+		"gallery_written": {
+			"en": "Gallery of %d folders written to %s",
+			"es": "Galería de %d carpetas escrita en %s",
+		},
+		"backup_started": {
+			"en": "Backing up the %d output folders this run changed",
+			"es": "Respaldando las %d carpetas de salida que cambió esta ejecución",
+		},
+		"backup_finished": {
+			"en": "Backup finished",
+			"es": "Respaldo terminado",
+		},
+		"backup_failed": {
+			"en": "Backup failed: %v",
+			"es": "El respaldo falló: %v",
+		},
+		"start_sync": {
+			"en": "Syncing the organized tree %q to %q",
+			"es": "Sincronizando el árbol organizado %q con %q",
+		},
+		"sync_first": {
+			"en": "No earlier sync to %q: mirroring the whole tree",
+			"es": "No hay una sincronización anterior con %q: se replica el árbol entero",
+		},
+		"sync_changed": {
+			"en": "%d folders changed since the last sync at %s",
+			"es": "%d carpetas cambiaron desde la última sincronización a las %s",
+		},
+		"sync_nothing": {
+			"en": "Nothing changed since the last sync at %s",
+			"es": "Nada cambió desde la última sincronización a las %s",
+		},
+		"sync_removed": {
+			"en": "Removed '%s' from the mirror",
+			"es": "Se eliminó '%s' de la réplica",
+		},
+		"start_audit": {
+			"en": "Auditing %q against the archive %q",
+			"es": "Auditando %q contra el archivo %q",
+		},
+		"start_repair": {
+			"en": "Repairing the organized tree %q",
+			"es": "Reparando el árbol organizado %q",
+		},
+		"repair_misplaced": {
+			"en": "'%s' belongs in '%s'",
+			"es": "'%s' corresponde a '%s'",
+		},
+		"repair_undated": {
+			"en": "Leaving undated file '%s' in place: %v",
+			"es": "Se deja en su sitio el archivo sin fecha '%s': %v",
+		},
+		"start_flatten": {
+			"en": "Flattening %q into %q",
+			"es": "Aplanando %q en %q",
+		},
+		"heatmap_title": {
+			"en": "When your files originate",
+			"es": "De cuándo son sus archivos",
+		},
+		"heatmap_quarters": {
+			"en": "Files per quarter",
+			"es": "Archivos por trimestre",
+		},
+		"heatmap_busiest": {
+			"en": "Busiest days (⚠ marks unusual spikes, often files with a wrong date)",
+			"es": "Días con más archivos (⚠ marca picos inusuales, a menudo archivos con fecha errónea)",
+		},
+		"heatmap_written": {
+			"en": "Heatmap written to %q",
+			"es": "Mapa de calor escrito en %q",
+		},
+		"heatmap_error": {
+			"en": "Could not write heatmap: %v",
+			"es": "No se pudo escribir el mapa de calor: %v",
+		},
+		"watch_started": {
+			"en": "Watching %q for new files (debounce %s)",
+			"es": "Vigilando %q en busca de archivos nuevos (espera %s)",
+		},
+		"watch_error": {
+			"en": "Watch error",
+			"es": "Error de vigilancia",
+		},
+		"config_file": {
+			"en": "Settings read from config file %q",
+			"es": "Ajustes leídos del archivo de configuración %q",
+		},
+		"config_profile": {
+			"en": "Using config profile %q",
+			"es": "Usando el perfil de configuración %q",
+		},
+		"start_rename": {
+			"en": "Renaming files in place under %q with pattern %q",
+			"es": "Renombrando archivos en su lugar bajo %q con el patrón %q",
+		},
+		"start_tag": {
+			"en": "Tagging files in place under %q in %s",
+			"es": "Etiquetando archivos en su lugar bajo %q en %s",
+		},
+		"tagged_file": {
+			"en": "Tagged: %q (%s)",
+			"es": "Etiquetado: %q (%s)",
+		},
+		"tag_error": {
+			"en": "Could not tag %q: %v",
+			"es": "No se pudo etiquetar %q: %v",
+		},
+		"renamed_file": {
+			"en": "Renamed: %q => %q",
+			"es": "Renombrado: %q => %q",
+		},
+		"rename_duplicate": {
+			"en": "Not renaming %q: %q already exists with identical content",
+			"es": "No se renombra %q: %q ya existe con contenido idéntico",
+		},
+		"summary_metadata_warning": {
+			"en": "Timestamps not preserved: %q: %s",
+			"es": "Fechas no conservadas: %q: %s",
+		},
+		"metadata_not_preserved": {
+			"en": "[WARN] Could not preserve timestamps of %q, continuing: %v",
+			"es": "[AVISO] No se pudieron conservar las fechas de %q, se continúa: %v",
+		},
+		"summary_hint": {
+			"en": "Hint: %s",
+			"es": "Sugerencia: %s",
+		},
+		"hint_cross_device": {
+			"en": "source and destination are on different drives; use --mode copy or keep them on the same drive",
+			"es": "el origen y el destino están en unidades distintas; use --mode copy o manténgalos en la misma unidad",
+		},
+		"hint_permission": {
+			"en": "check the file permissions or run as administrator",
+			"es": "revise los permisos del archivo o ejecute como administrador",
+		},
+		"hint_name_invalid": {
+			"en": "the destination does not accept this file name; rename the file or choose another output drive",
+			"es": "el destino no acepta este nombre de archivo; renombre el archivo o elija otra unidad de salida",
+		},
+		"hint_disk_full": {
+			"en": "destination full; free up space or choose another output folder",
+			"es": "destino lleno; libere espacio o elija otra carpeta de salida",
+		},
+		"hint_not_found": {
+			"en": "the file or folder disappeared during the run; check whether another program moved it",
+			"es": "el archivo o la carpeta desapareció durante la ejecución; verifique si otro programa lo movió",
+		},
+		"hint_transient": {
+			"en": "the file was briefly locked or the share did not answer; run again, or use --retries to try such files again",
+			"es": "el archivo estuvo bloqueado un momento o el recurso compartido no respondió; ejecute de nuevo, o use --retries para reintentar esos archivos",
+		},
+		"hint_quota": {
+			"en": "the output folder reached --output-quota; free space in it or raise the quota, and run again",
+			"es": "la carpeta de salida alcanzó --output-quota; libere espacio en ella o aumente la cuota, y ejecute de nuevo",
+		},
+		"hint_read_only": {
+			"en": "the run is in --no-write mode; remove that flag to allow changes",
+			"es": "la ejecución está en modo --no-write; quite esa opción para permitir cambios",
+		},
+		"journal_written": {
+			"en": "Journal written to %s (revert with: structo undo <journal>)",
+			"es": "Diario escrito en %s (revertir con: structo undo <diario>)",
+		},
+		"journal_error": {
+			"en": "Could not write the journal: %v",
+			"es": "No se pudo escribir el diario: %v",
+		},
+		"start_undo": {
+			"en": "=== Undoing %s (%d entries) ===",
+			"es": "=== Deshaciendo %s (%d entradas) ===",
+		},
+		"error_undoing": {
+			"en": "Error undoing run",
+			"es": "Error al deshacer la ejecución",
+		},
+		"undo_error": {
+			"en": "Could not undo %q => %q: %v",
+			"es": "No se pudo deshacer %q => %q: %v",
+		},
+		"undone_entry": {
+			"en": "Restored: %q => %q",
+			"es": "Restaurado: %q => %q",
+		},
+		"plan_written": {
+			"en": "Plan with %d moves written to %s",
+			"es": "Plan con %d movimientos escrito en %s",
+		},
+		"start_apply": {
+			"en": "=== Applying plan %s (%d moves) ===",
+			"es": "=== Aplicando el plan %s (%d movimientos) ===",
+		},
+		"plan_source_missing": {
+			"en": "Planned source no longer exists: %s",
+			"es": "El origen planificado ya no existe: %s",
+		},
+		"plan_move_done": {
+			"en": "Already applied: %q => %q",
+			"es": "Ya aplicado: %q => %q",
+		},
+		"plan_record_error": {
+			"en": "Could not record where the files of the plan were placed: %v",
+			"es": "No se pudo anotar dónde se colocaron los archivos del plan: %v",
+		},
+		"plan_partial": {
+			"en": "Applying %d of %d planned moves; the other %d stay pending in the plan",
+			"es": "Aplicando %d de %d movimientos planificados; los otros %d quedan pendientes en el plan",
+		},
+		"plan_source_changed": {
+			"en": "Skipping file changed since the plan was made: %s",
+			"es": "Saltando archivo modificado desde que se hizo el plan: %s",
+		},
+		"diag_written": {
+			"en": "Diagnostics bundle written to %s",
+			"es": "Paquete de diagnóstico escrito en %s",
+		},
+		"summary_error": {
+			"en": "Could not write the run summary: %v",
+			"es": "No se pudo escribir el resumen de la ejecución: %v",
+		},
+		"duplicate_skipped": {
+			"en": "Skipping %q: identical content already at %q",
+			"es": "Saltando %q: el mismo contenido ya está en %q",
+		},
+		"dir_times_restored": {
+			"en": "Restored the original times of %d recreated folders",
+			"es": "Se restauraron las fechas originales de %d carpetas recreadas",
+		},
+		"pruned_empty_dirs": {
+			"en": "Removed %d folders left empty in %q",
+			"es": "Se eliminaron %d carpetas que quedaron vacías en %q",
+		},
+		"original_trashed": {
+			"en": "Moved the original %q to the trash",
+			"es": "Original %q movido a la papelera",
+		},
+		"exif_cache_hits": {
+			"en": "The EXIF cache saved %d image parses",
+			"es": "La caché EXIF ahorró %d análisis de imágenes",
+		},
+		"exif_cache_error": {
+			"en": "EXIF cache error: %v",
+			"es": "Error en la caché EXIF: %v",
+		},
+		"index_processed": {
+			"en": "Skipping '%s': the index records it as already processed",
+			"es": "Se salta '%s': el índice lo registra como ya procesado",
+		},
+		"index_unchanged": {
+			"en": "Skipping '%s': the index records it as already placed at '%s'",
+			"es": "Se salta '%s': el índice lo registra como ya colocado en '%s'",
+		},
+		"index_duplicate": {
+			"en": "Skipping '%s': the index records its content at '%s'",
+			"es": "Se salta '%s': el índice registra su contenido en '%s'",
+		},
+		"index_skipped": {
+			"en": "The index let %d files already processed, %d already placed and %d duplicates be skipped",
+			"es": "El índice permitió saltar %d archivos ya procesados, %d ya colocados y %d duplicados",
+		},
+		"junction_skipped": {
+			"en": "Skipping junction or mount point %q and everything behind it (see --junctions)",
+			"es": "Omitiendo la unión o punto de montaje %q y todo lo que hay detrás (ver --junctions)",
+		},
+		"junction_followed": {
+			"en": "Following junction or mount point %q to %q",
+			"es": "Siguiendo la unión o punto de montaje %q hasta %q",
+		},
+		"junction_loop": {
+			"en": "Not following junction or mount point %q: %q is already walked",
+			"es": "No se sigue la unión o punto de montaje %q: %q ya se recorre",
+		},
+		"quota_index_usage": {
+			"en": "The index records %s of files in the output folder",
+			"es": "El índice registra %s de archivos en la carpeta de salida",
+		},
+		"quota_tree_usage": {
+			"en": "The output folder holds %s of files",
+			"es": "La carpeta de salida contiene %s de archivos",
+		},
+		"quota_error": {
+			"en": "Could not work out the size of the output folder for --output-quota: %v",
+			"es": "No se pudo calcular el tamaño de la carpeta de salida para --output-quota: %v",
+		},
+		"quota_warning": {
+			"en": "The output folder holds %s, more than --output-quota-warn %s",
+			"es": "La carpeta de salida contiene %s, más que --output-quota-warn %s",
+		},
+		"quota_reached": {
+			"en": "%q was not placed: %v",
+			"es": "%q no se colocó: %v",
+		},
+		"locked_postponed": {
+			"en": "%q is in use by another program; trying it again at the end of the run",
+			"es": "%q está en uso por otro programa; se reintentará al final de la ejecución",
+		},
+		"locked_retrying": {
+			"en": "Trying %d files that were in use again",
+			"es": "Reintentando %d archivos que estaban en uso",
+		},
+		"locked_remaining": {
+			"en": "%d files are still in use by another program and were not placed",
+			"es": "%d archivos siguen en uso por otro programa y no se colocaron",
+		},
+		"locked_scheduled": {
+			"en": "Scheduled %q to be moved to %q when Windows restarts",
+			"es": "Se programó mover %q a %q cuando Windows se reinicie",
+		},
+		"locked_schedule_error": {
+			"en": "Could not schedule %q to be moved on restart: %v",
+			"es": "No se pudo programar mover %q al reiniciar: %v",
+		},
+		"hardlink_kept": {
+			"en": "Linked %s => %s, the same file as %s",
+			"es": "Enlazado %s => %s, el mismo archivo que %s",
+		},
+		"hardlink_failed": {
+			"en": "Could not link %s to %s, placing it on its own: %v",
+			"es": "No se pudo enlazar %s con %s, se coloca por separado: %v",
+		},
+		"companion_follows": {
+			"en": "%q goes with %q",
+			"es": "%q va con %q",
+		},
+		"artifacts_migrated": {
+			"en": "Moved %d logs, journals and reports of earlier runs from the output root into %s",
+			"es": "Se movieron %d registros, diarios e informes de ejecuciones anteriores de la raíz de salida a %s",
+		},
+		"index_reconciled": {
+			"en": "Added %d placements recorded in %s to the index",
+			"es": "Se añadieron al índice %d colocaciones registradas en %s",
+		},
+		"index_error": {
+			"en": "Index error: %v",
+			"es": "Error en el índice: %v",
+		},
+		"tree_unchanged": {
+			"en": "%d files unchanged since the last run were skipped without planning (--rescan plans them again)",
+			"es": "%d archivos sin cambios desde la última ejecución se saltaron sin planificar (--rescan los vuelve a planificar)",
+		},
+		"tree_snapshot_error": {
+			"en": "Tree snapshot error: %v",
+			"es": "Error en la instantánea del árbol: %v",
+		},
+		"conflict_skipped": {
+			"en": "Skipping %q: %q already exists (--on-conflict skip)",
+			"es": "Saltando %q: %q ya existe (--on-conflict skip)",
+		},
+		"conflict_overwrite": {
+			"en": "Overwriting %q with %q (--on-conflict overwrite)",
+			"es": "Sobrescribiendo %q con %q (--on-conflict overwrite)",
+		},
+		"overwritten_restore_error": {
+			"en": "Could not put the overwritten file %q back to %q: %v",
+			"es": "No se pudo devolver el archivo sobrescrito %q a %q: %v",
+		},
+		"config_warning": {
+			"en": "[WARN] %s",
+			"es": "[AVISO] %s",
+		},
+		"hash_error": {
+			"en": "Could not hash %q: %v",
+			"es": "No se pudo calcular el hash de %q: %v",
+		},
+		"dedupe_error": {
+			"en": "Could not resolve duplicate %q: %v",
+			"es": "No se pudo resolver el duplicado %q: %v",
+		},
+		"dedupe_resolved": {
+			"en": "Duplicate resolved (%s): %q (kept %q)",
+			"es": "Duplicado resuelto (%s): %q (se conservó %q)",
+		},
+		"worker_failed": {
+			"en": "Worker %d stopped after %d failed file(s)",
+			"es": "El trabajador %d se detuvo tras %d archivo(s) fallido(s)",
+		},
+		"transfer_retry": {
+			"en": "Retrying %q in %s (attempt %d of %d): %v",
+			"es": "Reintentando %q en %s (intento %d de %d): %v",
+		},
+		"override_used": {
+			"en": "%q is pinned to %s by the overrides file",
+			"es": "%q está fijado en %s por el archivo de excepciones",
+		},
+		"skipping_undated": {
+			"en": "Skipping file no date source could date: %s",
+			"es": "Saltando archivo que ninguna fuente de fecha pudo fechar: %s",
+		},
+		"date_source_used": {
+			"en": "Date for %q taken from %s: %s",
+			"es": "Fecha de %q obtenida de %s: %s",
+		},
+		"fs_capabilities": {
+			"en": "Output filesystem (probed: %t): case-sensitive=%t, max name length=%d bytes, invalid characters=%s, timestamp granularity=%s, symlinks=%t, hardlinks=%t",
+			"es": "Sistema de archivos de salida (sondeado: %t): distingue mayúsculas=%t, longitud máxima de nombre=%d bytes, caracteres no válidos=%s, granularidad de fechas=%s, enlaces simbólicos=%t, enlaces duros=%t",
+		},
+		"fs_probe_failed": {
+			"en": "Could not probe the output filesystem, assuming defaults: %v",
+			"es": "No se pudo sondear el sistema de archivos de salida, se asumen valores por defecto: %v",
+		},
 	}
 
 	// Fallback logic: if the key or lang is missing, default to English
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+// LockedFiles holds the files of a run that another program had open
+// without sharing them, which happens on Windows with files open in an
+// editor, a sync client or a virus scanner. Instead of failing, such a file
+// is postponed and tried once more when every other file was placed, by
+// when it is often closed. A nil LockedFiles fails them right away.
+type LockedFiles struct {
+	mu    sync.Mutex
+	files []lockedFile
+	// final is set for the follow-up pass, whose locked files are failures
+	// like any other and are only collected for --move-on-reboot.
+	final bool
+}
+
+// lockedFile is a file postponed by LockedFiles, with its planned move.
+type lockedFile struct {
+	path string
+	info os.FileInfo
+	move PlannedMove
+	err  error
+}
+
+// withLockedFiles sets up LockedFiles for a run that organizes the input.
+func withLockedFiles(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	cfg.Locked = &LockedFiles{}
+	return cfg
+}
+
+// postpone reports whether the failure err of path, planned as move, was a
+// locked file left for the follow-up pass. Its failure is then taken off the
+// summary, so it only counts when the follow-up pass fails too.
+func (l *LockedFiles) postpone(path string, info os.FileInfo, move PlannedMove, err error, cfg FilesMoveConfiguration) bool {
+	if l == nil || !isLockedError(err) {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, lockedFile{path: path, info: info, move: move, err: err})
+	if l.final {
+		return false
+	}
+	cfg.Summary.forgetFailure(path)
+	log.Printf(locMsg("locked_postponed", cfg.Language), path)
+	return true
+}
+
+// take returns the files collected so far and starts collecting afresh,
+// for the follow-up pass.
+func (l *LockedFiles) take() []lockedFile {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files := l.files
+	l.files, l.final = nil, true
+	return files
+}
+
+// retryLockedFiles is the follow-up pass over the files postponed by
+// LockedFiles, once the run ended in runErr. After a run cut short they are
+// only reported as failed. Files still locked are reported, and scheduled to
+// be moved when Windows restarts with --move-on-reboot; they are failures of
+// the run all the same.
+func retryLockedFiles(ctx context.Context, cfg FilesMoveConfiguration, runErr error) error {
+	postponed := cfg.Locked.take()
+	if len(postponed) == 0 {
+		return runErr
+	}
+	if runErr != nil {
+		for _, file := range postponed {
+			cfg.Summary.recordFailure(file.path, file.err, cfg.Language)
+		}
+		return runErr
+	}
+	log.Printf(locMsg("locked_retrying", cfg.Language), len(postponed))
+	var err error
+	for _, file := range postponed {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if _, err = placeFile(ctx, file.path, file.info, nil, cfg); err != nil {
+			break
+		}
+	}
+	remaining := cfg.Locked.take()
+	if len(remaining) == 0 {
+		return err
+	}
+	log.Printf(locMsg("locked_remaining", cfg.Language), len(remaining))
+	if cfg.MoveOnReboot && cfg.Mode == ModeMove && cfg.Backend == BackendFilesystem {
+		for _, file := range remaining {
+			scheduleLockedFile(file, cfg)
+		}
+	}
+	return err
+}
+
+// scheduleLockedFile schedules the move of a file still locked after the
+// follow-up pass for the next time Windows starts, when no program holds it
+// yet. The move is not journaled, since it has not happened.
+func scheduleLockedFile(file lockedFile, cfg FilesMoveConfiguration) {
+	if file.move.Destination == "" {
+		return
+	}
+	// The locked file cannot be read, so its content is not compared.
+	dst, err := ensureUniquePath("", file.move.Destination, cfg)
+	if err == nil {
+		err = ensureTargetDirectory(dst, cfg)
+	}
+	if err == nil && cfg.DryRun {
+		log.Printf("[DRY RUN] Would schedule move on reboot: %s => %s", file.path, dst)
+		return
+	}
+	if err == nil {
+		err = scheduleMoveOnReboot(file.path, dst, cfg.FS)
+	}
+	if err != nil {
+		cfg.Reservations.release(dst)
+		log.Printf(locMsg("locked_schedule_error", cfg.Language), file.path, err)
+		return
+	}
+	log.Printf(locMsg("locked_scheduled", cfg.Language), file.path, dst)
+}
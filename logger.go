@@ -8,13 +8,27 @@ import (
 	"time"
 )
 
-// setupLogger opens a log file in the output folder and configures Go's logger to write there.
-// The log file name includes a timestamp for traceability, e.g. ".organizer_2024-12-31_15-04-05.log".
+// setupLogger opens a log file in the metadata folder of the output and configures Go's logger
+// to write there, after moving the files earlier versions left in the output root into that folder.
+// The log file name includes a timestamp for traceability, e.g. "log-2024-12-31_15-04-05.log".
+// In --no-write mode no file is created and logs go to stderr instead.
 func setupLogger(config FilesMoveConfiguration) (FilesMoveConfiguration, error) {
+	if config.FS.ReadOnly() {
+		// Nothing may be written in read-only mode, not even the log file.
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+		return config, nil
+	}
+
+	migrated, err := ensureMetadataDir(config.FS, config.OutputFolder)
+	if err != nil {
+		return FilesMoveConfiguration{}, err
+	}
+
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	logFilename := filepath.Join(config.OutputFolder, fmt.Sprintf(".organizer_%s.log", timestamp))
+	logFilename := filepath.Join(metadataDir(config.OutputFolder), fmt.Sprintf("%s%s.log", logFilePrefix, timestamp))
 
-	logFile, err := os.OpenFile(logFilename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	logFile, err := config.FS.OpenFile(logFilename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return FilesMoveConfiguration{}, fmt.Errorf("failed to open log file %q: %w", logFilename, err)
 	}
@@ -24,6 +38,9 @@ func setupLogger(config FilesMoveConfiguration) (FilesMoveConfiguration, error)
 	// Include date/time, source file, and line number for traceability
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	config.Logger = logFile
+	if migrated > 0 {
+		log.Printf(locMsg("artifacts_migrated", config.Language), migrated, metadataDir(config.OutputFolder))
+	}
 
 	return config, nil
 }
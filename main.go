@@ -1,21 +1,84 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
+	args := parseCommandLine()
+
+	// The first Ctrl+C stops the run cleanly; once that has begun, the default
+	// handling is restored so a second one quits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	switch {
+	case args.Undo != nil:
+		runUndo(ctx, args)
+	case args.Plan != nil:
+		runPlan(ctx, args)
+	case args.Apply != nil:
+		runApply(ctx, args)
+	case args.Diag != nil:
+		runDiag(args)
+	case args.CompareLayouts != nil:
+		runCompareLayouts(ctx, args)
+	case args.Dedupe != nil:
+		runDedupe(ctx, args)
+	case args.Rename != nil:
+		runRename(ctx, args)
+	case args.Tag != nil:
+		runTag(ctx, args)
+	case args.Flatten != nil:
+		runFlatten(ctx, args)
+	case args.Watch != nil:
+		runWatch(ctx, args)
+	case args.TestRules != nil:
+		runTestRules(ctx, args)
+	case args.ConfigTools != nil:
+		runConfigTools(args)
+	case args.Verify != nil:
+		runVerify(args)
+	case args.Repair != nil:
+		runRepair(ctx, args)
+	case args.Audit != nil:
+		runAudit(args)
+	case args.Stats != nil:
+		runStats(args)
+	case args.Gallery != nil:
+		runGallery(args)
+	case args.Sync != nil:
+		runSync(ctx, args)
+	case args.Selftest != nil:
+		runSelftest(ctx, args)
+	default:
+		runOrganize(ctx, args)
+	}
+}
+
+func runOrganize(ctx context.Context, args CommandLineArguments) {
 	// Build our config from the arguments
-	cfg, err := parseArgs()
+	cfg, err := parseArgs(args)
 	if err != nil {
 		// We'll temporarily log to stderr, then exit
 		log.Fatalf("Error parsing config: %v", err)
 	}
+	if args.Run != nil {
+		cfg.Warnings = append(cfg.Warnings, "command 'run' is deprecated; use 'organize' instead")
+	}
 
 	// Ensure the output folder exists (or create it).
-	if err := os.MkdirAll(cfg.OutputFolder, 0755); err != nil {
+	if err := cfg.FS.MkdirAll(cfg.OutputFolder, 0755); err != nil {
 		log.Fatalf("Failed to create output folder: %v", err)
 	}
 
@@ -25,23 +88,945 @@ func main() {
 		log.Fatalf("Could not set up logger: %v", err)
 	}
 	// Ensure we close the file when finished
-	defer cfg.Logger.Close()
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
 
 	// Initial logs (program start)
 	log.Printf(locMsg("start_organizer", cfg.Language), time.Now().Format(time.RFC3339))
 	log.Printf(locMsg("input_folder", cfg.Language), cfg.InputFolder)
 	log.Printf(locMsg("output_folder", cfg.Language), cfg.OutputFolder)
+	if cfg.ConfigFile != "" {
+		log.Printf(locMsg("config_file", cfg.Language), cfg.ConfigFile)
+	}
+	if cfg.Profile != "" {
+		log.Printf(locMsg("config_profile", cfg.Language), cfg.Profile)
+	}
+	if cfg.FS.ReadOnly() {
+		log.Println(locMsg("no_write_mode", cfg.Language))
+	}
+	logConfigWarnings(cfg)
+	cfg = detectCapabilities(cfg)
 
 	// Check if the input folder is valid
 	if err := checkFolderExists(cfg.InputFolder); err != nil {
 		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
 	}
+	cfg = tuneWorkers(cfg)
 
-	// Organize files
-	if err := organizeFiles(cfg); err != nil {
-		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	// Organize files, journaling every completed move so the run can be undone
+	cfg.Journal = newJournal(cfg)
+	cfg = withFailureHistory(cfg)
+	cfg = withTreeSnapshot(cfg)
+	cfg = withFileIndex(cfg)
+	cfg = withOutputQuota(cfg)
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	cfg = withDirTimes(cfg)
+	cfg = withHardlinkSets(cfg)
+	cfg = withLockedFiles(cfg)
+	cfg.Progress = newProgress(cfg, args.NoProgress)
+	if cfg.Progress != nil {
+		cfg.Progress.start(prescanInput(cfg))
+	}
+	err = organizeFiles(ctx, cfg)
+	cfg.Progress.finish()
+	if ranToEnd(err) {
+		pruneEmptySources(cfg)
 	}
+	err = errors.Join(err, restoreDirTimes(cfg))
+	saveJournal(cfg)
+	saveFailureHistory(cfg)
+	saveTreeSnapshot(cfg)
+	closeFileIndex(cfg)
+	closeExifCache(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("organize", cfg)
+	saveHeatmap(cfg.FS, args.Heatmap, cfg.Summary.Days, cfg.Language)
+	exitAfterRun(err, "error_organizing", cfg)
+	backupAfterRun(cfg)
 
 	log.Println(locMsg("file_org_complete", cfg.Language))
 	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
 }
+
+func runUndo(ctx context.Context, args CommandLineArguments) {
+	journal, err := loadJournal(migratedArtifactPath(args.Undo.Journal))
+	if err != nil {
+		log.Fatalf("Error reading journal: %v", err)
+	}
+	cfg := parseUndoArgs(args, journal)
+
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	// Setting up the logger may have moved a journal from the output root.
+	journal.path = migratedArtifactPath(journal.path)
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_undo", cfg.Language), args.Undo.Journal, len(journal.Entries))
+	cfg = withFileIndex(cfg)
+	err = undoJournal(ctx, journal, cfg)
+	closeFileIndex(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("undo", cfg)
+	exitAfterRun(err, "error_undoing", cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runPlan(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parsePlanArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+
+	logConfigWarnings(cfg)
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	plan, err := buildPlan(cfg)
+	closeExifCache(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	if err := writePlan(newFileSystem(args.NoWrite), plan, args.Plan.Out); err != nil {
+		log.Fatalf("Could not write plan: %v", err)
+	}
+	log.Printf(locMsg("plan_written", cfg.Language), len(plan.Moves), args.Plan.Out)
+	saveHeatmap(newFileSystem(args.NoWrite), args.Heatmap, heatmapFromMoves(plan.Moves), cfg.Language)
+}
+
+func runTestRules(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseTestRulesArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+
+	logConfigWarnings(cfg)
+	samples, err := readSamples(args.TestRules.Sample, cfg)
+	if err != nil {
+		log.Fatalf("Could not read samples: %v", err)
+	}
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	results := testRules(samples, cfg)
+	closeExifCache(cfg)
+	printRuleResults(os.Stdout, results)
+	for _, r := range results {
+		if r.Failed {
+			os.Exit(exitFileErrors)
+		}
+	}
+}
+
+func runConfigTools(args CommandLineArguments) {
+	switch {
+	case args.ConfigTools.Schema != nil:
+		schema, err := configJSONSchema()
+		if err != nil {
+			log.Fatalf("Could not build schema: %v", err)
+		}
+		os.Stdout.Write(schema)
+	case args.ConfigTools.Migrate != nil:
+		runConfigMigrate(args)
+	default:
+		fmt.Fprintln(os.Stderr, "error: expected a config subcommand: schema, migrate")
+		os.Exit(exitFatal)
+	}
+}
+
+// runConfigMigrate upgrades a config file and prints each change made.
+func runConfigMigrate(args CommandLineArguments) {
+	path := args.ConfigTools.Migrate.File
+	if path == "" {
+		path = args.Config
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "error: no config file to migrate; pass one or use --config")
+		os.Exit(exitFatal)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Could not read config file: %v", err)
+	}
+	migration, err := migrateConfig(path, data)
+	if err != nil {
+		log.Fatalf("Could not migrate config file: %v", err)
+	}
+	if len(migration.Edits) == 0 {
+		fmt.Printf("%s is up to date; nothing to migrate\n", path)
+		return
+	}
+	for _, edit := range migration.Edits {
+		if edit.Note != "" {
+			fmt.Printf("%s:%d:%d: %s\n", path, edit.Line, edit.Column, edit.Note)
+		}
+	}
+	written, err := writeConfigMigration(newFileSystem(args.NoWrite), path, args.ConfigTools.Migrate.Out, migration)
+	if err != nil {
+		log.Fatalf("Could not write migrated config file: %v", err)
+	}
+	if written == path {
+		fmt.Printf("Upgraded %s (original kept as %s.bak)\n", path, path)
+	} else {
+		fmt.Printf("Upgraded config written to %s\n", written)
+	}
+	doc, err := parseConfigDocument(written, migration.Data, strings.EqualFold(filepath.Ext(path), ".toml"))
+	if err == nil {
+		err = validateConfigDocument(written, doc)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The upgraded file still has problems to fix by hand:\n%v\n", err)
+		os.Exit(exitFatal)
+	}
+}
+
+func runCompareLayouts(ctx context.Context, args CommandLineArguments) {
+	formats, err := parseFolderFormatList(args.CompareLayouts.Formats)
+	if err != nil {
+		log.Fatalf("Error parsing config: invalid folder formats: %v", err)
+	}
+	cfg, err := parsePlanArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	results, err := compareLayouts(formats, cfg)
+	closeExifCache(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	printLayoutComparison(os.Stdout, results)
+}
+
+func runDedupe(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseDedupeArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+	cfg = tuneWorkers(cfg)
+	cfg = withFileIndex(cfg)
+
+	groups, err := findDuplicates(cfg)
+	closeFileIndex(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	printDuplicateReport(os.Stdout, groups)
+	cfg.Journal = newJournal(cfg)
+	err = resolveDuplicates(ctx, groups, args.Dedupe.Action, cfg)
+	saveJournal(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("dedupe", cfg)
+	if err != nil {
+		exitIfInterrupted(err, cfg.Language)
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+}
+
+func runRename(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseRenameArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_rename", cfg.Language), cfg.InputFolder, args.Rename.Pattern)
+	logConfigWarnings(cfg)
+	cfg = detectCapabilities(cfg)
+	cfg.Journal = newJournal(cfg)
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	err = renameFiles(ctx, args.Rename.Pattern, cfg)
+	closeExifCache(cfg)
+	saveJournal(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("rename", cfg)
+	exitAfterRun(err, "error_organizing", cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runTag(ctx context.Context, args CommandLineArguments) {
+	cfg, store, err := parseTagArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_tag", cfg.Language), cfg.InputFolder, store)
+	logConfigWarnings(cfg)
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	err = tagFiles(ctx, store, cfg)
+	closeExifCache(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("tag", cfg)
+	exitAfterRun(err, "error_organizing", cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runFlatten(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseFlattenArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	if err := cfg.FS.MkdirAll(cfg.OutputFolder, 0755); err != nil {
+		log.Fatalf("Failed to create output folder: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_flatten", cfg.Language), cfg.InputFolder, cfg.OutputFolder)
+	logConfigWarnings(cfg)
+	cfg = detectCapabilities(cfg)
+	cfg.Journal = newJournal(cfg)
+	cfg = withHardlinkSets(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	var origins map[string]string
+	if args.Flatten.RestorePaths {
+		var journals int
+		if origins, journals, err = recordedOrigins(cfg.InputFolder); err != nil {
+			log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+		}
+		log.Printf(locMsg("flatten_origins", cfg.Language), len(origins), journals)
+	}
+	err = flattenFiles(ctx, cfg, origins)
+	saveJournal(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("flatten", cfg)
+	exitAfterRun(err, "error_organizing", cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runWatch(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if cfg.FilesFrom != nil {
+		log.Fatal(locMsg("files_from_watch", cfg.Language))
+	}
+	if err := checkFolderExists(cfg.InputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	if err := cfg.FS.MkdirAll(cfg.OutputFolder, 0755); err != nil {
+		log.Fatalf("Failed to create output folder: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	if cfg.FS.ReadOnly() {
+		log.Println(locMsg("no_write_mode", cfg.Language))
+	}
+	logConfigWarnings(cfg)
+	cfg = detectCapabilities(cfg)
+	cfg.Journal = newJournal(cfg)
+	cfg = withFailureHistory(cfg)
+	cfg = withFileIndex(cfg)
+	cfg = withOutputQuota(cfg)
+	cfg = withExifCache(cfg)
+	cfg = withStabilityCheck(ctx, cfg)
+	cfg = withHardlinkSets(cfg)
+	err = watchAndOrganize(ctx, cfg, args.Watch.Debounce)
+	saveJournal(cfg)
+	closeFileIndex(cfg)
+	closeExifCache(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("watch", cfg)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runApply(ctx context.Context, args CommandLineArguments) {
+	plan, files, err := loadPlans(args.Apply.Plans)
+	if err != nil {
+		log.Fatalf("Error reading plan: %v", err)
+	}
+	filters, err := parsePlanFilters(args.Apply.Only)
+	if err != nil {
+		log.Fatalf("Error parsing config: invalid --only: %v", err)
+	}
+	if err := loadPlacedMoves(plan); err != nil {
+		log.Fatalf("Error reading plan: %v", err)
+	}
+	planned := len(plan.Moves)
+	plan = selectMoves(plan, filters)
+	cfg := parseApplyArgs(args, plan)
+	if cfg.PruneEmpty && plan.Input == "" {
+		// Folders emptied by moves are pruned up to the input folder, which
+		// plans of different inputs do not share.
+		log.Fatalf("Error reading plan: --prune-empty needs plans of one input folder, but %s have different ones; apply them separately", strings.Join(files, ", "))
+	}
+
+	if err := cfg.FS.MkdirAll(cfg.OutputFolder, 0755); err != nil {
+		log.Fatalf("Failed to create output folder: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_apply", cfg.Language), strings.Join(files, ", "), len(plan.Moves))
+	if len(filters) > 0 {
+		log.Printf(locMsg("plan_partial", cfg.Language), len(plan.Moves), planned, planned-len(plan.Moves))
+	}
+	cfg = detectCapabilities(cfg)
+	cfg.Journal = newJournal(cfg)
+	cfg = withHardlinkSets(cfg)
+	cfg.Progress = newProgress(cfg, args.NoProgress)
+	cfg.Progress.start(planTotals(plan))
+	err = applyPlan(ctx, plan, cfg)
+	cfg.Progress.finish()
+	if !cfg.DryRun {
+		if recordErr := recordPlacedMoves(plan, cfg); recordErr != nil {
+			log.Printf(locMsg("plan_record_error", cfg.Language), recordErr)
+		}
+	}
+	if ranToEnd(err) {
+		pruneEmptySources(cfg)
+	}
+	saveJournal(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("apply", cfg)
+	saveHeatmap(cfg.FS, args.Heatmap, cfg.Summary.Days, cfg.Language)
+	exitAfterRun(err, "error_organizing", cfg)
+	backupAfterRun(cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runVerify(args CommandLineArguments) {
+	cfg, err := parseVerifyArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if err := checkFolderExists(cfg.OutputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	logConfigWarnings(cfg)
+	cfg = withExifCache(cfg)
+	issues, checked, err := verifyTree(cfg)
+	closeExifCache(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	if args.Verify.Duplicates {
+		cfg = withFileIndex(cfg)
+		defer closeFileIndex(cfg)
+		duplicates, err := duplicateIssues(tuneWorkers(cfg))
+		if err != nil {
+			log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+		}
+		issues = append(issues, duplicates...)
+	}
+	printVerifyIssues(os.Stdout, issues, checked)
+	if len(issues) > 0 {
+		os.Exit(exitFileErrors)
+	}
+}
+
+func runAudit(args CommandLineArguments) {
+	cfg, err := parseAuditArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	for _, folder := range []string{cfg.InputFolder, cfg.OutputFolder} {
+		if err := checkFolderExists(folder); err != nil {
+			log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+		}
+	}
+	log.Printf(locMsg("start_audit", cfg.Language), cfg.InputFolder, cfg.OutputFolder)
+	cfg = tuneWorkers(withFileIndex(cfg))
+	result, err := auditArchive(cfg)
+	closeFileIndex(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	printAuditReport(os.Stdout, result)
+	if args.Audit.Out != "" {
+		if err := writeAuditList(newFileSystem(args.NoWrite), result, args.Audit.Out); err != nil {
+			log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+		}
+	}
+	if len(result.Missing) > 0 {
+		os.Exit(exitFileErrors)
+	}
+}
+
+func runRepair(ctx context.Context, args CommandLineArguments) {
+	cfg, err := parseRepairArgs(args)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if err := checkFolderExists(cfg.OutputFolder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_repair", cfg.Language), cfg.OutputFolder)
+	if cfg.FS.ReadOnly() {
+		log.Println(locMsg("no_write_mode", cfg.Language))
+	}
+	logConfigWarnings(cfg)
+	cfg = detectCapabilities(cfg)
+	cfg.Journal = newJournal(cfg)
+	cfg = withExifCache(cfg)
+	err = repairTree(ctx, cfg)
+	closeExifCache(cfg)
+	saveJournal(cfg)
+	logSummary(cfg.Summary, cfg.Language)
+	saveRunRecord("repair", cfg)
+	exitAfterRun(err, "error_organizing", cfg)
+	backupAfterRun(cfg)
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runStats(args CommandLineArguments) {
+	folder := args.Stats.Dir
+	if folder == "" {
+		folder = args.Output
+	}
+	if folder == "" {
+		folder = args.Input
+	}
+	if folder == "" {
+		log.Fatalf("Error parsing config: stats needs a folder, --output or --input")
+	}
+	cfg := parseRecordedRunArgs(args, folder, folder)
+	// stats only reads the tree, whatever --no-write says.
+	cfg.DryRun = true
+	cfg.FS = newFileSystem(true)
+	if err := checkFolderExists(folder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	stats, err := collectTreeStats(cfg)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	printTreeStats(os.Stdout, stats)
+}
+
+func runGallery(args CommandLineArguments) {
+	folder := args.Gallery.Dir
+	if folder == "" {
+		folder = args.Output
+	}
+	if folder == "" {
+		folder = args.Input
+	}
+	if folder == "" {
+		log.Fatalf("Error parsing config: gallery needs a folder, --output or --input")
+	}
+	if args.Gallery.ThumbSize < 16 {
+		log.Fatalf("Error parsing config: --thumb-size must be at least 16, got %d", args.Gallery.ThumbSize)
+	}
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	out := filepath.Join(folder, galleryDirName)
+	if args.Gallery.Out != "" {
+		if out, err = filepath.Abs(args.Gallery.Out); err != nil {
+			log.Fatalf("Error parsing config: %v", err)
+		}
+	}
+	cfg := parseRecordedRunArgs(args, folder, folder)
+	if err := checkFolderExists(folder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	folders, err := collectGalleryFolders(cfg, out)
+	if err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	if err := buildGallery(cfg.FS, folders, out, args.Gallery.ThumbSize, cfg.Language); err != nil {
+		log.Fatalf(locMsg("error_organizing", cfg.Language)+": %v", err)
+	}
+	log.Printf(locMsg("gallery_written", cfg.Language), len(folders), filepath.Join(out, "index.html"))
+}
+
+func runSync(ctx context.Context, args CommandLineArguments) {
+	folder := args.Sync.Dir
+	if folder == "" {
+		folder = args.Output
+	}
+	if folder == "" {
+		folder = args.Input
+	}
+	if folder == "" {
+		log.Fatalf("Error parsing config: sync needs a folder, --output or --input")
+	}
+	transport, err := ParseSyncTransport(args.Sync.Transport)
+	if err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	if folder, err = filepath.Abs(folder); err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	target := args.Sync.To
+	if !isRemoteTarget(target) {
+		if target, err = filepath.Abs(target); err != nil {
+			log.Fatalf("Error parsing config: %v", err)
+		}
+		if isBelow(target, folder) || target == folder {
+			log.Fatalf("Error parsing config: cannot sync %s into itself", folder)
+		}
+	}
+	cfg := parseRecordedRunArgs(args, folder, folder)
+	cfg.SyncTo = target
+	if err := checkFolderExists(folder); err != nil {
+		log.Fatalf(locMsg("input_folder_invalid", cfg.Language)+": %v", err)
+	}
+	cfg, err = setupLogger(cfg)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	if cfg.Logger != nil {
+		defer cfg.Logger.Close()
+	}
+
+	log.Printf(locMsg("start_sync", cfg.Language), cfg.OutputFolder, cfg.SyncTo)
+	if cfg.FS.ReadOnly() {
+		log.Println(locMsg("no_write_mode", cfg.Language))
+	}
+	transport, err = syncTree(ctx, cfg, transport)
+	if transport == SyncBuiltin {
+		logSummary(cfg.Summary, cfg.Language)
+	}
+	saveRunRecord("sync", cfg)
+	if err != nil {
+		exitIfInterrupted(err, cfg.Language)
+		log.Printf(locMsg("error_organizing", cfg.Language)+": %v", err)
+		os.Exit(exitFileErrors)
+	}
+	log.Printf(locMsg("finished", cfg.Language)+"\n", time.Now().Format(time.RFC3339))
+}
+
+func runSelftest(ctx context.Context, args CommandLineArguments) {
+	if args.NoWrite {
+		// The test tree is written, organized and undone for real.
+		dir := args.Selftest.Dir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		log.Fatalf("Could not create the test tree: %v", refuse("mkdir", dir))
+	}
+	root, err := os.MkdirTemp(args.Selftest.Dir, "structo-selftest-")
+	if err != nil {
+		log.Fatalf("Could not create the test tree: %v", err)
+	}
+	logFile, err := selftestLog(root)
+	if err != nil {
+		log.Fatalf("Could not set up logger: %v", err)
+	}
+	checks := selftestCycle(ctx, root)
+	logFile.Close()
+	log.SetOutput(os.Stderr)
+
+	passed := printSelftest(os.Stdout, checks)
+	if passed && !args.Selftest.Keep {
+		os.RemoveAll(root)
+	} else {
+		fmt.Printf("\nThe test tree and its log are in %s\n", root)
+	}
+	exitIfInterrupted(ctx.Err(), langOrDefault(args.Lang))
+	if !passed {
+		os.Exit(exitFileErrors)
+	}
+}
+
+func runDiag(args CommandLineArguments) {
+	folder := args.Output
+	if folder == "" {
+		folder = args.Input
+	}
+	if folder == "" {
+		log.Fatalf("Error parsing config: diag needs --output (or --input) pointing at the folder of the run")
+	}
+	out := args.Diag.Out
+	if out == "" {
+		out = fmt.Sprintf("structo-diag-%s.zip", time.Now().Format(journalTimestamp))
+	}
+	if err := buildDiagBundle(newFileSystem(args.NoWrite), folder, out, args.Diag.IncludeFilenames); err != nil {
+		log.Fatalf("Could not build diagnostics bundle: %v", err)
+	}
+	log.Printf(locMsg("diag_written", langOrDefault(args.Lang)), out)
+}
+
+// exitIfInterrupted ends a cancelled run with exitInterrupted,
+// after its journal and summary have been flushed.
+func exitIfInterrupted(err error, lang string) {
+	if errors.Is(err, context.Canceled) {
+		log.Println(locMsg("interrupted", lang))
+		fmt.Fprintln(os.Stderr, locMsg("interrupted", lang))
+		os.Exit(exitInterrupted)
+	}
+}
+
+// detectCapabilities probes the output filesystem, logs what it found and
+// refuses modes the destination cannot hold. A dry run probes nothing, so
+// it leaves the output exactly as it found it.
+func detectCapabilities(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	fsys := cfg.FS
+	if cfg.DryRun {
+		fsys = newFileSystem(true)
+	}
+	caps, err := probeCapabilities(cfg.OutputFolder, fsys)
+	if err != nil {
+		log.Printf(locMsg("fs_probe_failed", cfg.Language), err)
+	}
+	cfg.Capabilities = caps
+	cfg = withReservations(cfg)
+	logCapabilities(caps, cfg.Language)
+	if err := checkModeSupported(cfg.Mode, caps); err != nil {
+		log.Fatalf("Error parsing config: %v", err)
+	}
+	return cfg
+}
+
+// logConfigWarnings reports accepted-but-deprecated configuration once logging is set up.
+func logConfigWarnings(cfg FilesMoveConfiguration) {
+	for _, warning := range cfg.Warnings {
+		log.Printf(locMsg("config_warning", cfg.Language), warning)
+	}
+}
+
+// saveRunRecord writes the run summary file, logging rather than failing on error.
+func saveRunRecord(command string, cfg FilesMoveConfiguration) {
+	if err := writeRunRecord(command, cfg); err != nil {
+		log.Printf(locMsg("summary_error", cfg.Language), err)
+	}
+}
+
+// backupAfterRun runs --backup-cmd after a run that placed every file. A
+// failed backup fails the run, since the pipeline did not finish.
+func backupAfterRun(cfg FilesMoveConfiguration) {
+	if err := runBackupHook(cfg); err != nil {
+		log.Printf(locMsg("backup_failed", cfg.Language), err)
+		os.Exit(exitFileErrors)
+	}
+}
+
+// saveHeatmap writes the calendar heatmap report when --heatmap was given.
+func saveHeatmap(fsys FileSystem, path string, days map[string]int, lang string) {
+	if path == "" {
+		return
+	}
+	if err := writeHeatmap(fsys, path, days, lang); err != nil {
+		log.Printf(locMsg("heatmap_error", lang), err)
+		return
+	}
+	log.Printf(locMsg("heatmap_written", lang), path)
+}
+
+// withFailureHistory loads the per-file failure counts when --quarantine-after
+// is set. An unreadable history is logged and replaced by an empty one.
+func withFailureHistory(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.QuarantineAfter == 0 {
+		return cfg
+	}
+	history, err := loadFailureHistory(cfg.OutputFolder)
+	if err != nil {
+		log.Printf(locMsg("failure_history_error", cfg.Language), err)
+	}
+	cfg.Failures = history
+	return cfg
+}
+
+// saveFailureHistory persists the failure counts of a real run.
+func saveFailureHistory(cfg FilesMoveConfiguration) {
+	if cfg.DryRun {
+		return
+	}
+	if err := cfg.Failures.save(cfg.FS); err != nil {
+		log.Printf(locMsg("failure_history_error", cfg.Language), err)
+	}
+}
+
+// withTreeSnapshot loads the record of files the last run left settled. A
+// run over a --files-from list only sees part of the tree and keeps none.
+func withTreeSnapshot(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.FilesFrom != nil {
+		return cfg
+	}
+	tree, err := loadTreeSnapshot(cfg)
+	if err != nil {
+		log.Printf(locMsg("tree_snapshot_error", cfg.Language), err)
+	}
+	cfg.Tree = tree
+	return cfg
+}
+
+// withFileIndex opens the --index database, read-only for dry runs. A run
+// that cannot open it stops, since placing files without recording them
+// would leave the index behind the tree.
+func withFileIndex(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.IndexPath == "" {
+		return cfg
+	}
+	index, err := openFileIndex(cfg.IndexPath, cfg.DryRun || cfg.FS.ReadOnly())
+	if err != nil {
+		log.Fatalf(locMsg("index_error", cfg.Language), err)
+	}
+	if added, err := index.reconcile(cfg.OutputFolder); err != nil {
+		log.Printf(locMsg("index_error", cfg.Language), err)
+	} else if added > 0 {
+		log.Printf(locMsg("index_reconciled", cfg.Language), added, indexReplicaPath(cfg.OutputFolder))
+	}
+	cfg.Index = index
+	return cfg
+}
+
+// withExifCache opens the EXIF date cache, read-only when the run may not
+// write, as with --no-write or for plan. The cache only saves work, so one
+// that cannot be opened is logged and the run parses every image instead.
+func withExifCache(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.ExifCachePath == "" {
+		return cfg
+	}
+	cache, err := openExifCache(cfg.ExifCachePath, cfg.FS.ReadOnly())
+	if err != nil {
+		log.Printf(locMsg("exif_cache_error", cfg.Language), err)
+		return cfg
+	}
+	cfg.ExifCache = cache
+	return cfg
+}
+
+// closeExifCache reports how many image parses the cache saved, and
+// writes out the dates still pending.
+func closeExifCache(cfg FilesMoveConfiguration) {
+	if cfg.ExifCache == nil {
+		return
+	}
+	if cfg.ExifCache.hits > 0 {
+		log.Printf(locMsg("exif_cache_hits", cfg.Language), cfg.ExifCache.hits)
+	}
+	if err := cfg.ExifCache.close(); err != nil {
+		log.Printf(locMsg("exif_cache_error", cfg.Language), err)
+	}
+}
+
+// closeFileIndex reports how many files the index let the run skip, writes
+// out the placements still pending and replicates them into the output.
+func closeFileIndex(cfg FilesMoveConfiguration) {
+	if cfg.Index == nil {
+		return
+	}
+	if cfg.Index.skippedProcessed > 0 || cfg.Index.skippedUnchanged > 0 || cfg.Index.skippedDuplicates > 0 {
+		log.Printf(locMsg("index_skipped", cfg.Language), cfg.Index.skippedProcessed, cfg.Index.skippedUnchanged, cfg.Index.skippedDuplicates)
+	}
+	if err := cfg.Index.replicate(cfg.OutputFolder); err != nil {
+		log.Printf(locMsg("index_error", cfg.Language), err)
+	}
+	if err := cfg.Index.close(); err != nil {
+		log.Printf(locMsg("index_error", cfg.Language), err)
+	}
+}
+
+// saveTreeSnapshot reports how many files were skipped as unchanged and
+// persists the snapshot of a real run.
+func saveTreeSnapshot(cfg FilesMoveConfiguration) {
+	if cfg.Tree == nil {
+		return
+	}
+	if cfg.Tree.skipped > 0 {
+		log.Printf(locMsg("tree_unchanged", cfg.Language), cfg.Tree.skipped)
+	}
+	if cfg.DryRun {
+		return
+	}
+	if err := cfg.Tree.save(cfg.FS); err != nil {
+		log.Printf(locMsg("tree_snapshot_error", cfg.Language), err)
+	}
+}
+
+// saveJournal persists the run journal, logging rather than failing the run on error.
+func saveJournal(cfg FilesMoveConfiguration) {
+	if cfg.DryRun {
+		return
+	}
+	if err := cfg.Journal.save(cfg.FS); err != nil {
+		log.Printf(locMsg("journal_error", cfg.Language), err)
+		return
+	}
+	if len(cfg.Journal.Entries) > 0 {
+		log.Printf(locMsg("journal_written", cfg.Language), cfg.Journal.path)
+	}
+}
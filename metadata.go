@@ -1,36 +1,672 @@
 package main
 
 import (
-	"io/ioutil"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/dsoprea/go-exif"
 	log "github.com/dsoprea/go-logging"
 )
 
+const (
+	// exifPrefixLimit is how much of an image other than a JPEG is searched
+	// for EXIF data, which such formats keep near the start.
+	exifPrefixLimit = 4 << 20
+	// exifWholeFileLimit is the largest image read whole when its EXIF data
+	// is not in the first exifPrefixLimit bytes, as in WebP files that keep
+	// it at the end.
+	exifWholeFileLimit = 64 << 20
+	// exifItemLimit is the largest EXIF item of a HEIF image, and the
+	// largest item table, read into memory.
+	exifItemLimit = 1 << 20
+	// maxIFDEntries is the most entries an IFD of a TIFF-based image may
+	// have; more means the file is corrupt.
+	maxIFDEntries = 4096
+)
+
+// The TIFF tags read to find an image's date and camera.
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSTimeStamp       = 0x0007
+	tagGPSDateStamp       = 0x001D
+)
+
+// exifTags holds the EXIF tags structo reads from an image, as written: the
+// date it was taken, the offset from UTC of the clock that took it, and the
+// make and model of the camera. A tag the image lacks is empty.
+type exifTags struct {
+	dateTaken string
+	// offset is OffsetTimeOriginal, such as "+09:00", or else worked out
+	// from the GPS time, see gpsOffset.
+	offset      string
+	make, model string
+}
+
+// canonUUID is the type of the box of a CR3 raw file holding its metadata.
+var canonUUID = []byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+
+// errCorruptBox reports a box of a HEIF or CR3 image that does not fit.
+var errCorruptBox = errors.New("corrupt image: invalid box")
+
 func GetDateTaken(path string) (*time.Time, error) {
-	f, err := os.Open(path)
+	tags, err := readExifTags(path)
 	if err != nil {
 		return nil, err
 	}
+	return parseDateTaken(tags.dateTaken, tags.offset)
+}
+
+// readExifTags returns the DateTimeOriginal, its offset, Make and Model of the image at
+// path without reading more of it than needed: the APP1 segment of a JPEG, the
+// IFDs of a TIFF-based image such as most raw files, the EXIF item of a
+// HEIF image or the metadata box of a CR3 raw file, or the first
+// exifPrefixLimit bytes of other images. The format is told by the file's
+// content, not its extension. Errors reading the file are *fs.PathError;
+// any other error is about its EXIF data.
+func readExifTags(path string) (exifTags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return exifTags{}, err
+	}
 	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(12)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xFF, 0xD8}):
+		data, err := jpegExifSegment(r)
+		if err != nil {
+			return exifTags{}, wrapReadError(err)
+		}
+		return exifTagsOf(data)
+	case isTIFFHeader(magic):
+		tags, err := tiffExifTags(f)
+		return tags, wrapReadError(err)
+	case len(magic) == 12 && string(magic[4:8]) == "ftyp":
+		info, err := f.Stat()
+		if err != nil {
+			return exifTags{}, err
+		}
+		tags, err := bmffExifTags(f, info.Size())
+		return tags, wrapReadError(err)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, exifPrefixLimit))
 	if err != nil {
+		return exifTags{}, wrapReadError(err)
+	}
+	tags, err := exifTagsOf(data)
+	if err == nil || len(data) < exifPrefixLimit {
+		return tags, err
+	}
+	info, statErr := f.Stat()
+	if statErr != nil || info.Size() > exifWholeFileLimit {
+		return exifTags{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return exifTags{}, wrapReadError(err)
+	}
+	if data, err = io.ReadAll(f); err != nil {
+		return exifTags{}, wrapReadError(err)
+	}
+	return exifTagsOf(data)
+}
+
+// jpegExifSegment walks the segments of a JPEG up to its image data and
+// returns the APP1 segment holding EXIF data, or nothing when there is none,
+// so the image itself is never read.
+func jpegExifSegment(r *bufio.Reader) ([]byte, error) {
+	if _, err := r.Discard(2); err != nil {
 		return nil, err
 	}
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker != 0xFF {
+			return nil, errors.New("corrupt JPEG: expected a segment marker")
+		}
+		kind, err := r.ReadByte()
+		for err == nil && kind == 0xFF {
+			kind, err = r.ReadByte() // fill bytes
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case kind == 0xDA || kind == 0xD9:
+			// Start of scan or end of image: no EXIF data came first.
+			return nil, nil
+		case kind == 0x01 || (kind >= 0xD0 && kind <= 0xD7):
+			continue // markers without a length
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length < 2 {
+			return nil, errors.New("corrupt JPEG: invalid segment length")
+		}
+		size := int(length) - 2
+		if kind != 0xE1 {
+			if _, err := r.Discard(size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment, nil
+		}
+	}
+}
 
-	rawExif, err := exif.SearchAndExtractExif(data)
+// isTIFFHeader reports whether data starts like a TIFF file: its byte order
+// and 42, or the numbers Olympus ORF and Panasonic RW2 raw files have
+// instead.
+func isTIFFHeader(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false
+	}
+	switch order.Uint16(data[2:]) {
+	case 42, 0x4F52, 0x5352, 0x55:
+		return true
+	}
+	return false
+}
+
+// tiffEntry is an entry of an IFD: its type, count, and the value itself
+// when it fits in four bytes, or else where it is.
+type tiffEntry struct {
+	kind  uint16
+	count uint32
+	value [4]byte
+}
+
+// tiffExifTags returns the tags of the TIFF data in r, such as a CR2, NEF,
+// ARW or DNG raw file. Make and Model are tags of IFD0, and DateTimeOriginal
+// and OffsetTimeOriginal ones of the EXIF IFD, or of IFD0 where some
+// converters put them, and where the EXIF IFD of a CR3 file has them. Only
+// the IFDs are read, never the image data, wherever in the file they are. A
+// camera or offset tag that cannot be read is left empty, so it never costs
+// the date.
+func tiffExifTags(r io.ReaderAt) (exifTags, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return exifTags{}, err
+	}
+	if !isTIFFHeader(header) {
+		return exifTags{}, errors.New("corrupt TIFF: invalid header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	entries, err := readIFD(r, order, order.Uint32(header[4:]))
+	if err != nil {
+		return exifTags{}, err
+	}
+	var tags exifTags
+	if entry, ok := entries[tagMake]; ok {
+		tags.make, _ = tiffText(r, order, entry)
+	}
+	if entry, ok := entries[tagModel]; ok {
+		tags.model, _ = tiffText(r, order, entry)
+	}
+	if entry, ok := entries[tagOffsetTimeOriginal]; ok {
+		tags.offset, _ = tiffText(r, order, entry)
+	}
+	date, dated := entries[tagDateTimeOriginal]
+	if entry, ok := entries[tagExifIFD]; ok && (!dated || tags.offset == "") {
+		// A date of IFD0 does not need the EXIF IFD to be readable.
+		exifEntries, err := readIFD(r, order, order.Uint32(entry.value[:]))
+		if err != nil && !dated {
+			return tags, err
+		}
+		if !dated {
+			date, dated = exifEntries[tagDateTimeOriginal]
+		}
+		if entry, ok := exifEntries[tagOffsetTimeOriginal]; ok && tags.offset == "" {
+			tags.offset, _ = tiffText(r, order, entry)
+		}
+	}
+	if !dated {
+		return tags, nil
+	}
+	if tags.dateTaken, err = tiffText(r, order, date); err != nil {
+		return tags, err
+	}
+	if tags.offset == "" {
+		tags.offset = gpsOffset(tags.dateTaken, tiffGPSTime(r, order, entries))
+	}
+	return tags, nil
+}
+
+// tiffGPSTime returns the GPSDateStamp and GPSTimeStamp of the GPS IFD that
+// ifd0 points to, as "2006:01:02 15:04:05" in UTC, or "" when they are
+// missing or cannot be read.
+func tiffGPSTime(r io.ReaderAt, order binary.ByteOrder, ifd0 map[uint16]tiffEntry) string {
+	const rational = 5
+	entry, ok := ifd0[tagGPSIFD]
+	if !ok {
+		return ""
+	}
+	entries, err := readIFD(r, order, order.Uint32(entry.value[:]))
+	if err != nil {
+		return ""
+	}
+	dateEntry, okDate := entries[tagGPSDateStamp]
+	timeEntry, okTime := entries[tagGPSTimeStamp]
+	if !okDate || !okTime || timeEntry.kind != rational || timeEntry.count != 3 {
+		return ""
+	}
+	date, err := tiffText(r, order, dateEntry)
 	if err != nil {
+		return ""
+	}
+	data := make([]byte, 24)
+	if _, err := r.ReadAt(data, int64(order.Uint32(timeEntry.value[:]))); err != nil {
+		return ""
+	}
+	var clock [3]float64
+	for i := range clock {
+		num, den := order.Uint32(data[i*8:]), order.Uint32(data[i*8+4:])
+		if den == 0 {
+			return ""
+		}
+		clock[i] = float64(num) / float64(den)
+	}
+	return formatGPSTime(date, clock)
+}
+
+// formatGPSTime joins a GPSDateStamp and the hours, minutes and seconds of
+// a GPSTimeStamp.
+func formatGPSTime(date string, clock [3]float64) string {
+	return fmt.Sprintf("%s %02d:%02d:%02d", date, int(clock[0]), int(clock[1]), int(clock[2]))
+}
+
+// gpsOffset works out the offset from UTC of the clock that wrote
+// dateTaken from the GPS time of the same photo, gpsTime, which is UTC, to
+// the quarter hour, as "+09:00". It is empty without a GPS time, or when
+// the two are too far apart to be a time zone.
+func gpsOffset(dateTaken, gpsTime string) string {
+	const layout = "2006:01:02 15:04:05"
+	taken, err := time.Parse(layout, dateTaken)
+	if err != nil {
+		return ""
+	}
+	utc, err := time.Parse(layout, gpsTime)
+	if err != nil {
+		return ""
+	}
+	offset := taken.Sub(utc).Round(15 * time.Minute)
+	if offset < -12*time.Hour || offset > 14*time.Hour {
+		return ""
+	}
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, int(offset.Hours()), int(offset.Minutes())%60)
+}
+
+// readIFD reads the entries of the IFD at offset.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset uint32) (map[uint16]tiffEntry, error) {
+	head := make([]byte, 2)
+	if _, err := r.ReadAt(head, int64(offset)); err != nil {
 		return nil, err
 	}
+	count := int(order.Uint16(head))
+	if count > maxIFDEntries {
+		return nil, errors.New("corrupt TIFF: too many IFD entries")
+	}
+	data := make([]byte, count*12)
+	if _, err := r.ReadAt(data, int64(offset)+2); err != nil {
+		return nil, err
+	}
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		field := data[i*12 : (i+1)*12]
+		entry := tiffEntry{kind: order.Uint16(field[2:]), count: order.Uint32(field[4:])}
+		copy(entry.value[:], field[8:])
+		entries[order.Uint16(field)] = entry
+	}
+	return entries, nil
+}
+
+// tiffText returns the ASCII value of an IFD entry, without its trailing
+// NULs and spaces.
+func tiffText(r io.ReaderAt, order binary.ByteOrder, entry tiffEntry) (string, error) {
+	const ascii = 2
+	if entry.kind != ascii || entry.count > 64 {
+		return "", errors.New("corrupt TIFF: a text tag is not text")
+	}
+	text := entry.value[:min(entry.count, 4)]
+	if entry.count > 4 {
+		text = make([]byte, entry.count)
+		if _, err := r.ReadAt(text, int64(order.Uint32(entry.value[:]))); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(text), "\x00 "), nil
+}
+
+// bmffBox is a box of an ISO base media file, such as a HEIF image or a
+// CR3 raw file: its type and where its content is.
+type bmffBox struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+// findBox returns the first box of type kind among those from offset to
+// end, reading only their headers. Boxes after it are not looked at, so a
+// file cut short after its metadata still gives it.
+func findBox(r io.ReaderAt, offset, end int64, kind string) (bmffBox, bool, error) {
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return bmffBox{}, false, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - offset // the box runs to the end
+		case 1:
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return bmffBox{}, false, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || size > end-offset {
+			return bmffBox{}, false, errCorruptBox
+		}
+		if string(header[4:8]) == kind {
+			return bmffBox{kind: kind, offset: offset + headerSize, size: size - headerSize}, true, nil
+		}
+		offset += size
+	}
+	return bmffBox{}, false, nil
+}
+
+// readBox returns the content of a box, which must not be larger than
+// exifItemLimit.
+func readBox(r io.ReaderAt, box bmffBox) ([]byte, error) {
+	if box.size > exifItemLimit {
+		return nil, fmt.Errorf("corrupt image: %s box too large", box.kind)
+	}
+	data := make([]byte, box.size)
+	_, err := r.ReadAt(data, box.offset)
+	return data, err
+}
+
+// bmffExifTags returns the tags of an ISO base media file of size bytes:
+// those of the EXIF item of a HEIF image, such as HEIC and AVIF, or of the
+// metadata boxes of a CR3 raw file. They are empty when the file has
+// neither.
+func bmffExifTags(r io.ReaderAt, size int64) (exifTags, error) {
+	meta, ok, err := findBox(r, 0, size, "meta")
+	if err != nil {
+		return exifTags{}, err
+	}
+	if ok {
+		return heifExifTags(r, meta)
+	}
+	moov, ok, err := findBox(r, 0, size, "moov")
+	if err != nil || !ok {
+		return exifTags{}, err
+	}
+	return cr3ExifTags(r, moov)
+}
+
+// heifExifTags finds the EXIF item in the meta box of a HEIF image, by its
+// type in the item information box and its place in the item location box,
+// and reads the tags from the TIFF data it holds.
+func heifExifTags(r io.ReaderAt, meta bmffBox) (exifTags, error) {
+	// meta is a full box: its children follow a version and flags.
+	start, end := meta.offset+4, meta.offset+meta.size
+	iinf, ok, err := findBox(r, start, end, "iinf")
+	if err != nil || !ok {
+		return exifTags{}, err
+	}
+	iloc, ok, err := findBox(r, start, end, "iloc")
+	if err != nil || !ok {
+		return exifTags{}, err
+	}
+	idat, _, err := findBox(r, start, end, "idat")
+	if err != nil {
+		return exifTags{}, err
+	}
+
+	infos, err := readBox(r, iinf)
+	if err != nil {
+		return exifTags{}, err
+	}
+	id, ok, err := heifExifItem(infos)
+	if err != nil || !ok {
+		return exifTags{}, err
+	}
+	locations, err := readBox(r, iloc)
+	if err != nil {
+		return exifTags{}, err
+	}
+	offset, length, err := heifItemLocation(locations, id, idat)
+	if err != nil {
+		return exifTags{}, err
+	}
+	if length < 4 || length > exifItemLimit {
+		return exifTags{}, errors.New("corrupt image: invalid EXIF item")
+	}
+	item := make([]byte, length)
+	if _, err := r.ReadAt(item, offset); err != nil {
+		return exifTags{}, err
+	}
+	// The item starts with the offset of the TIFF header after it, which
+	// skips the "Exif\x00\x00" of a JPEG APP1 segment.
+	tiffStart := 4 + int64(binary.BigEndian.Uint32(item))
+	if tiffStart >= length {
+		return exifTags{}, errors.New("corrupt image: invalid EXIF item")
+	}
+	return tiffExifTags(bytes.NewReader(item[tiffStart:]))
+}
+
+// boxFields reads the big-endian fields of a box's content in turn. After
+// a field runs past the content, err is set and every field reads as 0.
+type boxFields struct {
+	data []byte
+	err  error
+}
+
+func (f *boxFields) uint(size int) uint64 {
+	if f.err != nil || len(f.data) < size {
+		f.err = errCorruptBox
+		return 0
+	}
+	var value uint64
+	for _, b := range f.data[:size] {
+		value = value<<8 | uint64(b)
+	}
+	f.data = f.data[size:]
+	return value
+}
+
+// heifExifItem returns the ID of the item of type "Exif" in the content of
+// an item information box.
+func heifExifItem(infos []byte) (uint32, bool, error) {
+	fields := &boxFields{data: infos}
+	version := fields.uint(4) >> 24
+	if version == 0 {
+		fields.uint(2) // entry count
+	} else {
+		fields.uint(4)
+	}
+	if fields.err != nil {
+		return 0, false, fields.err
+	}
+	r := bytes.NewReader(fields.data)
+	offset, end := int64(0), int64(len(fields.data))
+	for offset < end {
+		infe, ok, err := findBox(r, offset, end, "infe")
+		if err != nil || !ok {
+			return 0, false, err
+		}
+		offset = infe.offset + infe.size
+		entry := &boxFields{data: fields.data[infe.offset:offset]}
+		version := entry.uint(4) >> 24
+		if version < 2 {
+			continue // no item type
+		}
+		id := uint32(entry.uint(2))
+		if version > 2 {
+			id = id<<16 | uint32(entry.uint(2))
+		}
+		entry.uint(2) // protection index
+		kind := entry.uint(4)
+		if entry.err != nil {
+			return 0, false, entry.err
+		}
+		if kind == 'E'<<24|'x'<<16|'i'<<8|'f' {
+			return id, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// heifItemLocation returns where in the file the item id is, from the
+// content of an item location box. Items stored in the idat box are found
+// there; items split in several extents are not supported.
+func heifItemLocation(locations []byte, id uint32, idat bmffBox) (int64, int64, error) {
+	fields := &boxFields{data: locations}
+	version := fields.uint(4) >> 24
+	sizes := fields.uint(2)
+	offsetSize, lengthSize, baseOffsetSize := int(sizes>>12), int(sizes>>8&0xF), int(sizes>>4&0xF)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xF)
+	}
+	count := fields.uint(2)
+	if version == 2 {
+		count = count<<16 | fields.uint(2)
+	}
+	for i := uint64(0); i < count && fields.err == nil; i++ {
+		itemID := uint32(fields.uint(2))
+		if version == 2 {
+			itemID = itemID<<16 | uint32(fields.uint(2))
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = fields.uint(2) & 0xF
+		}
+		fields.uint(2) // data reference index
+		base := int64(fields.uint(baseOffsetSize))
+		extents := fields.uint(2)
+		var offset, length int64
+		for e := uint64(0); e < extents; e++ {
+			fields.uint(indexSize)
+			offset, length = base+int64(fields.uint(offsetSize)), int64(fields.uint(lengthSize))
+		}
+		if fields.err != nil || itemID != id {
+			continue
+		}
+		switch {
+		case extents != 1:
+			return 0, 0, errors.New("unsupported image: EXIF item in several parts")
+		case method == 1 && idat.kind != "":
+			return idat.offset + offset, length, nil
+		case method == 0:
+			return offset, length, nil
+		}
+		return 0, 0, errors.New("unsupported image: EXIF item stored by reference")
+	}
+	if fields.err != nil {
+		return 0, 0, fields.err
+	}
+	return 0, 0, errors.New("corrupt image: EXIF item has no location")
+}
+
+// cr3ExifTags reads the tags of a CR3 raw file from Canon's box of its movie
+// box: the camera from the CMT1 box, IFD0 as TIFF data, and the date from
+// the CMT2 box, the EXIF IFD as TIFF data.
+func cr3ExifTags(r io.ReaderAt, moov bmffBox) (exifTags, error) {
+	end := moov.offset + moov.size
+	for offset := moov.offset; offset < end; {
+		uuid, ok, err := findBox(r, offset, end, "uuid")
+		if err != nil || !ok {
+			return exifTags{}, err
+		}
+		offset = uuid.offset + uuid.size
+		kind := make([]byte, len(canonUUID))
+		if _, err := r.ReadAt(kind, uuid.offset); err != nil {
+			return exifTags{}, err
+		}
+		if !bytes.Equal(kind, canonUUID) {
+			continue
+		}
+		start := uuid.offset + int64(len(canonUUID))
+		var camera exifTags
+		if cmt1, ok, err := findBox(r, start, offset, "CMT1"); err == nil && ok {
+			camera, _ = tiffExifTags(io.NewSectionReader(r, cmt1.offset, cmt1.size))
+		}
+		cmt2, ok, err := findBox(r, start, offset, "CMT2")
+		if err != nil || !ok {
+			return camera, err
+		}
+		tags, err := tiffExifTags(io.NewSectionReader(r, cmt2.offset, cmt2.size))
+		tags.make, tags.model = camera.make, camera.model
+		return tags, err
+	}
+	return exifTags{}, nil
+}
+
+// wrapReadError tells a truncated image, which is a fact about its
+// content, from a failed read, which os already reports as *fs.PathError.
+func wrapReadError(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return errors.New("corrupt image: file ends early")
+	}
+	return err
+}
+
+// exifTagsOf returns the tags of the EXIF data in an image's content.
+func exifTagsOf(data []byte) (exifTags, error) {
+	rawExif, err := exif.SearchAndExtractExif(data)
+	if err != nil {
+		return exifTags{}, err
+	}
 
 	// Run the parse.
 	im := exif.NewIfdMappingWithStandard()
 	ti := exif.NewTagIndex()
 
-	var dateTaken string
+	var tags exifTags
+	var gpsDate string
+	var gpsClock []exif.Rational
+	exifPath := exif.IfdStandard + "/" + exif.IfdExif
+	gpsPath := exif.IfdStandard + "/" + exif.IfdGps
 
 	visitor := func(fqIfdPath string, ifdIndex int, tagId uint16, tagType exif.TagType, valueContext exif.ValueContext) (err error) {
 		defer func() {
@@ -43,6 +679,15 @@ func GetDateTaken(path string) (*time.Time, error) {
 		ifdPath, err := im.StripPathPhraseIndices(fqIfdPath)
 		log.PanicIf(err)
 
+		// OffsetTimeOriginal is newer than the tag index, which does not
+		// know it, so its NUL is not stripped either. An offset that cannot
+		// be read is left empty.
+		if ifdPath == exifPath && tagId == tagOffsetTimeOriginal {
+			offset, _ := valueContext.ReadAsciiNoNul()
+			tags.offset = strings.TrimRight(offset, "\x00 ")
+			return nil
+		}
+
 		it, err := ti.Get(ifdPath, tagId)
 		if err != nil {
 			if log.Is(err, exif.ErrTagNotFound) {
@@ -51,12 +696,26 @@ func GetDateTaken(path string) (*time.Time, error) {
 			log.Panic(err)
 		}
 
-		// Check if the tag is DateTimeOriginal (Tag ID 0x9003)
-		if it.Name == "DateTimeOriginal" {
+		switch {
+		case it.Name == "DateTimeOriginal":
 			valueString, err := valueContext.FormatFirst()
 			log.PanicIf(err)
 
-			dateTaken = valueString
+			tags.dateTaken = valueString
+		case ifdPath == exif.IfdStandard && (it.Name == "Make" || it.Name == "Model"):
+			// A camera tag that cannot be read is left empty.
+			if valueString, err := valueContext.FormatFirst(); err == nil {
+				if it.Name == "Make" {
+					tags.make = strings.TrimRight(valueString, "\x00 ")
+				} else {
+					tags.model = strings.TrimRight(valueString, "\x00 ")
+				}
+			}
+		case ifdPath == gpsPath && it.Name == "GPSDateStamp":
+			stamp, _ := valueContext.ReadAsciiNoNul()
+			gpsDate = strings.TrimRight(stamp, "\x00 ")
+		case ifdPath == gpsPath && it.Name == "GPSTimeStamp":
+			gpsClock, _ = valueContext.ReadRationals()
 		}
 
 		return nil
@@ -64,9 +723,32 @@ func GetDateTaken(path string) (*time.Time, error) {
 
 	_, err = exif.Visit(exif.IfdStandard, im, ti, rawExif, visitor)
 	if err != nil {
-		return nil, err
+		return exifTags{}, err
 	}
+	if tags.offset == "" && len(gpsClock) == 3 {
+		var clock [3]float64
+		for i, part := range gpsClock {
+			if part.Denominator == 0 {
+				return tags, nil
+			}
+			clock[i] = float64(part.Numerator) / float64(part.Denominator)
+		}
+		tags.offset = gpsOffset(tags.dateTaken, formatGPSTime(gpsDate, clock))
+	}
+	return tags, nil
+}
+
+// parseDateTaken parses a DateTimeOriginal value. With an offset, such as
+// "+09:00", the date is that moment, in a zone of that offset; without one,
+// or with one that does not parse, it is the clock time in UTC, which
+// inTimeZone tells apart.
+func parseDateTaken(dateTaken, offset string) (*time.Time, error) {
 	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		if parsedTime, err := time.Parse(layout+"-07:00", dateTaken+offset); err == nil {
+			return &parsedTime, nil
+		}
+	}
 	parsedTime, err := time.Parse(layout, dateTaken)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// metadataDirName is the hidden folder in the output root where structo
+// keeps what it writes about the archive: logs, journals, run records,
+// failure history, tree snapshot and index replica.
+const metadataDirName = ".structo"
+
+const (
+	// The names structo's files had in the output root before the metadata
+	// folder. Archives written back then still hold them, and they are still
+	// never organized.
+	legacyLogFilePrefix      = ".organizer_"
+	legacyJournalPrefix      = ".structo-journal-"
+	legacySummaryPrefix      = ".structo-summary-"
+	legacyFailureHistoryName = ".structo-failures.json"
+	legacyTreeSnapshotName   = ".structo-tree.json.gz"
+)
+
+// legacyArtifacts pairs the legacy name prefixes with the ones the same
+// files have in the metadata folder.
+var legacyArtifacts = []struct{ legacy, current string }{
+	{legacyLogFilePrefix, logFilePrefix},
+	{legacyJournalPrefix, journalPrefix},
+	{legacySummaryPrefix, summaryPrefix},
+	{legacyFailureHistoryName, failureHistoryName},
+	{legacyTreeSnapshotName, treeSnapshotName},
+}
+
+// metadataDir returns the metadata folder of an output folder.
+func metadataDir(outputFolder string) string {
+	return filepath.Join(outputFolder, metadataDirName)
+}
+
+// isMetadataPath reports whether path is a metadata folder: that of the
+// output, or that of another organized tree below the input, such as an old
+// archive being merged into a new one. Names are compared without case,
+// which is how Windows and macOS compare them.
+func isMetadataPath(path string) bool {
+	return strings.EqualFold(filepath.Base(path), metadataDirName)
+}
+
+// inMetadataDir reports whether path lies below a metadata folder.
+func inMetadataDir(path string) bool {
+	for dir := filepath.Dir(filepath.Clean(path)); ; dir = filepath.Dir(dir) {
+		if isMetadataPath(dir) {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// ensureMetadataDir creates the metadata folder of an existing output
+// folder and moves the files earlier versions wrote to the output root into
+// it, under their current names. A file whose current name is taken stays
+// where it is. It returns how many files were moved.
+func ensureMetadataDir(fsys FileSystem, outputFolder string) (int, error) {
+	dir := metadataDir(outputFolder)
+	if _, err := os.Stat(outputFolder); err != nil {
+		return 0, newOpError("create metadata folder", dir, err)
+	}
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		return 0, newOpError("create metadata folder", dir, err)
+	}
+	entries, err := os.ReadDir(outputFolder)
+	if err != nil {
+		return 0, newOpError("read output folder", outputFolder, err)
+	}
+	moved := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		current, ok := currentArtifactName(entry.Name())
+		if !ok {
+			continue
+		}
+		dst := filepath.Join(dir, current)
+		if fileExists(dst) {
+			continue
+		}
+		if err := fsys.Rename(filepath.Join(outputFolder, entry.Name()), dst); err != nil {
+			return moved, newOpError("move to metadata folder", filepath.Join(outputFolder, entry.Name()), err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// currentArtifactName returns the name a file with a legacy name has in the
+// metadata folder.
+func currentArtifactName(name string) (string, bool) {
+	for _, artifact := range legacyArtifacts {
+		if rest, ok := strings.CutPrefix(name, artifact.legacy); ok {
+			if artifact.legacy == legacyLogFilePrefix && !strings.HasSuffix(name, ".log") {
+				return "", false
+			}
+			return artifact.current + rest, true
+		}
+	}
+	return "", false
+}
+
+// artifactFile returns the path of the file name in the metadata folder of
+// outputFolder. While its legacy counterpart in the output root was not
+// moved there yet, as before a run that may write, that one is returned.
+func artifactFile(outputFolder, name string) string {
+	path := filepath.Join(metadataDir(outputFolder), name)
+	for _, artifact := range legacyArtifacts {
+		if artifact.current != name {
+			continue
+		}
+		if legacy := filepath.Join(outputFolder, artifact.legacy); !fileExists(path) && fileExists(legacy) {
+			return legacy
+		}
+	}
+	return path
+}
+
+// artifactFiles returns the files of outputFolder named prefix, a timestamp
+// and ext, oldest first: those in the metadata folder and those still in the
+// output root under the legacy prefix.
+func artifactFiles(outputFolder, prefix, ext string) ([]string, error) {
+	type stamped struct{ path, stamp string }
+	var files []stamped
+	collect := func(dir, prefix string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+			if entry.IsDir() || !ok || filepath.Ext(stamp) != ext {
+				continue
+			}
+			files = append(files, stamped{filepath.Join(dir, entry.Name()), stamp})
+		}
+		return nil
+	}
+	if err := collect(metadataDir(outputFolder), prefix); err != nil && !os.IsNotExist(err) {
+		return nil, newOpError("read metadata folder", metadataDir(outputFolder), err)
+	}
+	for _, artifact := range legacyArtifacts {
+		if artifact.current != prefix {
+			continue
+		}
+		if err := collect(outputFolder, artifact.legacy); err != nil {
+			return nil, newOpError("read output folder", outputFolder, err)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].stamp < files[j].stamp })
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.path
+	}
+	return paths, nil
+}
+
+// writeArtifact writes data, the named kind of file, to path atomically
+// through a temporary file beside it, creating the metadata folder when no
+// run did yet.
+func writeArtifact(fsys FileSystem, path string, data []byte, what string) error {
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return newOpError("create metadata folder", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := fsys.WriteFile(tmp, data, 0644); err != nil {
+		return newOpError("write "+what, tmp, err)
+	}
+	return newOpError("commit "+what, path, fsys.Rename(tmp, path))
+}
+
+// migratedArtifactPath returns where the file at path, named as before the
+// metadata folder, is now, once a run moved it there. Other paths are
+// returned as they are.
+func migratedArtifactPath(path string) string {
+	current, ok := currentArtifactName(filepath.Base(path))
+	if !ok || fileExists(path) {
+		return path
+	}
+	if moved := filepath.Join(metadataDir(filepath.Dir(path)), current); fileExists(moved) {
+		return moved
+	}
+	return path
+}
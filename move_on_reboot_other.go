@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// scheduleMoveOnReboot is only supported on Windows.
+func scheduleMoveOnReboot(src, dst string, fsys FileSystem) error {
+	return errors.ErrUnsupported
+}
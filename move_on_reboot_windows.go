@@ -0,0 +1,21 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// scheduleMoveOnReboot has Windows move src to dst the next time it starts,
+// before any program can open src. It needs administrator rights, and both
+// paths on the same volume.
+func scheduleMoveOnReboot(src, dst string, fsys FileSystem) error {
+	if fsys.ReadOnly() {
+		return refuse("schedule move on reboot", src)
+	}
+	from, err := windows.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	return newOpError("schedule move on reboot", src, windows.MoveFileEx(from, to, windows.MOVEFILE_DELAY_UNTIL_REBOOT))
+}
@@ -0,0 +1,56 @@
+package main
+
+import "fmt"
+
+type OrganizeMode int
+
+const (
+	ModeMove OrganizeMode = iota
+	ModeCopy
+	ModeSymlink
+	ModeHardlink
+)
+
+const (
+	ModeNameMove     = "move"
+	ModeNameCopy     = "copy"
+	ModeNameSymlink  = "symlink"
+	ModeNameHardlink = "hardlink"
+)
+
+var modeName = map[OrganizeMode]string{
+	ModeMove:     ModeNameMove,
+	ModeCopy:     ModeNameCopy,
+	ModeSymlink:  ModeNameSymlink,
+	ModeHardlink: ModeNameHardlink,
+}
+
+var reverseModeName = map[string]OrganizeMode{
+	ModeNameMove:     ModeMove,
+	ModeNameCopy:     ModeCopy,
+	ModeNameSymlink:  ModeSymlink,
+	ModeNameHardlink: ModeHardlink,
+}
+
+// String returns the string representation of OrganizeMode.
+func (m OrganizeMode) String() string {
+	return modeName[m]
+}
+
+// ParseOrganizeMode parses a string into an OrganizeMode.
+func ParseOrganizeMode(input string) (OrganizeMode, error) {
+	if mode, ok := reverseModeName[input]; ok {
+		return mode, nil
+	}
+	return 0, fmt.Errorf("invalid OrganizeMode: %s", input)
+}
+
+// KeepsSource reports whether the original file stays where it is.
+func (m OrganizeMode) KeepsSource() bool {
+	return m != ModeMove
+}
+
+// IsLink reports whether the organized tree is built from links to the originals.
+func (m OrganizeMode) IsLink() bool {
+	return m == ModeSymlink || m == ModeHardlink
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// overrideHashPrefix marks an overrides entry that matches a file by content
+// instead of by path.
+const overrideHashPrefix = "xxh3:"
+
+// Overrides pins single files to folders, from an --overrides CSV file. A file
+// is matched by its path or by the hash of its contents; the hash still
+// matches after the file was moved or renamed. Folders are relative to the
+// output folder.
+type Overrides struct {
+	byPath map[string]string
+	byHash map[string]string
+}
+
+// loadOverrides reads an --overrides file. Each row is "source,folder", where
+// source is a path, relative to inputFolder unless absolute, or
+// "xxh3:<hash>" as printed by 'xxhsum -H2'. Rows starting with '#' are
+// ignored, as is a "source,folder" header. A path also matches the file at
+// its pinned place in outputFolder.
+func loadOverrides(path, inputFolder, outputFolder string) (*Overrides, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	overrides := &Overrides{byPath: map[string]string{}, byHash: map[string]string{}}
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		source, folder := strings.TrimSpace(record[0]), strings.Trim(strings.TrimSpace(record[1]), "/")
+		if line == 1 && strings.EqualFold(source, "source") && strings.EqualFold(folder, "folder") {
+			continue
+		}
+		if err := validateOverrideFolder(folder); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if hash, ok := strings.CutPrefix(source, overrideHashPrefix); ok {
+			hash = strings.ToLower(hash)
+			if len(hash) != 32 || strings.Trim(hash, "0123456789abcdef") != "" {
+				return nil, fmt.Errorf("%s:%d: invalid hash %q: expected 32 hex digits", path, line, hash)
+			}
+			overrides.byHash[hash] = folder
+			continue
+		}
+		if source == "" {
+			return nil, fmt.Errorf("%s:%d: missing source path", path, line)
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(inputFolder, source)
+		}
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		overrides.byPath[abs] = folder
+		// Once placed, the file stays pinned when the output is reorganized.
+		if placed, err := filepath.Abs(filepath.Join(outputFolder, folder, filepath.Base(abs))); err == nil {
+			overrides.byPath[placed] = folder
+		}
+	}
+	return overrides, nil
+}
+
+func validateOverrideFolder(folder string) error {
+	if folder == "" {
+		return errors.New("missing destination folder")
+	}
+	if err := validateTemplate(folder, true, nil); err != nil {
+		return fmt.Errorf("invalid folder: %w", err)
+	}
+	return nil
+}
+
+// folder returns the folder a file is pinned to, and false when no entry
+// matches it. Paths are checked first; files are only hashed when the
+// overrides hold hashes, and only once they passed every skip filter.
+func (o *Overrides) folder(path string, info os.FileInfo, cfg FilesMoveConfiguration) (string, bool, error) {
+	if o == nil {
+		return "", false, nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		if folder, ok := o.byPath[abs]; ok {
+			return filepath.Join(cfg.OutputFolder, sanitizeRelPath(folder, cfg.Capabilities)), true, nil
+		}
+	}
+	if len(o.byHash) == 0 {
+		return "", false, nil
+	}
+	hash, err := hashFile(path, HashXXH3, cfg.HashMmap)
+	if err != nil {
+		return "", false, newOpError("hash", path, err)
+	}
+	if folder, ok := o.byHash[hash]; ok {
+		return filepath.Join(cfg.OutputFolder, sanitizeRelPath(folder, cfg.Capabilities)), true, nil
+	}
+	return "", false, nil
+}
+
+// entries lists the overrides as "source => folder", sorted, for the config
+// snapshot.
+func (o *Overrides) entries() []string {
+	if o == nil {
+		return nil
+	}
+	var entries []string
+	for path, folder := range o.byPath {
+		entries = append(entries, path+" => "+folder)
+	}
+	for hash, folder := range o.byHash {
+		entries = append(entries, overrideHashPrefix+hash+" => "+folder)
+	}
+	sort.Strings(entries)
+	return entries
+}
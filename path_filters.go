@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// matchGlob reports whether name, a slash-separated path, matches pattern,
+// with the syntax of doublestar: "*" and "?" stay within one folder, "**"
+// matches any number of folders, including none, "[a-z]" a class of
+// characters and "{jpg,png}" either alternative. Patterns match from the
+// top of name, so "**/node_modules/**" matches at any depth and
+// "node_modules/**" at the top only.
+func matchGlob(pattern, name string) bool {
+	ok, _ := doublestar.Match(pattern, name)
+	return ok
+}
+
+// validateGlobs checks that every pattern is well-formed.
+func validateGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("%q: %w", pattern, doublestar.ErrBadPattern)
+		}
+	}
+	return nil
+}
+
+// compileRegexps compiles the --exclude-regex patterns.
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// inputRelPath returns path relative to the input folder with forward
+// slashes, the form patterns are written in.
+func inputRelPath(p string, cfg FilesMoveConfiguration) string {
+	rel, err := filepath.Rel(cfg.InputFolder, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isPathPatternFilter skips files matched by --exclude-glob or --exclude-regex,
+// and files not matched by --include-glob when it is given.
+func isPathPatternFilter(p string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if len(cfg.IncludeGlobs) == 0 && len(cfg.ExcludeGlobs) == 0 && len(cfg.ExcludeRegexps) == 0 {
+		return false, nil
+	}
+	rel := inputRelPath(p, cfg)
+	excluded := isExcludedPath(rel, cfg)
+	if !excluded && len(cfg.IncludeGlobs) > 0 {
+		excluded = true
+		for _, pattern := range cfg.IncludeGlobs {
+			if matchGlob(pattern, rel) {
+				excluded = false
+				break
+			}
+		}
+	}
+	if excluded {
+		log.Printf(locMsg("skipping_pattern", cfg.Language), p)
+	}
+	return excluded, nil
+}
+
+func isExcludedPath(rel string, cfg FilesMoveConfiguration) bool {
+	for _, pattern := range cfg.ExcludeGlobs {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	for _, re := range cfg.ExcludeRegexps {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedDir reports whether a whole directory is excluded, so the walk
+// can skip it instead of visiting every file below: the directory matches an
+// exclude glob itself, or one ending in "/**"; its name or relative path
+// matches --exclude-dir; or it lies --max-depth levels below the input.
+func isExcludedDir(dir string, cfg FilesMoveConfiguration) bool {
+	rel := inputRelPath(dir, cfg)
+	if rel == "." {
+		return false
+	}
+	if cfg.MaxDepth > 0 && strings.Count(rel, "/")+1 >= cfg.MaxDepth {
+		return true
+	}
+	for _, pattern := range cfg.ExcludeGlobs {
+		if matchGlob(pattern, rel) || matchGlob(strings.TrimSuffix(pattern, "/**"), rel) {
+			return true
+		}
+	}
+	for _, pattern := range cfg.ExcludeDirs {
+		if matchGlob(pattern, path.Base(rel)) || matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// byteUnits maps size suffixes to multipliers: SI units are powers of 1000,
+// IEC units ("MiB") powers of 1024.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses sizes such as "500", "10MB", "1.5 GiB" or "200kb".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q (use B, KB, MB, GB, TB or KiB, MiB, GiB, TiB)", s, s[i:])
+	}
+	return int64(value * multiplier), nil
+}
+
+// isSizeFilter skips files smaller than --min-size or larger than --max-size.
+func isSizeFilter(p string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.MinSize == 0 && cfg.MaxSize == 0 {
+		return false, nil
+	}
+	size := info.Size()
+	if size >= cfg.MinSize && (cfg.MaxSize == 0 || size <= cfg.MaxSize) {
+		return false, nil
+	}
+	log.Printf(locMsg("skipping_size", cfg.Language), p, formatBytes(size))
+	return true, nil
+}
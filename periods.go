@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// customPeriod maps a range of days, both ends included, to a folder name.
+// Days are kept as "YYYY-MM-DD" strings, which sort like the days they name.
+type customPeriod struct {
+	Start, End string
+	Name       string
+}
+
+func (p customPeriod) String() string {
+	return fmt.Sprintf("%s..%s => %s", p.Start, p.End, p.Name)
+}
+
+// loadPeriods reads a --periods file. Each line maps a range of days to a
+// folder, like "2022-07-01..2022-07-21 => Japan Trip"; the name may hold
+// slashes for nested folders. Blank lines and lines starting with '#' are
+// ignored.
+func loadPeriods(path string) ([]customPeriod, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var periods []customPeriod
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		period, err := parsePeriod(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		periods = append(periods, period)
+	}
+	return periods, scanner.Err()
+}
+
+func parsePeriod(text string) (customPeriod, error) {
+	dates, name, ok := strings.Cut(text, "=>")
+	start, end, ok2 := strings.Cut(dates, "..")
+	if !ok || !ok2 {
+		return customPeriod{}, fmt.Errorf("expected 'YYYY-MM-DD..YYYY-MM-DD => name', got %q", text)
+	}
+	period := customPeriod{
+		Start: strings.TrimSpace(start),
+		End:   strings.TrimSpace(end),
+		Name:  strings.Trim(strings.TrimSpace(name), "/"),
+	}
+	for _, day := range []string{period.Start, period.End} {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return customPeriod{}, fmt.Errorf("invalid date %q: %w", day, err)
+		}
+	}
+	if period.End < period.Start {
+		return customPeriod{}, fmt.Errorf("period %q ends before it starts", period.Name)
+	}
+	if period.Name == "" {
+		return customPeriod{}, fmt.Errorf("period %s..%s has no name", period.Start, period.End)
+	}
+	if err := validateTemplate(period.Name, true, nil); err != nil {
+		return customPeriod{}, fmt.Errorf("invalid period name: %w", err)
+	}
+	return period, nil
+}
+
+// customPeriodFolder returns the folder of the first period that date falls
+// in, and false when there is none.
+func customPeriodFolder(outputRoot string, date time.Time, cfg FilesMoveConfiguration) (string, bool) {
+	day := date.Format("2006-01-02")
+	for _, period := range cfg.Periods {
+		if period.Start <= day && day <= period.End {
+			return filepath.Join(outputRoot, sanitizeRelPath(period.Name, cfg.Capabilities)), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// errStopWalk aborts the producer once a worker has failed.
+var errStopWalk = errors.New("stopping walk after a failed move")
+
+// largeQueueSize buffers the large-file queue. Large files are few, and the
+// buffer lets the walk move on while one of them is copied.
+const largeQueueSize = 1024
+
+// fileTask is one walked file handed from the producer to the workers.
+type fileTask struct {
+	path string
+	info os.FileInfo
+	// companions are the files that go with this one, see walkInputGroups.
+	companions []fileTask
+}
+
+// organizeConcurrently runs the walk as a producer feeding cfg.Workers consumers
+// through a bounded channel. Each worker keeps its own error list; they are joined
+// at the end. Every log line already names the file it refers to, so interleaved
+// output from several workers stays attributable.
+//
+// With --large-file-queue, files over --large-file-threshold go to one extra
+// worker of their own, so a long copy never holds up the small files behind it.
+func organizeConcurrently(ctx context.Context, cfg FilesMoveConfiguration) error {
+	tasks := make(chan fileTask, cfg.Workers*4)
+	largeTasks := make(chan fileTask, largeQueueSize)
+	workerErrs := make([][]error, cfg.Workers+1)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+
+	work := func(worker int, queue <-chan fileTask) {
+		defer wg.Done()
+		for task := range queue {
+			if failed.Load() || ctx.Err() != nil {
+				// Drain without processing so the producer never blocks.
+				continue
+			}
+			if err := organizeTask(ctx, task, cfg); err != nil {
+				workerErrs[worker] = append(workerErrs[worker], err)
+				failed.Store(true)
+			}
+		}
+	}
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go work(w, tasks)
+	}
+	wg.Add(1)
+	go work(cfg.Workers, largeTasks)
+
+	walkErr := walkInputGroups(cfg, func(task fileTask) error {
+		if failed.Load() {
+			return errStopWalk
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if cfg.LargeFileQueue && isLargeFile(task.info, cfg) {
+			log.Printf(locMsg("large_file_deferred", cfg.Language), task.path, formatBytes(task.info.Size()))
+			largeTasks <- task
+			return nil
+		}
+		tasks <- task
+		return nil
+	})
+	close(tasks)
+	close(largeTasks)
+	wg.Wait()
+
+	var errs []error
+	if walkErr != nil && !errors.Is(walkErr, errStopWalk) {
+		errs = append(errs, walkErr)
+	}
+	for worker, list := range workerErrs {
+		if len(list) > 0 {
+			log.Printf(locMsg("worker_failed", cfg.Language), worker, len(list))
+		}
+		errs = append(errs, list...)
+	}
+	return errors.Join(errs...)
+}
+
+// Reservations holds the destinations chosen during one run but possibly not
+// yet written, so concurrent workers never pick the same free name. A name
+// placement failed to use is released again; a placed name stays claimed, as
+// does every name of a dry run, which writes nothing. On case-insensitive
+// destinations names differing only in case collide, so keys are folded.
+//
+// A name reserved to place a file that is compared with others, see
+// ensureUniquePath, is in flight until the placement lands or is given up:
+// a worker finding it taken waits for it, so that it compares with the file
+// and does not take "(1)" for a copy of the same content.
+type Reservations struct {
+	mu       sync.Mutex
+	taken    map[string]bool
+	aside    map[string]string
+	inFlight map[string]chan struct{}
+	foldCase bool
+}
+
+// withReservations starts the reservations of a run placing files in the
+// output. Without them, only the files already there are avoided.
+func withReservations(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	cfg.Reservations = &Reservations{
+		taken:    map[string]bool{},
+		aside:    map[string]string{},
+		inFlight: map[string]chan struct{}{},
+		foldCase: !cfg.Capabilities.CaseSensitive,
+	}
+	return cfg
+}
+
+func (r *Reservations) key(path string) string {
+	if r.foldCase {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// reserve claims path unless it already exists or is claimed.
+func (r *Reservations) reserve(path string) bool {
+	if r == nil {
+		return !fileExists(path)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.key(path)
+	if r.taken[key] || fileExists(path) {
+		return false
+	}
+	r.taken[key] = true
+	return true
+}
+
+// reserveInFlight reserves path like reserve, and marks it in flight until
+// settle or release is called for it.
+func (r *Reservations) reserveInFlight(path string) bool {
+	if r == nil {
+		return !fileExists(path)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.key(path)
+	if r.taken[key] || fileExists(path) {
+		return false
+	}
+	r.taken[key] = true
+	r.inFlight[key] = make(chan struct{})
+	return true
+}
+
+// wait blocks while a placement to path is in flight, and reports whether
+// there was one, so the caller looks at the name again.
+func (r *Reservations) wait(path string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	done := r.inFlight[r.key(path)]
+	r.mu.Unlock()
+	if done == nil {
+		return false
+	}
+	<-done
+	return true
+}
+
+// settle ends the flight of a placement to path that landed; the name stays
+// claimed.
+func (r *Reservations) settle(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settleLocked(r.key(path))
+}
+
+// settleLocked wakes the waiters of key. r.mu must be held.
+func (r *Reservations) settleLocked(key string) {
+	if done, ok := r.inFlight[key]; ok {
+		close(done)
+		delete(r.inFlight, key)
+	}
+}
+
+// claim claims path even if a file already exists there, unless this run
+// already claimed it.
+func (r *Reservations) claim(path string) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.key(path)
+	if r.taken[key] {
+		return false
+	}
+	r.taken[key] = true
+	return true
+}
+
+// release frees a destination claimed by a placement that failed or was
+// given up, so that retrying it can claim the same name again.
+func (r *Reservations) release(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.key(path)
+	delete(r.taken, key)
+	r.settleLocked(key)
+}
+
+// keepAside records where the file path overwrote was set aside.
+func (r *Reservations) keepAside(path, aside string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aside[r.key(path)] = aside
+}
+
+// takeAside returns where the file path overwrote was set aside, if any,
+// and forgets it.
+func (r *Reservations) takeAside(path string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.key(path)
+	aside := r.aside[key]
+	delete(r.aside, key)
+	return aside
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestConcurrentDuplicatesShareOneName places identical files of the same
+// name with several workers, which must skip the copies as a sequential
+// run does instead of giving them "(1)" names while the first is written.
+func TestConcurrentDuplicatesShareOneName(t *testing.T) {
+	const copies = 8
+	root := t.TempDir()
+	input, output := filepath.Join(root, "input"), filepath.Join(root, "output")
+	content := bytes.Repeat([]byte("structo"), 4<<20)
+	modTime := time.Date(2023, 5, 14, 12, 0, 0, 0, time.Local)
+	for i := 0; i < copies; i++ {
+		path := filepath.Join(input, fmt.Sprintf("folder%d", i), "a.bin")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mode, noDryRun := "copy", true
+	cfg, err := parseArgs(CommandLineArguments{
+		Input: input, Output: output, Lang: "en",
+		Mode: &mode, NoDryRun: &noDryRun, Workers: copies,
+		NoReflink: true, NoCopyOffload: true, Fsync: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetCreatedDirs()
+	if err := cfg.FS.MkdirAll(output, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg = withReservations(cfg)
+	cfg.Journal = newJournal(cfg)
+	if err := organizeFiles(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var placed []string
+	err = filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == metadataDirName {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".bin" {
+			placed = append(placed, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placed) != 1 || filepath.Base(placed[0]) != "a.bin" {
+		t.Fatalf("placed %q, expected a single a.bin", placed)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PlannedMove is one intended placement. Size and ModTime capture the source as it
+// was when planned so apply can refuse to act on files that changed since.
+type PlannedMove struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Mode        string    `json:"mode"`
+	Backend     string    `json:"backend"`
+	OnConflict  string    `json:"on_conflict,omitempty"`
+	DateSource  string    `json:"date_source"`
+	Date        time.Time `json:"date"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	// Hash is the XXH3 of the source, set when --index hashed it.
+	Hash string `json:"hash,omitempty"`
+	// Placed is where apply put the file, which may carry a "(1)" suffix,
+	// set once the move was done; see recordPlacedMoves. Plans are written
+	// without it, though ones an earlier version of apply rewrote hold it.
+	Placed string `json:"placed,omitempty"`
+}
+
+// Plan is the serialized output of "structo plan", consumed by "structo apply".
+type Plan struct {
+	CreatedAt time.Time     `json:"created_at"`
+	Input     string        `json:"input"`
+	Output    string        `json:"output"`
+	Moves     []PlannedMove `json:"moves"`
+}
+
+// buildPlan walks the input and records every intended move without touching disk.
+// Callers must pass a dry-run, read-only configuration.
+func buildPlan(cfg FilesMoveConfiguration) (*Plan, error) {
+	cfg = withEventClusters(withPeriodDensity(cfg))
+	plan := &Plan{
+		CreatedAt: time.Now(),
+		Input:     cfg.InputFolder,
+		Output:    cfg.OutputFolder,
+	}
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		move, skip, err := planFile(path, info, cfg)
+		if err != nil || skip {
+			return err
+		}
+		plan.Moves = append(plan.Moves, move)
+		return nil
+	})
+	return plan, err
+}
+
+// writePlan serializes a plan to path. The plan file is the command's own output,
+// so it is written through fsys, which only --no-write makes read-only, rather
+// than through the read-only planning filesystem.
+func writePlan(fsys FileSystem, plan *Plan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return newOpError("write plan", path, fsys.WriteFile(path, data, 0644))
+}
+
+// loadPlan reads a plan written by "structo plan".
+func loadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, newOpError("read plan", path, err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan %q: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// loadPlans reads plan files, expanding folders to the .json files inside
+// them in name order, and merges them into one plan applied in that order.
+// All plans must share an output folder, so the run has one journal and
+// summary; when their inputs differ, the merged plan's Input is empty.
+func loadPlans(paths []string) (*Plan, []string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, newOpError("read plan", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, nil, newOpError("read plan folder", path, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(name), ".json") && !isStructoArtifactName(name) {
+				files = append(files, filepath.Join(path, name))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no plan files found in %s", strings.Join(paths, ", "))
+	}
+
+	var merged *Plan
+	for _, file := range files {
+		plan, err := loadPlan(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if merged == nil {
+			merged = plan
+			continue
+		}
+		if filepath.Clean(plan.Output) != filepath.Clean(merged.Output) {
+			return nil, nil, fmt.Errorf("plan %q writes to %q, but %q writes to %q; apply them separately", file, plan.Output, files[0], merged.Output)
+		}
+		if filepath.Clean(plan.Input) != filepath.Clean(merged.Input) {
+			merged.Input = ""
+		}
+		merged.Moves = append(merged.Moves, plan.Moves...)
+	}
+	return merged, files, nil
+}
+
+// applyPlan executes every move of a plan. Sources that vanished or changed
+// since planning are skipped rather than moved to a stale destination.
+//
+// Moves are checked first, then the destination folders of those still to be
+// made are created in one batch, and only then are files moved.
+func applyPlan(ctx context.Context, plan *Plan, cfg FilesMoveConfiguration) error {
+	type pendingMove struct {
+		index int
+		move  PlannedMove
+		info  os.FileInfo
+		cfg   FilesMoveConfiguration
+	}
+	var pending []pendingMove
+	var dirMoves []PlannedMove
+	for i, move := range plan.Moves {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := os.Stat(move.Source)
+		if err != nil && isMoveApplied(move) {
+			// Applied by an earlier, partial apply of the same plan.
+			log.Printf(locMsg("plan_move_done", cfg.Language), move.Source, move.placedPath())
+			cfg.Summary.recordSkipped()
+			cfg.Progress.advance(move.Size)
+			continue
+		}
+		if err != nil {
+			log.Printf(locMsg("plan_source_missing", cfg.Language), move.Source)
+			cfg.Summary.recordFailure(move.Source, newOpError("stat", move.Source, err), cfg.Language)
+			cfg.Progress.advance(move.Size)
+			continue
+		}
+		if info.Size() != move.Size || !info.ModTime().Equal(move.ModTime) {
+			log.Printf(locMsg("plan_source_changed", cfg.Language), move.Source)
+			cfg.Summary.recordSkipped()
+			cfg.Progress.advance(move.Size)
+			continue
+		}
+
+		moveCfg, err := configForMove(move, cfg)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, pendingMove{i, move, info, moveCfg})
+		if moveCfg.Backend != BackendChunkStore {
+			dirMoves = append(dirMoves, move)
+		}
+	}
+
+	precreateDirs(dirMoves, cfg)
+	for _, p := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cfg.Progress.advance(p.move.Size)
+		placed, err := executeMove(ctx, p.move, p.info, p.cfg)
+		if placed != "" && !cfg.DryRun {
+			plan.Moves[p.index].Placed = placed
+		}
+		if err != nil && (!cfg.KeepGoing || ctx.Err() != nil) {
+			return err
+		}
+	}
+	if cfg.KeepGoing {
+		return cfg.Summary.filesFailed()
+	}
+	return nil
+}
+
+// isMoveApplied reports whether the path a move was placed at, or its
+// destination when no apply recorded one, holds a file of the planned size,
+// as left by an earlier apply.
+func isMoveApplied(move PlannedMove) bool {
+	info, err := os.Stat(move.placedPath())
+	return err == nil && !info.IsDir() && info.Size() == move.Size
+}
+
+// placedPath returns where the move was placed, or its destination while
+// it was not.
+func (m PlannedMove) placedPath() string {
+	if m.Placed != "" {
+		return m.Placed
+	}
+	return m.Destination
+}
+
+// planPlacementsName is the file in the metadata folder of the output that
+// records where apply placed the files of planned moves. Plan files are left
+// as they were reviewed.
+const planPlacementsName = "plan-placements.json"
+
+// planPlacement is where apply placed the file of the move from a source to
+// Destination, which may carry a "(1)" suffix.
+type planPlacement struct {
+	Destination string `json:"destination"`
+	Placed      string `json:"placed"`
+}
+
+// readPlanPlacements reads the placements recorded for output, by source.
+func readPlanPlacements(output string) (map[string]planPlacement, error) {
+	path := artifactFile(output, planPlacementsName)
+	placements := map[string]planPlacement{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return placements, nil
+	}
+	if err != nil {
+		return nil, newOpError("read plan placements", path, err)
+	}
+	if err := json.Unmarshal(data, &placements); err != nil {
+		return nil, fmt.Errorf("invalid plan placements %q: %w", path, err)
+	}
+	return placements, nil
+}
+
+// loadPlacedMoves sets Placed on the moves of plan that an earlier apply
+// recorded, so applying a plan again knows a move that got a "(1)" name is
+// done. A placement only counts for a move to the same destination.
+func loadPlacedMoves(plan *Plan) error {
+	placements, err := readPlanPlacements(plan.Output)
+	if err != nil {
+		return err
+	}
+	for i, move := range plan.Moves {
+		if p, ok := placements[move.Source]; ok && move.Placed == "" && p.Destination == move.Destination {
+			plan.Moves[i].Placed = p.Placed
+		}
+	}
+	return nil
+}
+
+// recordPlacedMoves adds where apply placed the files of applied to the
+// placements recorded in the metadata folder of its output.
+func recordPlacedMoves(applied *Plan, cfg FilesMoveConfiguration) error {
+	placements, err := readPlanPlacements(applied.Output)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, move := range applied.Moves {
+		placement := planPlacement{Destination: move.Destination, Placed: move.Placed}
+		if move.Placed != "" && placements[move.Source] != placement {
+			placements[move.Source] = placement
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(placements, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeArtifact(cfg.FS, artifactFile(applied.Output, planPlacementsName), data, "plan placements")
+}
+
+// planFilter selects plan moves for a partial apply, written as
+// "field~glob" or "field!~glob". Fields are src and dest, matched relative to
+// the plan's input and output folders, and date (YYYY-MM-DD). A glob also
+// matches everything below a matching folder, so "dest~2023/*" selects all
+// moves into 2023.
+type planFilter struct {
+	Field   string
+	Pattern string
+	Negate  bool
+}
+
+// parsePlanFilters parses the --only filters of apply.
+func parsePlanFilters(specs []string) ([]planFilter, error) {
+	var filters []planFilter
+	for _, spec := range specs {
+		field, pattern, ok := strings.Cut(spec, "~")
+		negate := strings.HasSuffix(field, "!")
+		field = strings.TrimSpace(strings.TrimSuffix(field, "!"))
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("expected field~pattern, got %q", spec)
+		}
+		switch field {
+		case "src", "dest", "date":
+		default:
+			return nil, fmt.Errorf("unknown field %q in %q: expected src, dest or date", field, spec)
+		}
+		if err := validateGlobs([]string{pattern}); err != nil {
+			return nil, err
+		}
+		filters = append(filters, planFilter{Field: field, Pattern: pattern, Negate: negate})
+	}
+	return filters, nil
+}
+
+func (f planFilter) matches(move PlannedMove, plan *Plan) bool {
+	var value string
+	switch f.Field {
+	case "src":
+		value = relSlashPath(plan.Input, move.Source)
+	case "dest":
+		value = relSlashPath(plan.Output, move.Destination)
+	case "date":
+		value = move.Date.Format("2006-01-02")
+	}
+	matched := false
+	segments := strings.Split(value, "/")
+	for i := len(segments); i > 0 && !matched; i-- {
+		matched = matchGlob(f.Pattern, strings.Join(segments[:i], "/"))
+	}
+	return matched != f.Negate
+}
+
+// relSlashPath returns path relative to base with forward slashes, or path
+// itself when it is not below base.
+func relSlashPath(base, path string) string {
+	if base != "" {
+		if rel, ok := relWithin(base, path); ok {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// selectMoves returns a copy of plan holding only the moves every filter
+// selects.
+func selectMoves(plan *Plan, filters []planFilter) *Plan {
+	selected := *plan
+	selected.Moves = nil
+	for _, move := range plan.Moves {
+		keep := true
+		for _, filter := range filters {
+			keep = keep && filter.matches(move, plan)
+		}
+		if keep {
+			selected.Moves = append(selected.Moves, move)
+		}
+	}
+	return &selected
+}
+
+// planTotals returns the number of moves and bytes in a plan, for progress reporting.
+func planTotals(plan *Plan) (int64, int64) {
+	var bytes int64
+	for _, move := range plan.Moves {
+		bytes += move.Size
+	}
+	return int64(len(plan.Moves)), bytes
+}
+
+// configForMove applies the mode and backend recorded in the plan entry.
+func configForMove(move PlannedMove, cfg FilesMoveConfiguration) (FilesMoveConfiguration, error) {
+	mode, err := ParseOrganizeMode(move.Mode)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid plan entry for %q: %w", move.Source, err)
+	}
+	backend, err := ParseOutputBackend(move.Backend)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid plan entry for %q: %w", move.Source, err)
+	}
+	cfg.Mode = mode
+	cfg.Backend = backend
+	if move.OnConflict != "" {
+		if cfg.OnConflict, err = ParseConflictPolicy(move.OnConflict); err != nil {
+			return cfg, fmt.Errorf("invalid plan entry for %q: %w", move.Source, err)
+		}
+	}
+	return cfg, nil
+}
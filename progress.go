@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressInterval = 200 * time.Millisecond
+	progressBarWidth = 30
+)
+
+// Progress renders a live progress bar on stderr while detailed logs go to the
+// log file. It is shared by pointer through FilesMoveConfiguration; a nil
+// *Progress is valid and does nothing.
+type Progress struct {
+	totalFiles int64
+	totalBytes int64
+	doneFiles  atomic.Int64
+	doneBytes  atomic.Int64
+	// copyingBytes counts bytes of large files still being copied.
+	copyingBytes atomic.Int64
+	started      time.Time
+	out          io.Writer
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// newProgress returns nil when no bar should be drawn: when disabled, when logs
+// already go to stderr (--no-write), or when stderr is not a terminal.
+func newProgress(cfg FilesMoveConfiguration, disabled bool) *Progress {
+	if disabled || cfg.FS.ReadOnly() || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return &Progress{out: os.Stderr, stop: make(chan struct{})}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// prescanInput counts the files and bytes a run will walk, for the progress totals.
+func prescanInput(cfg FilesMoveConfiguration) (int64, int64) {
+	var files, bytes int64
+	walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes
+}
+
+// start begins redrawing the bar until finish is called.
+func (p *Progress) start(totalFiles, totalBytes int64) {
+	if p == nil {
+		return
+	}
+	p.totalFiles, p.totalBytes = totalFiles, totalBytes
+	p.started = time.Now()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.render()
+			}
+		}
+	}()
+}
+
+// advance records one processed file, whether it was moved, skipped or failed.
+func (p *Progress) advance(size int64) {
+	if p == nil {
+		return
+	}
+	p.doneFiles.Add(1)
+	p.doneBytes.Add(size)
+}
+
+// copying records bytes of a file still being copied, so the bar moves during
+// a long copy; they are handed back with a negative count before the file is
+// counted by advance.
+func (p *Progress) copying(n int64) {
+	if p == nil {
+		return
+	}
+	p.copyingBytes.Add(n)
+}
+
+// finish draws the final state and ends the line.
+func (p *Progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	p.render()
+	fmt.Fprintln(p.out)
+}
+
+func (p *Progress) render() {
+	files, bytes := p.doneFiles.Load(), p.doneBytes.Load()+p.copyingBytes.Load()
+	fraction := 1.0
+	if p.totalBytes > 0 {
+		fraction = float64(bytes) / float64(p.totalBytes)
+	} else if p.totalFiles > 0 {
+		fraction = float64(files) / float64(p.totalFiles)
+	}
+	fraction = min(fraction, 1)
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(p.out, "\r[%s] %d/%d files  %s/%s  ETA %s   ", bar, files, p.totalFiles,
+		formatBytes(bytes), formatBytes(p.totalBytes), p.eta(fraction))
+}
+
+// eta extrapolates the remaining time from the share of work done so far.
+func (p *Progress) eta(fraction float64) string {
+	if fraction >= 1 {
+		return "0s"
+	}
+	if fraction <= 0 {
+		return "--"
+	}
+	elapsed := time.Since(p.started)
+	remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+	return remaining.Round(time.Second).String()
+}
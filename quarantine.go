@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// failureHistoryName is the file in the metadata folder of the output
+	// that counts per-file failures across runs.
+	failureHistoryName = "failures.json"
+	// quarantineDirName is the folder in the output root that receives
+	// files which failed too often, each with its error history.
+	quarantineDirName = ".structo-quarantine"
+	// errorHistoryExt is appended to a quarantined file's name for the
+	// file holding its error history.
+	errorHistoryExt = ".errors.json"
+)
+
+// FailureRecord is one failed attempt at a file.
+type FailureRecord struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Error string    `json:"error"`
+}
+
+// FailedFile is the failure history of one source file. Size and ModTime
+// identify the version that failed; a changed file starts over.
+type FailedFile struct {
+	Size        int64           `json:"size"`
+	ModTime     time.Time       `json:"mod_time"`
+	Failures    []FailureRecord `json:"failures"`
+	Quarantined string          `json:"quarantined,omitempty"`
+}
+
+// FailureHistory tracks files that keep failing, keyed by absolute source
+// path. A nil history records nothing, which is how quarantine is disabled.
+type FailureHistory struct {
+	mu    sync.Mutex
+	path  string
+	Files map[string]*FailedFile `json:"files"`
+}
+
+// loadFailureHistory reads the history in outputFolder, starting empty when
+// there is none yet.
+func loadFailureHistory(outputFolder string) (*FailureHistory, error) {
+	history := &FailureHistory{
+		path:  artifactFile(outputFolder, failureHistoryName),
+		Files: map[string]*FailedFile{},
+	}
+	data, err := os.ReadFile(history.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return history, newOpError("read failure history", history.path, err)
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return history, fmt.Errorf("invalid failure history %q: %w", history.path, err)
+	}
+	if history.Files == nil {
+		history.Files = map[string]*FailedFile{}
+	}
+	return history, nil
+}
+
+// save writes the history atomically.
+func (h *FailureHistory) save(fsys FileSystem) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeArtifact(fsys, h.path, data, "failure history")
+}
+
+func historyKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// current returns the entry for path if it describes this version of the
+// file. Callers must hold h.mu.
+func (h *FailureHistory) current(path string, info os.FileInfo) *FailedFile {
+	entry := h.Files[historyKey(path)]
+	if entry == nil || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return nil
+	}
+	return entry
+}
+
+// quarantined returns where this version of path was quarantined, if it was.
+func (h *FailureHistory) quarantined(path string, info os.FileInfo) string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if entry := h.current(path, info); entry != nil {
+		return entry.Quarantined
+	}
+	return ""
+}
+
+// recordFailure adds a failure of path and returns its history so far.
+func (h *FailureHistory) recordFailure(path string, info os.FileInfo, err error) []FailureRecord {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := h.current(path, info)
+	if entry == nil {
+		entry = &FailedFile{Size: info.Size(), ModTime: info.ModTime()}
+		h.Files[historyKey(path)] = entry
+	}
+	entry.Failures = append(entry.Failures, FailureRecord{Time: time.Now(), Kind: errorKindName(err), Error: err.Error()})
+	return append([]FailureRecord(nil), entry.Failures...)
+}
+
+// markQuarantined remembers where path went, so later runs leave it alone.
+func (h *FailureHistory) markQuarantined(path string, info os.FileInfo, dst string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if entry := h.current(path, info); entry != nil {
+		entry.Quarantined = dst
+	}
+}
+
+// clear forgets the failures of a file that has now been organized.
+func (h *FailureHistory) clear(path string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.Files, historyKey(path))
+}
+
+// isQuarantinePath reports whether path is the quarantine folder itself or
+// lives inside it.
+func isQuarantinePath(path string, cfg FilesMoveConfiguration) bool {
+	_, ok := relWithin(filepath.Join(cfg.OutputFolder, quarantineDirName), path)
+	return ok
+}
+
+// handleFileFailure records a failed file and, once it has failed
+// --quarantine-after times, diverts it to the quarantine folder. It returns
+// nil when the file was quarantined, so one bad file no longer fails the run.
+func handleFileFailure(ctx context.Context, path string, info os.FileInfo, fileErr error, cfg FilesMoveConfiguration) error {
+	failures := cfg.Failures.recordFailure(path, info, fileErr)
+	if cfg.QuarantineAfter == 0 || len(failures) < cfg.QuarantineAfter {
+		return fileErr
+	}
+	dst, err := quarantineFile(ctx, path, info, failures, cfg)
+	if err != nil {
+		log.Printf(locMsg("quarantine_error", cfg.Language), path, err)
+		return fileErr
+	}
+	cfg.Failures.markQuarantined(path, info, dst)
+	cfg.Summary.recordQuarantined(path)
+	log.Printf(locMsg("quarantined_file", cfg.Language), path, len(failures), dst)
+	return nil
+}
+
+// quarantineFile moves path, or copies it when the mode keeps sources, below
+// the quarantine folder at its path relative to the input, and writes its
+// error history next to it. The move is journaled so undo brings it back.
+func quarantineFile(ctx context.Context, path string, info os.FileInfo, failures []FailureRecord, cfg FilesMoveConfiguration) (string, error) {
+	rel := filepath.Base(path)
+	if r, ok := relWithin(cfg.InputFolder, path); ok {
+		rel = r
+	}
+	dst := filepath.Join(cfg.OutputFolder, quarantineDirName, rel)
+
+	qcfg := cfg
+	qcfg.Backend = BackendFilesystem
+	qcfg.Mode = ModeMove
+	if cfg.Mode.KeepsSource() {
+		qcfg.Mode = ModeCopy
+	}
+	if !cfg.DryRun {
+		if err := cfg.FS.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", newOpError("create quarantine directory", filepath.Dir(dst), err)
+		}
+	}
+	final, err := transferFile(ctx, path, dst, info, qcfg)
+	if err != nil || cfg.DryRun {
+		return final, err
+	}
+	cfg.Journal.record(path, final, info, nil, qcfg)
+
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return final, err
+	}
+	if err := cfg.FS.WriteFile(final+errorHistoryExt, data, 0644); err != nil {
+		log.Printf(locMsg("quarantine_error", cfg.Language), final+errorHistoryExt, err)
+	}
+	return final, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// OutputQuota keeps the size of the output folder within --output-quota,
+// and warns once it grows past --output-quota-warn. Every placement reserves
+// its size before the file is transferred and gives it back when the file
+// was not placed after all. Dry runs reserve too, so they tell where a real
+// run would stop. A nil quota lets the output grow.
+type OutputQuota struct {
+	mu sync.Mutex
+	// limit and warnAt are the sizes of --output-quota and
+	// --output-quota-warn, 0 when not given.
+	limit, warnAt int64
+	// used is the size of the output with the files reserved so far.
+	used   int64
+	warned bool
+}
+
+// withOutputQuota works out the size of the output when a quota is given:
+// from the files the index recorded in it, or by walking it without an index.
+func withOutputQuota(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.QuotaLimit == 0 && cfg.QuotaWarn == 0 {
+		return cfg
+	}
+	var used int64
+	var err error
+	if cfg.Index != nil {
+		used, err = cfg.Index.sizeBelow(cfg.OutputFolder)
+		log.Printf(locMsg("quota_index_usage", cfg.Language), formatBytes(used))
+	} else {
+		used, err = treeSize(cfg.OutputFolder)
+		log.Printf(locMsg("quota_tree_usage", cfg.Language), formatBytes(used))
+	}
+	if err != nil {
+		log.Fatalf(locMsg("quota_error", cfg.Language), err)
+	}
+	cfg.Quota = &OutputQuota{limit: cfg.QuotaLimit, warnAt: cfg.QuotaWarn, used: used}
+	// An output already past --output-quota-warn is warned about right away.
+	cfg.Quota.reserveSize(0, cfg)
+	return cfg
+}
+
+// treeSize adds up the sizes of the files below root. A root that does not
+// exist yet is empty.
+func treeSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// sizeBelow adds up the sizes the index recorded for the files placed below
+// root.
+func (x *FileIndex) sizeBelow(root string) (int64, error) {
+	root = historyKey(root)
+	var size int64
+	err := x.db.View(func(tx *bolt.Tx) error {
+		placed := tx.Bucket(indexPlaced)
+		if placed == nil {
+			return nil
+		}
+		return placed.ForEach(func(dst, data []byte) error {
+			var record indexRecord
+			if _, ok := relBelow(root, string(dst)); ok && json.Unmarshal(data, &record) == nil {
+				size += record.Size
+			}
+			return nil
+		})
+	})
+	return size, err
+}
+
+// grows reports whether placing move adds to the size of the output: links
+// take no space, and moving a file already inside the output keeps its size.
+func grows(move PlannedMove, cfg FilesMoveConfiguration) bool {
+	if cfg.Mode.IsLink() {
+		return false
+	}
+	return cfg.Mode.KeepsSource() || !isBelow(move.Source, cfg.OutputFolder)
+}
+
+// reserve sets aside the size of a file about to be placed as move. It fails
+// with ErrQuotaExceeded when the file would not fit.
+func (q *OutputQuota) reserve(move PlannedMove, info os.FileInfo, cfg FilesMoveConfiguration) error {
+	if q == nil || !grows(move, cfg) {
+		return nil
+	}
+	return q.reserveSize(info.Size(), cfg)
+}
+
+func (q *OutputQuota) reserveSize(size int64, cfg FilesMoveConfiguration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit > 0 && q.used+size > q.limit {
+		return fmt.Errorf("%w: %s of %s used, %s more does not fit", ErrQuotaExceeded, formatBytes(q.used), formatBytes(q.limit), formatBytes(size))
+	}
+	q.used += size
+	if q.warnAt > 0 && q.used > q.warnAt && !q.warned {
+		q.warned = true
+		log.Printf(locMsg("quota_warning", cfg.Language), formatBytes(q.used), formatBytes(q.warnAt))
+	}
+	return nil
+}
+
+// release gives back what reserve set aside for a file that was not placed.
+func (q *OutputQuota) release(move PlannedMove, info os.FileInfo, cfg FilesMoveConfiguration) {
+	if q == nil || !grows(move, cfg) {
+		return
+	}
+	q.mu.Lock()
+	q.used -= info.Size()
+	q.mu.Unlock()
+}
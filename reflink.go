@@ -0,0 +1,27 @@
+package main
+
+import "errors"
+
+// errNoReflink reports that a file cannot be cloned here; the caller copies
+// it instead.
+var errNoReflink = errors.New("reflink is not supported")
+
+// reflinkFile makes dst a copy-on-write clone of src when both are on one
+// filesystem that supports it (btrfs, XFS, APFS, ...). The clone shares the
+// blocks of src, so it is instant and takes no space until one of the two
+// files changes. It reports false, leaving nothing at dst, when the file
+// cannot be cloned.
+func reflinkFile(src, dst string, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.NoReflink || cfg.FS.ReadOnly() {
+		return false, nil
+	}
+	err := cloneFile(src, dst, cfg)
+	if errors.Is(err, errNoReflink) {
+		return false, nil
+	}
+	if err != nil {
+		cfg.FS.Remove(dst)
+		return false, newOpError("clone", dst, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile clones src to dst with clonefile(2), which APFS supports
+// within one volume. clonefile refuses to replace a file, so a partial copy
+// left by an earlier run is removed first.
+func cloneFile(src, dst string, cfg FilesMoveConfiguration) error {
+	cfg.FS.Remove(dst)
+	err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+	if errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL) {
+		return errNoReflink
+	}
+	return err
+}
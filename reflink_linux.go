@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile clones src to dst with the FICLONE ioctl.
+func cloneFile(src, dst string, cfg FilesMoveConfiguration) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errNoReflink
+	}
+	defer srcFile.Close()
+	dstFile, err := cfg.FS.Create(dst)
+	if err != nil {
+		return errNoReflink
+	}
+	defer dstFile.Close()
+
+	if err := unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd())); err != nil {
+		dstFile.Close()
+		cfg.FS.Remove(dst)
+		if isCloneUnsupported(err) {
+			return errNoReflink
+		}
+		return err
+	}
+	return dstFile.Close()
+}
+
+func isCloneUnsupported(err error) bool {
+	return errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EBADF) || errors.Is(err, unix.EPERM)
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// cloneFile is not supported on this platform; files are copied as usual.
+func cloneFile(src, dst string, cfg FilesMoveConfiguration) error {
+	return errNoReflink
+}
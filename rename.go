@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRenamePattern only applies the sanitize rules.
+const defaultRenamePattern = "{name}{ext}"
+
+// validateRenamePattern rejects unknown placeholders and helpers, and patterns
+// that would move files into other folders; rename never relocates.
+func validateRenamePattern(pattern string) error {
+	if err := validateTemplate(pattern, false, map[string]bool{"{ext}": true}); err != nil {
+		return err
+	}
+	if err := validateTemplatePlaceholders(pattern); err != nil {
+		return err
+	}
+	if !renamePatternKeepsNamesDistinct(pattern) {
+		return fmt.Errorf("%q needs {name} or {time} to keep names distinct", pattern)
+	}
+	return nil
+}
+
+// renderFileName fills in the rename pattern for one file.
+func renderFileName(pattern, path string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	date, _, err := resolveFileDate(path, info, cfg)
+	if err != nil {
+		return "", err
+	}
+	name, err := renderTemplate(pattern, templateValues(info.Name(), date), cfg.Language)
+	if err != nil {
+		return "", fmt.Errorf("rename pattern %q: %w", pattern, err)
+	}
+	name = sanitizeFileName(name, cfg.Capabilities)
+	return name, validateRenderedName(name, pattern)
+}
+
+// renamePatternKeepsNamesDistinct reports whether pattern uses {name} or
+// {time}, with any helpers, so two files of a folder rarely get the same name.
+func renamePatternKeepsNamesDistinct(pattern string) bool {
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(pattern, -1) {
+		if match[1] == "name" || match[1] == "time" {
+			return true
+		}
+	}
+	return false
+}
+
+// collectInputFiles walks the input and returns every file that passes the
+// in-place filters. Commands that rename or move within the input collect
+// first, so the walk never sees a file twice.
+func collectInputFiles(cfg FilesMoveConfiguration) ([]fileTask, error) {
+	var tasks []fileTask
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if skip, err := applyInPlaceFilters(path, info, cfg); skip || err != nil {
+			if skip {
+				cfg.Summary.recordSkipped()
+			}
+			return err
+		}
+		tasks = append(tasks, fileTask{path: path, info: info})
+		return nil
+	})
+	return tasks, err
+}
+
+// renameFiles gives every file under the input folder its canonical name in place.
+func renameFiles(ctx context.Context, pattern string, cfg FilesMoveConfiguration) error {
+	tasks, err := collectInputFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := renameFile(task.path, task.info, pattern, cfg); err != nil {
+			cfg.Summary.recordFailure(task.path, err, cfg.Language)
+			logMoveError(task.path, task.path, cfg.Language, err)
+		}
+	}
+	return nil
+}
+
+func renameFile(path string, info os.FileInfo, pattern string, cfg FilesMoveConfiguration) error {
+	name, err := renderFileName(pattern, path, info, cfg)
+	if err != nil {
+		return err
+	}
+	if name == info.Name() {
+		cfg.Summary.recordSkipped()
+		return nil
+	}
+
+	target := filepath.Join(filepath.Dir(path), name)
+	// A case-only change on a case-insensitive filesystem names the file itself.
+	caseOnly := !cfg.Capabilities.CaseSensitive && strings.EqualFold(name, info.Name())
+	if !caseOnly {
+		target, err = ensureUniquePath(path, target, cfg)
+		var dup *duplicateFileError
+		if errors.As(err, &dup) {
+			log.Printf(locMsg("rename_duplicate", cfg.Language), path, dup.Existing)
+			cfg.Summary.recordSkipped()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would rename: %s => %s", path, target)
+		cfg.Reservations.settle(target)
+		cfg.Summary.recordTransferred()
+		return nil
+	}
+	if err := cfg.FS.Rename(path, target); err != nil {
+		cfg.Reservations.release(target)
+		return newOpError("rename", path, err)
+	}
+	cfg.Reservations.settle(target)
+	cfg.Summary.recordTransferred()
+	cfg.Journal.record(path, target, info, nil, cfg)
+	log.Printf(locMsg("renamed_file", cfg.Language), path, target)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// repairTree moves the files verify reports as misplaced into the folders
+// their dates lead to under the current settings, for example after an
+// early run sorted by modification time and EXIF dates were added later.
+// Undated files stay where they are. Names stay conflict-safe through
+// executeMove, every move is journaled for undo, and folders emptied by
+// moving are removed.
+func repairTree(ctx context.Context, cfg FilesMoveConfiguration) error {
+	auditCfg := cfg
+	auditCfg.DryRun = true
+	auditCfg.FS = newFileSystem(true)
+	issues, _, err := verifyTree(auditCfg)
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch issue.Kind {
+		case IssueUndated:
+			log.Printf(locMsg("repair_undated", cfg.Language), issue.Path, issue.Err)
+			cfg.Summary.recordSkipped()
+			continue
+		case IssueMisplaced:
+		default:
+			continue
+		}
+		info, err := os.Stat(issue.Path)
+		if err != nil {
+			cfg.Summary.recordFailure(issue.Path, newOpError("stat", issue.Path, err), cfg.Language)
+			continue
+		}
+		date, source, dateErr := resolveFileDate(issue.Path, info, cfg)
+		if dateErr != nil {
+			// Only a file pinned by --overrides is misplaced without a date.
+			date, source = info.ModTime(), DateSourceMtime
+		}
+		log.Printf(locMsg("repair_misplaced", cfg.Language), issue.Path, issue.Expected)
+		move := PlannedMove{
+			Source:      issue.Path,
+			Destination: filepath.Join(issue.Expected, sanitizeFileName(info.Name(), cfg.Capabilities)),
+			Mode:        cfg.Mode.String(),
+			Backend:     cfg.Backend.String(),
+			OnConflict:  cfg.OnConflict.String(),
+			DateSource:  source.String(),
+			Date:        date,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+		}
+		if _, err := executeMove(ctx, move, info, cfg); err != nil && !cfg.KeepGoing {
+			return err
+		}
+		if !cfg.DryRun {
+			removeEmptyParents(filepath.Dir(issue.Path), cfg.OutputFolder, cfg)
+		}
+	}
+	if cfg.KeepGoing {
+		return cfg.Summary.filesFailed()
+	}
+	return nil
+}
+
+// validateRepairConfig rejects settings under which repairing would not
+// leave one file in one place inside the tree.
+func validateRepairConfig(cfg FilesMoveConfiguration) error {
+	if cfg.Mode != ModeMove {
+		return fmt.Errorf("repair only moves files, not --mode %s", cfg.Mode)
+	}
+	if cfg.Backend == BackendChunkStore {
+		return fmt.Errorf("repair does not support the %s backend", cfg.Backend)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// defaultRetryDelay is the wait before the first retry without --retry-delay.
+const defaultRetryDelay = 2 * time.Second
+
+// RetryAttempt is a failed attempt at placing a file that was tried again.
+type RetryAttempt struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// transferWithRetries places a file like transferFile, trying again up to
+// --retries times when it fails with a temporary error such as a locked file
+// or a share that did not answer. The wait starts at --retry-delay and doubles
+// with every attempt. It returns the attempts that were retried.
+func transferWithRetries(ctx context.Context, src, dst string, info os.FileInfo, cfg FilesMoveConfiguration) (string, []RetryAttempt, error) {
+	var retries []RetryAttempt
+	delay := cfg.RetryDelay
+	for attempt := 1; ; attempt++ {
+		finalPath, err := transferFile(ctx, src, dst, info, cfg)
+		if err == nil || attempt > cfg.Retries || !errors.Is(err, ErrTransient) || ctx.Err() != nil {
+			return finalPath, retries, err
+		}
+		log.Printf(locMsg("transfer_retry", cfg.Language), src, delay, attempt, cfg.Retries, err)
+		retries = append(retries, RetryAttempt{Time: time.Now(), Error: err.Error()})
+		select {
+		case <-ctx.Done():
+			return "", retries, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// selftestFile is one file of the synthetic tree: where it starts, what it
+// holds and the folder, relative to the output, it must end up in.
+type selftestFile struct {
+	Path    string
+	Content []byte
+	ModTime time.Time
+	Folder  string
+}
+
+// selftestCheck is one line of the selftest report.
+type selftestCheck struct {
+	Name   string
+	Err    error
+	Detail string
+}
+
+// selftestFiles builds the synthetic tree: plain dates across quarters, a
+// photo whose EXIF date disagrees with its modification time, a name clash,
+// identical contents under two names, and names that trip up naive tools.
+func selftestFiles() []selftestFile {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 12, 0, 0, 0, time.Local)
+	}
+	notes := []byte("structo selftest: notes\n")
+	return []selftestFile{
+		{Path: "notes.txt", Content: notes, ModTime: day(2021, time.February, 10), Folder: "2021/Q1_Jan-Mar"},
+		{Path: "copy of notes.txt", Content: notes, ModTime: day(2021, time.February, 10), Folder: "2021/Q1_Jan-Mar"},
+		{Path: "report.pdf", Content: []byte("%PDF-1.4 first\n"), ModTime: day(2022, time.August, 15), Folder: "2022/Q3_Jul-Sep"},
+		{Path: "work/report.pdf", Content: []byte("%PDF-1.4 second\n"), ModTime: day(2022, time.August, 15), Folder: "2022/Q3_Jul-Sep"},
+		{Path: "work/deep/clip.mp4", Content: []byte("not really a video\n"), ModTime: day(2023, time.November, 30), Folder: "2023/Q4_Oct-Dec"},
+		{Path: "camera/IMG_0001.jpg", Content: selftestJPEG(day(2020, time.July, 4)), ModTime: day(2024, time.January, 1), Folder: "2020/Q3_Jul-Sep"},
+		{Path: "spaces and ünïcödé.txt", Content: []byte("unicode\n"), ModTime: day(2019, time.May, 1), Folder: "2019/Q2_Apr-Jun"},
+		{Path: "semi;colon & 'quote'.txt", Content: []byte("punctuation\n"), ModTime: day(2019, time.May, 2), Folder: "2019/Q2_Apr-Jun"},
+		{Path: "-leading-dash.txt", Content: []byte("dash\n"), ModTime: day(2018, time.December, 31), Folder: "2018/Q4_Oct-Dec"},
+		{Path: ".hidden.txt", Content: []byte("hidden\n"), ModTime: day(2018, time.March, 3), Folder: "2018/Q1_Jan-Mar"},
+		{Path: strings.Repeat("long-name-", 12) + ".txt", Content: []byte("long\n"), ModTime: day(2017, time.October, 9), Folder: "2017/Q4_Oct-Dec"},
+	}
+}
+
+// selftestCompanionFiles builds a tree of two photos with sidecars of the
+// same content, each of which must end up beside its own photo.
+func selftestCompanionFiles() []selftestFile {
+	day := func(month time.Month) time.Time {
+		return time.Date(2016, month, 1, 12, 0, 0, 0, time.Local)
+	}
+	sidecar := []byte("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"/>\n")
+	return []selftestFile{
+		{Path: "a.jpg", Content: selftestJPEG(day(time.June)), ModTime: day(time.June), Folder: "2016/Q2_Apr-Jun"},
+		{Path: "a.xmp", Content: sidecar, ModTime: day(time.December), Folder: "2016/Q2_Apr-Jun"},
+		{Path: "b.jpg", Content: selftestJPEG(day(time.September)), ModTime: day(time.September), Folder: "2016/Q3_Jul-Sep"},
+		{Path: "b.xmp", Content: sidecar, ModTime: day(time.December), Folder: "2016/Q3_Jul-Sep"},
+	}
+}
+
+// selftestJPEG returns the smallest JPEG the EXIF reader accepts: an APP1
+// segment whose Exif IFD holds only DateTimeOriginal.
+func selftestJPEG(taken time.Time) []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	write := func(values ...any) {
+		for _, v := range values {
+			binary.Write(&tiff, le, v)
+		}
+	}
+	tiff.WriteString("II")
+	write(uint16(42))
+	write(uint32(8)) // IFD0
+	write(uint16(1))
+	write(uint16(0x8769), uint16(4), uint32(1), uint32(26)) // ExifIFDPointer
+	write(uint32(0))
+	write(uint16(1))
+	write(uint16(0x9003), uint16(2), uint32(20), uint32(44)) // DateTimeOriginal
+	write(uint32(0))
+	tiff.WriteString(taken.Format("2006:01:02 15:04:05") + "\x00")
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+// selftestCycle generates the synthetic tree under root, then plans,
+// applies, verifies and undoes an organize run over it, the way separate
+// invocations would, then organizes photos with sidecars through an index.
+// Once a step fails, the later ones are not run.
+func selftestCycle(ctx context.Context, root string) []selftestCheck {
+	input, output := filepath.Join(root, "input"), filepath.Join(root, "output")
+	files := selftestFiles()
+	var checks []selftestCheck
+	step := func(name string, fn func() (string, error)) {
+		if len(checks) > 0 && checks[len(checks)-1].Err != nil {
+			return
+		}
+		detail, err := fn()
+		checks = append(checks, selftestCheck{Name: name, Err: err, Detail: detail})
+	}
+
+	var cfg FilesMoveConfiguration
+	var plan *Plan
+	step("generate tree", func() (string, error) {
+		if err := writeSelftestTree(input, files); err != nil {
+			return "", err
+		}
+		var err error
+		cfg, err = parseArgs(CommandLineArguments{Input: input, Output: output, Lang: "en"})
+		return fmt.Sprintf("%d files in %s", len(files), input), err
+	})
+	step("plan", func() (string, error) {
+		planCfg := cfg
+		planCfg.DryRun = true
+		planCfg.FS = newFileSystem(true)
+		var err error
+		if plan, err = buildPlan(planCfg); err != nil {
+			return "", err
+		}
+		planned := map[string]string{}
+		for _, move := range plan.Moves {
+			planned[move.Source] = filepath.Dir(move.Destination)
+		}
+		for _, file := range files {
+			want := filepath.Join(output, filepath.FromSlash(file.Folder))
+			if got := planned[filepath.Join(input, filepath.FromSlash(file.Path))]; got != want {
+				return "", fmt.Errorf("%q planned for %q, expected %q", file.Path, got, want)
+			}
+		}
+		return fmt.Sprintf("%d moves", len(plan.Moves)), nil
+	})
+	step("apply", func() (string, error) {
+		// A separate apply would start without the plan's reservations.
+		cfg = withReservations(cfg)
+		forgetCreatedDirs()
+		if err := cfg.FS.MkdirAll(output, 0755); err != nil {
+			return "", newOpError("create output folder", output, err)
+		}
+		cfg.DryRun = false
+		cfg.Journal = newJournal(cfg)
+		if err := applyPlan(ctx, plan, cfg); err != nil {
+			return "", err
+		}
+		if err := cfg.Summary.filesFailed(); err != nil {
+			return "", err
+		}
+		if err := cfg.Journal.save(cfg.FS); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d files placed", len(cfg.Journal.Entries)), nil
+	})
+	step("verify", func() (string, error) {
+		detail, err := verifySelftestTree(input, output, files, cfg.Journal)
+		if err != nil {
+			return "", err
+		}
+		auditCfg := cfg
+		auditCfg.InputFolder = output
+		auditCfg.FS = newFileSystem(true)
+		issues, _, err := verifyTree(auditCfg)
+		if err != nil {
+			return "", err
+		}
+		if len(issues) > 0 {
+			return "", fmt.Errorf("verify reports %s %q", issues[0].Kind, issues[0].Path)
+		}
+		return detail, nil
+	})
+	step("undo", func() (string, error) {
+		journal, err := loadJournal(cfg.Journal.path)
+		if err != nil {
+			return "", err
+		}
+		undoCfg := parseRecordedRunArgs(CommandLineArguments{Lang: "en"}, journal.Input, journal.Output)
+		undoCfg.DryRun = false
+		if err := undoJournal(ctx, journal, undoCfg); err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			if err := checkSelftestFile(filepath.Join(input, filepath.FromSlash(file.Path)), file); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("%d files back in place", len(files)), nil
+	})
+	step("companions", func() (string, error) {
+		dir := filepath.Join(root, "companions")
+		input, output := filepath.Join(dir, "input"), filepath.Join(dir, "output")
+		pairs := selftestCompanionFiles()
+		if err := writeSelftestTree(input, pairs); err != nil {
+			return "", err
+		}
+		pairCfg, err := parseArgs(CommandLineArguments{Input: input, Output: output, Lang: "en", Index: filepath.Join(dir, "index.db")})
+		if err != nil {
+			return "", err
+		}
+		forgetCreatedDirs()
+		if err := pairCfg.FS.MkdirAll(output, 0755); err != nil {
+			return "", newOpError("create output folder", output, err)
+		}
+		pairCfg.DryRun = false
+		pairCfg = withFileIndex(withReservations(pairCfg))
+		pairCfg.Journal = newJournal(pairCfg)
+		err = organizeFiles(ctx, pairCfg)
+		closeFileIndex(pairCfg)
+		if err != nil {
+			return "", err
+		}
+		return verifySelftestTree(input, output, pairs, pairCfg.Journal)
+	})
+	return checks
+}
+
+// writeSelftestTree creates the files of the synthetic tree under input.
+func writeSelftestTree(input string, files []selftestFile) error {
+	for _, file := range files {
+		path := filepath.Join(input, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return newOpError("create folder", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, file.Content, 0644); err != nil {
+			return newOpError("write", path, err)
+		}
+		if err := os.Chtimes(path, file.ModTime, file.ModTime); err != nil {
+			return newOpError("set times", path, err)
+		}
+	}
+	return nil
+}
+
+// verifySelftestTree checks, from the journal, that every file left the input
+// and sits in its expected folder with its content and time intact. Files
+// with the same name in the same folder must have been given distinct names.
+func verifySelftestTree(input, output string, files []selftestFile, journal *Journal) (string, error) {
+	placed := map[string]string{}
+	for _, entry := range journal.Entries {
+		placed[entry.Source] = entry.Destination
+	}
+	names := map[string]bool{}
+	for _, file := range files {
+		src := filepath.Join(input, filepath.FromSlash(file.Path))
+		dst, ok := placed[src]
+		if !ok {
+			return "", fmt.Errorf("%q is missing from the journal", file.Path)
+		}
+		if fileExists(src) {
+			return "", fmt.Errorf("%q is still in the input after being moved", file.Path)
+		}
+		if want := filepath.Join(output, filepath.FromSlash(file.Folder)); filepath.Dir(dst) != want {
+			return "", fmt.Errorf("%q ended up in %q, expected %q", file.Path, filepath.Dir(dst), want)
+		}
+		if err := checkSelftestFile(dst, file); err != nil {
+			return "", err
+		}
+		if names[dst] {
+			return "", fmt.Errorf("two files were placed at %q", dst)
+		}
+		names[dst] = true
+	}
+	return fmt.Sprintf("%d files match", len(files)), nil
+}
+
+// checkSelftestFile compares path with the file it was generated as.
+func checkSelftestFile(path string, file selftestFile) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return newOpError("read", path, err)
+	}
+	if !bytes.Equal(content, file.Content) {
+		return fmt.Errorf("%q does not hold the content of %q", path, file.Path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return newOpError("stat", path, err)
+	}
+	if !info.ModTime().Equal(file.ModTime) {
+		return fmt.Errorf("%q has modification time %s, expected %s", path, info.ModTime().Format(time.RFC3339), file.ModTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// printSelftest writes one line per check and reports whether all passed.
+func printSelftest(w io.Writer, checks []selftestCheck) bool {
+	passed := true
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		result, detail := "PASS", check.Detail
+		if check.Err != nil {
+			result, detail, passed = "FAIL", check.Err.Error(), false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, result, detail)
+	}
+	tw.Flush()
+	return passed
+}
+
+// selftestLog sends the log of the cycle to a file in root, so that only
+// the report reaches the terminal.
+func selftestLog(root string) (io.Closer, error) {
+	file, err := os.Create(filepath.Join(root, "selftest.log"))
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(file)
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	return file, nil
+}
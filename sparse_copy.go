@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// defaultCopyBuffer is the buffer size of a copy without --copy-buffer.
+const defaultCopyBuffer = 1 << 20
+
+// errNoHoles reports that holes cannot be found in a file here; it is copied
+// as if it had none.
+var errNoHoles = errors.New("finding holes is not supported")
+
+// copyBuffers pools the buffers of copies, so concurrent workers do not each
+// allocate one per file.
+var copyBuffers sync.Pool
+
+// getCopyBuffer returns a buffer of size bytes; hand it back with putCopyBuffer.
+func getCopyBuffer(size int) *[]byte {
+	if size <= 0 {
+		size = defaultCopyBuffer
+	}
+	if buf, ok := copyBuffers.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+func putCopyBuffer(buf *[]byte) {
+	copyBuffers.Put(buf)
+}
+
+// writerOnly hides the ReadFrom method of an *os.File, so io.CopyBuffer goes
+// through the pooled buffer instead of the kernel's own copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// copyBuffered copies r to dst until EOF through a pooled buffer of
+// --copy-buffer bytes.
+func copyBuffered(dst io.Writer, r io.Reader, cfg FilesMoveConfiguration) (int64, error) {
+	buf := getCopyBuffer(cfg.CopyBuffer)
+	defer putCopyBuffer(buf)
+	return io.CopyBuffer(writerOnly{dst}, r, *buf)
+}
+
+// copyRange copies the n bytes of src from offset to the same offset of dst.
+// When sparse, only the data regions of src are read and written; dst is
+// seeked past the holes in between, so they stay holes. The caller truncates
+// dst to its full size, which keeps a hole at the end. It returns io.EOF when
+// src ends early.
+func copyRange(ctx context.Context, dst, src *os.File, offset, n int64, sparse bool, cfg FilesMoveConfiguration) (int64, error) {
+	end := offset + n
+	pos := offset
+	for pos < end {
+		start, stop := pos, end
+		if sparse {
+			dataStart, dataEnd, err := nextDataRegion(src, pos)
+			if errors.Is(err, errNoHoles) {
+				sparse = false
+				continue
+			}
+			if err != nil {
+				return pos - offset, err
+			}
+			start, stop = min(dataStart, end), min(dataEnd, end)
+			// Holes count as copied for the progress bar.
+			cfg.Progress.copying(start - pos)
+		}
+		if start < stop {
+			if _, err := src.Seek(start, io.SeekStart); err != nil {
+				return pos - offset, err
+			}
+			if _, err := dst.Seek(start, io.SeekStart); err != nil {
+				return pos - offset, err
+			}
+			reader := progressReader{contextReader{ctx, io.LimitReader(src, stop-start)}, cfg.Progress}
+			written, err := copyBuffered(dst, reader, cfg)
+			if err == nil && written < stop-start {
+				err = io.EOF
+			}
+			if err != nil {
+				return start - offset + written, err
+			}
+		}
+		pos = stop
+	}
+	return n, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "os"
+
+// isSparseFile cannot tell sparse files apart on this platform; they are
+// copied in full.
+func isSparseFile(info os.FileInfo) bool {
+	return false
+}
+
+func nextDataRegion(f *os.File, offset int64) (int64, int64, error) {
+	return 0, 0, errNoHoles
+}
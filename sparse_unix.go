@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isSparseFile reports whether a file takes fewer blocks than its size needs,
+// which means it has holes.
+func isSparseFile(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < info.Size()
+}
+
+// nextDataRegion returns the first region of data at or after offset, found
+// with SEEK_DATA and SEEK_HOLE. Past the last data region it returns an empty
+// region at the end of the file.
+func nextDataRegion(f *os.File, offset int64) (int64, int64, error) {
+	start, err := f.Seek(offset, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		end, err := f.Seek(0, io.SeekEnd)
+		return end, end, err
+	}
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return 0, 0, errNoHoles
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := f.Seek(start, unix.SEEK_HOLE)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
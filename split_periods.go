@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// periodDensity counts how many files, of the input and already in the output,
+// fall in each quarter and month, so dense periods can be split into finer
+// folders while sparse ones stay coarse.
+// It is built once before placing anything; watch adds the files it finds
+// later, so a period growing while watched is split as a new run would.
+type periodDensity struct {
+	mu       sync.Mutex
+	quarters map[string]int
+	months   map[string]int
+}
+
+func quarterKey(t time.Time) string {
+	return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+}
+
+func monthKey(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// countPeriodDensity counts the collected dates, those of the input files and
+// of the files already in the output tree, so a re-run keeps the same split.
+func countPeriodDensity(dates *CollectedDates, cfg FilesMoveConfiguration) *periodDensity {
+	density := &periodDensity{quarters: map[string]int{}, months: map[string]int{}}
+	for _, file := range dates.files {
+		density.add(file.date, cfg)
+	}
+	return density
+}
+
+// add counts one more file dated date.
+func (d *periodDensity) add(date time.Time, cfg FilesMoveConfiguration) {
+	if d == nil {
+		return
+	}
+	date = bucketDate(date, cfg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.quarters[quarterKey(date)]++
+	d.months[monthKey(date)]++
+}
+
+// counts returns how many files fall in the quarter and the month of date.
+func (d *periodDensity) counts(date time.Time) (quarter, month int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quarters[quarterKey(date)], d.months[monthKey(date)]
+}
+
+// withPeriodDensity attaches the density counts when --split-threshold is set.
+func withPeriodDensity(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.SplitThreshold > 0 && cfg.Density == nil {
+		cfg = withCollectedDates(cfg)
+		cfg.Density = countPeriodDensity(cfg.Dates, cfg)
+	}
+	return cfg
+}
+
+// refineDensePeriod adds a month folder inside a quarter holding more than
+// SplitThreshold files, and a day folder inside such a month likewise.
+func refineDensePeriod(quarterDir string, date time.Time, cfg FilesMoveConfiguration) string {
+	if cfg.SplitThreshold <= 0 || cfg.Density == nil {
+		return quarterDir
+	}
+	quarter, month := cfg.Density.counts(date)
+	if quarter <= cfg.SplitThreshold {
+		return quarterDir
+	}
+	monthDir := filepath.Join(quarterDir, formatMonthFolder(date.Month(), cfg.Language))
+	if month <= cfg.SplitThreshold {
+		return monthDir
+	}
+	return filepath.Join(monthDir, fmt.Sprintf("%02d", date.Day()))
+}
+
+// formatMonthFolder names a month folder like "03_Mar", sorting in calendar order.
+func formatMonthFolder(month time.Month, lang string) string {
+	months := map[string][]string{
+		"en": {"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		"es": {"Ene", "Feb", "Mar", "Abr", "May", "Jun", "Jul", "Ago", "Sep", "Oct", "Nov", "Dic"},
+	}
+	labels := months[lang]
+	if len(labels) == 0 {
+		labels = months["en"]
+	}
+	return fmt.Sprintf("%02d_%s", int(month), labels[month-1])
+}
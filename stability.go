@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// remainingAge returns how much longer a file must stay untouched before it
+// is old enough for --min-age, or zero when it already is.
+func remainingAge(info os.FileInfo, cfg FilesMoveConfiguration) time.Duration {
+	if cfg.MinAge <= 0 {
+		return 0
+	}
+	return max(cfg.MinAge-time.Since(info.ModTime()), 0)
+}
+
+// isMinAgeFilter skips files modified more recently than --min-age.
+func isMinAgeFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if remainingAge(info, cfg) == 0 {
+		return false, nil
+	}
+	log.Printf(locMsg("skipping_too_new", cfg.Language), path, cfg.MinAge)
+	return true, nil
+}
+
+// fileVersion is the size and modification time a file had when looked at.
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+// FileStability holds the recently modified files --stability-check saw
+// settle. withStabilityCheck looks at all of them before the run, waits
+// once, and looks again. A nil FileStability saw none.
+type FileStability struct {
+	// settled holds the files that kept their size and modification time
+	// over the wait, as they were.
+	settled map[string]fileVersion
+}
+
+// withStabilityCheck looks at the files of the input modified within
+// --stability-check, waits until the newest of them was modified that long
+// ago, and keeps those that did not change meanwhile. A file last modified
+// over N ago has already been stable that long, so it is not waited on. The
+// wait ends early when ctx is cancelled, and the files it was for are then
+// skipped as unstable.
+func withStabilityCheck(ctx context.Context, cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.StabilityCheck <= 0 {
+		return cfg
+	}
+	recent := map[string]fileVersion{}
+	var wait time.Duration
+	walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if age := time.Since(info.ModTime()); age < cfg.StabilityCheck {
+			recent[path] = fileVersion{size: info.Size(), modTime: info.ModTime()}
+			// A modification time in the future is waited on for the full period.
+			wait = max(wait, min(cfg.StabilityCheck-age, cfg.StabilityCheck))
+		}
+		return nil
+	})
+	cfg.Stability = &FileStability{settled: map[string]fileVersion{}}
+	if len(recent) == 0 {
+		return cfg
+	}
+	log.Printf(locMsg("stability_wait", cfg.Language), wait.Round(time.Millisecond), len(recent))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return cfg
+	case <-timer.C:
+	}
+	for path, before := range recent {
+		later, err := os.Stat(path)
+		if err == nil && later.Size() == before.size && later.ModTime().Equal(before.modTime) {
+			cfg.Stability.settled[path] = before
+		}
+	}
+	return cfg
+}
+
+// hasSettled reports whether withStabilityCheck saw the file at path settle
+// as info has it.
+func (s *FileStability) hasSettled(path string, info os.FileInfo) bool {
+	if s == nil {
+		return false
+	}
+	before, ok := s.settled[path]
+	return ok && before.size == info.Size() && before.modTime.Equal(info.ModTime())
+}
+
+// isUnstableFileFilter skips files that are still being written: with
+// --stability-check N, a file modified within N must have kept its size and
+// modification time over the wait of withStabilityCheck. Files that changed
+// since, or appeared after it, are skipped until a later run.
+func isUnstableFileFilter(path string, info os.FileInfo, cfg FilesMoveConfiguration) (bool, error) {
+	if cfg.StabilityCheck <= 0 || time.Since(info.ModTime()) >= cfg.StabilityCheck || cfg.Stability.hasSettled(path, info) {
+		return false, nil
+	}
+	log.Printf(locMsg("skipping_unstable", cfg.Language), path)
+	return true, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// topLevelName labels files that sit directly in the summarized folder.
+const topLevelName = "."
+
+// bucketStats counts the files of one group of a tree.
+type bucketStats struct {
+	Name           string
+	Files          int
+	Bytes          int64
+	Oldest, Newest time.Time
+}
+
+func (b *bucketStats) add(info os.FileInfo) {
+	b.Files++
+	b.Bytes += info.Size()
+	if mod := info.ModTime(); b.Oldest.IsZero() || mod.Before(b.Oldest) {
+		b.Oldest = mod
+	}
+	if mod := info.ModTime(); mod.After(b.Newest) {
+		b.Newest = mod
+	}
+}
+
+// treeStats summarizes a tree per top-level folder, usually a year, and per
+// kind of file as named by extCategory.
+type treeStats struct {
+	Total   bucketStats
+	Folders []*bucketStats
+	Kinds   []*bucketStats
+}
+
+// collectTreeStats walks cfg.InputFolder the way organizing does, so the
+// folders and files structo keeps for itself are left out.
+func collectTreeStats(cfg FilesMoveConfiguration) (treeStats, error) {
+	folders := map[string]*bucketStats{}
+	kinds := map[string]*bucketStats{}
+	stats := treeStats{}
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if isStructoArtifact(path) {
+			return nil
+		}
+		stats.Total.add(info)
+		folder := topLevelName
+		if rel, err := filepath.Rel(cfg.InputFolder, path); err == nil && strings.ContainsRune(rel, filepath.Separator) {
+			folder = strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		}
+		statsBucket(folders, folder).add(info)
+		statsBucket(kinds, extCategory(filepath.Ext(path), cfg.Language)).add(info)
+		return nil
+	})
+	stats.Folders = sortedBuckets(folders, func(a, b *bucketStats) bool { return a.Name < b.Name })
+	stats.Kinds = sortedBuckets(kinds, func(a, b *bucketStats) bool {
+		return a.Bytes > b.Bytes || (a.Bytes == b.Bytes && a.Name < b.Name)
+	})
+	return stats, err
+}
+
+func statsBucket(buckets map[string]*bucketStats, name string) *bucketStats {
+	if buckets[name] == nil {
+		buckets[name] = &bucketStats{Name: name}
+	}
+	return buckets[name]
+}
+
+func sortedBuckets(buckets map[string]*bucketStats, less func(a, b *bucketStats) bool) []*bucketStats {
+	list := make([]*bucketStats, 0, len(buckets))
+	for _, bucket := range buckets {
+		list = append(list, bucket)
+	}
+	sort.Slice(list, func(i, j int) bool { return less(list[i], list[j]) })
+	return list
+}
+
+// printTreeStats writes the per-folder and per-kind tables, then the totals.
+func printTreeStats(w io.Writer, stats treeStats) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FOLDER\tFILES\tSIZE\tOLDEST\tNEWEST")
+	for _, b := range stats.Folders {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", b.Name, b.Files, formatBytes(b.Bytes), b.Oldest.Format("2006-01-02"), b.Newest.Format("2006-01-02"))
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "KIND\tFILES\tSIZE\tSHARE")
+	for _, b := range stats.Kinds {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f%%\n", b.Name, b.Files, formatBytes(b.Bytes), 100*float64(b.Bytes)/float64(max(stats.Total.Bytes, 1)))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d files, %s\n", stats.Total.Files, formatBytes(stats.Total.Bytes))
+}
@@ -0,0 +1,67 @@
+package main
+
+import "log"
+
+// StorageKind is the kind of device a folder lives on, used to pick how many
+// files are moved in parallel.
+type StorageKind int
+
+const (
+	StorageUnknown StorageKind = iota
+	StorageHDD
+	StorageSSD
+	StorageNVMe
+	StorageNetwork
+)
+
+var storageKindName = map[StorageKind]string{
+	StorageUnknown: "unknown",
+	StorageHDD:     "HDD",
+	StorageSSD:     "SSD",
+	StorageNVMe:    "NVMe",
+	StorageNetwork: "network",
+}
+
+// String returns the string representation of StorageKind.
+func (k StorageKind) String() string {
+	return storageKindName[k]
+}
+
+// storageDevice identifies the device behind a folder. ID tells devices
+// apart; it is empty when unknown.
+type storageDevice struct {
+	Kind StorageKind
+	ID   string
+}
+
+// recommendedWorkers is the parallelism each kind of storage handles well:
+// spinning disks slow down when they seek between files, flash does not, and
+// network shares hide their latency behind requests in flight.
+var recommendedWorkers = map[StorageKind]int{
+	StorageUnknown: 1,
+	StorageHDD:     1,
+	StorageSSD:     4,
+	StorageNVMe:    8,
+	StorageNetwork: 4,
+}
+
+// autoWorkers picks the worker count for moving from input to output. The
+// slower side decides; two separate spinning disks get one worker each.
+func autoWorkers(input, output storageDevice) int {
+	if input.Kind == StorageHDD && output.Kind == StorageHDD && input.ID != "" && output.ID != "" && input.ID != output.ID {
+		return 2
+	}
+	return min(recommendedWorkers[input.Kind], recommendedWorkers[output.Kind])
+}
+
+// tuneWorkers chooses the worker count from the storage under the input and
+// output folders unless --workers set it, and logs the decision.
+func tuneWorkers(cfg FilesMoveConfiguration) FilesMoveConfiguration {
+	if cfg.Workers > 0 {
+		return cfg
+	}
+	input, output := detectStorage(cfg.InputFolder), detectStorage(cfg.OutputFolder)
+	cfg.Workers = autoWorkers(input, output)
+	log.Printf(locMsg("auto_workers", cfg.Language), input.Kind, output.Kind, cfg.Workers)
+	return cfg
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// networkFilesystems are the statfs(2) magic numbers of network filesystems.
+var networkFilesystems = map[int64]bool{
+	0x6969:     true, // NFS
+	0x517b:     true, // SMB
+	0xff534d42: true, // CIFS
+	0xfe534d42: true, // SMB2
+	0x00c36400: true, // Ceph
+	0x5346414f: true, // AFS
+	0x47504653: true, // GPFS
+	0x0bd00bd0: true, // Lustre
+}
+
+// detectStorage classifies the device under path: network filesystems by
+// their statfs type, local disks through /sys/dev/block, where "rotational"
+// tells spinning disks from flash.
+func detectStorage(path string) storageDevice {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return storageDevice{}
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return storageDevice{}
+	}
+	id := fmt.Sprintf("%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev))
+	if networkFilesystems[int64(fs.Type)] {
+		return storageDevice{Kind: StorageNetwork, ID: id}
+	}
+
+	dev, err := filepath.EvalSymlinks("/sys/dev/block/" + id)
+	if err != nil {
+		return storageDevice{ID: id}
+	}
+	// A partition has no queue of its own; its disk is the parent folder.
+	for _, dir := range []string{dev, filepath.Dir(dev)} {
+		rotational, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.TrimSpace(string(rotational)) == "1":
+			return storageDevice{Kind: StorageHDD, ID: filepath.Base(dir)}
+		case strings.HasPrefix(filepath.Base(dir), "nvme"):
+			return storageDevice{Kind: StorageNVMe, ID: filepath.Base(dir)}
+		default:
+			return storageDevice{Kind: StorageSSD, ID: filepath.Base(dir)}
+		}
+	}
+	return storageDevice{ID: id}
+}
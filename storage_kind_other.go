@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// detectStorage cannot tell devices apart on this platform; runs keep the
+// sequential default unless --workers is given.
+func detectStorage(path string) storageDevice {
+	return storageDevice{}
+}
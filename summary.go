@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const summaryPrefix = "summary-"
+
+// RunRecord is the machine-readable summary written at the end of every run as
+// "summary-<timestamp>.json" in the metadata folder; diagnostics bundles are built from it.
+type RunRecord struct {
+	Command     string         `json:"command"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Config      ConfigSnapshot `json:"config"`
+	Transferred int            `json:"transferred"`
+	Skipped     int            `json:"skipped"`
+	Quarantined int            `json:"quarantined,omitempty"`
+	Failures    []FileFailure  `json:"failures"`
+	// MetadataWarnings lists files that were placed but whose timestamps could not be preserved.
+	MetadataWarnings []FileFailure `json:"metadata_warnings,omitempty"`
+}
+
+// RunSummary aggregates the outcome of a run. It is shared by pointer through
+// FilesMoveConfiguration so every stage can record into it.
+type RunSummary struct {
+	mu          sync.Mutex
+	Transferred int
+	Skipped     int
+	Quarantined int
+	Failures    []FileFailure
+	// MetadataWarnings are non-fatal metadata preservation failures.
+	MetadataWarnings []FileFailure
+	// Days counts placed files per date, for the heatmap report.
+	Days map[string]int
+}
+
+// FileFailure is a single per-file error with its category and remediation hint.
+type FileFailure struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+func newRunSummary() *RunSummary {
+	return &RunSummary{}
+}
+
+func (s *RunSummary) recordTransferred() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Transferred++
+}
+
+func (s *RunSummary) recordDay(date time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Days == nil {
+		s.Days = map[string]int{}
+	}
+	s.Days[date.Format(dayKeyLayout)]++
+}
+
+func (s *RunSummary) recordSkipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Skipped++
+}
+
+// recordQuarantined counts path as quarantined instead of failed.
+func (s *RunSummary) recordQuarantined(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Quarantined++
+	s.Failures = slices.DeleteFunc(s.Failures, func(f FileFailure) bool { return f.Path == path })
+}
+
+// forgetFailure takes the failures of path off the summary, for a file that
+// is tried again later.
+func (s *RunSummary) forgetFailure(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failures = slices.DeleteFunc(s.Failures, func(f FileFailure) bool { return f.Path == path })
+}
+
+func (s *RunSummary) recordFailure(path string, err error, lang string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failures = append(s.Failures, FileFailure{
+		Path:  path,
+		Kind:  errorKindName(err),
+		Error: err.Error(),
+		Hint:  remediationHint(err, lang),
+	})
+}
+
+func (s *RunSummary) recordMetadataWarning(path string, err error, lang string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MetadataWarnings = append(s.MetadataWarnings, FileFailure{
+		Path:  path,
+		Kind:  errorKindName(err),
+		Error: err.Error(),
+		Hint:  remediationHint(err, lang),
+	})
+}
+
+// filesFailedError ends a --keep-going run in which some files failed.
+type filesFailedError struct {
+	Failed int
+	Total  int
+}
+
+func (e *filesFailedError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", e.Failed, e.Total)
+}
+
+// filesFailed returns a *filesFailedError when any file failed, or nil.
+func (s *RunSummary) filesFailed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Failures) == 0 {
+		return nil
+	}
+	total := s.Transferred + s.Skipped + s.Quarantined + len(s.Failures)
+	return &filesFailedError{Failed: len(s.Failures), Total: total}
+}
+
+// ranToEnd reports whether a run went through every file, possibly past
+// failed ones with --keep-going.
+func ranToEnd(err error) bool {
+	var failed *filesFailedError
+	return err == nil || errors.As(err, &failed)
+}
+
+// logSummary writes the totals and every failure with its remediation hint.
+func logSummary(s *RunSummary, lang string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log.Printf(locMsg("summary_totals", lang), s.Transferred, s.Skipped, len(s.Failures))
+	if s.Quarantined > 0 {
+		log.Printf(locMsg("summary_quarantined", lang), s.Quarantined)
+	}
+	if len(s.Failures) > 1 {
+		log.Printf(locMsg("summary_failure_kinds", lang), failureKindCounts(s.Failures))
+	}
+	for _, failure := range s.Failures {
+		log.Printf(locMsg("summary_failure", lang), failure.Path, failure.Kind, failure.Error)
+		if failure.Hint != "" {
+			log.Printf(locMsg("summary_hint", lang), failure.Hint)
+		}
+	}
+	for _, warning := range s.MetadataWarnings {
+		log.Printf(locMsg("summary_metadata_warning", lang), warning.Path, warning.Error)
+	}
+}
+
+// failureKindCounts tells how many failures fall in each category, most
+// common first, like "3 permission denied, 1 destination is full".
+func failureKindCounts(failures []FileFailure) string {
+	counts := map[string]int{}
+	var kinds []string
+	for _, failure := range failures {
+		if counts[failure.Kind] == 0 {
+			kinds = append(kinds, failure.Kind)
+		}
+		counts[failure.Kind]++
+	}
+	sort.SliceStable(kinds, func(i, j int) bool { return counts[kinds[i]] > counts[kinds[j]] })
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[kind], kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeRunRecord persists the summary of a finished run next to its log.
+func writeRunRecord(command string, cfg FilesMoveConfiguration) error {
+	if cfg.FS.ReadOnly() {
+		return nil
+	}
+	s := cfg.Summary
+	s.mu.Lock()
+	record := RunRecord{
+		Command:          command,
+		FinishedAt:       time.Now(),
+		Config:           snapshotConfig(cfg),
+		Transferred:      s.Transferred,
+		Skipped:          s.Skipped,
+		Quarantined:      s.Quarantined,
+		Failures:         append([]FileFailure(nil), s.Failures...),
+		MetadataWarnings: append([]FileFailure(nil), s.MetadataWarnings...),
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(metadataDir(cfg.OutputFolder), summaryPrefix+record.FinishedAt.Format(journalTimestamp)+".json")
+	return writeArtifact(cfg.FS, path, data, "summary")
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncTransport is how sync mirrors the tree, chosen with --transport.
+type SyncTransport int
+
+const (
+	// SyncAuto uses rsync when it is installed, and the built-in copy otherwise.
+	SyncAuto SyncTransport = iota
+	// SyncRsync runs rsync, which also reaches remote hosts over SSH.
+	SyncRsync
+	// SyncBuiltin copies with structo's own copy backends, to local folders
+	// and mounted shares only.
+	SyncBuiltin
+)
+
+var syncTransportName = map[SyncTransport]string{
+	SyncAuto:    "auto",
+	SyncRsync:   "rsync",
+	SyncBuiltin: "builtin",
+}
+
+var reverseSyncTransportName = map[string]SyncTransport{
+	"auto":    SyncAuto,
+	"rsync":   SyncRsync,
+	"builtin": SyncBuiltin,
+}
+
+// String returns the string representation of SyncTransport.
+func (t SyncTransport) String() string {
+	return syncTransportName[t]
+}
+
+// ParseSyncTransport parses a string into a SyncTransport.
+func ParseSyncTransport(input string) (SyncTransport, error) {
+	if transport, ok := reverseSyncTransportName[input]; ok {
+		return transport, nil
+	}
+	return 0, fmt.Errorf("invalid SyncTransport: %s (expected auto, rsync or builtin)", input)
+}
+
+const (
+	// syncBatch is how many folders one rsync call mirrors, which keeps its
+	// command line within the limits of every system.
+	syncBatch = 200
+	// syncModifyWindow is how far apart two modification times may be and
+	// still count as equal, since FAT drives store them in 2-second steps.
+	syncModifyWindow = 2 * time.Second
+)
+
+// syncTree mirrors the folders of the tree at cfg.OutputFolder that structo
+// runs changed since the last successful sync to cfg.SyncTo, or the whole
+// tree the first time. Changes are taken from the run journals, so only the
+// folders files were placed in, or moved out of, are compared. Logs,
+// journals and other structo files stay behind; the chunk store of
+// --backend chunkstore is mirrored with the manifests that need it.
+func syncTree(ctx context.Context, cfg FilesMoveConfiguration, transport SyncTransport) (SyncTransport, error) {
+	if transport == SyncAuto {
+		transport = SyncBuiltin
+		if _, err := exec.LookPath("rsync"); err == nil {
+			transport = SyncRsync
+		}
+	}
+	if transport == SyncBuiltin && isRemoteTarget(cfg.SyncTo) {
+		return transport, fmt.Errorf("%s is on another host, which needs rsync", cfg.SyncTo)
+	}
+	root := cfg.OutputFolder
+	since, err := lastSync(root, cfg.SyncTo)
+	if err != nil {
+		return transport, err
+	}
+	folders := []string{"."}
+	if since.IsZero() {
+		log.Printf(locMsg("sync_first", cfg.Language), cfg.SyncTo)
+	} else {
+		if folders, err = changedFolders(root, since); err != nil {
+			return transport, err
+		}
+		if len(folders) == 0 {
+			log.Printf(locMsg("sync_nothing", cfg.Language), since.Format(time.RFC3339))
+			return transport, nil
+		}
+		log.Printf(locMsg("sync_changed", cfg.Language), len(folders), since.Format(time.RFC3339))
+	}
+	if transport == SyncRsync {
+		err = rsyncFolders(root, folders, cfg)
+	} else {
+		err = mirrorFolders(ctx, root, folders, cfg)
+	}
+	if !ranToEnd(err) {
+		// The run record must not pass for a successful sync.
+		cfg.Summary.recordFailure(root, err, cfg.Language)
+	}
+	return transport, err
+}
+
+// lastSync returns when the last successful sync of the tree at root to
+// target finished, from the run records; it is zero when there was none.
+// Unreadable records are passed over, which at worst syncs more.
+func lastSync(root, target string) (time.Time, error) {
+	paths, err := artifactFiles(root, summaryPrefix, ".json")
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var record RunRecord
+		if json.Unmarshal(data, &record) != nil {
+			continue
+		}
+		if record.Command != "sync" || record.Config.SyncTo != target || record.Config.DryRun || len(record.Failures) > 0 {
+			continue
+		}
+		if record.FinishedAt.After(last) {
+			last = record.FinishedAt
+		}
+	}
+	return last, nil
+}
+
+// changedFolders returns the folders of the tree at root, relative to it,
+// that the journals show were changed after since: those files were placed
+// in or moved out of, by runs since or by runs, or parts of them, undone
+// since. See coveringFolders; mirroring a folder mirrors everything below it.
+func changedFolders(root string, since time.Time) ([]string, error) {
+	paths, err := artifactFiles(root, journalPrefix, ".json")
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for _, path := range paths {
+		journal, err := loadJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		undone := journal.UndoneAt != nil && journal.UndoneAt.After(since)
+		for _, entry := range journal.Entries {
+			if undone || entry.Time.After(since) || entry.Undone != nil && entry.Undone.After(since) {
+				addEntryFolders(changed, journal.Output, entry)
+			}
+		}
+	}
+	if len(changed) > 0 && fileExists(chunkStoreRoot(root)) {
+		changed[chunkStoreDirName] = true
+	}
+	return coveringFolders(root, changed), nil
+}
+
+// addEntryFolders adds the folders of output, relative to it, that a journal
+// entry placed a file in or moved one out of.
+func addEntryFolders(changed map[string]bool, output string, entry JournalEntry) {
+	for _, p := range []string{entry.Source, entry.Destination} {
+		if rel, ok := folderBelow(output, filepath.Dir(p)); ok {
+			changed[rel] = true
+		}
+	}
+}
+
+// coveringFolders reduces changed folders of the tree at root, relative to
+// it, to the fewest folders that still hold every change: a folder that no
+// longer exists stands for its nearest existing parent, and folders below
+// another one are left out.
+func coveringFolders(root string, changed map[string]bool) []string {
+	existing := map[string]bool{}
+	for rel := range changed {
+		for rel != "." && !fileExists(filepath.Join(root, filepath.FromSlash(rel))) {
+			rel = path.Dir(rel)
+		}
+		existing[rel] = true
+	}
+	var folders []string
+	for rel := range existing {
+		covered := false
+		for parent := rel; parent != "." && !covered; {
+			parent = path.Dir(parent)
+			covered = existing[parent]
+		}
+		if !covered {
+			folders = append(folders, rel)
+		}
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// folderBelow is relBelow for folders, which also accepts root itself as ".".
+func folderBelow(root, dir string) (string, bool) {
+	if filepath.Clean(dir) == filepath.Clean(root) {
+		return ".", true
+	}
+	return relBelow(root, dir)
+}
+
+// isRemoteTarget reports whether target names another host the way rsync
+// does, as host:path or rsync://host/path.
+func isRemoteTarget(target string) bool {
+	if strings.HasPrefix(target, "rsync://") {
+		return true
+	}
+	if filepath.VolumeName(target) != "" {
+		return false
+	}
+	colon := strings.Index(target, ":")
+	return colon > 0 && !strings.ContainsAny(target[:colon], `/\`)
+}
+
+// rsyncFolders mirrors folders of root to cfg.SyncTo with rsync, keeping
+// their paths below root. Dry runs let rsync list what it would change.
+func rsyncFolders(root string, folders []string, cfg FilesMoveConfiguration) error {
+	base := []string{
+		"-a", "--delete", "--relative",
+		"--include=/" + chunkStoreDirName + "/",
+		"--exclude=/" + metadataDirName + "/",
+		"--exclude=.structo-*",
+		"--exclude=*" + partialSuffix,
+		"--exclude=*" + checkpointSuffix,
+		"--exclude=" + legacyLogFilePrefix + "*.log",
+	}
+	if cfg.DryRun {
+		base = append(base, "--dry-run", "--itemize-changes")
+	}
+	target := cfg.SyncTo
+	if !strings.HasSuffix(target, "/") {
+		target += "/"
+	}
+	for start := 0; start < len(folders); start += syncBatch {
+		args := append([]string(nil), base...)
+		for _, rel := range folders[start:min(start+syncBatch, len(folders))] {
+			// The "/./" marks where the path rsync recreates at the target begins.
+			src := root + "/./" + rel
+			if rel == "." {
+				src = root + "/./"
+			}
+			args = append(args, src)
+		}
+		args = append(args, target)
+		cmd := exec.Command("rsync", args...)
+		var output []byte
+		var err error
+		if cfg.DryRun {
+			output, err = cmd.CombinedOutput()
+		} else {
+			output, err = cfg.FS.RunCommand(cmd)
+		}
+		if len(output) > 0 {
+			log.Print(strings.TrimRight(string(output), "\n"))
+		}
+		if err != nil {
+			return fmt.Errorf("rsync to %s failed: %w", cfg.SyncTo, err)
+		}
+	}
+	return nil
+}
+
+// mirrorFolders mirrors folders of root to the local folder cfg.SyncTo with
+// the built-in copy: files missing at the target or differing in size or
+// modification time are copied, and files the tree no longer has are
+// removed. A file that fails is recorded and the rest still mirrored.
+func mirrorFolders(ctx context.Context, root string, folders []string, cfg FilesMoveConfiguration) error {
+	for _, rel := range folders {
+		src := filepath.Join(root, filepath.FromSlash(rel))
+		dst := filepath.Join(cfg.SyncTo, filepath.FromSlash(rel))
+		if err := mirrorFolder(ctx, root, src, dst, cfg); err != nil {
+			return err
+		}
+		if err := pruneMirror(root, src, dst, cfg); err != nil {
+			return err
+		}
+	}
+	return cfg.Summary.filesFailed()
+}
+
+// isSyncSkipped reports whether a file or folder of the tree stays out of a
+// mirror: structo's own files and folders, except the chunk store.
+func isSyncSkipped(root, p string, isDir bool) bool {
+	name := filepath.Base(p)
+	if isDir {
+		return isMetadataPath(p) || strings.HasPrefix(name, ".structo-") && p != chunkStoreRoot(root)
+	}
+	return isStructoArtifactName(name)
+}
+
+func mirrorFolder(ctx context.Context, root, src, dst string, cfg FilesMoveConfiguration) error {
+	return filepath.WalkDir(src, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			cfg.Summary.recordFailure(p, newOpError("read", p, err), cfg.Language)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if isSyncSkipped(root, p, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, rel)
+		if entry.IsDir() {
+			if cfg.DryRun {
+				return nil
+			}
+			if err := cfg.FS.MkdirAll(target, 0755); err != nil {
+				return newOpError("create folder", target, err)
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			cfg.Summary.recordFailure(p, newOpError("stat", p, err), cfg.Language)
+			return nil
+		}
+		if current, err := os.Stat(target); err == nil && current.Size() == info.Size() &&
+			current.ModTime().Sub(info.ModTime()).Abs() < syncModifyWindow {
+			cfg.Summary.recordSkipped()
+			return nil
+		}
+		if err := copyFilePreserve(ctx, p, target, info, cfg); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			cfg.Summary.recordFailure(p, err, cfg.Language)
+			return nil
+		}
+		cfg.Summary.recordTransferred()
+		return nil
+	})
+}
+
+// pruneMirror removes what the mirror dst holds that src no longer has,
+// leaving out what isSyncSkipped keeps out of mirrors.
+func pruneMirror(root, src, dst string, cfg FilesMoveConfiguration) error {
+	var extra []string
+	err := filepath.WalkDir(dst, func(p string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return newOpError("read", p, err)
+		}
+		rel, _ := filepath.Rel(dst, p)
+		if rel == "." {
+			return nil
+		}
+		if isSyncSkipped(root, filepath.Join(src, rel), entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); errors.Is(err, fs.ErrNotExist) {
+			extra = append(extra, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Folders come before what they hold, so going backwards empties them first.
+	for i := len(extra) - 1; i >= 0; i-- {
+		if cfg.DryRun {
+			log.Printf("[DRY RUN] Would remove from the mirror: %s", extra[i])
+			continue
+		}
+		if err := cfg.FS.Remove(extra[i]); err != nil {
+			cfg.Summary.recordFailure(extra[i], newOpError("remove", extra[i], err), cfg.Language)
+			continue
+		}
+		log.Printf(locMsg("sync_removed", cfg.Language), extra[i])
+	}
+	return nil
+}
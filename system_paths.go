@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// systemPath is a folder structo refuses to organize. With Tree set, every
+// folder below it is refused too; otherwise only the folder itself, so a
+// home directory is protected while its Downloads folder is not.
+type systemPath struct {
+	Path string
+	Tree bool
+}
+
+// systemPaths lists the system-critical folders of the running OS, plus the
+// current user's profile root.
+func systemPaths() []systemPath {
+	var paths []systemPath
+	switch runtime.GOOS {
+	case "windows":
+		for _, name := range []string{"SystemRoot", "ProgramFiles", "ProgramFiles(x86)", "ProgramW6432", "ProgramData"} {
+			if dir := os.Getenv(name); dir != "" {
+				paths = append(paths, systemPath{dir, true})
+			}
+		}
+		drive := os.Getenv("SystemDrive")
+		if drive == "" {
+			drive = "C:"
+		}
+		paths = append(paths,
+			systemPath{drive + `\`, false},
+			systemPath{drive + `\Users`, false},
+		)
+	default:
+		for _, dir := range []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib32", "/lib64", "/proc", "/sbin", "/sys", "/usr", "/var/lib", "/var/log"} {
+			paths = append(paths, systemPath{dir, true})
+		}
+		paths = append(paths,
+			systemPath{"/", false},
+			systemPath{"/home", false},
+			systemPath{"/opt", false},
+			systemPath{"/root", false},
+			systemPath{"/var", false},
+		)
+		if runtime.GOOS == "darwin" {
+			for _, dir := range []string{"/System", "/Library", "/Applications", "/private/etc"} {
+				paths = append(paths, systemPath{dir, true})
+			}
+			paths = append(paths, systemPath{"/Users", false}, systemPath{"/private", false}, systemPath{"/private/var", false})
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		paths = append(paths, systemPath{home, false})
+	}
+	return paths
+}
+
+// checkSystemPath refuses path, used as role ("input" or "output"), when it is
+// a system folder. Symlinks are resolved so an alias cannot slip past.
+func checkSystemPath(role, path string) error {
+	candidates := []string{path}
+	if abs, err := filepath.Abs(path); err == nil {
+		candidates = append(candidates, abs)
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			candidates = append(candidates, real)
+		}
+	}
+	for _, candidate := range candidates {
+		for _, sys := range systemPaths() {
+			if isSystemPathMatch(filepath.Clean(candidate), filepath.Clean(sys.Path), sys.Tree) {
+				return fmt.Errorf("refusing to use %q as the %s folder: %s is a system folder; pass --i-know-what-im-doing if this is really intended", path, role, sys.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// isSystemPathMatch compares paths case-insensitively where the usual
+// filesystem is, on Windows and macOS.
+func isSystemPathMatch(path, sys string, tree bool) bool {
+	equal := func(a, b string) bool { return a == b }
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		equal = strings.EqualFold
+	}
+	if equal(path, sys) {
+		return true
+	}
+	if !tree {
+		return false
+	}
+	prefix := strings.TrimSuffix(sys, string(filepath.Separator)) + string(filepath.Separator)
+	return len(path) > len(prefix) && equal(path[:len(prefix)], prefix)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TagStore is where tag writes the classification of each file, chosen
+// with --store.
+type TagStore int
+
+const (
+	// TagStoreXattr writes extended attributes on the files themselves,
+	// alternate data streams on Windows.
+	TagStoreXattr TagStore = iota
+	// TagStoreDB writes the tags database in the metadata folder of the
+	// input, and leaves the files alone.
+	TagStoreDB
+)
+
+var tagStoreName = map[TagStore]string{
+	TagStoreXattr: "xattr",
+	TagStoreDB:    "db",
+}
+
+var reverseTagStoreName = map[string]TagStore{
+	"xattr": TagStoreXattr,
+	"db":    TagStoreDB,
+}
+
+// String returns the string representation of TagStore.
+func (s TagStore) String() string {
+	return tagStoreName[s]
+}
+
+// ParseTagStore parses a string into a TagStore.
+func ParseTagStore(input string) (TagStore, error) {
+	if store, ok := reverseTagStoreName[input]; ok {
+		return store, nil
+	}
+	return 0, fmt.Errorf("invalid TagStore: %s (expected xattr or db)", input)
+}
+
+const (
+	// tagAttrPrefix starts the names of the extended attributes tag writes,
+	// as in "user.structo.year"; on Windows the streams are named
+	// "structo.year".
+	tagAttrPrefix = "user.structo."
+	// tagDBName is the tags database in the metadata folder of the input.
+	tagDBName = "tags.db"
+)
+
+// tagBucket maps the path of a file, relative to the input and with
+// forward slashes, to its fileTags as JSON.
+var tagBucket = []byte("tags")
+
+// fileTags is the classification tag records for a file: what organizing
+// would work out for it, without moving it.
+type fileTags struct {
+	// Date is the date of the file, in RFC 3339, and Source the date
+	// source it came from.
+	Date   string `json:"date"`
+	Source string `json:"source"`
+	// Year and Quarter, as "Q1", are those of the day the date falls on
+	// after --bucket-offset.
+	Year    string `json:"year"`
+	Quarter string `json:"quarter"`
+	// Type is the kind of file, after its extension: images, videos,
+	// audio, documents, archives or other.
+	Type string `json:"type"`
+	// Event names the event of the file as the events folder format does,
+	// grouped with --event-gap.
+	Event string `json:"event"`
+	// Folder is the folder organizing would put the file in under the
+	// current settings, relative to the input.
+	Folder string `json:"folder"`
+}
+
+// attributes lists the tags as attribute names, without tagAttrPrefix, and
+// values.
+func (t fileTags) attributes() [][2]string {
+	return [][2]string{
+		{"date", t.Date}, {"source", t.Source}, {"year", t.Year}, {"quarter", t.Quarter},
+		{"type", t.Type}, {"event", t.Event}, {"folder", t.Folder},
+	}
+}
+
+// classifyFile works out the tags of the file at path. A companion, such as
+// a sidecar, is classified like its leader, see folderFile.
+func classifyFile(path string, info os.FileInfo, cfg FilesMoveConfiguration) (fileTags, error) {
+	dated, datedInfo := folderFile(path, info, cfg)
+	date, source, err := resolveFileDate(dated, datedInfo, cfg)
+	if err != nil {
+		return fileTags{}, err
+	}
+	folder, err := expectedFolder(path, info, cfg)
+	if err != nil {
+		return fileTags{}, err
+	}
+	rel, ok := relBelow(cfg.OutputFolder, folder)
+	if !ok {
+		rel = filepath.ToSlash(folder)
+	}
+	day := bucketDate(date, cfg)
+	kind := extCategories[lowerExt(dated)]
+	if kind == "" {
+		kind = "other"
+	}
+	return fileTags{
+		Date:    date.Format(time.RFC3339),
+		Source:  source.String(),
+		Year:    strconv.Itoa(day.Year()),
+		Quarter: fmt.Sprintf("Q%d", (int(day.Month())-1)/3+1),
+		Type:    kind,
+		Event:   cfg.Events.find(date, cfg).name(cfg.Language),
+		Folder:  rel,
+	}, nil
+}
+
+// tagFiles records the classification of every file under the input folder
+// in store, without moving any. The events are grouped over the whole input
+// first, so every file gets the event of its neighbours.
+func tagFiles(ctx context.Context, store TagStore, cfg FilesMoveConfiguration) error {
+	tasks, err := collectInputFiles(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.Events = collectEvents(cfg, false)
+
+	var db *bolt.DB
+	if store == TagStoreDB && !cfg.DryRun {
+		if db, err = openTagDB(cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	tagged := map[string][]byte{}
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !task.info.Mode().IsRegular() {
+			cfg.Summary.recordSkipped()
+			continue
+		}
+		tags, err := classifyFile(task.path, task.info, cfg)
+		if err == nil {
+			err = tagFile(task.path, task.info, tags, store, tagged, cfg)
+		}
+		if err != nil {
+			cfg.Summary.recordFailure(task.path, err, cfg.Language)
+			log.Printf(locMsg("tag_error", cfg.Language), task.path, err)
+			continue
+		}
+		cfg.Summary.recordTransferred()
+	}
+	if db == nil {
+		return nil
+	}
+	return saveTags(db, tagged, cfg)
+}
+
+// tagFile writes the tags of one file as extended attributes, or adds them
+// to tagged, by the file's key in the tags database, for saveTags.
+func tagFile(path string, info os.FileInfo, tags fileTags, store TagStore, tagged map[string][]byte, cfg FilesMoveConfiguration) error {
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would tag: %s (%s, %s %s, %s, %s)", path, tags.Folder, tags.Year, tags.Quarter, tags.Type, tags.Event)
+		return nil
+	}
+	if cfg.FS.ReadOnly() {
+		return refuse("tag", path)
+	}
+	if store == TagStoreDB {
+		rel, ok := relBelow(cfg.InputFolder, path)
+		if !ok {
+			return fmt.Errorf("%s is not below %s", path, cfg.InputFolder)
+		}
+		data, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		tagged[rel] = data
+	} else if err := writeTagAttributes(path, info, tags); err != nil {
+		return newOpError("write tags", path, err)
+	}
+	log.Printf(locMsg("tagged_file", cfg.Language), path, tags.Folder)
+	return nil
+}
+
+// openTagDB opens the tags database of the input, creating it and the
+// metadata folder when no run did yet.
+func openTagDB(cfg FilesMoveConfiguration) (*bolt.DB, error) {
+	path := filepath.Join(metadataDir(cfg.InputFolder), tagDBName)
+	if err := cfg.FS.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, newOpError("create metadata folder", filepath.Dir(path), err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: indexLockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("tags database %q is in use by another run", path)
+	}
+	if err != nil {
+		return nil, newOpError("open tags database", path, err)
+	}
+	return db, nil
+}
+
+// saveTags writes the tags of this run to the database in one transaction,
+// and removes those of files no longer in the input.
+func saveTags(db *bolt.DB, tagged map[string][]byte, cfg FilesMoveConfiguration) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(tagBucket)
+		if err != nil {
+			return err
+		}
+		var gone [][]byte
+		err = b.ForEach(func(rel, _ []byte) error {
+			if _, ok := tagged[string(rel)]; !ok && !fileExists(filepath.Join(cfg.InputFolder, filepath.FromSlash(string(rel)))) {
+				gone = append(gone, append([]byte(nil), rel...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, rel := range gone {
+			if err := b.Delete(rel); err != nil {
+				return err
+			}
+		}
+		for rel, data := range tagged {
+			if err := b.Put([]byte(rel), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return newOpError("write tags database", db.Path(), err)
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !(linux || darwin || freebsd || netbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// writeTagAttributes fails on platforms without an extended attribute API;
+// --store db works everywhere.
+func writeTagAttributes(path string, info os.FileInfo, tags fileTags) error {
+	return errors.New("extended attributes are not supported on this system; use --store db")
+}
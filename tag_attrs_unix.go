@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeTagAttributes sets the tags of path as the extended attributes
+// user.structo.year and so on, replacing those of an earlier run.
+func writeTagAttributes(path string, info os.FileInfo, tags fileTags) error {
+	for _, attr := range tags.attributes() {
+		if err := unix.Setxattr(path, tagAttrPrefix+attr[0], []byte(attr[1]), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// writeTagAttributes writes the tags of path to the alternate data streams
+// structo.year and so on, replacing those of an earlier run. Writing a
+// stream changes the modification time of the file, which is put back, since
+// it may be the date the file is organized by.
+func writeTagAttributes(path string, info os.FileInfo, tags fileTags) error {
+	for _, attr := range tags.attributes() {
+		stream := path + ":" + strings.TrimPrefix(tagAttrPrefix, "user.") + attr[0]
+		if err := os.WriteFile(stream, []byte(attr[1]), 0644); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(path, time.Time{}, info.ModTime())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// validateTemplate checks a user-written name or path template before any file
+// is touched, so a bad template stops the run instead of producing a broken
+// tree. Callers prefix errors with what the template is for.
+// Placeholders are "{...}" and only their literal text is checked; those whose
+// value is in mayBeEmpty can render to nothing, unless a helper such as
+// extCategory always gives text, so a segment made only of them could end
+// up empty. Unless allowDirs, the template must be a single name.
+func validateTemplate(template string, allowDirs bool, mayBeEmpty map[string]bool) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("%q is empty", template)
+	}
+	if !allowDirs && strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("%q must not contain path separators", template)
+	}
+	if filepath.IsAbs(template) || strings.HasPrefix(template, "/") || strings.HasPrefix(template, `\`) || filepath.VolumeName(template) != "" {
+		return fmt.Errorf("%q is an absolute path; it must be relative to the output folder", template)
+	}
+	// Helpers may use characters that names cannot, such as "|", so only the
+	// literal text is checked; placeholders keep their length for the offset.
+	literal := templatePlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		return strings.Repeat("_", len(p))
+	})
+	if offset, r, ok := invalidTemplateChar(literal); ok {
+		return fmt.Errorf("%q: character %q at offset %d is not allowed in file names", template, r, offset)
+	}
+	segments := strings.Split(strings.ReplaceAll(template, `\`, "/"), "/")
+	for _, segment := range segments {
+		switch {
+		case segment == "":
+			return fmt.Errorf("%q has an empty folder name; remove the doubled or trailing slash", template)
+		case segment == ".":
+			return fmt.Errorf("%q must not contain a \".\" part, which names no folder", template)
+		case segment == "..":
+			return fmt.Errorf("%q must not contain \"..\"; it could reach outside the output folder", template)
+		case strings.TrimSpace(templatePlaceholder.ReplaceAllStringFunc(segment, func(p string) string {
+			if placeholderMayBeEmpty(p, mayBeEmpty) {
+				return ""
+			}
+			return p
+		})) == "":
+			return fmt.Errorf("%q: the part %q can turn out empty; add fixed text or a placeholder that always has a value", template, segment)
+		case runtime.GOOS == "windows" && strings.TrimRight(segment, ". ") != segment:
+			return fmt.Errorf("%q: the part %q ends in a dot or space, which Windows does not allow", template, segment)
+		}
+	}
+	return nil
+}
+
+// invalidTemplateChar returns the first character of template that no name can
+// hold on this platform: control characters everywhere, and the reserved
+// characters on Windows. Separators are checked with the segments.
+func invalidTemplateChar(template string) (int, rune, bool) {
+	for offset, r := range template {
+		if r < 0x20 || r == 0x7f {
+			return offset, r, true
+		}
+		if runtime.GOOS == "windows" && strings.ContainsRune(`<>:"|?*`, r) {
+			return offset, r, true
+		}
+	}
+	return 0, 0, false
+}
+
+// validateRenderedName rejects a rendered template that came out as no usable
+// name, which happens when every placeholder in it was empty for this file.
+func validateRenderedName(name, template string) error {
+	if strings.TrimSpace(name) == "" || name == "." || name == ".." {
+		return fmt.Errorf("template %q rendered %q, which is not a usable name", template, name)
+	}
+	return nil
+}
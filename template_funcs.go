@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// templatePlaceholder matches "{value}", optionally followed by helpers that
+// transform the value in turn: "{name|slugify|truncate 20}".
+var templatePlaceholder = regexp.MustCompile(`\{([a-z]+)((?:\|[^{}|/\\]*)*)\}`)
+
+// templateValueNames are the values rename patterns and folder templates may use.
+var templateValueNames = map[string]bool{
+	"name": true, "ext": true, "date": true, "time": true,
+	"year": true, "month": true, "day": true,
+}
+
+// templateHelper is one helper usable after a "|" in a placeholder. check
+// validates its arguments before the run; apply transforms a value. Helpers
+// marked neverEmpty always produce text, so a folder made only of them
+// cannot end up without a name.
+type templateHelper struct {
+	minArgs, maxArgs int
+	neverEmpty       bool
+	check            func(args []string) error
+	apply            func(value string, args []string, lang string) (string, error)
+}
+
+var templateHelpers = map[string]templateHelper{
+	"lower": {apply: func(value string, _ []string, _ string) (string, error) {
+		return strings.ToLower(value), nil
+	}},
+	"slugify": {apply: func(value string, _ []string, _ string) (string, error) {
+		return slugify(value), nil
+	}},
+	"truncate": {minArgs: 1, maxArgs: 1, check: checkTruncateArgs, apply: func(value string, args []string, _ string) (string, error) {
+		n, _ := strconv.Atoi(args[0])
+		if runes := []rune(value); len(runes) > n {
+			return string(runes[:n]), nil
+		}
+		return value, nil
+	}},
+	"padMonth": {neverEmpty: true, apply: func(value string, _ []string, _ string) (string, error) {
+		month, err := templateMonth(value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%02d", int(month)), nil
+	}},
+	"quarterLabel": {maxArgs: 1, neverEmpty: true, check: checkLanguageArgs, apply: func(value string, args []string, lang string) (string, error) {
+		month, err := templateMonth(value)
+		if err != nil {
+			return "", err
+		}
+		if len(args) > 0 {
+			lang = args[0]
+		}
+		return formatQuarterFolder(quarterInfoForMonth(int(month), lang)), nil
+	}},
+	"extCategory": {neverEmpty: true, apply: func(value string, _ []string, lang string) (string, error) {
+		return extCategory(value, lang), nil
+	}},
+}
+
+// templateCall is one parsed "|helper arg..." of a placeholder.
+type templateCall struct {
+	helper string
+	args   []string
+}
+
+// parseTemplatePlaceholder splits a placeholder matched by templatePlaceholder
+// into its value name and helper calls, checking helpers and their arguments.
+func parseTemplatePlaceholder(match []string) (string, []templateCall, error) {
+	var calls []templateCall
+	if match[2] == "" {
+		return match[1], nil, nil
+	}
+	for _, part := range strings.Split(match[2][1:], "|") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			return "", nil, fmt.Errorf("%s has an empty helper after a \"|\"", match[0])
+		}
+		helper, ok := templateHelpers[fields[0]]
+		if !ok {
+			return "", nil, fmt.Errorf("%s uses unknown helper %q (known: %s)", match[0], fields[0], strings.Join(templateHelperNames(), ", "))
+		}
+		args := fields[1:]
+		if len(args) < helper.minArgs || len(args) > helper.maxArgs {
+			return "", nil, fmt.Errorf("%s: %s takes %s", match[0], fields[0], describeArgCount(helper.minArgs, helper.maxArgs))
+		}
+		if helper.check != nil {
+			if err := helper.check(args); err != nil {
+				return "", nil, fmt.Errorf("%s: %s: %v", match[0], fields[0], err)
+			}
+		}
+		calls = append(calls, templateCall{helper: fields[0], args: args})
+	}
+	return match[1], calls, nil
+}
+
+// validateTemplatePlaceholders rejects unknown values and helpers in template.
+func validateTemplatePlaceholders(template string) error {
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+		value, _, err := parseTemplatePlaceholder(match)
+		if err != nil {
+			return fmt.Errorf("%q: %v", template, err)
+		}
+		if !templateValueNames[value] {
+			return fmt.Errorf("%q uses unknown placeholder {%s}", template, value)
+		}
+	}
+	return nil
+}
+
+// placeholderMayBeEmpty reports whether a placeholder can render to nothing:
+// a value in mayBeEmpty whose last helper, if any, does not always give text.
+func placeholderMayBeEmpty(placeholder string, mayBeEmpty map[string]bool) bool {
+	match := templatePlaceholder.FindStringSubmatch(placeholder)
+	value, calls, err := parseTemplatePlaceholder(match)
+	if err != nil || !mayBeEmpty["{"+value+"}"] {
+		return false
+	}
+	return len(calls) == 0 || !templateHelpers[calls[len(calls)-1].helper].neverEmpty
+}
+
+// templateValues returns the values of a file's placeholders.
+func templateValues(base string, date time.Time) map[string]string {
+	ext := filepath.Ext(base)
+	return map[string]string{
+		"name":  strings.TrimSuffix(base, ext),
+		"ext":   ext,
+		"date":  date.Format("2006-01-02"),
+		"time":  date.Format("150405"),
+		"year":  date.Format("2006"),
+		"month": date.Format("01"),
+		"day":   date.Format("02"),
+	}
+}
+
+// renderTemplate fills in every placeholder of a validated template,
+// running each value through its helpers.
+func renderTemplate(template string, values map[string]string, lang string) (string, error) {
+	var renderErr error
+	rendered := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, calls, err := parseTemplatePlaceholder(templatePlaceholder.FindStringSubmatch(placeholder))
+		if err != nil {
+			renderErr = err
+			return ""
+		}
+		text := values[value]
+		for _, call := range calls {
+			if text, err = templateHelpers[call.helper].apply(text, call.args, lang); err != nil && renderErr == nil {
+				renderErr = fmt.Errorf("%s: %s: %v", placeholder, call.helper, err)
+			}
+		}
+		return text
+	})
+	return rendered, renderErr
+}
+
+func templateHelperNames() []string {
+	names := make([]string, 0, len(templateHelpers))
+	for name := range templateHelpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func describeArgCount(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs == 0:
+		return "no arguments"
+	case minArgs == maxArgs:
+		return fmt.Sprintf("exactly %d argument(s)", minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", minArgs, maxArgs)
+	}
+}
+
+func checkTruncateArgs(args []string) error {
+	if n, err := strconv.Atoi(args[0]); err != nil || n < 1 {
+		return fmt.Errorf("expected a length of at least 1, got %q", args[0])
+	}
+	return nil
+}
+
+func checkLanguageArgs(args []string) error {
+	for _, arg := range args {
+		if !isSupportedLanguage(arg) {
+			return fmt.Errorf("unsupported language %q (supported: %s)", arg, strings.Join(supportedLanguages, ", "))
+		}
+	}
+	return nil
+}
+
+func isSupportedLanguage(lang string) bool {
+	for _, supported := range supportedLanguages {
+		if lang == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// templateMonth reads a month from a month number such as "3" or "03", a
+// date such as "2024-03-05", or a month name or abbreviation in any
+// supported language.
+func templateMonth(value string) (time.Month, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.Month(), nil
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= 12 {
+		return time.Month(n), nil
+	}
+	upper := strings.ToUpper(value)
+	if month, ok := monthByLabel[upper]; ok {
+		return month, nil
+	}
+	for month := time.January; month <= time.December; month++ {
+		if strings.ToUpper(month.String()) == upper {
+			return month, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a month", value)
+}
+
+// accentFolder replaces accented Latin letters with their plain form for slugify.
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "ý", "y", "ÿ", "y", "ß", "ss", "æ", "ae", "œ", "oe",
+)
+
+// slugify lowercases value, strips accents and joins its words with "-",
+// so "Café de Paris!" becomes "cafe-de-paris".
+func slugify(value string) string {
+	var b strings.Builder
+	pendingDash := false
+	for _, r := range accentFolder.Replace(strings.ToLower(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if pendingDash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingDash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			pendingDash = true
+		}
+	}
+	return b.String()
+}
+
+// extCategories maps lowercase extensions to the kind of file they hold.
+var extCategories = func() map[string]string {
+	kinds := map[string][]string{
+		"images":    {"jpg", "jpeg", "png", "gif", "bmp", "tiff", "tif", "webp", "svg", "heic", "heif", "avif", "raw", "cr2", "cr3", "nef", "arw", "dng", "orf", "rw2"},
+		"videos":    {"mp4", "mov", "avi", "mkv", "m4v", "wmv", "webm", "3gp", "mts", "m2ts", "mpg", "mpeg"},
+		"audio":     {"mp3", "wav", "flac", "aac", "m4a", "ogg", "opus", "wma", "aiff"},
+		"documents": {"pdf", "doc", "docx", "odt", "rtf", "txt", "md", "xls", "xlsx", "ods", "csv", "ppt", "pptx", "odp", "epub"},
+		"archives":  {"zip", "rar", "7z", "tar", "gz", "tgz", "bz2", "xz", "zst", "iso"},
+	}
+	categories := map[string]string{}
+	for kind, exts := range kinds {
+		for _, ext := range exts {
+			categories[ext] = kind
+		}
+	}
+	return categories
+}()
+
+// extCategoryLabels names each category of extCategories, and "other", per language.
+var extCategoryLabels = map[string]map[string]string{
+	"en": {"images": "Images", "videos": "Videos", "audio": "Audio", "documents": "Documents", "archives": "Archives", "other": "Other"},
+	"es": {"images": "Imágenes", "videos": "Videos", "audio": "Audio", "documents": "Documentos", "archives": "Comprimidos", "other": "Otros"},
+}
+
+// extCategory names the kind of file an extension such as ".JPG" or "mp4" holds.
+func extCategory(ext, lang string) string {
+	kind, ok := extCategories[strings.ToLower(strings.TrimPrefix(ext, "."))]
+	if !ok {
+		kind = "other"
+	}
+	labels := extCategoryLabels[lang]
+	if labels == nil {
+		labels = extCategoryLabels["en"]
+	}
+	return labels[kind]
+}
+
+// validateFolderTemplate checks --folder-template before the run. Only {ext}
+// can be empty; run through extCategory it never is.
+func validateFolderTemplate(template string) error {
+	if err := validateTemplate(template, true, map[string]bool{"{ext}": true}); err != nil {
+		return err
+	}
+	return validateTemplatePlaceholders(template)
+}
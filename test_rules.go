@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// ruleResult is what the classifier chain decided for one sample path.
+type ruleResult struct {
+	Path        string
+	Destination string
+	DateSource  string
+	Note        string
+	Failed      bool
+}
+
+// readSamples returns the sample paths: every file under sample when it is a
+// folder, or the lines of sample when it is a file list ('#' starts a comment).
+func readSamples(sample string, cfg FilesMoveConfiguration) ([]string, error) {
+	info, err := os.Stat(sample)
+	if err != nil {
+		return nil, newOpError("read samples", sample, err)
+	}
+	var paths []string
+	if info.IsDir() {
+		cfg.InputFolder = sample
+		err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+			paths = append(paths, path)
+			return nil
+		})
+		return paths, err
+	}
+
+	f, err := os.Open(sample)
+	if err != nil {
+		return nil, newOpError("read samples", sample, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// testRules runs the skip filters and destination rules against each sample
+// without touching disk. cfg must be a dry-run, read-only configuration.
+func testRules(paths []string, cfg FilesMoveConfiguration) []ruleResult {
+	if cfg.FolderFormat == Events {
+		// Samples are grouped into events among themselves, in their order.
+		cfg.Events = newEventClusters(cfg)
+	}
+	results := make([]ruleResult, 0, len(paths))
+	for _, path := range paths {
+		result := ruleResult{Path: path}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			result.Note, result.Failed = err.Error(), true
+		case info.IsDir():
+			result.Note = "folder"
+		default:
+			if skip, err := applyInPlaceFilters(path, info, cfg); err != nil {
+				result.Note, result.Failed = err.Error(), true
+			} else if skip {
+				result.Note = "skipped"
+			} else if move, skip, err := determineTargetPath(path, info, cfg); err != nil {
+				result.Note, result.Failed = err.Error(), true
+			} else if skip {
+				result.Note = "skipped"
+			} else {
+				result.Destination = move.Destination
+				if rel, err := filepath.Rel(cfg.OutputFolder, move.Destination); err == nil {
+					result.Destination = rel
+				}
+				result.DateSource = move.DateSource
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// printRuleResults writes the input -> destination table.
+func printRuleResults(w io.Writer, results []ruleResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tDESTINATION\tDATE SOURCE\tNOTE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Path, orDash(r.Destination), orDash(r.DateSource), orDash(r.Note))
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
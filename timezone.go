@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// The zone database is built in, since Windows has none that Go reads.
+	_ "time/tzdata"
+)
+
+// utcOffset matches a fixed offset from UTC such as "+05:30" or "-03".
+var utcOffset = regexp.MustCompile(`^([+-])(\d{2})(?::?(\d{2}))?$`)
+
+// parseTimeZone parses --timezone: "local" for the zone of the system, an
+// IANA name such as "Europe/Madrid" or "UTC", or a fixed offset such as
+// "+05:30". It returns nil when none was given, which keeps dates as they
+// are read.
+func parseTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(name, "local") {
+		return time.Local, nil
+	}
+	if match := utcOffset.FindStringSubmatch(name); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3] + strings.Repeat("0", 2-len(match[3])))
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("offset %q is out of range", name)
+		}
+		seconds := hours*3600 + minutes*60
+		if match[1] == "-" {
+			seconds = -seconds
+		}
+		return time.FixedZone(name, seconds), nil
+	}
+	return time.LoadLocation(name)
+}
+
+// inTimeZone puts date, read from source, in zone, the zone of --timezone,
+// so that the folders of all files are worked out in one zone. Modification,
+// change and creation times, and EXIF dates with an offset, are moments,
+// shown in zone. Other dates are clock times, read from EXIF without an
+// offset, see parseDateTaken, or from names, and keep their clock in zone.
+// Without --timezone every date keeps the clock it was read with: EXIF
+// dates the clock of the camera, whatever their offset.
+func inTimeZone(date time.Time, source DateSource, zone *time.Location) time.Time {
+	clock := source == DateSourceFilename || source == DateSourceChat
+	offset := false
+	if source == DateSourceExif {
+		offset = date.Location() != time.UTC
+		clock = !offset
+	}
+	switch {
+	case zone == nil && offset:
+		return wallClockIn(date, time.UTC)
+	case zone == nil:
+		return date
+	case clock:
+		return wallClockIn(date, zone)
+	default:
+		return date.In(zone)
+	}
+}
+
+// wallClockIn returns the time in zone that shows the same clock as date.
+func wallClockIn(date time.Time, zone *time.Location) time.Time {
+	year, month, day := date.Date()
+	hour, minute, second := date.Clock()
+	return time.Date(year, month, day, hour, minute, second, date.Nanosecond(), zone)
+}
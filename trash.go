@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// removeOriginal deletes the source of a file that was copied into place,
+// or with --trash moves it to the trash, from where an unwanted run can
+// still be recovered.
+func removeOriginal(src string, cfg FilesMoveConfiguration) error {
+	if !cfg.Trash {
+		if cfg.DryRun {
+			log.Printf("[DRY RUN] Would remove original: %s", src)
+			return nil
+		}
+		return newOpError("remove original", src, cfg.FS.Remove(src))
+	}
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would move original to the trash: %s", src)
+		return nil
+	}
+	abs, err := filepath.Abs(src)
+	if err == nil {
+		err = trashFile(abs, cfg.FS)
+	}
+	if err != nil {
+		return newOpError("move original to trash", src, err)
+	}
+	log.Printf(locMsg("original_trashed", cfg.Language), src)
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// trashFile moves path to the Finder trash: ~/.Trash for files on the
+// startup volume, and the volume's own .Trashes/<uid> folder otherwise, so
+// nothing is copied across drives.
+func trashFile(path string, fsys FileSystem) error {
+	if home, err := os.UserHomeDir(); err == nil {
+		err = trashInto(filepath.Join(home, ".Trash"), path, fsys)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	root, err := mountRoot(path)
+	if err != nil {
+		return err
+	}
+	return trashInto(filepath.Join(root, ".Trashes", strconv.Itoa(os.Getuid())), path, fsys)
+}
+
+// trashInto moves path into trashDir, naming it "name 2.ext" and so on when
+// the trash already holds a file of that name.
+func trashInto(trashDir, path string, fsys FileSystem) error {
+	if err := fsys.MkdirAll(trashDir, 0700); err != nil {
+		return err
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	target := filepath.Join(trashDir, base)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+			return fsys.Rename(path, target)
+		}
+		target = filepath.Join(trashDir, fmt.Sprintf("%s %d%s", stem, i, ext))
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+// trashFile is not supported on this platform.
+func trashFile(path string, fsys FileSystem) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// mountRoot returns the top folder of the filesystem holding path.
+func mountRoot(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	dev := info.Sys().(*syscall.Stat_t).Dev
+	for dir := path; ; {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		parentInfo, err := os.Stat(parent)
+		if err != nil {
+			return "", err
+		}
+		if parentInfo.Sys().(*syscall.Stat_t).Dev != dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
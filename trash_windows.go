@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// shFileOpStruct is SHFILEOPSTRUCTW. The 32-bit header packs it to one
+// byte, which only moves the fields after fFlags; those are all zero here
+// and the larger Go struct leaves room for them either way.
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+// trashFile moves path to the Recycle Bin, without any prompt or dialog.
+func trashFile(path string, fsys FileSystem) error {
+	if fsys.ReadOnly() {
+		return refuse("move to recycle bin", path)
+	}
+	if err := procSHFileOperationW.Find(); err != nil {
+		return err
+	}
+	// pFrom is a list of names, ended by an empty one.
+	from, err := windows.UTF16FromString(path)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	if code, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op))); code != 0 {
+		return fmt.Errorf("SHFileOperation failed with code 0x%x", code)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return fmt.Errorf("moving to the Recycle Bin was aborted")
+	}
+	return nil
+}
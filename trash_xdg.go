@@ -0,0 +1,87 @@
+//go:build unix && !darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// trashFile moves path to the trash following the freedesktop.org trash
+// specification, so file managers can list and restore it. Files on the
+// home filesystem go to the home trash; others go to a .Trash-<uid> folder
+// at the top of their own filesystem, so nothing is copied across drives.
+func trashFile(path string, fsys FileSystem) error {
+	if home, err := homeTrashDir(); err == nil {
+		err = trashInto(home, path, path, fsys)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	root, err := mountRoot(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	return trashInto(filepath.Join(root, fmt.Sprintf(".Trash-%d", os.Getuid())), path, rel, fsys)
+}
+
+func homeTrashDir() (string, error) {
+	if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+		return filepath.Join(data, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+// trashInto moves path into trashDir, recording origPath in its .trashinfo
+// file. The info file is created first and exclusively, which also picks a
+// free name in the trash.
+func trashInto(trashDir, path, origPath string, fsys FileSystem) error {
+	filesDir, infoDir := filepath.Join(trashDir, "files"), filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := fsys.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	name := base
+	for i := 2; ; i++ {
+		infoPath := filepath.Join(infoDir, name+".trashinfo")
+		info, err := fsys.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: origPath}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if closeErr := info.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = fsys.Rename(path, filepath.Join(filesDir, name))
+		}
+		if err != nil {
+			fsys.Remove(infoPath)
+		}
+		return err
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// treeSnapshotName is the file in the metadata folder that remembers which
+// input files a run left settled, so the next run can skip them.
+const treeSnapshotName = "tree.json.gz"
+
+// treeEntry is the version of a settled file: its size and modification
+// time in nanoseconds. Short keys keep the snapshot of a large tree small.
+type treeEntry struct {
+	Size    int64 `json:"s"`
+	ModTime int64 `json:"m"`
+}
+
+// TreeSnapshot records the input files a run had nothing left to do for:
+// files already in place, copied or linked, or skipped as duplicates. On the
+// next run with the same settings, such a file is only planned again once
+// its size or modification time changes. A nil snapshot records nothing.
+type TreeSnapshot struct {
+	mu   sync.Mutex
+	path string
+	// Input is the absolute input folder, which Files are relative to, and
+	// Config a fingerprint of the settings; the snapshot only applies to runs
+	// that match both.
+	Input  string               `json:"input"`
+	Config string               `json:"config"`
+	Files  map[string]treeEntry `json:"files"`
+	// previous holds the files settled by the last run; Files collects
+	// those of this run.
+	previous map[string]treeEntry
+	skipped  int
+}
+
+// configFingerprint identifies the settings that decide where files go.
+// Settings that do not, such as the language, dry-run or the config file
+// they were read from, are left out.
+func configFingerprint(cfg FilesMoveConfiguration) string {
+	snapshot := snapshotConfig(cfg)
+	snapshot.Language, snapshot.DryRun = "", false
+	snapshot.ConfigFile, snapshot.Profile, snapshot.SyncTo = "", "", ""
+	data, _ := json.Marshal(snapshot)
+	return encodeHash128(xxh3.Hash128(data))
+}
+
+// loadTreeSnapshot reads the snapshot in outputFolder. The last run's files
+// are only used when it was made for the same input and settings.
+func loadTreeSnapshot(cfg FilesMoveConfiguration) (*TreeSnapshot, error) {
+	input, err := filepath.Abs(cfg.InputFolder)
+	if err != nil {
+		return nil, err
+	}
+	tree := &TreeSnapshot{
+		path:   artifactFile(cfg.OutputFolder, treeSnapshotName),
+		Input:  input,
+		Config: configFingerprint(cfg),
+		Files:  map[string]treeEntry{},
+	}
+	if cfg.Rescan {
+		return tree, nil
+	}
+	file, err := os.Open(tree.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tree, nil
+	}
+	if err != nil {
+		return tree, newOpError("read tree snapshot", tree.path, err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return tree, fmt.Errorf("invalid tree snapshot %q: %w", tree.path, err)
+	}
+	var last TreeSnapshot
+	if err := json.NewDecoder(reader).Decode(&last); err != nil {
+		return tree, fmt.Errorf("invalid tree snapshot %q: %w", tree.path, err)
+	}
+	if last.Input == tree.Input && last.Config == tree.Config {
+		tree.previous = last.Files
+	}
+	return tree, nil
+}
+
+// key returns path relative to the input folder, and the version of info.
+func (t *TreeSnapshot) key(path string, info os.FileInfo) (string, treeEntry) {
+	key := historyKey(path)
+	if rel, err := filepath.Rel(t.Input, key); err == nil {
+		key = rel
+	}
+	return key, treeEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// unchanged reports whether the last run settled this version of path. Such
+// a file is carried over into this run's snapshot.
+func (t *TreeSnapshot) unchanged(path string, info os.FileInfo) bool {
+	if t == nil || t.previous == nil {
+		return false
+	}
+	key, entry := t.key(path, info)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.previous[key]; !ok || last != entry {
+		return false
+	}
+	t.Files[key] = entry
+	t.skipped++
+	return true
+}
+
+// settle records that the run is done with path, unless the file is gone
+// or a later run could still treat it differently: files kept back by
+// --min-age or --stability-check are planned again once they are old enough.
+func (t *TreeSnapshot) settle(path string, info os.FileInfo, cfg FilesMoveConfiguration) {
+	if t == nil || remainingAge(info, cfg) > 0 || time.Since(info.ModTime()) < cfg.StabilityCheck {
+		return
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return
+	}
+	key, entry := t.key(path, info)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Files[key] = entry
+}
+
+// save writes the snapshot atomically.
+func (t *TreeSnapshot) save(fsys FileSystem) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(t); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return writeArtifact(fsys, t.path, buf.Bytes(), "tree snapshot")
+}
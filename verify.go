@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// VerifyIssueKind classifies what verify found wrong with a path.
+type VerifyIssueKind int
+
+const (
+	IssueMisplaced VerifyIssueKind = iota
+	IssueUndated
+	IssueEmptyFolder
+	IssueDuplicate
+)
+
+var verifyIssueKindName = map[VerifyIssueKind]string{
+	IssueMisplaced:   "misplaced",
+	IssueUndated:     "undated",
+	IssueEmptyFolder: "empty-folder",
+	IssueDuplicate:   "duplicate",
+}
+
+// String returns the string representation of VerifyIssueKind.
+func (k VerifyIssueKind) String() string {
+	if name, ok := verifyIssueKindName[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("VerifyIssueKind(%d)", int(k))
+}
+
+// verifyIssue is one finding of verify. Expected is the folder a misplaced
+// file belongs in; Err says why an undated file has no date; Original is the
+// file a duplicate has the same content as.
+type verifyIssue struct {
+	Kind     VerifyIssueKind
+	Path     string
+	Expected string
+	Err      error
+	Original string
+}
+
+// verifyTree audits an organized tree, cfg.OutputFolder, under the current
+// settings: every file must sit in the folder its date leads to, every file
+// must have a date, and no folder may be left without files. It returns the
+// issues found and how many files were checked.
+func verifyTree(cfg FilesMoveConfiguration) ([]verifyIssue, int, error) {
+	if cfg.FolderFormat == Events && cfg.Events == nil {
+		// The tree is grouped afresh, so files its event folders hold
+		// against the current --event-gap are reported.
+		cfg.Events = collectEvents(cfg, false)
+	}
+	var issues []verifyIssue
+	checked := 0
+	err := walkInputFiles(cfg, func(path string, info os.FileInfo) error {
+		if isStructoArtifact(path) {
+			return nil
+		}
+		checked++
+		expected, err := expectedFolder(path, info, cfg)
+		if err != nil {
+			issues = append(issues, verifyIssue{Kind: IssueUndated, Path: path, Err: err})
+			return nil
+		}
+		if !isInFolder(path, expected, cfg) {
+			issues = append(issues, verifyIssue{Kind: IssueMisplaced, Path: path, Expected: expected})
+		}
+		return nil
+	})
+	if err != nil {
+		return issues, checked, err
+	}
+	empty, err := findEmptyFolders(cfg)
+	for _, dir := range empty {
+		issues = append(issues, verifyIssue{Kind: IssueEmptyFolder, Path: dir})
+	}
+	return issues, checked, err
+}
+
+// duplicateIssues reports every file of the tree whose content is already
+// in another file, hashed with cfg.Hash; see findDuplicates.
+func duplicateIssues(cfg FilesMoveConfiguration) ([]verifyIssue, error) {
+	groups, err := findDuplicates(cfg)
+	var issues []verifyIssue
+	for _, group := range groups {
+		for _, dup := range group.Duplicates {
+			issues = append(issues, verifyIssue{Kind: IssueDuplicate, Path: dup, Original: group.Keep})
+		}
+	}
+	return issues, err
+}
+
+// expectedFolder works out the folder a file of the tree belongs in, the way
+// organizing would, without creating anything.
+func expectedFolder(path string, info os.FileInfo, cfg FilesMoveConfiguration) (string, error) {
+	dated, datedInfo := folderFile(path, info, cfg)
+	if pinned, overridden, err := overriddenFolder(path, info, dated, datedInfo, cfg); err != nil || overridden {
+		return pinned, err
+	}
+	date, _, err := resolveFileDate(dated, datedInfo, cfg)
+	if err != nil {
+		return "", err
+	}
+	dir, err := createFolderFormatDirectory(cfg.OutputFolder, datedInfo.Name(), date, cfg)
+	if err != nil {
+		return "", err
+	}
+	return resolveEquivalentFolders(cfg.OutputFolder, cameraFolder(dir, dated, datedInfo, cfg)), nil
+}
+
+// isInFolder reports whether path sits in dir. Under --preserve-structure or
+// --split-threshold a file may also sit in a folder below dir.
+func isInFolder(path, dir string, cfg FilesMoveConfiguration) bool {
+	parent := filepath.Dir(path)
+	if parent == dir {
+		return true
+	}
+	if !cfg.PreserveStructure && cfg.SplitThreshold <= 0 {
+		return false
+	}
+	_, ok := relWithin(dir, parent)
+	return ok
+}
+
+// findEmptyFolders returns the folders of the tree that hold no file at any
+// depth. Only the topmost such folder of each branch is reported.
+func findEmptyFolders(cfg FilesMoveConfiguration) ([]string, error) {
+	hasFiles := map[string]bool{}
+	var dirs []string
+	err := filepath.WalkDir(cfg.OutputFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if isPrunedDir(path, newLazyFileInfo(entry), cfg) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		for dir := filepath.Dir(path); !hasFiles[dir]; dir = filepath.Dir(dir) {
+			hasFiles[dir] = true
+			if dir == cfg.OutputFolder || filepath.Dir(dir) == dir {
+				break
+			}
+		}
+		return nil
+	})
+	var empty []string
+	for _, dir := range dirs {
+		if dir != cfg.OutputFolder && !hasFiles[dir] && hasFiles[filepath.Dir(dir)] {
+			empty = append(empty, dir)
+		}
+	}
+	return empty, err
+}
+
+// printVerifyIssues writes the issues, grouped by kind, and a closing count.
+func printVerifyIssues(w io.Writer, issues []verifyIssue, checked int) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Kind != issues[j].Kind {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Path < issues[j].Path
+	})
+	if len(issues) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ISSUE\tPATH\tDETAIL")
+		for _, issue := range issues {
+			detail := "-"
+			switch {
+			case issue.Expected != "":
+				detail = "belongs in " + issue.Expected
+			case issue.Err != nil:
+				detail = issue.Err.Error()
+			case issue.Original != "":
+				detail = "same content as " + issue.Original
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", issue.Kind, issue.Path, detail)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+	counts := map[VerifyIssueKind]int{}
+	for _, issue := range issues {
+		counts[issue.Kind]++
+	}
+	fmt.Fprintf(w, "%d files checked: %d misplaced, %d undated, %d empty folders, %d duplicates\n",
+		checked, counts[IssueMisplaced], counts[IssueUndated], counts[IssueEmptyFolder], counts[IssueDuplicate])
+}
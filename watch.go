@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pendingFile is a file seen changing that is not yet stable enough to organize.
+type pendingFile struct {
+	lastEvent time.Time
+	size      int64
+}
+
+// watchSession is the state of one watch run.
+type watchSession struct {
+	watcher *fsnotify.Watcher
+	cfg     FilesMoveConfiguration
+	pending map[string]pendingFile
+	// owned holds the absolute paths of files this session placed, with when
+	// an event for them was last seen. When the output is inside the input,
+	// their events must not queue them again, or a renamed duplicate such as
+	// "a (1).jpg" would be organized forever. Once their events have settled
+	// they are forgotten, so the map does not grow for as long as the watch
+	// runs.
+	owned map[string]time.Time
+	// claimed counts the journal entries already added to owned.
+	claimed int
+}
+
+// watchAndOrganize organizes the input once, then keeps watching it and
+// organizes each new file once it has had no write events for debounce and
+// its size has stopped changing. It returns when ctx is cancelled.
+func watchAndOrganize(ctx context.Context, cfg FilesMoveConfiguration, debounce time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	session := &watchSession{
+		watcher: watcher,
+		cfg:     withEventClusters(withPeriodDensity(cfg)),
+		pending: map[string]pendingFile{},
+		owned:   map[string]time.Time{},
+	}
+	cfg = session.cfg
+	if err := session.watchTree(cfg.InputFolder, false); err != nil {
+		return err
+	}
+	if err := organizeFiles(ctx, cfg); err != nil {
+		return err
+	}
+	session.claimOutputs()
+	saveWatchFailures(cfg)
+	log.Printf(locMsg("watch_started", cfg.Language), cfg.InputFolder, debounce)
+
+	ticker := time.NewTicker(max(debounce/2, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logError("watch_error", cfg.Language, err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			session.handleEvent(event)
+		case <-ticker.C:
+			if session.organizeStableFiles(ctx, debounce) {
+				saveWatchFailures(cfg)
+			}
+		}
+	}
+}
+
+// watchTree adds dir and every folder below it; fsnotify watches are not
+// recursive. Files found on the way are queued when queue is set. An output
+// folder nested in the input is ours and not watched at all.
+func (s *watchSession) watchTree(dir string, queue bool) error {
+	cfg := s.cfg
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logError("watch_error", cfg.Language, err)
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil && queue {
+				s.queue(path, info)
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil && (isPrunedDir(path, info, cfg) || isNestedOutputPath(path, cfg)) {
+			return filepath.SkipDir
+		}
+		return s.watcher.Add(path)
+	})
+}
+
+// queue marks a file as pending unless this session placed it.
+func (s *watchSession) queue(path string, info os.FileInfo) {
+	if s.owns(path) {
+		return
+	}
+	s.pending[path] = pendingFile{lastEvent: time.Now(), size: info.Size()}
+}
+
+// handleEvent marks created or written files as pending. New folders are
+// watched too, and the files already inside them are queued.
+func (s *watchSession) handleEvent(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	// Our own logs and journals change all the time; do not even queue them.
+	if isStructoArtifact(event.Name) {
+		return
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		s.queue(event.Name, info)
+		return
+	}
+	if err := s.watchTree(event.Name, true); err != nil {
+		logError("watch_error", s.cfg.Language, err)
+	}
+}
+
+// organizeStableFiles organizes the pending files that have settled and
+// reports whether any was handled. Per-file errors are logged and recorded;
+// watching goes on.
+func (s *watchSession) organizeStableFiles(ctx context.Context, debounce time.Duration) bool {
+	handled := false
+	// Every batch is a run of its own: what earlier batches placed is on
+	// disk by now, so their reservations are dropped instead of piling up.
+	s.cfg = withReservations(s.cfg)
+	forgetCreatedDirs()
+	for path, file := range s.pending {
+		if time.Since(file.lastEvent) < debounce {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			delete(s.pending, path)
+			continue
+		}
+		if info.Size() != file.size {
+			// Still being written without events, e.g. over some network shares.
+			s.pending[path] = pendingFile{lastEvent: time.Now(), size: info.Size()}
+			continue
+		}
+		if remainingAge(info, s.cfg) > 0 || time.Since(info.ModTime()) < s.cfg.StabilityCheck {
+			// Too new for --min-age, or changed within --stability-check,
+			// which the size check above keeps watching; look again on a
+			// later tick.
+			continue
+		}
+		delete(s.pending, path)
+		if date, ok := s.cfg.Dates.add(path, info, s.cfg); ok {
+			s.cfg.Density.add(date, s.cfg)
+		}
+		if err := organizeFile(ctx, path, info, s.cfg); err != nil && !errors.Is(err, context.Canceled) {
+			logError("error_organizing", s.cfg.Language, err)
+		}
+		handled = true
+	}
+	s.forgetOwned(debounce)
+	s.claimOutputs()
+	return handled
+}
+
+// claimOutputs records the destinations journaled since the last call as
+// owned. Events for them are delivered after the move returns, so claiming
+// right after each batch is early enough.
+func (s *watchSession) claimOutputs() {
+	journal := s.cfg.Journal
+	if journal == nil {
+		return
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	for _, entry := range journal.Entries[s.claimed:] {
+		if abs, err := filepath.Abs(entry.Destination); err == nil {
+			s.owned[abs] = time.Now()
+		}
+	}
+	s.claimed = len(journal.Entries)
+}
+
+// owns reports whether this session placed path, and notes the event.
+func (s *watchSession) owns(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if _, ok := s.owned[abs]; !ok {
+		return false
+	}
+	s.owned[abs] = time.Now()
+	return true
+}
+
+// forgetOwned drops the placed files that had no event for debounce: their
+// own events were delivered by then. A later change to one of them is the
+// user's and queues it like any other file; an organized file is left
+// where it is.
+func (s *watchSession) forgetOwned(debounce time.Duration) {
+	for path, seen := range s.owned {
+		if time.Since(seen) >= debounce {
+			delete(s.owned, path)
+		}
+	}
+}
+
+// isNestedOutputPath reports whether path is the output folder of a run
+// whose output lives inside, but is not the same as, the input.
+func isNestedOutputPath(path string, cfg FilesMoveConfiguration) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absOutput, err := filepath.Abs(cfg.OutputFolder)
+	if err != nil {
+		return false
+	}
+	absInput, err := filepath.Abs(cfg.InputFolder)
+	if err != nil {
+		return false
+	}
+	return absPath == absOutput && absOutput != absInput
+}
+
+// saveWatchFailures writes the failure history after each batch, so files
+// that keep failing are counted even if the watcher is killed. The journal
+// needs no such step: every move is appended to it as it completes.
+func saveWatchFailures(cfg FilesMoveConfiguration) {
+	if cfg.DryRun {
+		return
+	}
+	saveFailureHistory(cfg)
+}
@@ -0,0 +1,8 @@
+//go:build unix && !(linux || darwin || freebsd || netbsd)
+
+package main
+
+// copyXattrs does nothing on platforms without an extended attribute API.
+func copyXattrs(src, dst string, fsys FileSystem) error {
+	return nil
+}
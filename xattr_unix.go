@@ -0,0 +1,78 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// skippedXattrs are left for the destination to set. The SELinux label of a
+// file depends on where it is, and the policy labels new files itself.
+var skippedXattrs = map[string]bool{
+	"security.selinux": true,
+}
+
+// copyXattrs copies every extended attribute of src to dst. On Linux, POSIX
+// ACLs are stored as extended attributes and are copied with them.
+func copyXattrs(src, dst string, fsys FileSystem) error {
+	names, err := listXattrs(src)
+	if errors.Is(err, unix.ENOTSUP) || len(names) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fsys.ReadOnly() {
+		return refuse("set extended attributes", dst)
+	}
+	var errs []error
+	for _, name := range names {
+		if skippedXattrs[name] {
+			continue
+		}
+		value, err := getXattr(src, name)
+		if err == nil {
+			err = unix.Setxattr(dst, name, value, 0)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("extended attribute %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	yearDatasetBtrfs     = "btrfs"
+	yearDatasetZfsPrefix = "zfs:"
+)
+
+// yearFolderPattern matches a year folder: "2024", or "2023-2024" for a school year.
+var yearFolderPattern = regexp.MustCompile(`^\d{4}(-\d{4})?$`)
+
+// yearDatasets remembers which year folders were already handled during this run.
+var yearDatasets = struct {
+	sync.Mutex
+	done map[string]bool
+}{done: map[string]bool{}}
+
+// validateYearDataset checks the --year-dataset value before any file is touched.
+func validateYearDataset(value string) error {
+	switch {
+	case value == "", value == yearDatasetBtrfs:
+		return nil
+	case strings.HasPrefix(value, yearDatasetZfsPrefix) && len(value) > len(yearDatasetZfsPrefix):
+		return nil
+	default:
+		return fmt.Errorf("expected 'btrfs' or 'zfs:<parent-dataset>', got %q", value)
+	}
+}
+
+// yearFolderFor returns the year folder that dir lives under, if the folder
+// format puts a year, or a school year, at the top of the output tree.
+func yearFolderFor(outputFolder, dir string) (string, string, bool) {
+	rel, ok := relWithin(outputFolder, dir)
+	if !ok {
+		return "", "", false
+	}
+	year := strings.Split(filepath.ToSlash(rel), "/")[0]
+	if !yearFolderPattern.MatchString(year) {
+		return "", "", false
+	}
+	return filepath.Join(outputFolder, year), year, true
+}
+
+// ensureYearDataset creates a dataset/subvolume for the year folder containing dir
+// the first time it is needed. Existing folders are left alone, so re-runs are idempotent.
+func ensureYearDataset(dir string, cfg FilesMoveConfiguration) error {
+	if cfg.YearDataset == "" && cfg.YearDatasetCmd == "" {
+		return nil
+	}
+	yearPath, year, ok := yearFolderFor(cfg.OutputFolder, dir)
+	if !ok {
+		return nil
+	}
+
+	yearDatasets.Lock()
+	defer yearDatasets.Unlock()
+	if yearDatasets.done[yearPath] {
+		return nil
+	}
+	if fileExists(yearPath) {
+		yearDatasets.done[yearPath] = true
+		return nil
+	}
+
+	cmd := yearDatasetCommand(yearPath, year, cfg)
+	if cfg.DryRun {
+		log.Printf("[DRY RUN] Would create year dataset: %s", strings.Join(cmd.Args, " "))
+		yearDatasets.done[yearPath] = true
+		return nil
+	}
+
+	output, err := cfg.FS.RunCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to create year dataset for %q (%s): %w: %s", yearPath, strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(output)))
+	}
+	log.Printf(locMsg("year_dataset_created", cfg.Language), yearPath)
+	if info, statErr := os.Stat(yearPath); statErr != nil || !info.IsDir() {
+		log.Printf("[WARN] Year dataset created but %q is not mounted; falling back to a plain folder", yearPath)
+	}
+	yearDatasets.done[yearPath] = true
+	return nil
+}
+
+// yearDatasetCommand builds the command for the configured preset or custom hook.
+// Custom hooks run through the shell with {path} and {year} substituted.
+func yearDatasetCommand(yearPath, year string, cfg FilesMoveConfiguration) *exec.Cmd {
+	if cfg.YearDatasetCmd != "" {
+		command := strings.NewReplacer("{path}", shellQuote(yearPath), "{year}", year).Replace(cfg.YearDatasetCmd)
+		if runtime.GOOS == "windows" {
+			return exec.Command("cmd", "/C", command)
+		}
+		return exec.Command("sh", "-c", command)
+	}
+	if cfg.YearDataset == yearDatasetBtrfs {
+		return exec.Command("btrfs", "subvolume", "create", yearPath)
+	}
+	// The child dataset inherits its mountpoint, so the parent must be mounted at the output folder.
+	parent := strings.TrimSuffix(strings.TrimPrefix(cfg.YearDataset, yearDatasetZfsPrefix), "/")
+	return exec.Command("zfs", "create", "-p", parent+"/"+year)
+}
+
+func shellQuote(value string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + value + `"`
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}